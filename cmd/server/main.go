@@ -13,15 +13,21 @@ import (
 	"strings"
 	"time"
 
+	"github.com/amaranth494/MudPuppy/internal/account"
+	"github.com/amaranth494/MudPuppy/internal/audit"
 	"github.com/amaranth494/MudPuppy/internal/auth"
+	"github.com/amaranth494/MudPuppy/internal/automation"
 	"github.com/amaranth494/MudPuppy/internal/config"
 	"github.com/amaranth494/MudPuppy/internal/connections"
 	"github.com/amaranth494/MudPuppy/internal/crypto"
 	"github.com/amaranth494/MudPuppy/internal/help"
+	"github.com/amaranth494/MudPuppy/internal/logs"
+	"github.com/amaranth494/MudPuppy/internal/maps"
 	"github.com/amaranth494/MudPuppy/internal/metrics"
 	"github.com/amaranth494/MudPuppy/internal/profiles"
 	"github.com/amaranth494/MudPuppy/internal/redis"
 	"github.com/amaranth494/MudPuppy/internal/session"
+	"github.com/amaranth494/MudPuppy/internal/sessionlog"
 	"github.com/amaranth494/MudPuppy/internal/store"
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
@@ -120,17 +126,30 @@ func main() {
 
 	// Initialize stores and handlers
 	userStore := store.NewUserStore(db)
-	authHandler := auth.NewHandler(userStore, redisClient, cfg)
-
-	// Initialize connections handler FIRST (SP03PH05) - needed for session callbacks
-	connectionStore := store.NewConnectionStore(db)
-	credentialsStore := store.NewCredentialsStore(db)
-	profileStore := store.NewProfileStore(db)
 	// Create encryption key store - uses DefaultKeyStore to generate default key if none configured
 	keyStore, err := crypto.DefaultKeyStore()
 	if err != nil {
 		log.Fatalf("Failed to initialize encryption key store: %v", err)
 	}
+	otpStore := store.NewOTPChallengeStore(db)
+	passkeyStore := store.NewPasskeyStore(db)
+	totpStore := store.NewTOTPStore(db)
+	apiTokenStore := store.NewAPITokenStore(db)
+	identityStore := store.NewIdentityStore(db)
+	emailChangeStore := store.NewEmailChangeStore(db)
+	authHandler := auth.NewHandler(userStore, otpStore, passkeyStore, totpStore, apiTokenStore, identityStore, emailChangeStore, keyStore, redisClient, cfg)
+	// Logins, failed codes and credential changes are recorded for the user to review
+	auditStore := store.NewAuditStore(db)
+	auditLog := audit.NewLog(auditStore)
+	authHandler.SetAuditLog(auditLog)
+
+	// Initialize connections handler FIRST (SP03PH05) - needed for session callbacks
+	connectionStore := store.NewConnectionStore(db)
+	credentialsStore := store.NewCredentialsStore(db)
+	profileStore := store.NewProfileStore(db)
+	mapStore := store.NewMapStore(db)
+	sessionLogStore := store.NewSessionLogStore(db)
+	recordingStore := store.NewSessionRecordingStore(db)
 
 	// Initialize session manager first (SP02PH01)
 	sessionManager := session.NewManager(
@@ -140,12 +159,47 @@ func main() {
 		cfg.IdleTimeoutMinutes,
 		cfg.HardSessionCapHours,
 	)
+	// Live automation engines load profiles from the profile store
+	sessionManager.SetAutomationStore(profileStore)
+	automationOpts := automation.Options{FlushInterval: time.Duration(cfg.VariableFlushSeconds) * time.Second}
+	if cfg.CommandRateLimitPerSec > 0 {
+		// Keep speedwalks within the per-user command rate limit
+		automationOpts.MinStepDelay = time.Second / time.Duration(cfg.CommandRateLimitPerSec)
+	}
+	if cfg.SessionVarsBackend == "redis" {
+		automationOpts.Runtime = automation.NewRedisRuntimeStore(redisClient)
+	}
+	// Live sessions record rooms into the connection's map
+	automationOpts.Maps = mapStore
+	sessionManager.SetAutomationOptions(automationOpts)
+	sessionManager.SetScrollbackLines(cfg.ScrollbackLines)
+
+	// Sessions on connections with logging turned on are recorded
+	sessionManager.SetSessionLogStore(sessionLogStore)
+	logLimits := sessionlog.Limits{
+		RetentionDays: cfg.LogRetentionDays,
+		MaxBytes:      int64(cfg.LogMaxMBPerUser) << 20,
+	}
+	// Timed recordings are started on demand and kept for the same retention period
+	sessionManager.SetRecordingStore(recordingStore)
+	go sessionlog.RunPruner(context.Background(), sessionLogStore, recordingStore, logLimits, time.Duration(cfg.LogPruneIntervalMins)*time.Minute)
 
 	// Initialize connections handler with session manager (SP03PH06)
 	connectionsHandler := connections.NewHandler(connectionStore, credentialsStore, keyStore, sessionManager)
+	connectionsHandler.SetAuditLog(auditLog)
 
 	// Initialize profiles handler (SP04PH02)
-	profilesHandler := profiles.NewHandler(profileStore)
+	profilesHandler := profiles.NewHandler(profileStore, sessionManager)
+	profilesHandler.SetAuditLog(auditLog)
+
+	// Initialize automapper handler
+	mapsHandler := maps.NewHandler(mapStore, sessionManager)
+
+	// Initialize session logs handler
+	logsHandler := logs.NewHandler(sessionLogStore, recordingStore, sessionManager, logLimits)
+
+	// Initialize account data handler
+	accountHandler := account.NewHandler(userStore, connectionStore, profileStore, mapStore, sessionLogStore, recordingStore, auditStore)
 
 	// Initialize help handler (SP06PH01T04)
 	helpHandler := help.NewHandler("./help")
@@ -161,10 +215,24 @@ func main() {
 		SendCredentials: func(userID, username, password string) error {
 			return sessionManager.SendCredentials(userID, username, password)
 		},
+		LookupEmail: func(userID uuid.UUID) (string, error) {
+			user, err := userStore.GetByID(userID)
+			if err != nil || user == nil {
+				return "", err
+			}
+			return user.Email, nil
+		},
 	})
 
 	// Initialize WebSocket handler (SP02PH02)
 	wsHandler := session.NewWebSocketHandler(sessionManager, cfg)
+	wsHandler.SetRecordingStore(recordingStore)
+	// Logging out or revoking a login closes the WebSockets it opened
+	authHandler.SetOnRevoke(wsHandler.CloseLogins)
+	// Deleting an account ends its MUD session; there is usually none, so the error is ignored
+	authHandler.SetOnAccountDeleted(func(userID string) {
+		sessionManager.Disconnect(userID, "account deleted")
+	})
 
 	// Initialize metrics (SP02PH04T03)
 	metrics.Init()
@@ -224,6 +292,108 @@ func main() {
 		}
 	})
 
+	// Session logging setting - registered BEFORE connections/{id} like the profile route
+	mux.HandleFunc("/api/v1/connections/{id}/logging", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			logsHandler.GetConnectionLogging(w, r)
+		case http.MethodPut:
+			logsHandler.PutConnectionLogging(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	// Automapper endpoints - registered BEFORE connections/{id} like the profile route
+	mux.HandleFunc("/api/v1/connections/{id}/map", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			mapsHandler.Get(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/api/v1/connections/{id}/map/settings", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPut:
+			mapsHandler.PutSettings(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/api/v1/connections/{id}/map/areas", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			mapsHandler.ListAreas(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/api/v1/connections/{id}/map/areas/{area}", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			mapsHandler.GetArea(w, r)
+		case http.MethodPut:
+			mapsHandler.RenameArea(w, r)
+		case http.MethodDelete:
+			mapsHandler.DeleteArea(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/api/v1/connections/{id}/map/path", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			mapsHandler.FindPath(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/api/v1/connections/{id}/map/travel", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			mapsHandler.Travel(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/api/v1/connections/{id}/map/import", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			mapsHandler.Import(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/api/v1/connections/{id}/map/export", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			mapsHandler.Export(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/api/v1/connections/{id}/map/rooms", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			mapsHandler.ListRooms(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/api/v1/connections/{id}/map/rooms/{room_id}", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			mapsHandler.GetRoom(w, r)
+		case http.MethodPut:
+			mapsHandler.UpdateRoom(w, r)
+		case http.MethodDelete:
+			mapsHandler.DeleteRoom(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
 	mux.HandleFunc("/api/v1/connections/{id}", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
@@ -246,11 +416,326 @@ func main() {
 	mux.HandleFunc("/api/v1/login", authHandler.Login)
 	mux.HandleFunc("/api/v1/logout", authHandler.Logout)
 	mux.HandleFunc("/api/v1/me", authHandler.Me)
+	mux.HandleFunc("/api/v1/login/totp", authHandler.LoginTOTP)
+	mux.HandleFunc("/api/v1/login/link", authHandler.LoginLink)
+
+	// Single sign-on (OpenID Connect)
+	mux.HandleFunc("/api/v1/oidc", authHandler.OIDCInfo)
+	mux.HandleFunc("/api/v1/oidc/login", authHandler.OIDCLogin)
+	mux.HandleFunc("/api/v1/oidc/callback", authHandler.OIDCCallback)
+	mux.HandleFunc("/api/v1/account/identities", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			authHandler.ListIdentities(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/api/v1/account/identities/link", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			authHandler.LinkIdentity(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/api/v1/account/identities/{id}", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodDelete:
+			authHandler.DeleteIdentity(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	// Account export and deletion
+	mux.HandleFunc("/api/v1/account", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodDelete:
+			authHandler.DeleteAccount(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/api/v1/account/export", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			accountHandler.Export(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	// Account activity (security audit log)
+	mux.HandleFunc("/api/v1/account/audit", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			accountHandler.Audit(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	// Email address changes
+	mux.HandleFunc("/api/v1/account/email", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			authHandler.RequestEmailChange(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/api/v1/account/email/confirm", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			authHandler.ConfirmEmailChange(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/api/v1/email/revert", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			authHandler.RevertEmailChangePage(w, r)
+		case http.MethodPost:
+			authHandler.RevertEmailChange(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	// Personal API tokens
+	mux.HandleFunc("/api/v1/account/tokens", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			authHandler.ListAPITokens(w, r)
+		case http.MethodPost:
+			authHandler.CreateAPIToken(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/api/v1/account/tokens/{id}", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodDelete:
+			authHandler.DeleteAPIToken(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	// Active logins
+	mux.HandleFunc("/api/v1/account/sessions", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			authHandler.ListSessions(w, r)
+		case http.MethodDelete:
+			authHandler.RevokeAllSessions(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/api/v1/account/sessions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodDelete:
+			authHandler.RevokeSession(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	// TOTP second factor
+	mux.HandleFunc("/api/v1/account/totp", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			authHandler.GetTOTPStatus(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/api/v1/account/totp/setup", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			authHandler.SetupTOTP(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/api/v1/account/totp/enable", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			authHandler.EnableTOTP(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/api/v1/account/totp/disable", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			authHandler.DisableTOTP(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/api/v1/account/totp/recovery-codes", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			authHandler.RegenerateRecoveryCodes(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	// Passkey login and management; login/begin and login/finish are public
+	mux.HandleFunc("/api/v1/passkeys/login/begin", authHandler.BeginPasskeyLogin)
+	mux.HandleFunc("/api/v1/passkeys/login/finish", authHandler.FinishPasskeyLogin)
+	mux.HandleFunc("/api/v1/passkeys/register/begin", authHandler.BeginPasskeyRegistration)
+	mux.HandleFunc("/api/v1/passkeys/register/finish", authHandler.FinishPasskeyRegistration)
+	mux.HandleFunc("/api/v1/passkeys", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			authHandler.ListPasskeys(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/api/v1/passkeys/{id}", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPatch:
+			authHandler.RenamePasskey(w, r)
+		case http.MethodDelete:
+			authHandler.DeletePasskey(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
 
 	// Add session endpoints to mux (SP02PH01)
 	mux.HandleFunc("/api/v1/session/connect", sessionHandler.Connect)
 	mux.HandleFunc("/api/v1/session/disconnect", sessionHandler.Disconnect)
 	mux.HandleFunc("/api/v1/session/status", sessionHandler.Status)
+	mux.HandleFunc("/api/v1/session/variables", sessionHandler.Variables)
+	mux.HandleFunc("/api/v1/session/scrollback", sessionHandler.Scrollback)
+
+	// Read-only session sharing; spectators attach to /api/v1/session/stream?spectate=<token>
+	mux.HandleFunc("/api/v1/session/shares", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			sessionHandler.ListShares(w, r)
+		case http.MethodPost:
+			sessionHandler.CreateShare(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/api/v1/session/shares/{id}", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodDelete:
+			sessionHandler.RevokeShare(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/api/v1/session/spectators", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			sessionHandler.ListSpectators(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/api/v1/session/spectators/{id}", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodDelete:
+			sessionHandler.RemoveSpectator(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	// Session logs endpoints
+	mux.HandleFunc("/api/v1/logs", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			logsHandler.List(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/api/v1/logs/search", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			logsHandler.Search(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/api/v1/logs/settings", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			logsHandler.GetSettings(w, r)
+		case http.MethodPut:
+			logsHandler.PutSettings(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/api/v1/logs/{id}/export", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			logsHandler.Export(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/api/v1/logs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			logsHandler.Get(w, r)
+		case http.MethodDelete:
+			logsHandler.Delete(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/api/v1/session/recording", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			logsHandler.GetRecordingStatus(w, r)
+		case http.MethodPost:
+			logsHandler.StartRecording(w, r)
+		case http.MethodDelete:
+			logsHandler.StopRecording(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/api/v1/recordings", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			logsHandler.ListRecordings(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/api/v1/recordings/{id}/export", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			logsHandler.ExportRecording(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/api/v1/recordings/{id}", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			logsHandler.GetRecording(w, r)
+		case http.MethodDelete:
+			logsHandler.DeleteRecording(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
 
 	// Add profiles endpoints to mux (SP04PH02)
 	mux.HandleFunc("/api/v1/profiles/{id}", func(w http.ResponseWriter, r *http.Request) {
@@ -298,6 +783,37 @@ func main() {
 		}
 	})
 
+	// Automation classes endpoints
+	mux.HandleFunc("/api/v1/profiles/{connection_id}/classes", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			profilesHandler.GetClasses(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	mux.HandleFunc("/api/v1/profiles/{connection_id}/classes/{name}", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPut:
+			profilesHandler.PutClass(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	// Named speedwalk paths endpoint
+	mux.HandleFunc("/api/v1/profiles/{connection_id}/paths", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			profilesHandler.GetPaths(w, r)
+		case http.MethodPut:
+			profilesHandler.PutPaths(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
 	// WebSocket endpoint (SP02PH02)
 	mux.HandleFunc("/api/v1/session/stream", wsHandler.HandleWebSocket)
 
@@ -329,7 +845,7 @@ func main() {
 	})
 
 	// Protected endpoints - wrapped with session middleware
-	protectedHandler := sessionMiddleware(redisClient, mux)
+	protectedHandler := sessionMiddleware(redisClient, authHandler, mux)
 
 	// Add security headers middleware (SP01PH05T03)
 	handler := securityHeadersMiddleware(protectedHandler)
@@ -359,13 +875,15 @@ func metricsHandler(cfg *config.Config) http.HandlerFunc {
 }
 
 // sessionMiddleware validates session for protected routes
-func sessionMiddleware(redisClient *redis.Client, next http.Handler) http.Handler {
+func sessionMiddleware(redisClient *redis.Client, authHandler *auth.Handler, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Route the request first to determine if it's a protected path
 		path := r.URL.Path
 
 		// Allow public endpoints through
-		if path == "/health" || path == "/api/v1/register" || path == "/api/v1/send-otp" || path == "/api/v1/login" {
+		if path == "/health" || path == "/api/v1/register" || path == "/api/v1/send-otp" || path == "/api/v1/login" ||
+			path == "/api/v1/login/totp" || path == "/api/v1/login/link" || path == "/api/v1/passkeys/login/begin" || path == "/api/v1/passkeys/login/finish" ||
+			path == "/api/v1/oidc" || path == "/api/v1/oidc/login" || path == "/api/v1/oidc/callback" || path == "/api/v1/email/revert" {
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -378,6 +896,26 @@ func sessionMiddleware(redisClient *redis.Client, next http.Handler) http.Handle
 				return
 			}
 
+			// Personal API tokens only reach the endpoints their scopes allow
+			if auth.IsAPIToken(sessionToken) {
+				token, err := authHandler.AuthenticateAPIToken(r, sessionToken)
+				if err == auth.ErrAPITokenScope {
+					http.Error(w, "Forbidden: token does not have the required scope", http.StatusForbidden)
+					return
+				}
+				if err != nil {
+					if err != auth.ErrAPITokenInvalid {
+						log.Printf("Error checking API token: %v", err)
+					}
+					http.Error(w, "Unauthorized", http.StatusUnauthorized)
+					return
+				}
+				ctx := context.WithValue(r.Context(), "user_id", token.UserID.String())
+				ctx = context.WithValue(ctx, "auth_session_id", auth.APITokenLoginID(token.ID))
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+
 			ctx := context.Background()
 			userID, err := redisClient.GetSession(ctx, sessionToken)
 			if err != nil {
@@ -389,7 +927,10 @@ func sessionMiddleware(redisClient *redis.Client, next http.Handler) http.Handle
 			redisClient.RefreshSession(ctx, sessionToken)
 
 			// Add user ID to context for session handlers (SP02PH01)
-			r = r.WithContext(context.WithValue(r.Context(), "user_id", userID))
+			ctx = context.WithValue(r.Context(), "user_id", userID)
+			// The login's public ID lets WebSockets be closed when it is revoked
+			ctx = context.WithValue(ctx, "auth_session_id", redis.SessionPublicID(sessionToken))
+			r = r.WithContext(ctx)
 		}
 
 		next.ServeHTTP(w, r)
@@ -264,6 +264,13 @@ export function SessionProvider({ children }: SessionProviderProps): JSX.Element
         }
       });
       
+      // Keep alias and trigger classes in step with #class on the server
+      manager.onAutomationEvent((event) => {
+        if (event.type === 'classes' && engine) {
+          engine.setClasses(event.classes ?? []);
+        }
+      });
+      
       manager.onDisconnect(() => {
         setConnectionState('disconnected');
         // Event-driven: refresh status on WS disconnect
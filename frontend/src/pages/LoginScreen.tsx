@@ -2,16 +2,22 @@ import { useState } from 'react';
 
 const API_BASE = '/api/v1';
 
-type AuthMode = 'login' | 'register' | 'otp';
+type AuthMode = 'login' | 'register' | 'otp' | 'mfa';
+
+// Login links and single sign-on send accounts with an authenticator app to /login?mfa=1, with the MFA token in a cookie
+const redirectedForMfa = new URLSearchParams(window.location.search).get('mfa') === '1';
 
 export default function LoginScreen() {
-  const [mode, setMode] = useState<AuthMode>('login');
+  const [mode, setMode] = useState<AuthMode>(redirectedForMfa ? 'mfa' : 'login');
   const [email, setEmail] = useState('');
   const [otp, setOtp] = useState('');
   const [message, setMessage] = useState('');
   const [messageType, setMessageType] = useState<'error' | 'success'>('error');
   const [isLoading, setIsLoading] = useState(false);
   const [pendingEmail, setPendingEmail] = useState('');
+  const [mfaToken, setMfaToken] = useState('');
+  const [mfaCode, setMfaCode] = useState('');
+  const [useRecoveryCode, setUseRecoveryCode] = useState(false);
 
   const showMessage = (msg: string, type: 'error' | 'success') => {
     setMessage(msg);
@@ -68,7 +74,11 @@ export default function LoginScreen() {
 
       const data = await response.json();
 
-      if (response.ok) {
+      if (response.ok && data.mfa_required) {
+        setMfaToken(data.mfa_token);
+        setMfaCode('');
+        setMode('mfa');
+      } else if (response.ok) {
         window.location.reload();
       } else {
         showMessage(data.error || 'Invalid verification code.', 'error');
@@ -80,6 +90,46 @@ export default function LoginScreen() {
     }
   };
 
+  const handleLoginMfa = async (e: React.FormEvent) => {
+    e.preventDefault();
+    clearMessage();
+    setIsLoading(true);
+
+    try {
+      // Without a token from /login the server uses the MFA cookie set by the redirect
+      const response = await fetch(`${API_BASE}/login/totp`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        credentials: 'include',
+        body: JSON.stringify(useRecoveryCode
+          ? { mfa_token: mfaToken, recovery_code: mfaCode.trim() }
+          : { mfa_token: mfaToken, code: mfaCode.trim() }),
+      });
+
+      if (response.ok) {
+        window.location.replace('/');
+      } else {
+        const text = await response.text();
+        showMessage(text.trim() || 'Invalid code.', 'error');
+      }
+    } catch {
+      showMessage('Network error. Please try again.', 'error');
+    } finally {
+      setIsLoading(false);
+    }
+  };
+
+  const restartLogin = () => {
+    setMode('login');
+    clearMessage();
+    setMfaToken('');
+    setMfaCode('');
+    setOtp('');
+    if (redirectedForMfa) {
+      window.history.replaceState(null, '', '/login');
+    }
+  };
+
   const handleRegister = async (e: React.FormEvent) => {
     e.preventDefault();
     clearMessage();
@@ -298,6 +348,66 @@ export default function LoginScreen() {
             </div>
           </>
         )}
+
+        {mode === 'mfa' && (
+          <>
+            <h2 style={{ marginBottom: '1.5rem', textAlign: 'center' }}>Two-Factor Authentication</h2>
+
+            <p style={{ fontSize: '0.9rem', marginBottom: '1rem', opacity: 0.8 }}>
+              {useRecoveryCode
+                ? 'Enter one of the recovery codes you saved when you set up your authenticator app.'
+                : 'Enter the 6-digit code from your authenticator app.'}
+            </p>
+
+            {message && (
+              <div className={`message message-${messageType}`}>
+                {message}
+              </div>
+            )}
+
+            <form onSubmit={handleLoginMfa}>
+              <div className="form-group">
+                <label className="form-label">{useRecoveryCode ? 'Recovery Code' : 'Authenticator Code'}</label>
+                <input
+                  type="text"
+                  className="form-input"
+                  placeholder={useRecoveryCode ? 'xxxxx-xxxxx' : '000000'}
+                  maxLength={useRecoveryCode ? 32 : 6}
+                  autoComplete="one-time-code"
+                  value={mfaCode}
+                  onChange={(e) => setMfaCode(e.target.value)}
+                  required
+                  style={useRecoveryCode ? undefined : { letterSpacing: '0.5rem', fontSize: '1.5rem', textAlign: 'center' }}
+                />
+              </div>
+              <button type="submit" className="btn btn-primary" disabled={isLoading}>
+                {isLoading ? 'Verifying...' : 'Verify'}
+              </button>
+            </form>
+
+            <div style={{ marginTop: '1rem', textAlign: 'center' }}>
+              <p style={{ fontSize: '0.9rem' }}>
+                <span
+                  style={{ color: '#00ff00', textDecoration: 'underline', cursor: 'pointer' }}
+                  onClick={() => { setUseRecoveryCode(!useRecoveryCode); setMfaCode(''); clearMessage(); }}
+                >
+                  {useRecoveryCode ? 'Use authenticator code' : 'Use a recovery code'}
+                </span>
+              </p>
+            </div>
+
+            <div style={{ marginTop: '0.5rem', textAlign: 'center' }}>
+              <p style={{ fontSize: '0.9rem' }}>
+                <span
+                  style={{ color: '#00ff00', textDecoration: 'underline', cursor: 'pointer' }}
+                  onClick={restartLogin}
+                >
+                  Start over
+                </span>
+              </p>
+            </div>
+          </>
+        )}
       </div>
     </div>
   );
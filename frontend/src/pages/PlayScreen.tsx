@@ -4,7 +4,7 @@ import { FitAddon } from '@xterm/addon-fit';
 import '@xterm/xterm/css/xterm.css';
 import { useSession } from '../context/SessionContext';
 import { useInputInterceptor } from '../hooks/useInputInterceptor';
-import { ProfileSettings } from '../types';
+import { ProfileSettings, ScriptContext } from '../types';
 
 // Enable text selection in terminal
 const terminalSelectionStyle = {
@@ -212,8 +212,8 @@ export default function PlayScreen() {
   useEffect(() => {
     if (automationEngine && wsManager) {
       // Set up the callback for automation to submit commands
-      automationEngine.setSubmitCommandCallback((command: string) => {
-        wsManager.sendCommand(command + '\n');
+      automationEngine.setSubmitCommandCallback((command: string, script?: ScriptContext) => {
+        wsManager.sendCommand(command + '\n', script);
         
         // Echo the command (automation commands)
         const settings = profile?.settings;
@@ -1,4 +1,4 @@
-import { User, SessionStatus, ConnectRequest, ConnectResponse, DisconnectResponse, WSMessage, SavedConnection, CreateConnectionRequest, UpdateConnectionRequest, SetCredentialsRequest, CredentialStatus, Profile, UpdateProfileRequest, Alias, Trigger, Variable, AliasesResponse, TriggersResponse, VariablesResponse, HelpSection, HelpSummary } from '../types';
+import { User, SessionStatus, ConnectRequest, ConnectResponse, DisconnectResponse, WSMessage, SavedConnection, CreateConnectionRequest, UpdateConnectionRequest, SetCredentialsRequest, CredentialStatus, Profile, UpdateProfileRequest, Alias, Trigger, Variable, AliasesResponse, TriggersResponse, VariablesResponse, HelpSection, HelpSummary, ScriptContext, AutomationEvent } from '../types';
 
 const API_BASE = '/api/v1';
 
@@ -94,6 +94,7 @@ export class WebSocketManager {
   private errorHandlers: ((error: string) => void)[] = [];
   private statusHandlers: ((status: string) => void)[] = [];
   private disconnectHandlers: (() => void)[] = [];
+  private automationHandlers: ((event: AutomationEvent) => void)[] = [];
 
   connect(): Promise<void> {
     return new Promise((resolve, reject) => {
@@ -147,14 +148,20 @@ export class WebSocketManager {
       case 'disconnect':
         this.disconnectHandlers.forEach(handler => handler());
         break;
+      case 'automation':
+        if (message.event) {
+          this.automationHandlers.forEach(handler => handler(message.event!));
+        }
+        break;
     }
   }
 
-  sendCommand(command: string): void {
+  sendCommand(command: string, script?: ScriptContext): void {
     if (this.ws && this.ws.readyState === WebSocket.OPEN) {
       this.ws.send(JSON.stringify({
         type: 'data',
         data: command,
+        script,
       }));
     }
   }
@@ -221,6 +228,17 @@ export class WebSocketManager {
     }
   }
 
+  onAutomationEvent(handler: (event: AutomationEvent) => void): void {
+    this.automationHandlers.push(handler);
+  }
+  
+  offAutomationEvent(handler: (event: AutomationEvent) => void): void {
+    const index = this.automationHandlers.indexOf(handler);
+    if (index > -1) {
+      this.automationHandlers.splice(index, 1);
+    }
+  }
+
   disconnect(): void {
     if (this.ws) {
       this.ws.close();
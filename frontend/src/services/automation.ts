@@ -5,7 +5,7 @@
  * Includes loop detection, command queuing, and circuit breaker protections.
  */
 
-import { Trigger, AutomationAliases, AutomationTriggers, AutomationVariables } from '../types';
+import { Alias, Trigger, AutomationAliases, AutomationTriggers, AutomationVariables, ScriptContext, ClassSummary } from '../types';
 
 // ============================================
 // Types
@@ -18,11 +18,18 @@ export type CommandSource = 'user' | 'alias' | 'trigger';
 export interface ProcessedCommand {
   command: string;
   source: CommandSource;
+  script?: ScriptContext;
+}
+
+// Command produced by alias expansion or a trigger action
+export interface ExpandedCommand {
+  command: string;
+  script?: ScriptContext;
 }
 
 // Alias expansion result
 export interface AliasExpansionResult {
-  commands: string[];
+  commands: ExpandedCommand[];
   depth: number;
 }
 
@@ -80,6 +87,9 @@ const LOOP_DETECTION_WINDOW_MS = 2000;
 // Max triggers per second (SP05 spec)
 const MAX_TRIGGERS_PER_SECOND = 10;
 
+// Actions starting with #script run on the server as one script
+const SCRIPT_ACTION_PATTERN = /^#script(\s|$)/i;
+
 // ============================================
 // Automation Engine Class
 // ============================================
@@ -91,6 +101,9 @@ export class AutomationEngine {
   private variables: AutomationVariables = { items: [] };
   private connectionId: string | null = null;
   
+  // Classes switched off with #class - their aliases and triggers do not fire
+  private disabledClasses: Set<string> = new Set();
+  
   // Connection state
   private isConnected: boolean = false;
   
@@ -122,7 +135,7 @@ export class AutomationEngine {
   private triggerCountResetTime: number = 0;
   
   // Callback for submitting commands to MUD
-  private onSubmitCommand: ((command: string) => void) | null = null;
+  private onSubmitCommand: ((command: string, script?: ScriptContext) => void) | null = null;
   
   // Callback for circuit breaker notifications
   private onCircuitBreakerTripped: ((reason: string) => void) | null = null;
@@ -137,10 +150,24 @@ export class AutomationEngine {
     this.connectionId = config.connectionId;
   }
 
+  /**
+   * Apply the class states sent by the server
+   */
+  setClasses(classes: ClassSummary[]): void {
+    this.disabledClasses = new Set(classes.filter(c => !c.enabled).map(c => c.name));
+  }
+
+  /**
+   * Check whether an alias or trigger is enabled and its class is on
+   */
+  private isActive(item: Alias | Trigger): boolean {
+    return item.enabled && !(item.class && this.disabledClasses.has(item.class));
+  }
+
   /**
    * Set the command submission callback
    */
-  setSubmitCommandCallback(callback: (command: string) => void): void {
+  setSubmitCommandCallback(callback: (command: string, script?: ScriptContext) => void): void {
     this.onSubmitCommand = callback;
   }
 
@@ -253,11 +280,12 @@ export class AutomationEngine {
       // Process each expanded command
       for (const expandedCmd of expansions.commands) {
         // Record in command history for loop detection
-        this.recordCommand(expandedCmd);
+        this.recordCommand(expandedCmd.command);
 
         processedCommands.push({
-          command: expandedCmd,
+          command: expandedCmd.command,
           source: 'user',
+          script: expandedCmd.script,
         });
       }
     }
@@ -283,12 +311,13 @@ export class AutomationEngine {
    * - Variable substitution
    * - Explicit @alias invocation
    * Note: Trigger actions do NOT implicitly pass through alias evaluation
+   * A #script action receives the output line that fired the trigger
    */
-  processTriggerAction(actionText: string): string[] {
+  processTriggerAction(actionText: string, line: string): ExpandedCommand[] {
     // SP05: Trigger actions support command separation like user input
     const commands = this.parseCommandString(actionText);
     
-    const processedCommands: string[] = [];
+    const processedCommands: ExpandedCommand[] = [];
     
     for (const cmd of commands) {
       // Variable substitution
@@ -300,15 +329,21 @@ export class AutomationEngine {
         const aliasResult = this.invokeExplicitAlias(processed.substring(1));
         if (aliasResult) {
           // Alias result may contain semicolons - split and process each part
-          const aliasCommands = this.parseCommandString(aliasResult);
+          const aliasCommands = this.parseCommandString(aliasResult.replacement);
           for (const aliasCmd of aliasCommands) {
-            processedCommands.push(aliasCmd);
+            processedCommands.push({
+              command: aliasCmd,
+              script: this.scriptContext(aliasCmd, line, aliasResult.args),
+            });
           }
           continue;
         }
       }
       
-      processedCommands.push(processed);
+      processedCommands.push({
+        command: processed,
+        script: this.scriptContext(processed, line),
+      });
     }
     
     return processedCommands;
@@ -334,7 +369,7 @@ export class AutomationEngine {
 
       const matchingTrigger = this.findMatchingTrigger(line);
       if (matchingTrigger) {
-        this.fireTrigger(matchingTrigger);
+        this.fireTrigger(matchingTrigger, line);
       }
     }
   }
@@ -346,8 +381,13 @@ export class AutomationEngine {
   /**
    * Parse command string by semicolon separator
    * "look;inv;draw sword" -> ["look", "inv", "draw sword"]
+   * A #script action is kept whole - semicolons separate its statements
    */
   private parseCommandString(input: string): string[] {
+    if (this.isScriptAction(input)) {
+      return [input.trim()];
+    }
+
     // Split by semicolon
     const parts = input.split(';');
     
@@ -363,6 +403,23 @@ export class AutomationEngine {
     return commands;
   }
 
+  /**
+   * Check whether a command is a #script action
+   */
+  private isScriptAction(command: string): boolean {
+    return SCRIPT_ACTION_PATTERN.test(command.trim());
+  }
+
+  /**
+   * Build the context sent with a #script action, or undefined for plain commands
+   */
+  private scriptContext(command: string, line: string, args: string[] = []): ScriptContext | undefined {
+    if (!this.isScriptAction(command)) {
+      return undefined;
+    }
+    return { line, args };
+  }
+
   // ============================================
   // Variable Substitution (SP05PH03T03)
   // ============================================
@@ -393,8 +450,8 @@ export class AutomationEngine {
    * Returns the expanded command and whether expansion occurred
    */
   private evaluateAlias(input: string, depth: number): AliasExpansionResult {
-    // Get enabled aliases
-    const enabledAliases = this.aliases.items.filter(a => a.enabled);
+    // Get enabled aliases in active classes
+    const enabledAliases = this.aliases.items.filter(a => this.isActive(a));
     
     for (const alias of enabledAliases) {
       // Always use prefix match - pattern must match the beginning of input
@@ -427,12 +484,19 @@ export class AutomationEngine {
         // This implements depth-first expansion where each part of the replacement
         // is evaluated for more aliases before returning
         const replacementCommands = this.parseCommandString(replacement);
-        const allExpandedCommands: string[] = [];
+        const allExpandedCommands: ExpandedCommand[] = [];
         let maxDepth = depth + 1;
         
         for (const repCmd of replacementCommands) {
           // Substitute variables in each command part
           const varSubbedCmd = this.substituteVariables(repCmd);
+
+          // Scripts run on the server with the alias input and arguments
+          const script = this.scriptContext(varSubbedCmd, input, matchResult.args);
+          if (script) {
+            allExpandedCommands.push({ command: varSubbedCmd, script });
+            continue;
+          }
           
           // Recursively evaluate each part (depth-first)
           const nestedResult = this.evaluateAlias(varSubbedCmd, depth + 1);
@@ -449,15 +513,16 @@ export class AutomationEngine {
 
     // No matching alias found - return input as single command
     return {
-      commands: [input],
+      commands: [{ command: input }],
       depth: depth,
     };
   }
 
   /**
    * Explicit alias invocation from trigger action (@alias)
+   * Returns the replacement and the arguments it was invoked with
    */
-  private invokeExplicitAlias(text: string): string | null {
+  private invokeExplicitAlias(text: string): { replacement: string; args: string[] } | null {
     // Parse alias name and args
     const spaceIndex = text.indexOf(' ');
     let aliasName: string;
@@ -473,7 +538,7 @@ export class AutomationEngine {
 
     // Find the alias
     const alias = this.aliases.items.find(a => 
-      a.enabled && a.pattern === aliasName
+      this.isActive(a) && a.pattern === aliasName
     );
     
     if (!alias) {
@@ -493,7 +558,7 @@ export class AutomationEngine {
     // Substitute ${variable} patterns in the replacement
     replacement = this.substituteVariables(replacement);
     
-    return replacement;
+    return { replacement, args: argsArray };
   }
 
   // ============================================
@@ -507,7 +572,7 @@ export class AutomationEngine {
     const now = Date.now();
     
     for (const trigger of this.triggers.items) {
-      if (!trigger.enabled) continue;
+      if (!this.isActive(trigger)) continue;
       
       // Check cooldown
       const lastFired = this.triggerLastFired.get(trigger.id) || 0;
@@ -529,7 +594,7 @@ export class AutomationEngine {
   /**
    * Fire a trigger - queue its action for execution
    */
-  private fireTrigger(trigger: Trigger): void {
+  private fireTrigger(trigger: Trigger, line: string): void {
     const now = Date.now();
     
     // Update last fired time
@@ -540,13 +605,14 @@ export class AutomationEngine {
     this.lastTriggerCycleId = this.currentCycleId;
 
     // Process the trigger action (returns array to support command separation)
-    const processedCommands = this.processTriggerAction(trigger.action);
+    const processedCommands = this.processTriggerAction(trigger.action, line);
     
     // Add each command to queue with trigger source
     for (const cmd of processedCommands) {
       const queued = this.queueCommand({
-        command: cmd,
+        command: cmd.command,
         source: 'trigger',
+        script: cmd.script,
       });
       
       if (!queued) {
@@ -648,7 +714,7 @@ export class AutomationEngine {
       
       // Submit the command
       if (this.onSubmitCommand) {
-        this.onSubmitCommand(cmd.command);
+        this.onSubmitCommand(cmd.command, cmd.script);
       }
       
       // Track dispatch time for backpressure
@@ -40,7 +40,7 @@ export interface DisconnectResponse {
 }
 
 // WebSocket message types
-export type WSMessageType = 'connect' | 'disconnect' | 'data' | 'error' | 'status';
+export type WSMessageType = 'connect' | 'disconnect' | 'data' | 'error' | 'status' | 'automation';
 
 export interface WSMessage {
   type: WSMessageType;
@@ -49,6 +49,29 @@ export interface WSMessage {
   data?: string;
   error?: string;
   status?: string;
+  script?: ScriptContext;
+  event?: AutomationEvent;
+}
+
+// Server-side automation event - 'classes' carries the current class states
+export interface AutomationEvent {
+  type: string;
+  classes?: ClassSummary[];
+  message?: string;
+}
+
+// Class (group) of aliases and triggers that can be switched on and off with #class
+export interface ClassSummary {
+  name: string;
+  enabled: boolean;
+  alias_count: number;
+  trigger_count: number;
+}
+
+// What ran a #script action - passed to the script as the locals line and args
+export interface ScriptContext {
+  line: string;
+  args?: string[];
 }
 
 // Error mapping
@@ -172,6 +195,7 @@ export interface Alias {
   pattern: string;
   replacement: string;
   enabled: boolean;
+  class?: string;
 }
 
 // Trigger type - executes commands based on output
@@ -182,6 +206,7 @@ export interface Trigger {
   action: string;
   cooldown_ms: number;
   enabled: boolean;
+  class?: string;
 }
 
 // Variable type - reusable values for automation
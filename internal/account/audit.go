@@ -0,0 +1,67 @@
+package account
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/amaranth494/MudPuppy/internal/store"
+)
+
+// Audit log page sizes
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 200
+)
+
+// AuditResponse is a page of the user's audit log, newest first
+// NextBefore is passed as ?before= to get the next page, and is 0 on the last page.
+type AuditResponse struct {
+	Items      []store.AuditEvent `json:"items"`
+	NextBefore int64              `json:"next_before,omitempty"`
+}
+
+// Audit handles GET /api/v1/account/audit?limit=&before=
+// Lists logins, failed codes and credential changes on the account, newest first
+func (h *Handler) Audit(w http.ResponseWriter, r *http.Request) {
+	userUUID, err := h.getUserID(r)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+
+	query := r.URL.Query()
+	limit := defaultAuditPageSize
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxAuditPageSize {
+			h.sendError(w, fmt.Sprintf("limit must be between 1 and %d", maxAuditPageSize))
+			return
+		}
+		limit = n
+	}
+	var before int64
+	if s := query.Get("before"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n < 1 {
+			h.sendError(w, "Invalid before cursor")
+			return
+		}
+		before = n
+	}
+
+	// One extra event tells whether there is another page
+	items, err := h.auditStore.ListByUser(userUUID, before, limit+1)
+	if err != nil {
+		log.Printf("Error listing audit events: %v", err)
+		h.sendError(w, "Failed to list account activity")
+		return
+	}
+	resp := AuditResponse{Items: items}
+	if len(items) > limit {
+		resp.Items = items[:limit]
+		resp.NextBefore = items[limit-1].ID
+	}
+	h.sendJSON(w, resp)
+}
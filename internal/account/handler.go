@@ -0,0 +1,274 @@
+package account
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/amaranth494/MudPuppy/internal/sessionlog"
+	"github.com/amaranth494/MudPuppy/internal/store"
+	"github.com/google/uuid"
+)
+
+// Export formats
+const (
+	FormatZIP  = "zip"  // account.json, plus transcripts and recordings as separate files
+	FormatJSON = "json" // account.json on its own
+)
+
+// exportPage is the number of logs or recordings listed at a time while exporting
+const exportPage = 100
+
+// Handler handles account data HTTP requests
+type Handler struct {
+	userStore       *store.UserStore
+	connectionStore *store.ConnectionStore
+	profileStore    *store.ProfileStore
+	mapStore        *store.MapStore
+	logStore        *store.SessionLogStore
+	recordingStore  *store.SessionRecordingStore
+	auditStore      *store.AuditStore
+}
+
+// NewHandler creates a new account handler
+func NewHandler(userStore *store.UserStore, connectionStore *store.ConnectionStore, profileStore *store.ProfileStore, mapStore *store.MapStore, logStore *store.SessionLogStore, recordingStore *store.SessionRecordingStore, auditStore *store.AuditStore) *Handler {
+	return &Handler{
+		userStore:       userStore,
+		connectionStore: connectionStore,
+		profileStore:    profileStore,
+		mapStore:        mapStore,
+		logStore:        logStore,
+		recordingStore:  recordingStore,
+		auditStore:      auditStore,
+	}
+}
+
+// Export is everything stored for a user, as written to account.json
+// Saved MUD passwords and login secrets (passkeys, TOTP, API tokens) are never included.
+type Export struct {
+	ExportedAt  string                    `json:"exported_at"`
+	Account     ExportAccount             `json:"account"`
+	Connections []ExportConnection        `json:"connections"`
+	LogSettings *store.SessionLogSettings `json:"log_settings,omitempty"`
+
+	// Listed only when logs are exported; the transcripts and recordings are separate files in the ZIP
+	Logs       []store.SessionLog       `json:"logs,omitempty"`
+	Recordings []store.SessionRecording `json:"recordings,omitempty"`
+}
+
+type ExportAccount struct {
+	ID              uuid.UUID  `json:"id"`
+	Email           string     `json:"email"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+// ExportConnection is a saved connection with its profile (settings and automation) and map
+type ExportConnection struct {
+	store.SavedConnection
+	Profile *store.Profile `json:"profile,omitempty"`
+	Map     *ExportMap     `json:"map,omitempty"`
+}
+
+type ExportMap struct {
+	store.Map
+	Rooms []store.MapRoom `json:"rooms"`
+}
+
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// Export handles GET /api/v1/account/export?format=&logs=
+// format is zip (default) or json; logs=true adds session transcripts and recordings, which need the ZIP format.
+// In the ZIP, transcripts are logs/{id}.txt and recordings are recordings/{id}.cast, listed in account.json.
+func (h *Handler) Export(w http.ResponseWriter, r *http.Request) {
+	userUUID, err := h.getUserID(r)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = FormatZIP
+	}
+	if format != FormatZIP && format != FormatJSON {
+		h.sendError(w, "unknown export format: use zip or json")
+		return
+	}
+	includeLogs := query.Get("logs") == "true"
+	if includeLogs && format != FormatZIP {
+		h.sendError(w, "Logs can only be exported in the zip format")
+		return
+	}
+
+	export, err := h.buildExport(userUUID, includeLogs)
+	if err != nil {
+		log.Printf("Error building account export: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to export account"})
+		return
+	}
+	if export == nil {
+		h.sendError(w, "Account not found")
+		return
+	}
+
+	filename := "mudpuppy-export-" + time.Now().UTC().Format("20060102")
+	if format == FormatJSON {
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, filename))
+		h.sendJSON(w, export)
+		return
+	}
+
+	// The ZIP is streamed, so once it has started errors can only be logged
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, filename))
+	if err := h.writeZIP(w, export); err != nil {
+		log.Printf("Error writing account export for user %s: %v", userUUID, err)
+	}
+}
+
+// buildExport gathers a user's data, returning nil if the user does not exist
+func (h *Handler) buildExport(userID uuid.UUID, includeLogs bool) (*Export, error) {
+	user, err := h.userStore.GetByID(userID)
+	if err != nil || user == nil {
+		return nil, err
+	}
+	export := &Export{
+		ExportedAt: time.Now().UTC().Format(time.RFC3339),
+		Account: ExportAccount{
+			ID:              user.ID,
+			Email:           user.Email,
+			EmailVerifiedAt: user.EmailVerifiedAt,
+			CreatedAt:       user.CreatedAt,
+		},
+		Connections: []ExportConnection{},
+	}
+
+	connections, err := h.connectionStore.GetByUserID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("connections: %w", err)
+	}
+	for _, conn := range connections {
+		ec := ExportConnection{SavedConnection: conn}
+		if ec.Profile, err = h.profileStore.GetProfileByConnection(userID, conn.ID); err != nil {
+			return nil, fmt.Errorf("profile for connection %s: %w", conn.ID, err)
+		}
+		m, err := h.mapStore.GetMap(userID, conn.ID)
+		if err != nil {
+			return nil, fmt.Errorf("map for connection %s: %w", conn.ID, err)
+		}
+		if m != nil {
+			rooms, err := h.mapStore.ListRooms(m.ID, "")
+			if err != nil {
+				return nil, fmt.Errorf("rooms of map %s: %w", m.ID, err)
+			}
+			ec.Map = &ExportMap{Map: *m, Rooms: rooms}
+		}
+		export.Connections = append(export.Connections, ec)
+	}
+
+	if export.LogSettings, err = h.logStore.GetSettings(userID); err != nil {
+		return nil, fmt.Errorf("log settings: %w", err)
+	}
+	if !includeLogs {
+		return export, nil
+	}
+
+	export.Logs = []store.SessionLog{}
+	for offset := 0; ; offset += exportPage {
+		logs, err := h.logStore.ListLogs(userID, nil, exportPage, offset)
+		if err != nil {
+			return nil, fmt.Errorf("logs: %w", err)
+		}
+		export.Logs = append(export.Logs, logs...)
+		if len(logs) < exportPage {
+			break
+		}
+	}
+	export.Recordings = []store.SessionRecording{}
+	for offset := 0; ; offset += exportPage {
+		recordings, err := h.recordingStore.ListRecordings(userID, exportPage, offset)
+		if err != nil {
+			return nil, fmt.Errorf("recordings: %w", err)
+		}
+		export.Recordings = append(export.Recordings, recordings...)
+		if len(recordings) < exportPage {
+			break
+		}
+	}
+	return export, nil
+}
+
+// writeZIP writes the export as a ZIP archive, streaming each transcript and recording from the database
+func (h *Handler) writeZIP(w io.Writer, export *Export) error {
+	zw := zip.NewWriter(w)
+
+	f, err := zw.Create("account.json")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(export); err != nil {
+		return err
+	}
+
+	for i := range export.Logs {
+		l := &export.Logs[i]
+		f, err := zw.Create("logs/" + l.ID.String() + sessionlog.FileExtension(sessionlog.FormatText))
+		if err != nil {
+			return err
+		}
+		if err := sessionlog.Export(f, h.logStore, l, sessionlog.FormatText, nil, nil); err != nil {
+			return fmt.Errorf("log %s: %w", l.ID, err)
+		}
+	}
+	for i := range export.Recordings {
+		rec := &export.Recordings[i]
+		f, err := zw.Create("recordings/" + rec.ID.String() + ".cast")
+		if err != nil {
+			return err
+		}
+		if err := sessionlog.ExportCast(f, h.recordingStore, rec); err != nil {
+			return fmt.Errorf("recording %s: %w", rec.ID, err)
+		}
+	}
+	return zw.Close()
+}
+
+// getUserID returns the authenticated user
+func (h *Handler) getUserID(r *http.Request) (uuid.UUID, error) {
+	userID := r.Context().Value("user_id")
+	if userID == nil {
+		return uuid.Nil, fmt.Errorf("Unauthorized")
+	}
+	userUUID, err := uuid.Parse(userID.(string))
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("Invalid user ID")
+	}
+	return userUUID, nil
+}
+
+// sendJSON sends a JSON response
+func (h *Handler) sendJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		log.Printf("Failed to encode JSON: %v", err)
+	}
+}
+
+// sendError sends an error response
+func (h *Handler) sendError(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: message})
+}
@@ -0,0 +1,93 @@
+// Package audit records security-relevant account events, so users can review
+// who logged in from where and what changed their credentials
+package audit
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/amaranth494/MudPuppy/internal/store"
+	"github.com/google/uuid"
+)
+
+// Account and login events
+const (
+	EventLogin            = "login"
+	EventLogout           = "logout"
+	EventOTPFailed        = "otp_failed"
+	EventTOTPFailed       = "totp_failed"
+	EventSessionsRevoked  = "sessions_revoked"
+	EventTOTPEnabled      = "totp_enabled"
+	EventTOTPDisabled     = "totp_disabled"
+	EventPasskeyAdded     = "passkey_added"
+	EventPasskeyRemoved   = "passkey_removed"
+	EventAPITokenCreated  = "api_token_created"
+	EventAPITokenRevoked  = "api_token_revoked"
+	EventIdentityLinked   = "identity_linked"
+	EventIdentityUnlinked = "identity_unlinked"
+	EventEmailChanged     = "email_changed"
+	EventEmailReverted    = "email_change_reverted"
+)
+
+// Connection and profile events
+const (
+	EventCredentialsSet     = "credentials_set"
+	EventCredentialsDeleted = "credentials_deleted"
+	EventAutoLoginEnabled   = "auto_login_enabled"
+	EventAutoLoginDisabled  = "auto_login_disabled"
+	EventProfileUpdated     = "profile_updated"
+)
+
+// maxUserAgent limits the User-Agent header stored with an event
+const maxUserAgent = 256
+
+// Log writes events to the audit store
+// A nil *Log records nothing, so handlers work without one.
+type Log struct {
+	store *store.AuditStore
+}
+
+// NewLog creates a new audit log
+func NewLog(s *store.AuditStore) *Log {
+	return &Log{store: s}
+}
+
+// Record writes an event for a user, with the IP address and browser of the request that caused it
+// A failure is logged rather than returned: the action itself has already happened.
+func (l *Log) Record(r *http.Request, userID uuid.UUID, event string, details map[string]string) {
+	if l == nil {
+		return
+	}
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgent {
+		userAgent = userAgent[:maxUserAgent]
+	}
+	e := &store.AuditEvent{
+		UserID:    userID,
+		Event:     event,
+		IP:        ClientIP(r),
+		UserAgent: userAgent,
+		Details:   details,
+	}
+	if err := l.store.Record(e); err != nil {
+		log.Printf("Error recording audit event %s for user %s: %v", event, userID, err)
+	}
+}
+
+// ClientIP extracts the client IP address from a request
+func ClientIP(r *http.Request) string {
+	// Check X-Forwarded-For header first (for reverse proxy)
+	xff := r.Header.Get("X-Forwarded-For")
+	if xff != "" {
+		ips := strings.Split(xff, ",")
+		return strings.TrimSpace(ips[0])
+	}
+
+	// Fall back to RemoteAddr
+	ip := r.RemoteAddr
+	if idx := strings.LastIndex(ip, ":"); idx != -1 {
+		ip = ip[:idx]
+	}
+	return ip
+}
@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// Request/Response types for account deletion
+
+type DeleteAccountRequest struct {
+	OTP string `json:"otp"`
+}
+
+// SetOnAccountDeleted sets the function called with the ID of a user whose account is about to be deleted,
+// so their live MUD session can be ended first
+func (h *Handler) SetOnAccountDeleted(fn func(userID string)) {
+	h.onAccountDeleted = fn
+}
+
+// DeleteAccount handles DELETE /api/v1/account
+// The user confirms with a fresh code from POST /api/v1/send-otp. Their live MUD session is ended,
+// every login is logged out and the account is deleted along with everything it owns.
+func (h *Handler) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userUUID, ok := h.requestUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var req DeleteAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.OTP == "" {
+		http.Error(w, "A code sent to your email is required to delete your account", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.userStore.GetByID(userUUID)
+	if err != nil {
+		log.Printf("Error getting user: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	err = h.verifyOTP(user.Email, req.OTP)
+	h.recordOTPFailure(r, user.Email, err)
+	switch err {
+	case nil:
+	case errOTPNotFound:
+		http.Error(w, "Invalid or expired OTP", http.StatusUnauthorized)
+		return
+	case errOTPInvalid:
+		http.Error(w, "Invalid OTP", http.StatusUnauthorized)
+		return
+	case errOTPLocked:
+		http.Error(w, "Too many incorrect codes. Please request a new code.", http.StatusUnauthorized)
+		return
+	default:
+		log.Printf("Error verifying OTP: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// API tokens are deleted with the account, so note them first to close their connections afterwards
+	tokens, err := h.apiTokenStore.ListByUser(userUUID)
+	if err != nil {
+		log.Printf("Error listing API tokens: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// End the MUD session before deleting, so it stops producing output and commands. Its last transcript,
+	// recording and variable writes finish in the background and may land after the delete; they then
+	// fail on the missing rows and are dropped, so nothing of the account is left behind.
+	if h.onAccountDeleted != nil {
+		h.onAccountDeleted(userUUID.String())
+	}
+
+	deleted, err := h.userStore.Delete(userUUID)
+	if err != nil {
+		log.Printf("Error deleting user: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	// Login codes are keyed by email rather than user, so they are not removed with the account
+	if err := h.otpStore.DeleteByEmail(user.Email); err != nil {
+		log.Printf("Error deleting OTP challenges: %v", err)
+	}
+
+	// The account is gone, so a Redis failure here is logged rather than reported;
+	// sessions of a deleted user cannot reach any data and expire within a day
+	revoked, err := h.redisClient.DeleteUserData(context.Background(), userUUID.String())
+	if err != nil {
+		log.Printf("Error removing Redis data for deleted user %s: %v", userUUID, err)
+	}
+	for _, t := range tokens {
+		revoked = append(revoked, APITokenLoginID(t.ID))
+	}
+	h.notifyRevoked(revoked...)
+	clearSessionCookie(w)
+
+	log.Printf("Account deleted: user %s", userUUID)
+	h.sendJSON(w, map[string]string{"status": "deleted"})
+}
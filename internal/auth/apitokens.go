@@ -0,0 +1,262 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/amaranth494/MudPuppy/internal/audit"
+	"github.com/amaranth494/MudPuppy/internal/store"
+	"github.com/google/uuid"
+)
+
+// API token scopes
+// A token can only reach the endpoints its scopes cover; account settings are never reachable with one.
+const (
+	ScopeSessionRead     = "session:read"     // Session status, variables, scrollback and watching shared sessions
+	ScopeSessionWrite    = "session:write"    // Connecting, disconnecting and playing over the WebSocket
+	ScopeConnectionsRead = "connections:read" // Listing saved connections (never their credentials)
+	ScopeProfilesRead    = "profiles:read"    // Reading aliases, triggers and other automation
+	ScopeProfilesWrite   = "profiles:write"   // Changing aliases, triggers and other automation
+)
+
+// APIScopes lists every scope a token can be given
+var APIScopes = []string{ScopeSessionRead, ScopeSessionWrite, ScopeConnectionsRead, ScopeProfilesRead, ScopeProfilesWrite}
+
+// API token limits
+const (
+	APITokenPrefix      = "mpat_" // Sets tokens apart from session IDs, and makes leaked tokens easy to search for
+	MaxAPITokensPerUser = 20
+	MaxAPITokenName     = 100
+	DefaultAPITokenDays = 90
+	MaxAPITokenDays     = 365
+	apiTokenShownChars  = len(APITokenPrefix) + 8
+)
+
+var (
+	// ErrAPITokenInvalid indicates the token does not exist, was revoked or has expired
+	ErrAPITokenInvalid = errors.New("invalid api token")
+	// ErrAPITokenScope indicates the token is valid but not for this endpoint
+	ErrAPITokenScope = errors.New("api token missing required scope")
+)
+
+// Request/Response types for API tokens
+
+type CreateAPITokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days,omitempty"`
+}
+
+type CreateAPITokenResponse struct {
+	store.APIToken
+	Token string `json:"token"`
+}
+
+type APITokensResponse struct {
+	Items []store.APIToken `json:"items"`
+}
+
+// IsAPIToken reports whether a bearer token is a personal API token rather than a session ID
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, APITokenPrefix)
+}
+
+// hashToken hashes an API or email revert token for storage; tokens are random enough that a plain SHA-256 is safe
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// RequiredScope returns the scope an API token needs for a request, or "" if tokens cannot be used for it
+func RequiredScope(r *http.Request) string {
+	path := r.URL.Path
+	read := r.Method == http.MethodGet || r.Method == http.MethodHead
+
+	switch {
+	case path == "/api/v1/session/stream":
+		if r.URL.Query().Has("spectate") {
+			return ScopeSessionRead
+		}
+		return ScopeSessionWrite
+	case path == "/api/v1/session/status" || path == "/api/v1/session/variables" || path == "/api/v1/session/scrollback":
+		if read {
+			return ScopeSessionRead
+		}
+	case path == "/api/v1/session/connect" || path == "/api/v1/session/disconnect":
+		return ScopeSessionWrite
+	case strings.HasPrefix(path, "/api/v1/connections/") && strings.HasSuffix(path, "/connect"):
+		return ScopeSessionWrite
+	case path == "/api/v1/connections" || path == "/api/v1/connections/recent":
+		if read {
+			return ScopeConnectionsRead
+		}
+	case strings.HasPrefix(path, "/api/v1/connections/") && strings.Count(path, "/") == 4:
+		// /api/v1/connections/{id} only, not its credentials or other sub-resources
+		if read {
+			return ScopeConnectionsRead
+		}
+	case strings.HasPrefix(path, "/api/v1/profiles/"):
+		if read {
+			return ScopeProfilesRead
+		}
+		return ScopeProfilesWrite
+	}
+	return ""
+}
+
+// AuthenticateAPIToken checks a personal API token for a request and records its use
+// Returns ErrAPITokenInvalid or ErrAPITokenScope if the token cannot be used.
+func (h *Handler) AuthenticateAPIToken(r *http.Request, token string) (*store.APIToken, error) {
+	t, err := h.apiTokenStore.GetActiveByHash(hashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, ErrAPITokenInvalid
+	}
+	scope := RequiredScope(r)
+	if scope == "" || !slices.Contains(t.Scopes, scope) {
+		return nil, ErrAPITokenScope
+	}
+	if err := h.apiTokenStore.RecordUse(t.ID); err != nil {
+		log.Printf("Error recording API token use: %v", err)
+	}
+	return t, nil
+}
+
+// ListAPITokens handles GET /api/v1/account/tokens
+func (h *Handler) ListAPITokens(w http.ResponseWriter, r *http.Request) {
+	userUUID, ok := h.requestUserID(w, r)
+	if !ok {
+		return
+	}
+	tokens, err := h.apiTokenStore.ListByUser(userUUID)
+	if err != nil {
+		log.Printf("Error listing API tokens: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	h.sendJSON(w, APITokensResponse{Items: tokens})
+}
+
+// CreateAPIToken handles POST /api/v1/account/tokens
+// The token itself is only returned here; afterwards only its prefix is shown.
+func (h *Handler) CreateAPIToken(w http.ResponseWriter, r *http.Request) {
+	userUUID, ok := h.requestUserID(w, r)
+	if !ok {
+		return
+	}
+	var req CreateAPITokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > MaxAPITokenName {
+		http.Error(w, fmt.Sprintf("Name must be 1 to %d characters", MaxAPITokenName), http.StatusBadRequest)
+		return
+	}
+	if len(req.Scopes) == 0 {
+		http.Error(w, "At least one scope is required", http.StatusBadRequest)
+		return
+	}
+	scopes := []string{}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(APIScopes, scope) {
+			http.Error(w, fmt.Sprintf("Unknown scope %q (valid scopes: %s)", scope, strings.Join(APIScopes, ", ")), http.StatusBadRequest)
+			return
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if req.ExpiresInDays == 0 {
+		req.ExpiresInDays = DefaultAPITokenDays
+	}
+	if req.ExpiresInDays < 1 || req.ExpiresInDays > MaxAPITokenDays {
+		http.Error(w, fmt.Sprintf("expires_in_days must be between 1 and %d", MaxAPITokenDays), http.StatusBadRequest)
+		return
+	}
+
+	count, err := h.apiTokenStore.CountByUser(userUUID)
+	if err != nil {
+		log.Printf("Error counting API tokens: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if count >= MaxAPITokensPerUser {
+		http.Error(w, fmt.Sprintf("You can have at most %d API tokens", MaxAPITokensPerUser), http.StatusBadRequest)
+		return
+	}
+
+	random, err := generateSessionID()
+	if err != nil {
+		log.Printf("Error generating API token: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	token := APITokenPrefix + random
+	t := &store.APIToken{
+		UserID:    userUUID,
+		Name:      req.Name,
+		TokenHash: hashToken(token),
+		Prefix:    token[:apiTokenShownChars],
+		Scopes:    scopes,
+	}
+	if err := h.apiTokenStore.Create(t, time.Now().AddDate(0, 0, req.ExpiresInDays)); err != nil {
+		log.Printf("Error storing API token: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("API token %s created for user %s", t.ID, userUUID)
+	h.auditLog.Record(r, userUUID, audit.EventAPITokenCreated, map[string]string{"token_id": t.ID.String(), "name": t.Name})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CreateAPITokenResponse{APIToken: *t, Token: token})
+}
+
+// DeleteAPIToken handles DELETE /api/v1/account/tokens/{id}
+// Revoking a token also closes any WebSockets opened with it.
+func (h *Handler) DeleteAPIToken(w http.ResponseWriter, r *http.Request) {
+	userUUID, ok := h.requestUserID(w, r)
+	if !ok {
+		return
+	}
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid token ID", http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := h.apiTokenStore.Delete(userUUID, id)
+	if err != nil {
+		log.Printf("Error deleting API token: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	}
+	h.notifyRevoked(APITokenLoginID(id))
+
+	log.Printf("API token %s revoked for user %s", id, userUUID)
+	h.auditLog.Record(r, userUUID, audit.EventAPITokenRevoked, map[string]string{"token_id": id.String()})
+	h.sendJSON(w, map[string]string{"status": "revoked"})
+}
+
+// APITokenLoginID is the ID connections opened with an API token are tracked under,
+// alongside the public IDs of browser sessions
+func APITokenLoginID(id uuid.UUID) string {
+	return "token:" + id.String()
+}
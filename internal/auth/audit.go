@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/amaranth494/MudPuppy/internal/audit"
+)
+
+// loginMethods names how a user logged in, by the endpoint that created the session
+// Sessions created elsewhere, such as the re-issue after an email change, are not logins.
+var loginMethods = map[string]string{
+	"/api/v1/login":                 "email_code",
+	"/api/v1/login/link":            "email_link",
+	"/api/v1/login/totp":            "two_factor",
+	"/api/v1/passkeys/login/finish": "passkey",
+	"/api/v1/oidc/callback":         "sso",
+}
+
+// SetAuditLog sets the log that logins, failed codes and credential changes are recorded in
+func (h *Handler) SetAuditLog(l *audit.Log) {
+	h.auditLog = l
+}
+
+// recordOTPFailure records a wrong email code against the account it was sent for, if there is one
+func (h *Handler) recordOTPFailure(r *http.Request, email string, err error) {
+	if h.auditLog == nil || (err != errOTPInvalid && err != errOTPLocked) {
+		return
+	}
+	user, _ := h.userStore.GetByEmail(email)
+	if user == nil {
+		return
+	}
+	details := map[string]string{"endpoint": r.URL.Path}
+	if err == errOTPLocked {
+		details["locked"] = "true"
+	}
+	h.auditLog.Record(r, user.ID, audit.EventOTPFailed, details)
+}
@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"errors"
+	"math"
+)
+
+// Minimal CBOR (RFC 8949) decoding for WebAuthn attestation objects and COSE keys.
+// Maps decode to map[interface{}]interface{} with int64 or string keys, integers to int64,
+// byte strings to []byte and text to string. Indefinite lengths are not used by authenticators
+// and are rejected, as are floats.
+
+// Decoding limits keep hostile input from allocating much
+const (
+	cborMaxDepth = 16
+	cborMaxItems = 1024
+)
+
+var errCBOR = errors.New("invalid CBOR")
+
+// decodeCBOR decodes one CBOR item and returns it with the number of bytes it used
+func decodeCBOR(data []byte) (interface{}, int, error) {
+	d := cborDecoder{data: data}
+	v, err := d.item(0)
+	if err != nil {
+		return nil, 0, err
+	}
+	return v, d.pos, nil
+}
+
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+// head reads an item's major type and argument
+func (d *cborDecoder) head() (byte, uint64, error) {
+	if d.pos >= len(d.data) {
+		return 0, 0, errCBOR
+	}
+	major := d.data[d.pos] >> 5
+	info := d.data[d.pos] & 0x1f
+	d.pos++
+
+	var size int
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		return 0, 0, errCBOR
+	}
+	if d.pos+size > len(d.data) {
+		return 0, 0, errCBOR
+	}
+	var arg uint64
+	for _, b := range d.data[d.pos : d.pos+size] {
+		arg = arg<<8 | uint64(b)
+	}
+	d.pos += size
+	return major, arg, nil
+}
+
+func (d *cborDecoder) item(depth int) (interface{}, error) {
+	if depth > cborMaxDepth {
+		return nil, errCBOR
+	}
+	start := d.pos
+	major, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case 0: // unsigned integer
+		if arg > math.MaxInt64 {
+			return nil, errCBOR
+		}
+		return int64(arg), nil
+	case 1: // negative integer
+		if arg > math.MaxInt64 {
+			return nil, errCBOR
+		}
+		return -1 - int64(arg), nil
+	case 2, 3: // byte and text strings
+		if arg > uint64(len(d.data)-d.pos) {
+			return nil, errCBOR
+		}
+		b := d.data[d.pos : d.pos+int(arg)]
+		d.pos += int(arg)
+		if major == 3 {
+			return string(b), nil
+		}
+		return append([]byte(nil), b...), nil
+	case 4: // array
+		if arg > cborMaxItems {
+			return nil, errCBOR
+		}
+		items := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			v, err := d.item(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, v)
+		}
+		return items, nil
+	case 5: // map
+		if arg > cborMaxItems {
+			return nil, errCBOR
+		}
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			k, err := d.item(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, errCBOR
+			}
+			if _, dup := m[k]; dup {
+				return nil, errCBOR
+			}
+			v, err := d.item(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = v
+		}
+		return m, nil
+	case 6: // tag; the tagged item is returned as is
+		return d.item(depth + 1)
+	default: // simple values
+		info := d.data[start] & 0x1f
+		switch {
+		case info == 20:
+			return false, nil
+		case info == 21:
+			return true, nil
+		case info == 22, info == 23:
+			return nil, nil
+		}
+		// Floats do not appear in WebAuthn structures
+		return nil, errCBOR
+	}
+}
@@ -0,0 +1,373 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amaranth494/MudPuppy/internal/audit"
+	"github.com/amaranth494/MudPuppy/internal/redis"
+	"github.com/google/uuid"
+)
+
+// EmailRevertDays is how long the old address can undo an email change
+const EmailRevertDays = 7
+
+// Request/Response types for email changes
+
+type ChangeEmailRequest struct {
+	Email string `json:"email"`
+}
+
+type ConfirmEmailChangeRequest struct {
+	OTP string `json:"otp"`
+	// Keep other logins signed in; otherwise they are logged out and this one is given a new session
+	KeepSessions bool `json:"keep_sessions"`
+}
+
+type ConfirmEmailChangeResponse struct {
+	Email            string `json:"email"`
+	SessionsReissued bool   `json:"sessions_reissued"`
+}
+
+// RequestEmailChange handles POST /api/v1/account/email
+// Sends a code to the new address; the change is made once it is entered at /api/v1/account/email/confirm.
+func (h *Handler) RequestEmailChange(w http.ResponseWriter, r *http.Request) {
+	userUUID, ok := h.requestUserID(w, r)
+	if !ok {
+		return
+	}
+	var req ChangeEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	newEmail := strings.ToLower(strings.TrimSpace(req.Email))
+	if newEmail == "" || !strings.Contains(newEmail, "@") {
+		http.Error(w, "Invalid email address", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.userStore.GetByID(userUUID)
+	if err != nil {
+		log.Printf("Error getting user: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if newEmail == user.Email {
+		http.Error(w, "That is already your email address", http.StatusBadRequest)
+		return
+	}
+
+	exists, err := h.userStore.EmailExists(newEmail)
+	if err == nil && !exists {
+		exists, err = h.emailChangeStore.Reserved(newEmail)
+	}
+	if err != nil {
+		log.Printf("Error checking email: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if exists {
+		http.Error(w, "That email address is already in use", http.StatusConflict)
+		return
+	}
+
+	ctx := context.Background()
+	_, err = h.redisClient.CheckRateLimit(ctx, "otp", newEmail, 100, redis.OTPRateLimitTTL)
+	if err == redis.ErrRateLimited {
+		log.Printf("ABUSE: OTP rate limit exceeded for email hash, endpoint: %s, timestamp: %s",
+			r.URL.Path, time.Now().UTC().Format(time.RFC3339))
+		http.Error(w, "Too many OTP requests. Please try again later.", http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		log.Printf("Error checking rate limit: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	otp, err := generateOTP()
+	if err != nil {
+		log.Printf("Error generating OTP: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := h.emailChangeStore.Create(user.ID, user.Email, newEmail, h.hashOTP(newEmail, otp), time.Now().Add(h.otpExpiry)); err != nil {
+		log.Printf("Error storing email change: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if h.emailSender != nil && h.emailSender.IsConfigured() {
+		if err := h.emailSender.SendEmailChangeCode(newEmail, otp); err != nil {
+			log.Printf("Failed to send email change code: %v", err)
+			http.Error(w, "Service unavailable. Please try again later.", http.StatusServiceUnavailable)
+			return
+		}
+	} else if h.devLogOTPCodes {
+		log.Printf("DEV MODE - Email change code for %s: %s", newEmail, otp)
+	} else {
+		log.Printf("DEV MODE - SMTP not configured, email change code for %s was not sent (set DEV_LOG_OTP_CODES=true to log it)", newEmail)
+	}
+
+	log.Printf("Email change requested for user %s", user.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"status": "code_sent"})
+}
+
+// ConfirmEmailChange handles POST /api/v1/account/email/confirm
+// Checks the code sent to the new address and makes the change. The old address is told about it
+// and given a link to undo it for EmailRevertDays days.
+func (h *Handler) ConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	userUUID, ok := h.requestUserID(w, r)
+	if !ok {
+		return
+	}
+	var req ConfirmEmailChangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	change, err := h.emailChangeStore.StartAttempt(userUUID, MaxOTPAttempts)
+	if err != nil {
+		log.Printf("Error getting email change: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if change == nil {
+		http.Error(w, "Invalid or expired OTP", http.StatusUnauthorized)
+		return
+	}
+	if !hmac.Equal([]byte(h.hashOTP(change.NewEmail, req.OTP)), []byte(change.OTPHash)) {
+		h.auditLog.Record(r, userUUID, audit.EventOTPFailed, map[string]string{"endpoint": r.URL.Path})
+		if change.Attempts >= MaxOTPAttempts {
+			log.Printf("ABUSE: Email change attempt limit reached, change invalidated, timestamp: %s",
+				time.Now().UTC().Format(time.RFC3339))
+			http.Error(w, "Too many incorrect codes. Please request a new code.", http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, "Invalid OTP", http.StatusUnauthorized)
+		return
+	}
+
+	revertToken, err := generateSessionID()
+	if err != nil {
+		log.Printf("Error generating revert token: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	confirmed, err := h.emailChangeStore.Confirm(change, hashToken(revertToken), time.Now().AddDate(0, 0, EmailRevertDays), MaxOTPAttempts)
+	if err != nil {
+		log.Printf("Error changing email: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !confirmed {
+		http.Error(w, "That email address is already in use", http.StatusConflict)
+		return
+	}
+	// Login codes for the old address must not log into the account any more
+	if err := h.otpStore.DeleteByEmail(change.OldEmail); err != nil {
+		log.Printf("Error deleting OTP challenges: %v", err)
+	}
+	log.Printf("Email changed for user %s", userUUID)
+	h.auditLog.Record(r, userUUID, audit.EventEmailChanged, map[string]string{"old_email": change.OldEmail, "new_email": change.NewEmail})
+
+	link := ""
+	if h.appBaseURL != "" {
+		link = h.appBaseURL + "/api/v1/email/revert?token=" + url.QueryEscape(revertToken)
+	}
+	if h.emailSender != nil && h.emailSender.IsConfigured() {
+		// The change is made, so a failure to notify is logged rather than reported
+		if err := h.emailSender.SendEmailChanged(change.OldEmail, change.NewEmail, link, EmailRevertDays); err != nil {
+			log.Printf("Failed to send email change notice: %v", err)
+		}
+	} else if h.devLogOTPCodes {
+		log.Printf("DEV MODE - Email change revert link for %s: %s", change.OldEmail, link)
+	}
+
+	resp := ConfirmEmailChangeResponse{Email: change.NewEmail}
+	if !req.KeepSessions {
+		user, err := h.userStore.GetByID(userUUID)
+		if err != nil || user == nil {
+			log.Printf("Error getting user: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if err := h.logOutEverywhere(userUUID); err != nil {
+			log.Printf("Error revoking sessions: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if !h.createSession(w, r, user) {
+			return
+		}
+		resp.SessionsReissued = true
+	}
+	h.sendJSON(w, resp)
+}
+
+// revertConfirmPage asks the old address's owner to confirm before the change is undone
+// Link scanners and previews follow the emailed link with GET, so only the form's POST reverts.
+var revertConfirmPage = template.Must(template.New("revert").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Undo email change - MUDPuppy</title>
+<style>
+body { margin: 0; padding: 48px 16px; background: #1a1a1a; color: #e0e0e0; font: 16px sans-serif; }
+main { max-width: 420px; margin: 0 auto; }
+button { padding: 10px 20px; border: 0; border-radius: 4px; background: #c0392b; color: #ffffff; font: inherit; cursor: pointer; }
+</style>
+</head>
+<body>
+<main>
+<h1>Undo email change</h1>
+<p>Your account's email address was changed. If you did not make this change, undo it to move the account back to this address. Every login will be signed out.</p>
+<form method="post" action="/api/v1/email/revert">
+<input type="hidden" name="token" value="{{.}}">
+<button type="submit">Undo the change</button>
+</form>
+</main>
+</body>
+</html>
+`))
+
+// RevertEmailChangePage handles GET /api/v1/email/revert?token=
+// Opened from the notice sent to the old address: shows a page whose button posts the token to RevertEmailChange.
+func (h *Handler) RevertEmailChangePage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		http.Error(w, "This link is invalid or has expired.", http.StatusBadRequest)
+		return
+	}
+
+	// The token is in the URL, so keep it out of caches and Referer headers
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	if err := revertConfirmPage.Execute(w, token); err != nil {
+		log.Printf("Error rendering revert page: %v", err)
+	}
+}
+
+// RevertEmailChange handles POST /api/v1/email/revert with the form field token
+// Restores the old address, logs out every login and redirects to /login.
+func (h *Handler) RevertEmailChange(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	clientIP := getClientIP(r)
+	_, err := h.redisClient.CheckRateLimit(context.Background(), "login", clientIP, 10, redis.LoginRateLimitTTL)
+	if err == redis.ErrRateLimited {
+		log.Printf("ABUSE: Login rate limit exceeded for IP: %s, endpoint: %s, timestamp: %s",
+			clientIP, r.URL.Path, time.Now().UTC().Format(time.RFC3339))
+		http.Error(w, "Too many attempts. Please try again later.", http.StatusTooManyRequests)
+		return
+	}
+
+	token := r.PostFormValue("token")
+	if token == "" {
+		http.Error(w, "This link is invalid or has expired.", http.StatusBadRequest)
+		return
+	}
+	change, reverted, err := h.emailChangeStore.Revert(hashToken(token))
+	if err != nil {
+		log.Printf("Error reverting email change: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if change == nil {
+		http.Error(w, "This link is invalid, has expired or was already used.", http.StatusBadRequest)
+		return
+	}
+	if !reverted {
+		http.Error(w, "Your old email address now belongs to another account, so the change cannot be undone. Please contact support.", http.StatusConflict)
+		return
+	}
+
+	// Whoever made the change may still be logged in, or hold an API token
+	if err := h.logOutEverywhere(change.UserID); err != nil {
+		log.Printf("Error revoking sessions: %v", err)
+	}
+	tokenIDs, err := h.apiTokenStore.DeleteByUser(change.UserID)
+	if err != nil {
+		log.Printf("Error revoking API tokens: %v", err)
+	}
+	loginIDs := make([]string, 0, len(tokenIDs))
+	for _, id := range tokenIDs {
+		loginIDs = append(loginIDs, APITokenLoginID(id))
+	}
+	h.notifyRevoked(loginIDs...)
+	if err := h.otpStore.DeleteByEmail(change.NewEmail); err != nil {
+		log.Printf("Error deleting OTP challenges: %v", err)
+	}
+
+	// Passkeys and linked identities would still log in, so the owner is told which ones are new
+	details := map[string]string{
+		"old_email":          change.NewEmail,
+		"new_email":          change.OldEmail,
+		"api_tokens_revoked": strconv.Itoa(len(tokenIDs)),
+	}
+	passkeys, err := h.passkeyStore.ListCreatedSince(change.UserID, change.CreatedAt)
+	if err != nil {
+		log.Printf("Error listing passkeys: %v", err)
+	}
+	passkeyNames := make([]string, 0, len(passkeys))
+	for _, p := range passkeys {
+		passkeyNames = append(passkeyNames, p.Name)
+	}
+	if len(passkeyNames) > 0 {
+		details["passkeys_added"] = strings.Join(passkeyNames, ", ")
+	}
+	identities, err := h.identityStore.ListCreatedSince(change.UserID, change.CreatedAt)
+	if err != nil {
+		log.Printf("Error listing identities: %v", err)
+	}
+	identityNames := make([]string, 0, len(identities))
+	for _, i := range identities {
+		identityNames = append(identityNames, i.Issuer+" ("+i.Email+")")
+	}
+	if len(identityNames) > 0 {
+		details["identities_linked"] = strings.Join(identityNames, ", ")
+	}
+
+	log.Printf("Email change reverted for user %s", change.UserID)
+	h.auditLog.Record(r, change.UserID, audit.EventEmailReverted, details)
+	if h.emailSender != nil && h.emailSender.IsConfigured() {
+		if err := h.emailSender.SendEmailReverted(change.OldEmail, passkeyNames, identityNames); err != nil {
+			log.Printf("Failed to send email revert notice: %v", err)
+		}
+	}
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// logOutEverywhere ends all of a user's sessions and closes the connections opened with them
+func (h *Handler) logOutEverywhere(userID uuid.UUID) error {
+	revoked, err := h.redisClient.RevokeUserSessions(context.Background(), userID.String(), "")
+	h.notifyRevoked(revoked...)
+	return err
+}
@@ -11,7 +11,9 @@ import (
 	"strings"
 	"time"
 
+	"github.com/amaranth494/MudPuppy/internal/audit"
 	"github.com/amaranth494/MudPuppy/internal/config"
+	"github.com/amaranth494/MudPuppy/internal/crypto"
 	"github.com/amaranth494/MudPuppy/internal/email"
 	"github.com/amaranth494/MudPuppy/internal/redis"
 	"github.com/amaranth494/MudPuppy/internal/store"
@@ -20,24 +22,63 @@ import (
 
 // Handler handles authentication HTTP requests
 type Handler struct {
-	userStore     *store.UserStore
-	redisClient   *redis.Client
-	emailSender   *email.Sender
-	sessionSecret string
+	userStore        *store.UserStore
+	passkeyStore     *store.PasskeyStore
+	otpStore         *store.OTPChallengeStore
+	totpStore        *store.TOTPStore
+	apiTokenStore    *store.APITokenStore
+	identityStore    *store.IdentityStore
+	emailChangeStore *store.EmailChangeStore
+	keyStore         *crypto.KeyStore
+	redisClient      *redis.Client
+	emailSender      *email.Sender
+	sessionSecret    string
+	otpExpiry        time.Duration
+	devLogOTPCodes   bool
+	appBaseURL       string
+	rp               *RelyingParty
+	oidc             *OIDCProvider
+	auditLog         *audit.Log
+	onRevoke         func(sessionIDs []string)
+	onAccountDeleted func(userID string)
 }
 
 // NewHandler creates a new auth handler
-func NewHandler(userStore *store.UserStore, redisClient *redis.Client, cfg *config.Config) *Handler {
+// TOTP secrets are encrypted with keyStore, the same keys that protect saved MUD passwords
+func NewHandler(userStore *store.UserStore, otpStore *store.OTPChallengeStore, passkeyStore *store.PasskeyStore, totpStore *store.TOTPStore, apiTokenStore *store.APITokenStore, identityStore *store.IdentityStore, emailChangeStore *store.EmailChangeStore, keyStore *crypto.KeyStore, redisClient *redis.Client, cfg *config.Config) *Handler {
 	var emailSender *email.Sender
 	if cfg.SMTPHost != "" && cfg.SMTPUser != "" && cfg.SMTPPass != "" {
 		emailSender = email.NewSender(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUser, cfg.SMTPPass, cfg.EmailFromAddress)
 	}
 
 	return &Handler{
-		userStore:     userStore,
-		redisClient:   redisClient,
-		emailSender:   emailSender,
-		sessionSecret: cfg.SessionSecret,
+		userStore:        userStore,
+		otpStore:         otpStore,
+		passkeyStore:     passkeyStore,
+		totpStore:        totpStore,
+		apiTokenStore:    apiTokenStore,
+		identityStore:    identityStore,
+		emailChangeStore: emailChangeStore,
+		keyStore:         keyStore,
+		redisClient:      redisClient,
+		emailSender:      emailSender,
+		sessionSecret:    cfg.SessionSecret,
+		otpExpiry:        time.Duration(cfg.OTPExpiryMinutes) * time.Minute,
+		devLogOTPCodes:   cfg.DevLogOTPCodes,
+		appBaseURL:       cfg.AppBaseURL,
+		rp: &RelyingParty{
+			ID:      cfg.WebAuthnRPID,
+			Name:    cfg.WebAuthnRPName,
+			Origins: cfg.WebAuthnOrigins,
+		},
+		oidc: &OIDCProvider{
+			Issuer:       cfg.OIDCIssuer,
+			ClientID:     cfg.OIDCClientID,
+			ClientSecret: cfg.OIDCClientSecret,
+			RedirectURL:  cfg.OIDCRedirectURL,
+			Scopes:       cfg.OIDCScopes,
+			Name:         cfg.OIDCProviderName,
+		},
 	}
 }
 
@@ -57,6 +98,10 @@ type LoginRequest struct {
 
 type LoginResponse struct {
 	SessionToken string `json:"session_token"`
+
+	// Set instead of a session when the user must also enter a TOTP code
+	MFARequired bool   `json:"mfa_required,omitempty"`
+	MFAToken    string `json:"mfa_token,omitempty"`
 }
 
 type LogoutResponse struct {
@@ -132,6 +177,18 @@ func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// An address recently changed away from stays with its account while the change can be undone
+	reserved, err := h.emailChangeStore.Reserved(email)
+	if err != nil {
+		log.Printf("Error checking email: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if reserved {
+		http.Error(w, "This email address cannot be registered right now. Please try again later.", http.StatusConflict)
+		return
+	}
+
 	// Create new user
 	_, err = h.userStore.Create(email)
 	if err != nil {
@@ -153,37 +210,10 @@ func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Error checking rate limit: %v", err)
 	}
 
-	// Generate OTP
-	otp, err := generateOTP()
-	if err != nil {
-		log.Printf("Error generating OTP: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-
-	// Store OTP in Redis (15 min TTL)
-	if err := h.redisClient.StoreOTP(ctx, email, otp); err != nil {
-		log.Printf("Error storing OTP: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	if !h.issueOTP(w, email) {
 		return
 	}
 
-	// Send OTP via email
-	if h.emailSender != nil && h.emailSender.IsConfigured() {
-		if err := h.emailSender.SendOTP(email, otp); err != nil {
-			// If SMTP fails, delete the OTP from Redis and return 503
-			h.redisClient.CheckRateLimit(ctx, "otp", email, 100, redis.OTPRateLimitTTL) // Just to reset, error ignored
-			log.Printf("Failed to send OTP email: %v", err)
-			http.Error(w, "Service unavailable. Please try again later.", http.StatusServiceUnavailable)
-			return
-		}
-		// Log OTP for staging verification (without PII)
-		log.Printf("STAGING: OTP sent to user, code: %s", otp)
-	} else {
-		// In development, log the OTP
-		log.Printf("DEV MODE - OTP for %s: %s", email, otp)
-	}
-
 	// Return success
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -242,37 +272,10 @@ func (h *Handler) SendOTP(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Error checking rate limit: %v", err)
 	}
 
-	// Generate OTP
-	otp, err := generateOTP()
-	if err != nil {
-		log.Printf("Error generating OTP: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	if !h.issueOTP(w, email) {
 		return
 	}
 
-	// Store OTP in Redis (15 min TTL)
-	if err := h.redisClient.StoreOTP(ctx, email, otp); err != nil {
-		log.Printf("Error storing OTP: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-
-	// Send OTP via email
-	if h.emailSender != nil && h.emailSender.IsConfigured() {
-		if err := h.emailSender.SendOTP(email, otp); err != nil {
-			// If SMTP fails, delete the OTP from Redis and return 503
-			h.redisClient.CheckRateLimit(ctx, "otp", email, 100, redis.OTPRateLimitTTL) // Just to reset, error ignored
-			log.Printf("Failed to send OTP email: %v", err)
-			http.Error(w, "Service unavailable. Please try again later.", http.StatusServiceUnavailable)
-			return
-		}
-		// Log OTP for staging verification (without PII)
-		log.Printf("STAGING: OTP sent to user, code: %s", otp)
-	} else {
-		// In development, log the OTP
-		log.Printf("DEV MODE - OTP for %s: %s", email, otp)
-	}
-
 	// Return success
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -317,16 +320,21 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Verify OTP
-	err = h.redisClient.VerifyOTP(ctx, email, otp)
+	err = h.verifyOTP(email, otp)
 	if err != nil {
-		if err == redis.ErrOTPNotFound {
+		h.recordOTPFailure(r, email, err)
+		if err == errOTPNotFound {
 			http.Error(w, "Invalid or expired OTP", http.StatusUnauthorized)
 			return
 		}
-		if err.Error() == "invalid otp" {
+		if err == errOTPInvalid {
 			http.Error(w, "Invalid OTP", http.StatusUnauthorized)
 			return
 		}
+		if err == errOTPLocked {
+			http.Error(w, "Too many incorrect codes. Please request a new code.", http.StatusUnauthorized)
+			return
+		}
 		log.Printf("Error verifying OTP: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
@@ -344,12 +352,34 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	h.completeLogin(w, r, user)
+}
+
+// startSession logs a verified user in and writes the login response
+func (h *Handler) startSession(w http.ResponseWriter, r *http.Request, user *store.User) {
+	if !h.createSession(w, r, user) {
+		return
+	}
+
+	// Return success (no token in body)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(LoginResponse{
+		SessionToken: "", // Token is in cookie, not body
+	})
+}
+
+// createSession stores a new session in Redis and sets the session cookie
+// On failure it writes the error response and returns false.
+func (h *Handler) createSession(w http.ResponseWriter, r *http.Request, user *store.User) bool {
+	ctx := context.Background()
+
 	// Generate session ID
 	sessionID, err := generateSessionID()
 	if err != nil {
 		log.Printf("Error generating session ID: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+		return false
 	}
 
 	// Store session in Redis
@@ -357,16 +387,21 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 		UserID:    user.ID.String(),
 		Email:     user.Email,
 		CreatedAt: time.Now(),
+		UserAgent: truncateUserAgent(r.UserAgent()),
+		IP:        getClientIP(r),
 	}
 
 	if err := h.redisClient.StoreSession(ctx, sessionID, sessionData); err != nil {
 		log.Printf("Error storing session: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+		return false
 	}
 
 	// Log successful login (without sensitive data)
-	log.Printf("User %s logged in successfully", email)
+	log.Printf("User %s logged in successfully", user.Email)
+	if method, ok := loginMethods[r.URL.Path]; ok {
+		h.auditLog.Record(r, user.ID, audit.EventLogin, map[string]string{"method": method})
+	}
 
 	// Set session cookie (HTTP-only, secure, samesite lax, 24h max age)
 	http.SetCookie(w, &http.Cookie{
@@ -378,13 +413,7 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 		SameSite: http.SameSiteLaxMode,
 		MaxAge:   86400, // 24 hours - matches session hard cap
 	})
-
-	// Return success (no token in body)
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(LoginResponse{
-		SessionToken: "", // Token is in cookie, not body
-	})
+	return true
 }
 
 // Logout handles DELETE /api/v1/logout
@@ -402,6 +431,9 @@ func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
 
 	ctx := context.Background()
 
+	// Note whose session it is before it is gone, for the audit log
+	userID, _ := h.redisClient.GetSession(ctx, sessionID)
+
 	// Delete session from Redis
 	if err := h.redisClient.DeleteSession(ctx, sessionID); err != nil {
 		log.Printf("Error deleting session: %v", err)
@@ -409,18 +441,15 @@ func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	h.notifyRevoked(redis.SessionPublicID(sessionID))
+
 	// Clear the session cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session_token",
-		Value:    "",
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteLaxMode,
-		MaxAge:   -1,
-	})
+	clearSessionCookie(w)
 
 	log.Printf("User logged out, session: %s", sessionID[:8]+"...")
+	if userUUID, err := uuid.Parse(userID); err == nil {
+		h.auditLog.Record(r, userUUID, audit.EventLogout, nil)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -547,17 +576,5 @@ func getSessionToken(r *http.Request) string {
 
 // getClientIP gets the client IP from request
 func getClientIP(r *http.Request) string {
-	// Check X-Forwarded-For header first (for reverse proxy)
-	xff := r.Header.Get("X-Forwarded-For")
-	if xff != "" {
-		ips := strings.Split(xff, ",")
-		return strings.TrimSpace(ips[0])
-	}
-
-	// Fall back to RemoteAddr
-	ip := r.RemoteAddr
-	if idx := strings.LastIndex(ip, ":"); idx != -1 {
-		ip = ip[:idx]
-	}
-	return ip
+	return audit.ClientIP(r)
 }
@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amaranth494/MudPuppy/internal/redis"
+	"github.com/amaranth494/MudPuppy/internal/store"
+	"github.com/google/uuid"
+)
+
+// loginRequestCookie holds a random nonce identifying the browser that asked for a login email
+// Login links are signed over it, so a link only works in that browser.
+const loginRequestCookie = "login_request"
+
+// Login link errors shown to the user, who arrives from their email client
+const (
+	loginLinkInvalid      = "This login link is invalid or has expired. Please request a new code."
+	loginLinkOtherBrowser = "This login link only works in the browser where you asked to log in. Enter the code from the email instead."
+)
+
+// newLoginLink binds an OTP challenge to the requesting browser and returns a login link for it
+// It sets the login request cookie, so it must be called before the response is written.
+func (h *Handler) newLoginLink(w http.ResponseWriter, challengeID uuid.UUID) (string, error) {
+	nonce, err := generateSessionID()
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     loginRequestCookie,
+		Value:    nonce,
+		Path:     "/api/v1/login",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode, // Sent when the link is opened from an email client
+		MaxAge:   int(h.otpExpiry.Seconds()),
+	})
+
+	expires := time.Now().Add(h.otpExpiry).Unix()
+	token := challengeID.String() + "." + strconv.FormatInt(expires, 10) + "." + h.signLoginLink(challengeID, expires, nonce)
+	return h.appBaseURL + "/api/v1/login/link?token=" + url.QueryEscape(token), nil
+}
+
+// signLoginLink signs a login link with the session secret, over the challenge, expiry and browser nonce
+func (h *Handler) signLoginLink(challengeID uuid.UUID, expires int64, nonce string) string {
+	mac := hmac.New(sha256.New, []byte(h.sessionSecret))
+	mac.Write([]byte("login-link:" + challengeID.String() + ":" + strconv.FormatInt(expires, 10) + ":" + nonce))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// LoginLink handles GET /api/v1/login/link
+// Logs in from an emailed link and redirects to the app; users with TOTP are sent to /login?mfa=1 to finish.
+// Using the link also uses up the code sent with it, and requesting a new code invalidates the link.
+func (h *Handler) LoginLink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	clientIP := getClientIP(r)
+	_, err := h.redisClient.CheckRateLimit(context.Background(), "login", clientIP, 10, redis.LoginRateLimitTTL)
+	if err == redis.ErrRateLimited {
+		log.Printf("ABUSE: Login rate limit exceeded for IP: %s, endpoint: %s, timestamp: %s",
+			clientIP, r.URL.Path, time.Now().UTC().Format(time.RFC3339))
+		http.Error(w, "Too many login attempts. Please try again later.", http.StatusTooManyRequests)
+		return
+	}
+
+	parts := strings.Split(r.URL.Query().Get("token"), ".")
+	if len(parts) != 3 {
+		http.Error(w, loginLinkInvalid, http.StatusBadRequest)
+		return
+	}
+	challengeID, err := uuid.Parse(parts[0])
+	if err != nil {
+		http.Error(w, loginLinkInvalid, http.StatusBadRequest)
+		return
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		http.Error(w, loginLinkInvalid, http.StatusUnauthorized)
+		return
+	}
+
+	cookie, err := r.Cookie(loginRequestCookie)
+	if err != nil || cookie.Value == "" {
+		http.Error(w, loginLinkOtherBrowser, http.StatusUnauthorized)
+		return
+	}
+	if !hmac.Equal([]byte(h.signLoginLink(challengeID, expires, cookie.Value)), []byte(parts[2])) {
+		// A newer login request in this browser replaces the cookie, which also lands here
+		http.Error(w, loginLinkOtherBrowser, http.StatusUnauthorized)
+		return
+	}
+
+	email, err := h.otpStore.ConsumeActive(challengeID, MaxOTPAttempts)
+	if err != nil {
+		log.Printf("Error using login link: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if email == "" {
+		http.Error(w, loginLinkInvalid, http.StatusUnauthorized)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     loginRequestCookie,
+		Value:    "",
+		Path:     "/api/v1/login",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   -1,
+	})
+
+	user, err := h.userStore.GetByEmail(email)
+	if err != nil {
+		log.Printf("Error getting user: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+	h.redirectLogin(w, r, user)
+}
+
+// redirectLogin finishes a login that arrived as a browser navigation rather than an API call
+// It starts a session and redirects to the app, or sends users with TOTP to /login?mfa=1 to enter a code.
+// Their MFA token goes in mfaCookie, not the URL, so it does not reach history, logs or Referer headers.
+func (h *Handler) redirectLogin(w http.ResponseWriter, r *http.Request, user *store.User) {
+	enabled, err := h.totpStore.IsEnabled(user.ID)
+	if err != nil {
+		log.Printf("Error checking TOTP: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if enabled {
+		token, err := h.newMFAToken(user)
+		if err != nil {
+			log.Printf("Error storing MFA token: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		setMFACookie(w, token, int(redis.MFATicketTTL.Seconds()))
+		http.Redirect(w, r, "/login?mfa=1", http.StatusSeeOther)
+		return
+	}
+
+	if !h.createSession(w, r, user) {
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
@@ -0,0 +1,473 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OIDC client settings
+const (
+	oidcDiscoveryTTL   = time.Hour        // How long provider metadata is cached
+	oidcKeysMinRefresh = time.Minute      // Unknown key IDs refetch the JWKS at most this often
+	oidcClockSkew      = 2 * time.Minute  // Allowed clock difference when checking token times
+	maxOIDCResponse    = 1 << 20          // Largest discovery, JWKS or token response read
+	oidcHTTPTimeout    = 10 * time.Second // Timeout for requests to the provider
+)
+
+var (
+	// ErrOIDCProvider indicates the provider could not be reached or returned something unusable
+	ErrOIDCProvider = errors.New("oidc provider error")
+	// ErrOIDCIDToken indicates the ID token failed validation
+	ErrOIDCIDToken = errors.New("invalid id token")
+)
+
+// OIDCProvider is a generic OpenID Connect provider users can log in with
+// Endpoints and signing keys are discovered from the issuer, so any standard provider works, including a local mock issuer.
+type OIDCProvider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string // Empty for public clients, which rely on PKCE alone
+	RedirectURL  string
+	Scopes       []string
+	Name         string // Shown on the login button
+	HTTPClient   *http.Client
+
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	discoveredAt  time.Time
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+}
+
+// Enabled reports whether single sign-on is configured
+func (p *OIDCProvider) Enabled() bool {
+	return p != nil && p.Issuer != "" && p.ClientID != "" && p.RedirectURL != ""
+}
+
+// oidcDiscovery is the subset of the provider metadata document the login flow needs
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// IDTokenClaims are the ID token claims used to identify and look up a user
+type IDTokenClaims struct {
+	Issuer          string       `json:"iss"`
+	Subject         string       `json:"sub"`
+	Audience        oidcAudience `json:"aud"`
+	AuthorizedParty string       `json:"azp"`
+	Expiry          float64      `json:"exp"`
+	IssuedAt        float64      `json:"iat"`
+	Nonce           string       `json:"nonce"`
+	Email           string       `json:"email"`
+	EmailVerified   oidcBool     `json:"email_verified"`
+	Name            string       `json:"name"`
+}
+
+// oidcAudience accepts the aud claim as a single string or a list
+type oidcAudience []string
+
+func (a *oidcAudience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = oidcAudience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// oidcBool accepts true/false or "true"/"false", since some providers send email_verified as a string
+type oidcBool bool
+
+func (b *oidcBool) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	*b = oidcBool(s == "true")
+	return nil
+}
+
+// NewPKCE returns a PKCE code verifier and its S256 challenge (RFC 7636)
+func NewPKCE() (verifier, challenge string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	verifier = base64.RawURLEncoding.EncodeToString(buf)
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// AuthCodeURL returns the provider URL to send the browser to, for the authorization code flow with PKCE
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.ClientID)
+	query.Set("redirect_uri", p.RedirectURL)
+	query.Set("scope", strings.Join(p.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge)
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return d.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange redeems an authorization code at the token endpoint and returns the raw ID token
+func (p *OIDCProvider) Exchange(ctx context.Context, code, codeVerifier string) (string, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("code_verifier", codeVerifier)
+	if p.ClientSecret == "" {
+		form.Set("client_id", p.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		// client_secret_basic; both parts are form-encoded first (RFC 6749 section 2.3.1)
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	resp, err := p.httpClient().Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: token request: %v", ErrOIDCProvider, err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxOIDCResponse)).Decode(&body); err != nil {
+		return "", fmt.Errorf("%w: token response: %v", ErrOIDCProvider, err)
+	}
+	if resp.StatusCode != http.StatusOK || body.Error != "" {
+		return "", fmt.Errorf("%w: token request failed: %s %s", ErrOIDCProvider, body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", fmt.Errorf("%w: no id_token in token response", ErrOIDCProvider)
+	}
+	return body.IDToken, nil
+}
+
+// VerifyIDToken checks an ID token's signature against the provider's JWKS and validates its claims
+// (issuer, audience, expiry and nonce). Only RS256 and ES256 signatures are accepted.
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, raw, nonce string) (*IDTokenClaims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrOIDCIDToken)
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed header", ErrOIDCIDToken)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, fmt.Errorf("%w: malformed header", ErrOIDCIDToken)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrOIDCIDToken)
+	}
+
+	key, err := p.signingKey(ctx, header.Kid, header.Alg)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWS(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed payload", ErrOIDCIDToken)
+	}
+	var claims IDTokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("%w: malformed claims", ErrOIDCIDToken)
+	}
+
+	now := time.Now()
+	switch {
+	case claims.Issuer != p.Issuer:
+		return nil, fmt.Errorf("%w: wrong issuer %q", ErrOIDCIDToken, claims.Issuer)
+	case !slices.Contains(claims.Audience, p.ClientID):
+		return nil, fmt.Errorf("%w: not issued for this client", ErrOIDCIDToken)
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != p.ClientID:
+		return nil, fmt.Errorf("%w: wrong authorized party", ErrOIDCIDToken)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: no subject", ErrOIDCIDToken)
+	case now.After(time.Unix(int64(claims.Expiry), 0).Add(oidcClockSkew)):
+		return nil, fmt.Errorf("%w: expired", ErrOIDCIDToken)
+	case time.Unix(int64(claims.IssuedAt), 0).After(now.Add(oidcClockSkew)):
+		return nil, fmt.Errorf("%w: issued in the future", ErrOIDCIDToken)
+	case claims.Nonce != nonce:
+		return nil, fmt.Errorf("%w: wrong nonce", ErrOIDCIDToken)
+	}
+	return &claims, nil
+}
+
+// discover loads the provider metadata from {issuer}/.well-known/openid-configuration, cached for an hour
+// The document is fetched without holding the lock, so a slow provider does not stall logins using the cache.
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	if p.discovery != nil && time.Since(p.discoveredAt) < oidcDiscoveryTTL {
+		d := p.discovery
+		p.mu.Unlock()
+		return d, nil
+	}
+	p.mu.Unlock()
+
+	var d oidcDiscovery
+	if err := p.getJSON(ctx, strings.TrimSuffix(p.Issuer, "/")+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, err
+	}
+	// The metadata must be for the configured issuer, or tokens could be accepted from another one
+	if d.Issuer != p.Issuer {
+		return nil, fmt.Errorf("%w: discovery issuer %q does not match %q", ErrOIDCProvider, d.Issuer, p.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: discovery document is missing endpoints", ErrOIDCProvider)
+	}
+
+	p.mu.Lock()
+	p.discovery = &d
+	p.discoveredAt = time.Now()
+	p.mu.Unlock()
+	return &d, nil
+}
+
+// signingKey returns the JWKS key for a token, refetching the JWKS when the key ID is unknown (keys rotate)
+// The refetch happens without holding the lock and its result is swapped in afterwards.
+func (p *OIDCProvider) signingKey(ctx context.Context, kid, alg string) (crypto.PublicKey, error) {
+	if alg != "RS256" && alg != "ES256" {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrOIDCIDToken, alg)
+	}
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	key, ok := p.findKey(kid, alg)
+	lastFetch := p.keysFetchedAt
+	refresh := !ok && time.Since(lastFetch) >= oidcKeysMinRefresh
+	if refresh {
+		// Claim the refresh so concurrent tokens with unknown key IDs do not all fetch
+		p.keysFetchedAt = time.Now()
+	}
+	p.mu.Unlock()
+
+	if refresh {
+		keys, err := p.fetchKeys(ctx, d.JWKSURI)
+		p.mu.Lock()
+		if err != nil {
+			// A failed fetch does not hold off the next one
+			p.keysFetchedAt = lastFetch
+			p.mu.Unlock()
+			return nil, err
+		}
+		p.keys = keys
+		p.keysFetchedAt = time.Now()
+		key, ok = p.findKey(kid, alg)
+		p.mu.Unlock()
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown signing key %q", ErrOIDCIDToken, kid)
+	}
+	return key, nil
+}
+
+// fetchKeys downloads the provider's JWKS and returns its signing keys by key ID
+func (p *OIDCProvider) fetchKeys(ctx context.Context, jwksURI string) (map[string]crypto.PublicKey, error) {
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := p.getJSON(ctx, jwksURI, &jwks); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if pub, err := k.publicKey(); err == nil {
+			keys[k.Kid] = pub
+		}
+	}
+	return keys, nil
+}
+
+// findKey looks up a cached key; without a key ID, a provider's only key of the right type is used
+// The caller must hold p.mu.
+func (p *OIDCProvider) findKey(kid, alg string) (crypto.PublicKey, bool) {
+	if kid != "" {
+		key, ok := p.keys[kid]
+		return key, ok
+	}
+	var found crypto.PublicKey
+	for _, key := range p.keys {
+		if keyMatchesAlg(key, alg) {
+			if found != nil {
+				return nil, false
+			}
+			found = key
+		}
+	}
+	return found, found != nil
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, rawURL string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrOIDCProvider, err)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.httpClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrOIDCProvider, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s returned %s", ErrOIDCProvider, rawURL, resp.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxOIDCResponse)).Decode(v); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrOIDCProvider, rawURL, err)
+	}
+	return nil
+}
+
+func (p *OIDCProvider) httpClient() *http.Client {
+	if p.HTTPClient != nil {
+		return p.HTTPClient
+	}
+	return &http.Client{Timeout: oidcHTTPTimeout}
+}
+
+// jwk is a JSON Web Key (RFC 7517); only RSA and P-256 EC keys are used
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if pub.N.BitLen() < 2048 || pub.E < 3 {
+			return nil, errors.New("weak RSA key")
+		}
+		return pub, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, errors.New("unsupported curve")
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("point not on curve")
+		}
+		return pub, nil
+	}
+	return nil, errors.New("unsupported key type")
+}
+
+func keyMatchesAlg(key crypto.PublicKey, alg string) bool {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		return alg == "RS256"
+	case *ecdsa.PublicKey:
+		return alg == "ES256"
+	}
+	return false
+}
+
+// verifyJWS checks a JWS signature over signingInput
+func verifyJWS(alg string, key crypto.PublicKey, signingInput string, signature []byte) error {
+	if !keyMatchesAlg(key, alg) {
+		return fmt.Errorf("%w: key does not match algorithm", ErrOIDCIDToken)
+	}
+	digest := sha256.Sum256([]byte(signingInput))
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) == nil {
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		// JWS ECDSA signatures are r || s, not ASN.1
+		if len(signature) == 64 {
+			r := new(big.Int).SetBytes(signature[:32])
+			s := new(big.Int).SetBytes(signature[32:])
+			if ecdsa.Verify(pub, digest[:], r, s) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%w: bad signature", ErrOIDCIDToken)
+}
@@ -0,0 +1,436 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testClientID = "mudpuppy"
+	testNonce    = "nonce-123"
+)
+
+// mockIssuer is an OpenID provider serving discovery, a JWKS and a token endpoint
+type mockIssuer struct {
+	server *httptest.Server
+
+	mu        sync.Mutex
+	keys      map[string]crypto.Signer // Keys published in the JWKS, by key ID
+	jwksHits  int
+	jwksGate  chan struct{} // When set, JWKS requests wait for it to close
+	tokenForm url.Values    // Form of the last token request
+	idToken   string        // Returned by the token endpoint
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+	m := &mockIssuer{keys: make(map[string]crypto.Signer)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                m.server.URL,
+			AuthorizationEndpoint: m.server.URL + "/authorize",
+			TokenEndpoint:         m.server.URL + "/token",
+			JWKSURI:               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		m.jwksHits++
+		gate := m.jwksGate
+		m.mu.Unlock()
+		if gate != nil {
+			<-gate
+		}
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		keys := make([]map[string]string, 0, len(m.keys))
+		for kid, key := range m.keys {
+			keys = append(keys, publicJWK(kid, key.Public()))
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		m.mu.Lock()
+		m.tokenForm = r.PostForm
+		token := m.idToken
+		m.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]string{"id_token": token, "token_type": "Bearer"})
+	})
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+func (m *mockIssuer) provider() *OIDCProvider {
+	return &OIDCProvider{
+		Issuer:      m.server.URL,
+		ClientID:    testClientID,
+		RedirectURL: "https://mud.example.com/api/v1/oidc/callback",
+		Scopes:      []string{"openid", "email"},
+		HTTPClient:  m.server.Client(),
+	}
+}
+
+func (m *mockIssuer) addKey(kid string, key crypto.Signer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys[kid] = key
+}
+
+func (m *mockIssuer) hits() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.jwksHits
+}
+
+// claims returns valid ID token claims for the mock issuer
+func (m *mockIssuer) claims() map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":            m.server.URL,
+		"sub":            "user-42",
+		"aud":            testClientID,
+		"exp":            now.Add(time.Hour).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          testNonce,
+		"email":          "player@example.com",
+		"email_verified": true,
+	}
+}
+
+func publicJWK(kid string, pub crypto.PublicKey) map[string]string {
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "kid": kid, "use": "sig", "n": b64(pub.N.Bytes()), "e": b64(big.NewInt(int64(pub.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		x := make([]byte, 32)
+		y := make([]byte, 32)
+		pub.X.FillBytes(x)
+		pub.Y.FillBytes(y)
+		return map[string]string{"kty": "EC", "kid": kid, "use": "sig", "crv": "P-256", "x": b64(x), "y": b64(y)}
+	}
+	panic("publicJWK: unsupported key")
+}
+
+// signJWT builds a compact JWS; the signature is made with key for RS256 and ES256
+func signJWT(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	t.Helper()
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	headerJSON, _ := json.Marshal(header)
+	claimsJSON, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+	digest := sha256.Sum256([]byte(input))
+
+	var sig []byte
+	switch alg {
+	case "RS256":
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, key.(*rsa.PrivateKey), crypto.SHA256, digest[:]); err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, key.(*ecdsa.PrivateKey), digest[:])
+		if err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func mustRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate RSA key: %v", err)
+	}
+	return key
+}
+
+func mustECKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate EC key: %v", err)
+	}
+	return key
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	issuer := newMockIssuer(t)
+	rsaKey := mustRSAKey(t)
+	ecKey := mustECKey(t)
+	issuer.addKey("rsa-1", rsaKey)
+	issuer.addKey("ec-1", ecKey)
+	p := issuer.provider()
+
+	for _, tc := range []struct {
+		alg, kid string
+		key      crypto.Signer
+	}{
+		{"RS256", "rsa-1", rsaKey},
+		{"ES256", "ec-1", ecKey},
+	} {
+		t.Run(tc.alg, func(t *testing.T) {
+			token := signJWT(t, tc.alg, tc.kid, tc.key, issuer.claims())
+			claims, err := p.VerifyIDToken(context.Background(), token, testNonce)
+			if err != nil {
+				t.Fatalf("VerifyIDToken: %v", err)
+			}
+			if claims.Subject != "user-42" || claims.Email != "player@example.com" || !bool(claims.EmailVerified) {
+				t.Errorf("claims = %+v", claims)
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenRejects(t *testing.T) {
+	issuer := newMockIssuer(t)
+	rsaKey := mustRSAKey(t)
+	issuer.addKey("rsa-1", rsaKey)
+	p := issuer.provider()
+
+	withClaim := func(name string, value interface{}) string {
+		claims := issuer.claims()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return signJWT(t, "RS256", "rsa-1", rsaKey, claims)
+	}
+	unsigned := func(alg string, sign func(input string) []byte) string {
+		headerJSON, _ := json.Marshal(map[string]string{"alg": alg, "kid": "rsa-1"})
+		claimsJSON, _ := json.Marshal(issuer.claims())
+		input := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+		return input + "." + base64.RawURLEncoding.EncodeToString(sign(input))
+	}
+	// The public key as a shared secret, for the classic RS256 to HS256 confusion
+	publicDER, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	hs256 := func(input string) []byte {
+		mac := hmac.New(sha256.New, publicDER)
+		mac.Write([]byte(input))
+		return mac.Sum(nil)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		nonce string
+	}{
+		{"wrong issuer", withClaim("iss", "https://evil.example.com"), testNonce},
+		{"wrong audience", withClaim("aud", "someone-else"), testNonce},
+		{"audience list without client", withClaim("aud", []string{"a", "b"}), testNonce},
+		{"audience list with other azp", func() string {
+			claims := issuer.claims()
+			claims["aud"] = []string{testClientID, "other"}
+			claims["azp"] = "other"
+			return signJWT(t, "RS256", "rsa-1", rsaKey, claims)
+		}(), testNonce},
+		{"expired", withClaim("exp", time.Now().Add(-time.Hour).Unix()), testNonce},
+		{"issued in the future", withClaim("iat", time.Now().Add(time.Hour).Unix()), testNonce},
+		{"no subject", withClaim("sub", nil), testNonce},
+		{"nonce mismatch", signJWT(t, "RS256", "rsa-1", rsaKey, issuer.claims()), "another-nonce"},
+		{"missing nonce", withClaim("nonce", nil), testNonce},
+		{"alg none", unsigned("none", func(string) []byte { return nil }), testNonce},
+		{"HS256 with public key", unsigned("HS256", hs256), testNonce},
+		{"ES256 header with RSA key", func() string {
+			return unsigned("ES256", func(input string) []byte {
+				digest := sha256.Sum256([]byte(input))
+				sig, _ := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+				return sig
+			})
+		}(), testNonce},
+		{"bad signature", func() string {
+			token := signJWT(t, "RS256", "rsa-1", rsaKey, issuer.claims())
+			return token[:len(token)-4] + "AAAA"
+		}(), testNonce},
+		{"signed by another key", signJWT(t, "RS256", "rsa-1", mustRSAKey(t), issuer.claims()), testNonce},
+		{"malformed", "not.a-token", testNonce},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := p.VerifyIDToken(context.Background(), tt.token, tt.nonce)
+			if err == nil {
+				t.Fatalf("VerifyIDToken succeeded with claims %+v, want error", claims)
+			}
+			if !errors.Is(err, ErrOIDCIDToken) {
+				t.Errorf("error = %v, want %v", err, ErrOIDCIDToken)
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenRefreshesKeys(t *testing.T) {
+	issuer := newMockIssuer(t)
+	oldKey := mustECKey(t)
+	issuer.addKey("old", oldKey)
+	p := issuer.provider()
+	ctx := context.Background()
+
+	if _, err := p.VerifyIDToken(ctx, signJWT(t, "ES256", "old", oldKey, issuer.claims()), testNonce); err != nil {
+		t.Fatalf("VerifyIDToken(old): %v", err)
+	}
+	if got := issuer.hits(); got != 1 {
+		t.Fatalf("JWKS fetched %d times, want 1", got)
+	}
+
+	// The provider rotates in a new key; an unknown key ID refetches the JWKS
+	newKey := mustECKey(t)
+	issuer.addKey("new", newKey)
+	p.mu.Lock()
+	p.keysFetchedAt = time.Now().Add(-oidcKeysMinRefresh)
+	p.mu.Unlock()
+
+	if _, err := p.VerifyIDToken(ctx, signJWT(t, "ES256", "new", newKey, issuer.claims()), testNonce); err != nil {
+		t.Fatalf("VerifyIDToken(new): %v", err)
+	}
+	if got := issuer.hits(); got != 2 {
+		t.Fatalf("JWKS fetched %d times, want 2", got)
+	}
+
+	// Another unknown key ID soon after does not refetch
+	_, err := p.VerifyIDToken(ctx, signJWT(t, "ES256", "unknown", mustECKey(t), issuer.claims()), testNonce)
+	if !errors.Is(err, ErrOIDCIDToken) {
+		t.Fatalf("error = %v, want %v", err, ErrOIDCIDToken)
+	}
+	if got := issuer.hits(); got != 2 {
+		t.Errorf("JWKS fetched %d times, want 2", got)
+	}
+}
+
+func TestSigningKeyFetchDoesNotBlockCachedKeys(t *testing.T) {
+	issuer := newMockIssuer(t)
+	key := mustECKey(t)
+	issuer.addKey("known", key)
+	p := issuer.provider()
+	ctx := context.Background()
+
+	if _, err := p.VerifyIDToken(ctx, signJWT(t, "ES256", "known", key, issuer.claims()), testNonce); err != nil {
+		t.Fatalf("VerifyIDToken: %v", err)
+	}
+
+	// Hold the next JWKS request open while a token with an unknown key ID triggers it
+	gate := make(chan struct{})
+	issuer.mu.Lock()
+	issuer.jwksGate = gate
+	issuer.mu.Unlock()
+	p.mu.Lock()
+	p.keysFetchedAt = time.Now().Add(-oidcKeysMinRefresh)
+	p.mu.Unlock()
+
+	rotated := signJWT(t, "ES256", "rotated", mustECKey(t), issuer.claims())
+	known := signJWT(t, "ES256", "known", key, issuer.claims())
+
+	fetched := make(chan struct{})
+	go func() {
+		defer close(fetched)
+		p.VerifyIDToken(ctx, rotated, testNonce)
+	}()
+	for issuer.hits() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	verified := make(chan error, 1)
+	go func() {
+		_, err := p.VerifyIDToken(ctx, known, testNonce)
+		verified <- err
+	}()
+	select {
+	case err := <-verified:
+		if err != nil {
+			t.Errorf("VerifyIDToken with cached key: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("VerifyIDToken with cached key blocked behind the JWKS fetch")
+	}
+
+	close(gate)
+	<-fetched
+}
+
+func TestOIDCExchangeSendsPKCEVerifier(t *testing.T) {
+	issuer := newMockIssuer(t)
+	p := issuer.provider()
+	ctx := context.Background()
+
+	verifier, challenge, err := NewPKCE()
+	if err != nil {
+		t.Fatalf("NewPKCE: %v", err)
+	}
+
+	authURL, err := p.AuthCodeURL(ctx, "state-1", testNonce, challenge)
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("parse auth URL: %v", err)
+	}
+	query := u.Query()
+	sum := sha256.Sum256([]byte(verifier))
+	if query.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(sum[:]) || query.Get("code_challenge_method") != "S256" {
+		t.Errorf("auth URL challenge = %q (%s), want S256 of the verifier", query.Get("code_challenge"), query.Get("code_challenge_method"))
+	}
+	if query.Get("nonce") != testNonce || query.Get("state") != "state-1" || query.Get("client_id") != testClientID {
+		t.Errorf("auth URL query = %v", query)
+	}
+
+	issuer.mu.Lock()
+	issuer.idToken = "id-token"
+	issuer.mu.Unlock()
+	idToken, err := p.Exchange(ctx, "auth-code", verifier)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if idToken != "id-token" {
+		t.Errorf("id token = %q, want %q", idToken, "id-token")
+	}
+
+	issuer.mu.Lock()
+	form := issuer.tokenForm
+	issuer.mu.Unlock()
+	want := map[string]string{
+		"grant_type":    "authorization_code",
+		"code":          "auth-code",
+		"code_verifier": verifier,
+		"redirect_uri":  p.RedirectURL,
+		"client_id":     testClientID,
+	}
+	for name, value := range want {
+		if got := form.Get(name); got != value {
+			t.Errorf("token request %s = %q, want %q", name, got, value)
+		}
+	}
+	if strings.Contains(form.Encode(), "client_secret") {
+		t.Error("public client sent a client secret")
+	}
+}
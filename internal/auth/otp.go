@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// MaxOTPAttempts is how many wrong codes a challenge allows before it is invalidated
+const MaxOTPAttempts = 5
+
+var (
+	// errOTPNotFound indicates there is no usable code for the email (none sent, expired, used or locked)
+	errOTPNotFound = errors.New("otp not found")
+	// errOTPInvalid indicates the code was wrong
+	errOTPInvalid = errors.New("invalid otp")
+	// errOTPLocked indicates the code was wrong and the challenge has now run out of attempts
+	errOTPLocked = errors.New("too many otp attempts")
+)
+
+// hashOTP hashes a login code for storage, keyed with the session secret
+// Six-digit codes are easy to brute force, so a plain hash would not protect them if the database leaked.
+func (h *Handler) hashOTP(email, code string) string {
+	mac := hmac.New(sha256.New, []byte(h.sessionSecret))
+	mac.Write([]byte("otp:" + email + ":" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// issueOTP generates a login code for an email, stores its hash and sends it
+// On failure it writes the error response and returns false.
+func (h *Handler) issueOTP(w http.ResponseWriter, email string) bool {
+	otp, err := generateOTP()
+	if err != nil {
+		log.Printf("Error generating OTP: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+
+	// Replaces any earlier code for this email
+	challengeID, err := h.otpStore.Create(email, h.hashOTP(email, otp), time.Now().Add(h.otpExpiry))
+	if err != nil {
+		log.Printf("Error storing OTP: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+
+	// The email also carries a login link when the app's public URL is known
+	link := ""
+	if h.appBaseURL != "" {
+		if link, err = h.newLoginLink(w, challengeID); err != nil {
+			log.Printf("Error creating login link: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return false
+		}
+	}
+
+	// Send OTP via email
+	if h.emailSender != nil && h.emailSender.IsConfigured() {
+		if link != "" {
+			err = h.emailSender.SendLoginLink(email, otp, link)
+		} else {
+			err = h.emailSender.SendOTP(email, otp)
+		}
+		if err != nil {
+			// If SMTP fails, delete the OTP and return 503
+			h.otpStore.DeleteByEmail(email)
+			log.Printf("Failed to send OTP email: %v", err)
+			http.Error(w, "Service unavailable. Please try again later.", http.StatusServiceUnavailable)
+			return false
+		}
+		log.Printf("STAGING: OTP sent to user")
+	} else if h.devLogOTPCodes {
+		// Local development without SMTP only, enabled with DEV_LOG_OTP_CODES
+		log.Printf("DEV MODE - OTP for %s: %s %s", email, otp, link)
+	} else {
+		log.Printf("DEV MODE - SMTP not configured, OTP for %s was not sent (set DEV_LOG_OTP_CODES=true to log it)", email)
+	}
+	return true
+}
+
+// verifyOTP checks a login code and consumes its challenge if it is right
+// Each code entered counts against the challenge; after MaxOTPAttempts a new code must be requested.
+func (h *Handler) verifyOTP(email, code string) error {
+	challenge, err := h.otpStore.StartAttempt(email, MaxOTPAttempts)
+	if err != nil {
+		return err
+	}
+	if challenge == nil {
+		return errOTPNotFound
+	}
+
+	// Compare hashes in constant time so response timing does not reveal how much of the code matched
+	if !hmac.Equal([]byte(h.hashOTP(email, code)), []byte(challenge.OTPHash)) {
+		if challenge.Attempts >= MaxOTPAttempts {
+			log.Printf("ABUSE: OTP attempt limit reached, challenge invalidated, timestamp: %s",
+				time.Now().UTC().Format(time.RFC3339))
+			return errOTPLocked
+		}
+		return errOTPInvalid
+	}
+
+	// Single-use: of two requests with the same right code, only one logs in
+	consumed, err := h.otpStore.Consume(challenge.ID)
+	if err != nil {
+		return err
+	}
+	if !consumed {
+		return errOTPNotFound
+	}
+	return nil
+}
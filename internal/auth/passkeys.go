@@ -0,0 +1,457 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/amaranth494/MudPuppy/internal/audit"
+	"github.com/amaranth494/MudPuppy/internal/redis"
+	"github.com/amaranth494/MudPuppy/internal/store"
+	"github.com/google/uuid"
+)
+
+// Passkey limits
+const (
+	MaxPasskeysPerUser = 10
+	MaxPasskeyName     = 100
+	defaultPasskeyName = "Passkey"
+)
+
+// Request/Response types for passkeys
+
+type PasskeyRegisterRequest struct {
+	Name       string                 `json:"name"`
+	Credential RegistrationCredential `json:"credential"`
+}
+
+type PasskeyLoginBeginRequest struct {
+	Email string `json:"email,omitempty"`
+}
+
+type PasskeyLoginRequest struct {
+	Credential AssertionCredential `json:"credential"`
+}
+
+type PasskeyRenameRequest struct {
+	Name string `json:"name"`
+}
+
+type PasskeysResponse struct {
+	Items []store.Passkey `json:"items"`
+}
+
+// BeginPasskeyRegistration handles POST /api/v1/passkeys/register/begin
+// Returns the options for navigator.credentials.create()
+func (h *Handler) BeginPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.passkeyUser(w, r)
+	if !ok {
+		return
+	}
+	ctx := context.Background()
+
+	passkeys, err := h.passkeyStore.ListByUser(user.ID)
+	if err != nil {
+		log.Printf("Error listing passkeys: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if len(passkeys) >= MaxPasskeysPerUser {
+		http.Error(w, fmt.Sprintf("You can register at most %d passkeys", MaxPasskeysPerUser), http.StatusBadRequest)
+		return
+	}
+
+	challenge, err := NewChallenge()
+	if err != nil {
+		log.Printf("Error generating passkey challenge: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := h.redisClient.Set(ctx, redis.WebAuthnChallengeKey("register", user.ID.String()), challenge, redis.WebAuthnChallengeTTL); err != nil {
+		log.Printf("Error storing passkey challenge: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	userHandle, _ := user.ID.MarshalBinary()
+	h.sendJSON(w, h.rp.CreationOptions(challenge, userHandle, user.Email, passkeyDescriptors(passkeys)))
+}
+
+// FinishPasskeyRegistration handles POST /api/v1/passkeys/register/finish
+// Verifies the new credential against the pending challenge and stores it
+func (h *Handler) FinishPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.passkeyUser(w, r)
+	if !ok {
+		return
+	}
+	ctx := context.Background()
+
+	var req PasskeyRegisterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		req.Name = defaultPasskeyName
+	}
+	if len(req.Name) > MaxPasskeyName {
+		http.Error(w, fmt.Sprintf("Name must be %d characters or less", MaxPasskeyName), http.StatusBadRequest)
+		return
+	}
+
+	// The challenge is single-use, whether or not the registration succeeds
+	challenge, err := h.redisClient.GetDel(ctx, redis.WebAuthnChallengeKey("register", user.ID.String()))
+	if err == redis.ErrKeyNotFound {
+		http.Error(w, "Passkey registration expired. Please try again.", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading passkey challenge: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	cred, err := h.rp.VerifyRegistration(req.Credential, challenge)
+	if err != nil {
+		log.Printf("Passkey registration rejected for user %s: %v", user.ID, err)
+		http.Error(w, "Passkey could not be verified: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	exists, err := h.passkeyStore.CredentialExists(cred.CredentialID)
+	if err != nil {
+		log.Printf("Error checking passkey: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if exists {
+		http.Error(w, "This passkey is already registered", http.StatusConflict)
+		return
+	}
+
+	passkey := &store.Passkey{
+		UserID:         user.ID,
+		CredentialID:   cred.CredentialID,
+		PublicKey:      cred.PublicKey,
+		SignCount:      cred.SignCount,
+		AAGUID:         cred.AAGUID,
+		Transports:     cred.Transports,
+		Name:           req.Name,
+		BackupEligible: cred.BackupEligible,
+		BackupState:    cred.BackupState,
+	}
+	if passkey.Transports == nil {
+		passkey.Transports = []string{}
+	}
+	if err := h.passkeyStore.Create(passkey); err != nil {
+		log.Printf("Error storing passkey: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Passkey registered for user %s", user.ID)
+	h.auditLog.Record(r, user.ID, audit.EventPasskeyAdded, map[string]string{"name": passkey.Name})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(passkey)
+}
+
+// ListPasskeys handles GET /api/v1/passkeys
+func (h *Handler) ListPasskeys(w http.ResponseWriter, r *http.Request) {
+	userUUID, ok := h.requestUserID(w, r)
+	if !ok {
+		return
+	}
+	passkeys, err := h.passkeyStore.ListByUser(userUUID)
+	if err != nil {
+		log.Printf("Error listing passkeys: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	h.sendJSON(w, PasskeysResponse{Items: passkeys})
+}
+
+// RenamePasskey handles PATCH /api/v1/passkeys/{id}
+func (h *Handler) RenamePasskey(w http.ResponseWriter, r *http.Request) {
+	userUUID, ok := h.requestUserID(w, r)
+	if !ok {
+		return
+	}
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid passkey ID", http.StatusBadRequest)
+		return
+	}
+	var req PasskeyRenameRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > MaxPasskeyName {
+		http.Error(w, fmt.Sprintf("Name must be 1 to %d characters", MaxPasskeyName), http.StatusBadRequest)
+		return
+	}
+
+	renamed, err := h.passkeyStore.Rename(userUUID, id, req.Name)
+	if err != nil {
+		log.Printf("Error renaming passkey: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !renamed {
+		http.Error(w, "Passkey not found", http.StatusNotFound)
+		return
+	}
+	h.sendJSON(w, map[string]string{"status": "renamed"})
+}
+
+// DeletePasskey handles DELETE /api/v1/passkeys/{id}
+// Email codes always remain available, so removing the last passkey cannot lock a user out
+func (h *Handler) DeletePasskey(w http.ResponseWriter, r *http.Request) {
+	userUUID, ok := h.requestUserID(w, r)
+	if !ok {
+		return
+	}
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid passkey ID", http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := h.passkeyStore.Delete(userUUID, id)
+	if err != nil {
+		log.Printf("Error deleting passkey: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "Passkey not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("Passkey %s deleted for user %s", id, userUUID)
+	h.auditLog.Record(r, userUUID, audit.EventPasskeyRemoved, map[string]string{"passkey_id": id.String()})
+	h.sendJSON(w, map[string]string{"status": "deleted"})
+}
+
+// BeginPasskeyLogin handles POST /api/v1/passkeys/login/begin
+// With an email, only that user's passkeys are offered; without one the browser lists every passkey it has for the site.
+func (h *Handler) BeginPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.rp.Enabled() {
+		http.Error(w, "Passkeys are not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	ctx := context.Background()
+
+	var req PasskeyLoginBeginRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// The pending login remembers which user it was started for, if any
+	boundUser := ""
+	var allow []PublicKeyCredentialDescriptor
+	if email := strings.ToLower(strings.TrimSpace(req.Email)); email != "" {
+		user, err := h.userStore.GetByEmail(email)
+		if err != nil {
+			log.Printf("Error checking user: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		var passkeys []store.Passkey
+		if user != nil {
+			if passkeys, err = h.passkeyStore.ListByUser(user.ID); err != nil {
+				log.Printf("Error listing passkeys: %v", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+		}
+		if len(passkeys) == 0 {
+			http.Error(w, "No passkeys are registered for this email. Please log in with a code.", http.StatusNotFound)
+			return
+		}
+		boundUser = user.ID.String()
+		allow = passkeyDescriptors(passkeys)
+	}
+
+	challenge, err := NewChallenge()
+	if err != nil {
+		log.Printf("Error generating passkey challenge: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := h.redisClient.Set(ctx, redis.WebAuthnChallengeKey("login", challenge), boundUser, redis.WebAuthnChallengeTTL); err != nil {
+		log.Printf("Error storing passkey challenge: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	h.sendJSON(w, h.rp.RequestOptions(challenge, allow))
+}
+
+// FinishPasskeyLogin handles POST /api/v1/passkeys/login/finish
+// On success the user is logged in exactly as with an emailed code, including the TOTP step
+func (h *Handler) FinishPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.rp.Enabled() {
+		http.Error(w, "Passkeys are not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	ctx := context.Background()
+
+	// Passkey logins share the login rate limit (10 attempts per IP per 10 minutes)
+	clientIP := getClientIP(r)
+	_, err := h.redisClient.CheckRateLimit(ctx, "login", clientIP, 10, redis.LoginRateLimitTTL)
+	if err == redis.ErrRateLimited {
+		log.Printf("ABUSE: Login rate limit exceeded for IP: %s, endpoint: %s, timestamp: %s",
+			clientIP, r.URL.Path, time.Now().UTC().Format(time.RFC3339))
+		http.Error(w, "Too many login attempts. Please try again later.", http.StatusTooManyRequests)
+		return
+	}
+
+	var req PasskeyLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// The pending login is found through the challenge the authenticator signed, and used up
+	challenge, err := ClientDataChallenge(req.Credential.Response.ClientDataJSON)
+	if err != nil || challenge == "" {
+		http.Error(w, "Invalid passkey response", http.StatusBadRequest)
+		return
+	}
+	boundUser, err := h.redisClient.GetDel(ctx, redis.WebAuthnChallengeKey("login", challenge))
+	if err == redis.ErrKeyNotFound {
+		http.Error(w, "Passkey login expired. Please try again.", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading passkey challenge: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	credentialID, err := decodeBase64URL(req.Credential.RawID)
+	if err != nil || len(credentialID) == 0 {
+		http.Error(w, "Invalid passkey response", http.StatusBadRequest)
+		return
+	}
+	passkey, err := h.passkeyStore.GetByCredentialID(credentialID)
+	if err != nil {
+		log.Printf("Error loading passkey: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if passkey == nil || (boundUser != "" && passkey.UserID.String() != boundUser) {
+		http.Error(w, "Passkey not recognised", http.StatusUnauthorized)
+		return
+	}
+
+	assertion, err := h.rp.VerifyAssertion(req.Credential, challenge, passkey.PublicKey, passkey.SignCount)
+	if err != nil {
+		log.Printf("Passkey login rejected for user %s: %v", passkey.UserID, err)
+		http.Error(w, "Passkey could not be verified", http.StatusUnauthorized)
+		return
+	}
+	userHandle, _ := passkey.UserID.MarshalBinary()
+	if assertion.UserHandle != nil && !bytes.Equal(assertion.UserHandle, userHandle) {
+		http.Error(w, "Passkey not recognised", http.StatusUnauthorized)
+		return
+	}
+	recorded, err := h.passkeyStore.RecordUse(passkey.ID, passkey.SignCount, assertion.SignCount, assertion.BackupState)
+	if err != nil {
+		log.Printf("Error updating passkey: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !recorded {
+		http.Error(w, "Passkey could not be verified", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := h.userStore.GetByID(passkey.UserID)
+	if err != nil {
+		log.Printf("Error getting user: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	h.completeLogin(w, r, user)
+}
+
+// passkeyUser returns the logged-in user for passkey registration
+func (h *Handler) passkeyUser(w http.ResponseWriter, r *http.Request) (*store.User, bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	if !h.rp.Enabled() {
+		http.Error(w, "Passkeys are not enabled", http.StatusServiceUnavailable)
+		return nil, false
+	}
+	userUUID, ok := h.requestUserID(w, r)
+	if !ok {
+		return nil, false
+	}
+	user, err := h.userStore.GetByID(userUUID)
+	if err != nil {
+		log.Printf("Error getting user: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if user == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return nil, false
+	}
+	return user, true
+}
+
+// requestUserID returns the user ID the session middleware put in the request context
+func (h *Handler) requestUserID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	userID, _ := r.Context().Value("user_id").(string)
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return uuid.Nil, false
+	}
+	return userUUID, true
+}
+
+// passkeyDescriptors lists passkeys the way the browser expects them
+func passkeyDescriptors(passkeys []store.Passkey) []PublicKeyCredentialDescriptor {
+	descriptors := make([]PublicKeyCredentialDescriptor, 0, len(passkeys))
+	for _, p := range passkeys {
+		descriptors = append(descriptors, PublicKeyCredentialDescriptor{
+			Type:       "public-key",
+			ID:         base64.RawURLEncoding.EncodeToString(p.CredentialID),
+			Transports: p.Transports,
+		})
+	}
+	return descriptors
+}
+
+// sendJSON sends a JSON response
+func (h *Handler) sendJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
@@ -0,0 +1,203 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/amaranth494/MudPuppy/internal/audit"
+	"github.com/amaranth494/MudPuppy/internal/redis"
+)
+
+// maxUserAgent is how much of a User-Agent header is kept with a session
+const maxUserAgent = 256
+
+// LoginSession is one of the user's logins as shown in account settings
+type LoginSession struct {
+	redis.SessionInfo
+	Device  string `json:"device"`
+	Current bool   `json:"current"`
+}
+
+type LoginSessionsResponse struct {
+	Items []LoginSession `json:"items"`
+}
+
+type RevokeSessionsResponse struct {
+	Revoked int `json:"revoked"`
+}
+
+// SetOnRevoke sets the function called with the public IDs of sessions that were logged out,
+// so connections opened with them (such as WebSockets) can be closed
+func (h *Handler) SetOnRevoke(fn func(sessionIDs []string)) {
+	h.onRevoke = fn
+}
+
+// notifyRevoked passes revoked public session IDs to the revoke callback, if one is set
+func (h *Handler) notifyRevoked(sessionIDs ...string) {
+	if h.onRevoke != nil && len(sessionIDs) > 0 {
+		h.onRevoke(sessionIDs)
+	}
+}
+
+// ListSessions handles GET /api/v1/account/sessions
+// Returns the user's active logins, most recently used first
+func (h *Handler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	userUUID, ok := h.requestUserID(w, r)
+	if !ok {
+		return
+	}
+
+	sessions, err := h.redisClient.ListUserSessions(context.Background(), userUUID.String())
+	if err != nil {
+		log.Printf("Error listing sessions: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+
+	current := redis.SessionPublicID(getSessionToken(r))
+	items := make([]LoginSession, len(sessions))
+	for i, s := range sessions {
+		items[i] = LoginSession{
+			SessionInfo: s,
+			Device:      describeDevice(s.UserAgent),
+			Current:     s.ID == current,
+		}
+	}
+	h.sendJSON(w, LoginSessionsResponse{Items: items})
+}
+
+// RevokeSession handles DELETE /api/v1/account/sessions/{id}
+// Logs out one login; revoking the current one is the same as logging out
+func (h *Handler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	userUUID, ok := h.requestUserID(w, r)
+	if !ok {
+		return
+	}
+	id := r.PathValue("id")
+
+	revoked, err := h.redisClient.RevokeUserSession(context.Background(), userUUID.String(), id)
+	if err != nil {
+		log.Printf("Error revoking session: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !revoked {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	h.notifyRevoked(id)
+
+	if id == redis.SessionPublicID(getSessionToken(r)) {
+		clearSessionCookie(w)
+	}
+	log.Printf("Session %s revoked for user %s", id[:8]+"...", userUUID)
+	h.auditLog.Record(r, userUUID, audit.EventSessionsRevoked, map[string]string{"session_id": id})
+	h.sendJSON(w, map[string]string{"status": "revoked"})
+}
+
+// RevokeAllSessions handles DELETE /api/v1/account/sessions ("log out everywhere")
+// With ?keep_current=true the login making the request stays valid.
+func (h *Handler) RevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	userUUID, ok := h.requestUserID(w, r)
+	if !ok {
+		return
+	}
+	keep := ""
+	if r.URL.Query().Get("keep_current") == "true" {
+		keep = getSessionToken(r)
+	}
+
+	revoked, err := h.redisClient.RevokeUserSessions(context.Background(), userUUID.String(), keep)
+	h.notifyRevoked(revoked...)
+	if err != nil {
+		log.Printf("Error revoking sessions: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if keep == "" {
+		clearSessionCookie(w)
+	}
+	log.Printf("%d sessions revoked for user %s", len(revoked), userUUID)
+	h.auditLog.Record(r, userUUID, audit.EventSessionsRevoked, map[string]string{"count": strconv.Itoa(len(revoked))})
+	h.sendJSON(w, RevokeSessionsResponse{Revoked: len(revoked)})
+}
+
+// clearSessionCookie removes the session cookie from the browser
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session_token",
+		Value:    "",
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   -1,
+	})
+}
+
+// truncateUserAgent limits the User-Agent header stored with a session
+func truncateUserAgent(userAgent string) string {
+	if len(userAgent) > maxUserAgent {
+		return userAgent[:maxUserAgent]
+	}
+	return userAgent
+}
+
+// describeDevice turns a User-Agent into a short label such as "Firefox on Windows"
+// It only needs to be good enough for a user to recognize their own devices.
+func describeDevice(userAgent string) string {
+	if userAgent == "" {
+		return "Unknown device"
+	}
+
+	browser := ""
+	for _, b := range []struct{ token, name string }{
+		// Order matters: Edge and Opera also claim to be Chrome, and Chrome claims to be Safari
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"CriOS/", "Chrome"},
+		{"Safari/", "Safari"},
+	} {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	platform := ""
+	for _, p := range []struct{ token, name string }{
+		// iOS and Android before macOS and Linux, which their user agents also mention
+		{"iPhone", "iPhone"},
+		{"iPad", "iPad"},
+		{"Android", "Android"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(userAgent, p.token) {
+			platform = p.name
+			break
+		}
+	}
+
+	switch {
+	case browser != "" && platform != "":
+		return browser + " on " + platform
+	case browser != "":
+		return browser
+	case platform != "":
+		return platform
+	}
+	return "Unknown device"
+}
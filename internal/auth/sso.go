@@ -0,0 +1,374 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/amaranth494/MudPuppy/internal/audit"
+	"github.com/amaranth494/MudPuppy/internal/redis"
+	"github.com/amaranth494/MudPuppy/internal/store"
+	"github.com/google/uuid"
+)
+
+// oidcStateCookie binds a single sign-on attempt to the browser that started it,
+// so a callback URL taken from one browser cannot log in another
+const oidcStateCookie = "oidc_state"
+
+// Single sign-on errors shown to the user, who arrives from the identity provider
+const (
+	ssoAttemptInvalid    = "This sign-in attempt is invalid or has expired. Please try again."
+	ssoProviderFailed    = "Could not complete single sign-on with the identity provider. Please try again later."
+	ssoIdentityLinked    = "This account at the identity provider is already linked to a different MUDPuppy account."
+	ssoNoVerifiedAddress = "The identity provider did not share a verified email address. Log in with an emailed code, then link this account from the Account page."
+)
+
+// oidcLogin is what is kept while the user is away logging in at the identity provider
+type oidcLogin struct {
+	CodeVerifier string `json:"code_verifier"`
+	Nonce        string `json:"nonce"`
+	LinkUserID   string `json:"link_user_id,omitempty"` // Set when a logged-in user is linking an identity
+}
+
+// Request/Response types for single sign-on
+
+type OIDCInfoResponse struct {
+	Enabled bool   `json:"enabled"`
+	Name    string `json:"name,omitempty"`
+}
+
+type IdentitiesResponse struct {
+	Items []store.Identity `json:"items"`
+}
+
+// OIDCInfo handles GET /api/v1/oidc
+// Tells the login page whether to show the single sign-on button, and its label
+func (h *Handler) OIDCInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.oidc.Enabled() {
+		h.sendJSON(w, OIDCInfoResponse{Enabled: false})
+		return
+	}
+	h.sendJSON(w, OIDCInfoResponse{Enabled: true, Name: h.oidc.Name})
+}
+
+// OIDCLogin handles GET /api/v1/oidc/login
+// Redirects to the identity provider; it sends the user back to OIDCCallback
+func (h *Handler) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	h.startOIDC(w, r, "")
+}
+
+// LinkIdentity handles GET /api/v1/account/identities/link
+// Like OIDCLogin, but the identity the user logs in with is linked to their current account
+func (h *Handler) LinkIdentity(w http.ResponseWriter, r *http.Request) {
+	userUUID, ok := h.requestUserID(w, r)
+	if !ok {
+		return
+	}
+	h.startOIDC(w, r, userUUID.String())
+}
+
+// startOIDC starts an authorization code flow with PKCE and redirects to the identity provider
+func (h *Handler) startOIDC(w http.ResponseWriter, r *http.Request, linkUserID string) {
+	if !h.oidc.Enabled() {
+		http.Error(w, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+	ctx := context.Background()
+
+	state, err := generateSessionID()
+	if err != nil {
+		log.Printf("Error generating OIDC state: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	nonce, err := generateSessionID()
+	if err != nil {
+		log.Printf("Error generating OIDC nonce: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	verifier, challenge, err := NewPKCE()
+	if err != nil {
+		log.Printf("Error generating PKCE verifier: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	authURL, err := h.oidc.AuthCodeURL(ctx, state, nonce, challenge)
+	if err != nil {
+		log.Printf("Error starting single sign-on: %v", err)
+		http.Error(w, ssoProviderFailed, http.StatusBadGateway)
+		return
+	}
+
+	data, err := json.Marshal(oidcLogin{CodeVerifier: verifier, Nonce: nonce, LinkUserID: linkUserID})
+	if err != nil {
+		log.Printf("Error encoding OIDC state: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := h.redisClient.Set(ctx, redis.OIDCStateKey(state), string(data), redis.OIDCStateTTL); err != nil {
+		log.Printf("Error storing OIDC state: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	h.setOIDCStateCookie(w, state, int(redis.OIDCStateTTL.Seconds()))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// setOIDCStateCookie sets the single sign-on state cookie, or clears it when maxAge is negative
+func (h *Handler) setOIDCStateCookie(w http.ResponseWriter, state string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/v1/oidc",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode, // Sent on the provider's redirect back
+		MaxAge:   maxAge,
+	})
+}
+
+// OIDCCallback handles GET /api/v1/oidc/callback
+// Redeems the authorization code, validates the ID token and logs in the user the identity is linked to.
+// An unlinked identity with a verified email is linked to the account with that email, which is created if needed.
+func (h *Handler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.oidc.Enabled() {
+		http.Error(w, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+	ctx := context.Background()
+
+	clientIP := getClientIP(r)
+	_, err := h.redisClient.CheckRateLimit(ctx, "login", clientIP, 10, redis.LoginRateLimitTTL)
+	if err == redis.ErrRateLimited {
+		log.Printf("ABUSE: Login rate limit exceeded for IP: %s, endpoint: %s, timestamp: %s",
+			clientIP, r.URL.Path, time.Now().UTC().Format(time.RFC3339))
+		http.Error(w, "Too many login attempts. Please try again later.", http.StatusTooManyRequests)
+		return
+	}
+
+	query := r.URL.Query()
+	state := query.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if state == "" || err != nil || !hmac.Equal([]byte(cookie.Value), []byte(state)) {
+		http.Error(w, ssoAttemptInvalid, http.StatusBadRequest)
+		return
+	}
+	h.setOIDCStateCookie(w, "", -1)
+
+	// The state is single-use, whether or not the login succeeds
+	data, err := h.redisClient.GetDel(ctx, redis.OIDCStateKey(state))
+	if err == redis.ErrKeyNotFound {
+		http.Error(w, ssoAttemptInvalid, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading OIDC state: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	var login oidcLogin
+	if err := json.Unmarshal([]byte(data), &login); err != nil {
+		log.Printf("Error decoding OIDC state: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if providerError := query.Get("error"); providerError != "" {
+		log.Printf("Single sign-on refused by identity provider: %s", providerError)
+		http.Error(w, "Sign-in was cancelled or refused by the identity provider.", http.StatusUnauthorized)
+		return
+	}
+	code := query.Get("code")
+	if code == "" {
+		http.Error(w, ssoAttemptInvalid, http.StatusBadRequest)
+		return
+	}
+
+	rawIDToken, err := h.oidc.Exchange(ctx, code, login.CodeVerifier)
+	if err != nil {
+		log.Printf("Error redeeming OIDC authorization code: %v", err)
+		http.Error(w, ssoProviderFailed, http.StatusBadGateway)
+		return
+	}
+	claims, err := h.oidc.VerifyIDToken(ctx, rawIDToken, login.Nonce)
+	if err != nil {
+		log.Printf("ABUSE: Rejected OIDC ID token from IP: %s, reason: %v, timestamp: %s",
+			clientIP, err, time.Now().UTC().Format(time.RFC3339))
+		http.Error(w, ssoProviderFailed, http.StatusUnauthorized)
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(claims.Email))
+
+	identity, err := h.identityStore.Get(claims.Issuer, claims.Subject)
+	if err != nil {
+		log.Printf("Error getting identity: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if login.LinkUserID != "" {
+		h.finishIdentityLink(w, r, login.LinkUserID, identity, claims, email)
+		return
+	}
+
+	var user *store.User
+	if identity != nil {
+		if user, err = h.userStore.GetByID(identity.UserID); err != nil {
+			log.Printf("Error getting user: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if err := h.identityStore.RecordLogin(identity.ID, email); err != nil {
+			log.Printf("Error recording identity login: %v", err)
+		}
+	} else {
+		// Matching by email is only safe when the provider vouches for the address
+		if email == "" || !bool(claims.EmailVerified) {
+			http.Error(w, ssoNoVerifiedAddress, http.StatusForbidden)
+			return
+		}
+		if user, err = h.userStore.GetByEmail(email); err != nil {
+			log.Printf("Error getting user: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if user == nil {
+			reserved, err := h.emailChangeStore.Reserved(email)
+			if err != nil {
+				log.Printf("Error checking email: %v", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			if reserved {
+				http.Error(w, "This email address cannot be registered right now. Please try again later.", http.StatusConflict)
+				return
+			}
+			if user, err = h.userStore.Create(email); err != nil {
+				log.Printf("Error creating user: %v", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			if err := h.userStore.MarkEmailVerified(user.ID); err != nil {
+				log.Printf("Error marking email verified: %v", err)
+			}
+			log.Printf("User %s registered with single sign-on", user.ID)
+		}
+
+		created, err := h.identityStore.Create(&store.Identity{UserID: user.ID, Issuer: claims.Issuer, Subject: claims.Subject, Email: email})
+		if err != nil {
+			log.Printf("Error linking identity: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if !created {
+			// Linked by a concurrent request; make the user start over rather than guess whose it is
+			http.Error(w, ssoAttemptInvalid, http.StatusConflict)
+			return
+		}
+		log.Printf("Identity linked to user %s on first single sign-on", user.ID)
+		h.auditLog.Record(r, user.ID, audit.EventIdentityLinked, map[string]string{"issuer": claims.Issuer})
+	}
+	if user == nil {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	h.redirectLogin(w, r, user)
+}
+
+// finishIdentityLink links the identity a logged-in user just signed in with to their account,
+// then returns them to the Account page
+func (h *Handler) finishIdentityLink(w http.ResponseWriter, r *http.Request, linkUserID string, identity *store.Identity, claims *IDTokenClaims, email string) {
+	userUUID, err := uuid.Parse(linkUserID)
+	if err != nil {
+		http.Error(w, ssoAttemptInvalid, http.StatusBadRequest)
+		return
+	}
+	if identity != nil {
+		if identity.UserID != userUUID {
+			http.Error(w, ssoIdentityLinked, http.StatusConflict)
+			return
+		}
+		// Already linked to this account
+		http.Redirect(w, r, "/account", http.StatusSeeOther)
+		return
+	}
+
+	created, err := h.identityStore.Create(&store.Identity{UserID: userUUID, Issuer: claims.Issuer, Subject: claims.Subject, Email: email})
+	if err != nil {
+		log.Printf("Error linking identity: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !created {
+		http.Error(w, ssoIdentityLinked, http.StatusConflict)
+		return
+	}
+
+	log.Printf("Identity linked for user %s", userUUID)
+	h.auditLog.Record(r, userUUID, audit.EventIdentityLinked, map[string]string{"issuer": claims.Issuer})
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// ListIdentities handles GET /api/v1/account/identities
+func (h *Handler) ListIdentities(w http.ResponseWriter, r *http.Request) {
+	userUUID, ok := h.requestUserID(w, r)
+	if !ok {
+		return
+	}
+	identities, err := h.identityStore.ListByUser(userUUID)
+	if err != nil {
+		log.Printf("Error listing identities: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	h.sendJSON(w, IdentitiesResponse{Items: identities})
+}
+
+// DeleteIdentity handles DELETE /api/v1/account/identities/{id}
+// The account can still be logged into with emailed codes after its last identity is unlinked.
+func (h *Handler) DeleteIdentity(w http.ResponseWriter, r *http.Request) {
+	userUUID, ok := h.requestUserID(w, r)
+	if !ok {
+		return
+	}
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid identity ID", http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := h.identityStore.Delete(userUUID, id)
+	if err != nil {
+		log.Printf("Error unlinking identity: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "Identity not found", http.StatusNotFound)
+		return
+	}
+
+	log.Printf("Identity %s unlinked for user %s", id, userUUID)
+	h.auditLog.Record(r, userUUID, audit.EventIdentityUnlinked, map[string]string{"identity_id": id.String()})
+	h.sendJSON(w, map[string]string{"status": "unlinked"})
+}
@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP (RFC 6238) with the parameters authenticator apps assume: SHA-1, 6 digits, 30-second steps
+const (
+	totpDigits     = 6
+	totpPeriod     = 30
+	totpSecretSize = 20 // bytes, as RFC 4226 recommends
+	totpSkew       = 1  // steps accepted either side of now, for clock drift
+
+	RecoveryCodeCount = 10
+	recoveryCodeChars = 10
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTPSecret returns a random secret, base32 encoded as authenticator apps expect
+func NewTOTPSecret() (string, error) {
+	buf := make([]byte, totpSecretSize)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(buf), nil
+}
+
+// TOTPProvisioningURI returns the otpauth:// URI shown as a QR code when enrolling
+func TOTPProvisioningURI(issuer, account, secret string) string {
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// totpCode computes the code for a time step (RFC 4226 HOTP with the step as counter)
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// VerifyTOTP checks a code against the secret at time now
+// It returns the matching time step; codes for steps at or before lastStep were already used and are rejected.
+func VerifyTOTP(secret, code string, now time.Time, lastStep int64) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false
+	}
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+	matched := int64(0)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		// Every step is checked so timing does not reveal which one matched
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 && step > lastStep {
+			matched = step
+		}
+	}
+	return matched, matched != 0
+}
+
+// NewRecoveryCodes returns single-use recovery codes formatted as xxxxx-xxxxx
+func NewRecoveryCodes() ([]string, error) {
+	const alphabet = "abcdefghjkmnpqrstuvwxyz23456789" // no easily confused characters
+	codes := make([]string, 0, RecoveryCodeCount)
+	buf := make([]byte, recoveryCodeChars)
+	for len(codes) < RecoveryCodeCount {
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		var code strings.Builder
+		for i, b := range buf {
+			if i == recoveryCodeChars/2 {
+				code.WriteByte('-')
+			}
+			// Bytes past the largest multiple of the alphabet size are rerolled to avoid bias
+			for int(b) >= 256-256%len(alphabet) {
+				var one [1]byte
+				if _, err := rand.Read(one[:]); err != nil {
+					return nil, err
+				}
+				b = one[0]
+			}
+			code.WriteByte(alphabet[int(b)%len(alphabet)])
+		}
+		codes = append(codes, code.String())
+	}
+	return codes, nil
+}
+
+// hashRecoveryCode hashes a recovery code for storage, keyed with the session secret
+// so a copy of the database alone is not enough to guess codes offline.
+func (h *Handler) hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), " ", ""))
+	if len(code) == recoveryCodeChars {
+		code = code[:recoveryCodeChars/2] + "-" + code[recoveryCodeChars/2:]
+	}
+	mac := hmac.New(sha256.New, []byte(h.sessionSecret))
+	mac.Write([]byte("recovery-code:" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
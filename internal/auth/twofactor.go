@@ -0,0 +1,458 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/amaranth494/MudPuppy/internal/audit"
+	"github.com/amaranth494/MudPuppy/internal/redis"
+	"github.com/amaranth494/MudPuppy/internal/store"
+	"github.com/google/uuid"
+)
+
+// TOTP limits
+const (
+	totpIssuer      = "MUDPuppy"
+	maxMFAAttempts  = 5  // Wrong codes per login before it must be started again
+	maxTOTPAttempts = 10 // Wrong codes per user per 10 minutes when managing TOTP
+)
+
+// Request/Response types for the TOTP second factor
+
+// MFAToken may be left out when the first factor was a browser redirect, which sets mfaCookie instead
+type TOTPLoginRequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
+
+type TOTPCodeRequest struct {
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
+
+type TOTPStatusResponse struct {
+	Enabled                bool    `json:"enabled"`
+	EnabledAt              *string `json:"enabled_at,omitempty"`
+	RecoveryCodesRemaining int     `json:"recovery_codes_remaining"`
+}
+
+type TOTPSetupResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// completeLogin finishes a login whose first factor (email code or passkey) has been verified
+// Users with TOTP enabled get a short-lived MFA token instead of a session, to exchange at /api/v1/login/totp.
+func (h *Handler) completeLogin(w http.ResponseWriter, r *http.Request, user *store.User) {
+	enabled, err := h.totpStore.IsEnabled(user.ID)
+	if err != nil {
+		log.Printf("Error checking TOTP: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !enabled {
+		h.startSession(w, r, user)
+		return
+	}
+
+	token, err := h.newMFAToken(user)
+	if err != nil {
+		log.Printf("Error storing MFA token: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(LoginResponse{
+		MFARequired: true,
+		MFAToken:    token,
+	})
+}
+
+// mfaCookie carries the MFA token for logins finished by a browser redirect (email link or SSO)
+// It is HttpOnly and sent only to /api/v1/login/totp, keeping the token out of URLs, history and logs.
+const mfaCookie = "mfa_token"
+
+// setMFACookie stores an MFA token for the /login page's code form to complete; a negative maxAge clears it
+func setMFACookie(w http.ResponseWriter, token string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     mfaCookie,
+		Value:    token,
+		Path:     "/api/v1/login/totp",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   maxAge,
+	})
+}
+
+// newMFAToken starts the second step of a login, returning the token to complete it with
+func (h *Handler) newMFAToken(user *store.User) (string, error) {
+	token, err := generateSessionID()
+	if err != nil {
+		return "", err
+	}
+	if err := h.redisClient.Set(context.Background(), redis.MFAKey(token), user.ID.String(), redis.MFATicketTTL); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// LoginTOTP handles POST /api/v1/login/totp
+// Completes a login that needs a second factor, with an authenticator app code or a recovery code
+func (h *Handler) LoginTOTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req TOTPLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.MFAToken == "" {
+		if cookie, err := r.Cookie(mfaCookie); err == nil {
+			req.MFAToken = cookie.Value
+		}
+	}
+	if req.MFAToken == "" || (req.Code == "" && req.RecoveryCode == "") {
+		http.Error(w, "MFA token and code are required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+
+	clientIP := getClientIP(r)
+	_, err := h.redisClient.CheckRateLimit(ctx, "login", clientIP, 10, redis.LoginRateLimitTTL)
+	if err == redis.ErrRateLimited {
+		log.Printf("ABUSE: Login rate limit exceeded for IP: %s, endpoint: %s, timestamp: %s",
+			clientIP, r.URL.Path, time.Now().UTC().Format(time.RFC3339))
+		http.Error(w, "Too many login attempts. Please try again later.", http.StatusTooManyRequests)
+		return
+	}
+
+	mfaKey := redis.MFAKey(req.MFAToken)
+	userID, err := h.redisClient.Get(ctx, mfaKey)
+	if err != nil {
+		http.Error(w, "Login expired. Please log in again.", http.StatusUnauthorized)
+		return
+	}
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		http.Error(w, "Login expired. Please log in again.", http.StatusUnauthorized)
+		return
+	}
+
+	// Each login allows a few wrong codes before the first factor must be repeated
+	if _, err := h.redisClient.CheckRateLimit(ctx, "mfa", req.MFAToken, maxMFAAttempts, redis.MFATicketTTL); err == redis.ErrRateLimited {
+		h.redisClient.Delete(ctx, mfaKey)
+		http.Error(w, "Too many incorrect codes. Please log in again.", http.StatusUnauthorized)
+		return
+	}
+
+	ok, err := h.verifySecondFactor(userUUID, req.Code, req.RecoveryCode)
+	if err != nil {
+		log.Printf("Error verifying second factor: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		h.auditLog.Record(r, userUUID, audit.EventTOTPFailed, nil)
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	// The MFA token is single-use; losing a race for it means another request already logged in with it
+	if _, err := h.redisClient.GetDel(ctx, mfaKey); err != nil {
+		http.Error(w, "Login expired. Please log in again.", http.StatusUnauthorized)
+		return
+	}
+	h.redisClient.ResetRateLimit(ctx, "mfa", req.MFAToken)
+	setMFACookie(w, "", -1)
+
+	user, err := h.userStore.GetByID(userUUID)
+	if err != nil {
+		log.Printf("Error getting user: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+	h.startSession(w, r, user)
+}
+
+// GetTOTPStatus handles GET /api/v1/account/totp
+func (h *Handler) GetTOTPStatus(w http.ResponseWriter, r *http.Request) {
+	userUUID, ok := h.requestUserID(w, r)
+	if !ok {
+		return
+	}
+	totp, err := h.totpStore.Get(userUUID)
+	if err != nil {
+		log.Printf("Error getting TOTP: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := TOTPStatusResponse{}
+	if totp != nil && totp.EnabledAt != nil {
+		resp.Enabled = true
+		resp.EnabledAt = totp.EnabledAt
+		if resp.RecoveryCodesRemaining, err = h.totpStore.CountRecoveryCodes(userUUID); err != nil {
+			log.Printf("Error counting recovery codes: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+	h.sendJSON(w, resp)
+}
+
+// SetupTOTP handles POST /api/v1/account/totp/setup
+// Creates a new secret to add to an authenticator app; it takes effect once a code from it is confirmed
+func (h *Handler) SetupTOTP(w http.ResponseWriter, r *http.Request) {
+	userUUID, ok := h.requestUserID(w, r)
+	if !ok {
+		return
+	}
+	user, err := h.userStore.GetByID(userUUID)
+	if err != nil || user == nil {
+		log.Printf("Error getting user: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		log.Printf("Error generating TOTP secret: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	encrypted, version, err := h.keyStore.Encrypt([]byte(secret))
+	if err != nil {
+		log.Printf("Error encrypting TOTP secret: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	saved, err := h.totpStore.SavePending(userUUID, encrypted, version)
+	if err != nil {
+		log.Printf("Error storing TOTP secret: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !saved {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	h.sendJSON(w, TOTPSetupResponse{
+		Secret: secret,
+		URI:    TOTPProvisioningURI(totpIssuer, user.Email, secret),
+	})
+}
+
+// EnableTOTP handles POST /api/v1/account/totp/enable
+// Confirms the pending secret with a code from the app and returns the recovery codes, which are only shown once
+func (h *Handler) EnableTOTP(w http.ResponseWriter, r *http.Request) {
+	userUUID, ok := h.requestUserID(w, r)
+	if !ok {
+		return
+	}
+	var req TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !h.checkTOTPAttempts(w, userUUID) {
+		return
+	}
+
+	totp, err := h.totpStore.Get(userUUID)
+	if err != nil {
+		log.Printf("Error getting TOTP: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if totp == nil {
+		http.Error(w, "Start two-factor setup first", http.StatusBadRequest)
+		return
+	}
+	if totp.EnabledAt != nil {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+	secret, err := h.keyStore.DecryptWithVersion(totp.EncryptedSecret, totp.KeyVersion)
+	if err != nil {
+		log.Printf("Error decrypting TOTP secret: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	step, valid := VerifyTOTP(string(secret), req.Code, time.Now(), totp.LastUsedStep)
+	if !valid {
+		http.Error(w, "Invalid code", http.StatusBadRequest)
+		return
+	}
+
+	codes, hashes, err := h.newRecoveryCodes()
+	if err != nil {
+		log.Printf("Error generating recovery codes: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	enabled, err := h.totpStore.Enable(userUUID, step, hashes)
+	if err != nil {
+		log.Printf("Error enabling TOTP: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !enabled {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	log.Printf("TOTP enabled for user %s", userUUID)
+	h.auditLog.Record(r, userUUID, audit.EventTOTPEnabled, nil)
+	h.sendJSON(w, RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DisableTOTP handles POST /api/v1/account/totp/disable
+// Needs a current code or a recovery code, so a stolen session alone cannot turn it off
+func (h *Handler) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	userUUID, ok := h.requestUserID(w, r)
+	if !ok {
+		return
+	}
+	var req TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !h.checkTOTPAttempts(w, userUUID) {
+		return
+	}
+
+	valid, err := h.verifySecondFactor(userUUID, req.Code, req.RecoveryCode)
+	if err != nil {
+		log.Printf("Error verifying second factor: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		http.Error(w, "Invalid code", http.StatusBadRequest)
+		return
+	}
+	if err := h.totpStore.Disable(userUUID); err != nil {
+		log.Printf("Error disabling TOTP: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("TOTP disabled for user %s", userUUID)
+	h.auditLog.Record(r, userUUID, audit.EventTOTPDisabled, nil)
+	h.sendJSON(w, map[string]string{"status": "disabled"})
+}
+
+// RegenerateRecoveryCodes handles POST /api/v1/account/totp/recovery-codes
+// Replaces all recovery codes; needs a current code from the app
+func (h *Handler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userUUID, ok := h.requestUserID(w, r)
+	if !ok {
+		return
+	}
+	var req TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !h.checkTOTPAttempts(w, userUUID) {
+		return
+	}
+
+	valid, err := h.verifySecondFactor(userUUID, req.Code, "")
+	if err != nil {
+		log.Printf("Error verifying second factor: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		http.Error(w, "Invalid code", http.StatusBadRequest)
+		return
+	}
+
+	codes, hashes, err := h.newRecoveryCodes()
+	if err != nil {
+		log.Printf("Error generating recovery codes: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := h.totpStore.ReplaceRecoveryCodes(userUUID, hashes); err != nil {
+		log.Printf("Error storing recovery codes: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	h.sendJSON(w, RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// verifySecondFactor checks an authenticator code, or failing that a recovery code, for a user with TOTP enabled
+// Used codes are recorded so neither kind can be replayed.
+func (h *Handler) verifySecondFactor(userID uuid.UUID, code, recoveryCode string) (bool, error) {
+	totp, err := h.totpStore.Get(userID)
+	if err != nil || totp == nil || totp.EnabledAt == nil {
+		return false, err
+	}
+
+	if code = strings.TrimSpace(code); code != "" {
+		secret, err := h.keyStore.DecryptWithVersion(totp.EncryptedSecret, totp.KeyVersion)
+		if err != nil {
+			return false, err
+		}
+		step, valid := VerifyTOTP(string(secret), code, time.Now(), totp.LastUsedStep)
+		if !valid {
+			return false, nil
+		}
+		return h.totpStore.UseStep(userID, step)
+	}
+	if recoveryCode = strings.TrimSpace(recoveryCode); recoveryCode != "" {
+		used, err := h.totpStore.UseRecoveryCode(userID, h.hashRecoveryCode(recoveryCode))
+		if used {
+			log.Printf("Recovery code used for user %s", userID)
+		}
+		return used, err
+	}
+	return false, nil
+}
+
+// checkTOTPAttempts limits how often a logged-in user can try codes
+func (h *Handler) checkTOTPAttempts(w http.ResponseWriter, userID uuid.UUID) bool {
+	_, err := h.redisClient.CheckRateLimit(context.Background(), "totp", userID.String(), maxTOTPAttempts, redis.LoginRateLimitTTL)
+	if err == redis.ErrRateLimited {
+		http.Error(w, "Too many attempts. Please try again later.", http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// newRecoveryCodes returns fresh recovery codes and the hashes to store
+func (h *Handler) newRecoveryCodes() ([]string, []string, error) {
+	codes, err := NewRecoveryCodes()
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = h.hashRecoveryCode(code)
+	}
+	return codes, hashes, nil
+}
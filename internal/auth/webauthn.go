@@ -0,0 +1,492 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// WebAuthn (passkey) ceremonies. Only what passkey login needs is implemented: attestation statements
+// are not verified ("none" conveyance), and user verification is always required because a passkey
+// replaces the emailed code rather than adding to it.
+
+// COSE algorithm identifiers accepted for passkeys, in order of preference
+const (
+	coseAlgES256 = -7
+	coseAlgEdDSA = -8
+	coseAlgRS256 = -257
+)
+
+// Authenticator data flags
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagBackupElig   = 0x08
+	flagBackupState  = 0x10
+	flagAttested     = 0x40
+)
+
+// WebAuthnTimeoutMs is how long the browser waits for the authenticator
+const WebAuthnTimeoutMs = 120000
+
+var (
+	ErrWebAuthnChallenge = errors.New("challenge does not match")
+	ErrWebAuthnOrigin    = errors.New("origin is not allowed")
+	ErrWebAuthnRP        = errors.New("credential was created for another site")
+	ErrWebAuthnUser      = errors.New("user was not verified by the authenticator")
+	ErrWebAuthnSignature = errors.New("signature is invalid")
+	ErrWebAuthnCounter   = errors.New("signature counter went backwards; the passkey may have been cloned")
+)
+
+// RelyingParty identifies this site to authenticators
+// ID is the registrable domain passkeys are scoped to; Origins are the exact origins pages are served from.
+type RelyingParty struct {
+	ID      string
+	Name    string
+	Origins []string
+}
+
+// Enabled reports whether passkeys are configured
+func (rp *RelyingParty) Enabled() bool {
+	return rp != nil && rp.ID != "" && len(rp.Origins) > 0
+}
+
+// NewChallenge returns 32 random bytes, base64url encoded
+func NewChallenge() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// decodeBase64URL accepts base64url with or without padding, as browsers and libraries differ
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// Options sent to navigator.credentials.create() and .get(); binary values are base64url encoded
+
+type PublicKeyCredentialRP struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type PublicKeyCredentialUser struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type PublicKeyCredentialParam struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type PublicKeyCredentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	RequireResident  bool   `json:"requireResidentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+type CreationOptions struct {
+	Challenge              string                          `json:"challenge"`
+	RP                     PublicKeyCredentialRP           `json:"rp"`
+	User                   PublicKeyCredentialUser         `json:"user"`
+	PubKeyCredParams       []PublicKeyCredentialParam      `json:"pubKeyCredParams"`
+	Timeout                int                             `json:"timeout"`
+	ExcludeCredentials     []PublicKeyCredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection          `json:"authenticatorSelection"`
+	Attestation            string                          `json:"attestation"`
+}
+
+type RequestOptions struct {
+	Challenge        string                          `json:"challenge"`
+	RPID             string                          `json:"rpId"`
+	Timeout          int                             `json:"timeout"`
+	AllowCredentials []PublicKeyCredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                          `json:"userVerification"`
+}
+
+// CreationOptions builds registration options for a user; exclude lists credentials they already have
+func (rp *RelyingParty) CreationOptions(challenge string, userID []byte, name string, exclude []PublicKeyCredentialDescriptor) CreationOptions {
+	if exclude == nil {
+		exclude = []PublicKeyCredentialDescriptor{}
+	}
+	return CreationOptions{
+		Challenge: challenge,
+		RP:        PublicKeyCredentialRP{ID: rp.ID, Name: rp.Name},
+		User: PublicKeyCredentialUser{
+			ID:          base64.RawURLEncoding.EncodeToString(userID),
+			Name:        name,
+			DisplayName: name,
+		},
+		PubKeyCredParams: []PublicKeyCredentialParam{
+			{Type: "public-key", Alg: coseAlgES256},
+			{Type: "public-key", Alg: coseAlgEdDSA},
+			{Type: "public-key", Alg: coseAlgRS256},
+		},
+		Timeout:            WebAuthnTimeoutMs,
+		ExcludeCredentials: exclude,
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      "required",
+			RequireResident:  true,
+			UserVerification: "required",
+		},
+		Attestation: "none",
+	}
+}
+
+// RequestOptions builds login options; with no allowed credentials any discoverable passkey may answer
+func (rp *RelyingParty) RequestOptions(challenge string, allow []PublicKeyCredentialDescriptor) RequestOptions {
+	if allow == nil {
+		allow = []PublicKeyCredentialDescriptor{}
+	}
+	return RequestOptions{
+		Challenge:        challenge,
+		RPID:             rp.ID,
+		Timeout:          WebAuthnTimeoutMs,
+		AllowCredentials: allow,
+		UserVerification: "required",
+	}
+}
+
+// Credentials returned by the browser, with binary values base64url encoded
+
+type AttestationResponse struct {
+	ClientDataJSON    string   `json:"clientDataJSON"`
+	AttestationObject string   `json:"attestationObject"`
+	Transports        []string `json:"transports,omitempty"`
+}
+
+type RegistrationCredential struct {
+	ID       string              `json:"id"`
+	RawID    string              `json:"rawId"`
+	Type     string              `json:"type"`
+	Response AttestationResponse `json:"response"`
+}
+
+type AssertionResponse struct {
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AuthenticatorData string `json:"authenticatorData"`
+	Signature         string `json:"signature"`
+	UserHandle        string `json:"userHandle,omitempty"`
+}
+
+type AssertionCredential struct {
+	ID       string            `json:"id"`
+	RawID    string            `json:"rawId"`
+	Type     string            `json:"type"`
+	Response AssertionResponse `json:"response"`
+}
+
+// RegisteredCredential is a verified new passkey, ready to be stored
+type RegisteredCredential struct {
+	CredentialID   []byte
+	PublicKey      []byte // COSE_Key
+	SignCount      uint32
+	AAGUID         []byte
+	Transports     []string
+	BackupEligible bool
+	BackupState    bool
+}
+
+// Assertion is a verified login
+type Assertion struct {
+	CredentialID []byte
+	UserHandle   []byte
+	SignCount    uint32
+	BackupState  bool
+}
+
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+type authenticatorData struct {
+	rpIDHash  []byte
+	flags     byte
+	signCount uint32
+	// Attested credential data, present during registration
+	aaguid       []byte
+	credentialID []byte
+	publicKey    []byte
+}
+
+// ClientDataChallenge returns the challenge a client signed, so the ceremony it belongs to can be found
+func ClientDataChallenge(clientDataJSON string) (string, error) {
+	raw, err := decodeBase64URL(clientDataJSON)
+	if err != nil {
+		return "", fmt.Errorf("invalid client data")
+	}
+	var cd clientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return "", fmt.Errorf("invalid client data")
+	}
+	return cd.Challenge, nil
+}
+
+// verifyClientData checks the client data of a ceremony and returns its SHA-256 hash
+func (rp *RelyingParty) verifyClientData(clientDataJSON, ceremony, challenge string) ([]byte, error) {
+	raw, err := decodeBase64URL(clientDataJSON)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client data")
+	}
+	var cd clientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return nil, fmt.Errorf("invalid client data")
+	}
+	if cd.Type != ceremony {
+		return nil, fmt.Errorf("client data is for %q, not %q", cd.Type, ceremony)
+	}
+	if subtle.ConstantTimeCompare([]byte(cd.Challenge), []byte(challenge)) != 1 {
+		return nil, ErrWebAuthnChallenge
+	}
+	allowed := false
+	for _, origin := range rp.Origins {
+		if cd.Origin == origin {
+			allowed = true
+			break
+		}
+	}
+	if !allowed || cd.CrossOrigin {
+		return nil, ErrWebAuthnOrigin
+	}
+	sum := sha256.Sum256(raw)
+	return sum[:], nil
+}
+
+// parseAuthenticatorData splits authenticator data and checks it was made for this site by a verified user
+func (rp *RelyingParty) parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, fmt.Errorf("authenticator data is too short")
+	}
+	ad := &authenticatorData{
+		rpIDHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if subtle.ConstantTimeCompare(ad.rpIDHash, rpIDHash[:]) != 1 {
+		return nil, ErrWebAuthnRP
+	}
+	if ad.flags&flagUserPresent == 0 || ad.flags&flagUserVerified == 0 {
+		return nil, ErrWebAuthnUser
+	}
+
+	if ad.flags&flagAttested != 0 {
+		rest := data[37:]
+		if len(rest) < 18 {
+			return nil, fmt.Errorf("attested credential data is too short")
+		}
+		ad.aaguid = rest[:16]
+		idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if idLen == 0 || idLen > 1023 || len(rest) < idLen {
+			return nil, fmt.Errorf("invalid credential ID")
+		}
+		ad.credentialID = rest[:idLen]
+		_, n, err := decodeCBOR(rest[idLen:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid credential public key")
+		}
+		ad.publicKey = rest[idLen : idLen+n]
+	}
+	return ad, nil
+}
+
+// VerifyRegistration checks a new passkey against the challenge issued for it
+func (rp *RelyingParty) VerifyRegistration(cred RegistrationCredential, challenge string) (*RegisteredCredential, error) {
+	if cred.Type != "public-key" {
+		return nil, fmt.Errorf("unsupported credential type")
+	}
+	if _, err := rp.verifyClientData(cred.Response.ClientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	raw, err := decodeBase64URL(cred.Response.AttestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid attestation object")
+	}
+	obj, _, err := decodeCBOR(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid attestation object")
+	}
+	attestation, ok := obj.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid attestation object")
+	}
+	authData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return nil, fmt.Errorf("attestation object has no authenticator data")
+	}
+
+	ad, err := rp.parseAuthenticatorData(authData)
+	if err != nil {
+		return nil, err
+	}
+	if ad.credentialID == nil {
+		return nil, fmt.Errorf("authenticator did not return a credential")
+	}
+	rawID, err := decodeBase64URL(cred.RawID)
+	if err != nil || !bytes.Equal(rawID, ad.credentialID) {
+		return nil, fmt.Errorf("credential ID does not match")
+	}
+	if _, err := parseCOSEKey(ad.publicKey); err != nil {
+		return nil, err
+	}
+
+	return &RegisteredCredential{
+		CredentialID:   ad.credentialID,
+		PublicKey:      ad.publicKey,
+		SignCount:      ad.signCount,
+		AAGUID:         ad.aaguid,
+		Transports:     cred.Response.Transports,
+		BackupEligible: ad.flags&flagBackupElig != 0,
+		BackupState:    ad.flags&flagBackupState != 0,
+	}, nil
+}
+
+// VerifyAssertion checks a login made with a stored passkey
+// publicKey and storedCount are the credential's stored COSE key and signature counter.
+func (rp *RelyingParty) VerifyAssertion(cred AssertionCredential, challenge string, publicKey []byte, storedCount uint32) (*Assertion, error) {
+	if cred.Type != "public-key" {
+		return nil, fmt.Errorf("unsupported credential type")
+	}
+	clientDataHash, err := rp.verifyClientData(cred.Response.ClientDataJSON, "webauthn.get", challenge)
+	if err != nil {
+		return nil, err
+	}
+	authData, err := decodeBase64URL(cred.Response.AuthenticatorData)
+	if err != nil {
+		return nil, fmt.Errorf("invalid authenticator data")
+	}
+	ad, err := rp.parseAuthenticatorData(authData)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := decodeBase64URL(cred.Response.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature")
+	}
+
+	key, err := parseCOSEKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	signed := append(append([]byte(nil), authData...), clientDataHash...)
+	if !key.verify(signed, sig) {
+		return nil, ErrWebAuthnSignature
+	}
+
+	// Authenticators that keep no counter always report 0
+	if (ad.signCount != 0 || storedCount != 0) && ad.signCount <= storedCount {
+		return nil, ErrWebAuthnCounter
+	}
+
+	credentialID, err := decodeBase64URL(cred.RawID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid credential ID")
+	}
+	var userHandle []byte
+	if cred.Response.UserHandle != "" {
+		if userHandle, err = decodeBase64URL(cred.Response.UserHandle); err != nil {
+			return nil, fmt.Errorf("invalid user handle")
+		}
+	}
+	return &Assertion{
+		CredentialID: credentialID,
+		UserHandle:   userHandle,
+		SignCount:    ad.signCount,
+		BackupState:  ad.flags&flagBackupState != 0,
+	}, nil
+}
+
+// coseKey is a credential public key
+type coseKey struct {
+	alg int64
+	key crypto.PublicKey
+}
+
+// parseCOSEKey decodes a COSE_Key (RFC 9053) for one of the accepted algorithms
+func parseCOSEKey(data []byte) (*coseKey, error) {
+	v, _, err := decodeCBOR(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid credential public key")
+	}
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid credential public key")
+	}
+	kty, _ := m[int64(1)].(int64)
+	alg, _ := m[int64(3)].(int64)
+	crv, _ := m[int64(-1)].(int64)
+
+	switch {
+	case alg == coseAlgES256 && kty == 2 && crv == 1:
+		x, _ := m[int64(-2)].([]byte)
+		y, _ := m[int64(-3)].([]byte)
+		if len(x) != 32 || len(y) != 32 {
+			return nil, fmt.Errorf("invalid P-256 public key")
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, fmt.Errorf("invalid P-256 public key")
+		}
+		return &coseKey{alg: alg, key: pub}, nil
+	case alg == coseAlgEdDSA && kty == 1 && crv == 6:
+		x, _ := m[int64(-2)].([]byte)
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 public key")
+		}
+		return &coseKey{alg: alg, key: ed25519.PublicKey(x)}, nil
+	case alg == coseAlgRS256 && kty == 3:
+		n, _ := m[int64(-1)].([]byte)
+		e, _ := m[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid RSA public key")
+		}
+		exp := 0
+		for _, b := range e {
+			exp = exp<<8 | int(b)
+		}
+		return &coseKey{alg: alg, key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp}}, nil
+	}
+	return nil, fmt.Errorf("unsupported credential algorithm %d", alg)
+}
+
+func (k *coseKey) verify(data, sig []byte) bool {
+	switch pub := k.key.(type) {
+	case *ecdsa.PublicKey:
+		sum := sha256.Sum256(data)
+		return ecdsa.VerifyASN1(pub, sum[:], sig)
+	case ed25519.PublicKey:
+		return ed25519.Verify(pub, data, sig)
+	case *rsa.PublicKey:
+		sum := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig) == nil
+	}
+	return false
+}
@@ -0,0 +1,508 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+const (
+	testRPID   = "mud.example.com"
+	testOrigin = "https://mud.example.com"
+)
+
+func testRelyingParty() *RelyingParty {
+	return &RelyingParty{ID: testRPID, Name: "MudPuppy", Origins: []string{testOrigin}}
+}
+
+// cborPair is a map entry for cborEncode; maps are written in the order given
+type cborPair struct {
+	key, value interface{}
+}
+
+// cborEncode writes the subset of CBOR that authenticators produce
+func cborEncode(v interface{}) []byte {
+	var buf bytes.Buffer
+	writeHead := func(major byte, n uint64) {
+		switch {
+		case n < 24:
+			buf.WriteByte(major<<5 | byte(n))
+		case n <= 0xff:
+			buf.WriteByte(major<<5 | 24)
+			buf.WriteByte(byte(n))
+		case n <= 0xffff:
+			buf.WriteByte(major<<5 | 25)
+			buf.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+		default:
+			buf.WriteByte(major<<5 | 26)
+			buf.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+		}
+	}
+	switch v := v.(type) {
+	case int:
+		if v >= 0 {
+			writeHead(0, uint64(v))
+		} else {
+			writeHead(1, uint64(-1-v))
+		}
+	case []byte:
+		writeHead(2, uint64(len(v)))
+		buf.Write(v)
+	case string:
+		writeHead(3, uint64(len(v)))
+		buf.WriteString(v)
+	case []cborPair:
+		writeHead(5, uint64(len(v)))
+		for _, p := range v {
+			buf.Write(cborEncode(p.key))
+			buf.Write(cborEncode(p.value))
+		}
+	default:
+		panic("cborEncode: unsupported type")
+	}
+	return buf.Bytes()
+}
+
+// softAuthenticator is an ECDSA P-256 authenticator that keeps its key in memory
+type softAuthenticator struct {
+	key    *ecdsa.PrivateKey
+	credID []byte
+	rpID   string
+	origin string
+	flags  byte
+	count  uint32
+	// noCounter makes the authenticator report a sign count of 0, as ones without a counter do
+	noCounter bool
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	credID := make([]byte, 16)
+	rand.Read(credID)
+	return &softAuthenticator{
+		key:    key,
+		credID: credID,
+		rpID:   testRPID,
+		origin: testOrigin,
+		flags:  flagUserPresent | flagUserVerified,
+	}
+}
+
+// coseKey returns the authenticator's public key as a COSE_Key
+func (a *softAuthenticator) coseKey() []byte {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	a.key.X.FillBytes(x)
+	a.key.Y.FillBytes(y)
+	return cborEncode([]cborPair{
+		{1, 2},
+		{3, coseAlgES256},
+		{-1, 1},
+		{-2, x},
+		{-3, y},
+	})
+}
+
+func (a *softAuthenticator) clientData(ceremony, challenge string) []byte {
+	data, _ := json.Marshal(clientData{Type: ceremony, Challenge: challenge, Origin: a.origin})
+	return data
+}
+
+func (a *softAuthenticator) authData(attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	data := append([]byte(nil), rpIDHash[:]...)
+	flags := a.flags
+	if attested {
+		flags |= flagAttested
+	}
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, a.count)
+	if attested {
+		data = append(data, make([]byte, 16)...) // AAGUID
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.credID)))
+		data = append(data, a.credID...)
+		data = append(data, a.coseKey()...)
+	}
+	return data
+}
+
+func (a *softAuthenticator) register(challenge string) RegistrationCredential {
+	attestation := cborEncode([]cborPair{
+		{"fmt", "none"},
+		{"attStmt", []cborPair{}},
+		{"authData", a.authData(true)},
+	})
+	return RegistrationCredential{
+		ID:    base64.RawURLEncoding.EncodeToString(a.credID),
+		RawID: base64.RawURLEncoding.EncodeToString(a.credID),
+		Type:  "public-key",
+		Response: AttestationResponse{
+			ClientDataJSON:    base64.RawURLEncoding.EncodeToString(a.clientData("webauthn.create", challenge)),
+			AttestationObject: base64.RawURLEncoding.EncodeToString(attestation),
+		},
+	}
+}
+
+func (a *softAuthenticator) assert(t *testing.T, challenge string) AssertionCredential {
+	t.Helper()
+	if !a.noCounter {
+		a.count++
+	}
+	authData := a.authData(false)
+	clientDataJSON := a.clientData("webauthn.get", challenge)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	return AssertionCredential{
+		ID:    base64.RawURLEncoding.EncodeToString(a.credID),
+		RawID: base64.RawURLEncoding.EncodeToString(a.credID),
+		Type:  "public-key",
+		Response: AssertionResponse{
+			ClientDataJSON:    base64.RawURLEncoding.EncodeToString(clientDataJSON),
+			AuthenticatorData: base64.RawURLEncoding.EncodeToString(authData),
+			Signature:         base64.RawURLEncoding.EncodeToString(sig),
+			UserHandle:        base64.RawURLEncoding.EncodeToString([]byte("user-1")),
+		},
+	}
+}
+
+func mustChallenge(t *testing.T) string {
+	t.Helper()
+	challenge, err := NewChallenge()
+	if err != nil {
+		t.Fatalf("challenge: %v", err)
+	}
+	return challenge
+}
+
+func TestVerifyRegistration(t *testing.T) {
+	rp := testRelyingParty()
+	auth := newSoftAuthenticator(t)
+	challenge := mustChallenge(t)
+
+	reg, err := rp.VerifyRegistration(auth.register(challenge), challenge)
+	if err != nil {
+		t.Fatalf("VerifyRegistration: %v", err)
+	}
+	if !bytes.Equal(reg.CredentialID, auth.credID) {
+		t.Errorf("credential ID = %x, want %x", reg.CredentialID, auth.credID)
+	}
+	if !bytes.Equal(reg.PublicKey, auth.coseKey()) {
+		t.Errorf("public key does not match the authenticator's COSE key")
+	}
+	if reg.SignCount != 0 {
+		t.Errorf("sign count = %d, want 0", reg.SignCount)
+	}
+}
+
+func TestVerifyRegistrationRejects(t *testing.T) {
+	rp := testRelyingParty()
+	challenge := mustChallenge(t)
+
+	tests := []struct {
+		name    string
+		modify  func(a *softAuthenticator, cred *RegistrationCredential)
+		wantErr error
+	}{
+		{
+			name: "wrong challenge",
+			modify: func(a *softAuthenticator, cred *RegistrationCredential) {
+				*cred = a.register("another-challenge")
+			},
+			wantErr: ErrWebAuthnChallenge,
+		},
+		{
+			name: "wrong origin",
+			modify: func(a *softAuthenticator, cred *RegistrationCredential) {
+				a.origin = "https://evil.example.com"
+				*cred = a.register(challenge)
+			},
+			wantErr: ErrWebAuthnOrigin,
+		},
+		{
+			name: "wrong RP ID hash",
+			modify: func(a *softAuthenticator, cred *RegistrationCredential) {
+				a.rpID = "evil.example.com"
+				*cred = a.register(challenge)
+			},
+			wantErr: ErrWebAuthnRP,
+		},
+		{
+			name: "user not verified",
+			modify: func(a *softAuthenticator, cred *RegistrationCredential) {
+				a.flags = flagUserPresent
+				*cred = a.register(challenge)
+			},
+			wantErr: ErrWebAuthnUser,
+		},
+		{
+			name: "mismatched credential ID",
+			modify: func(a *softAuthenticator, cred *RegistrationCredential) {
+				cred.RawID = base64.RawURLEncoding.EncodeToString([]byte("other"))
+			},
+		},
+		{
+			name: "truncated attestation object",
+			modify: func(a *softAuthenticator, cred *RegistrationCredential) {
+				raw, _ := decodeBase64URL(cred.Response.AttestationObject)
+				cred.Response.AttestationObject = base64.RawURLEncoding.EncodeToString(raw[:len(raw)-10])
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth := newSoftAuthenticator(t)
+			cred := auth.register(challenge)
+			tt.modify(auth, &cred)
+
+			_, err := rp.VerifyRegistration(cred, challenge)
+			if err == nil {
+				t.Fatal("VerifyRegistration succeeded, want error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyAssertion(t *testing.T) {
+	rp := testRelyingParty()
+	auth := newSoftAuthenticator(t)
+	publicKey := auth.coseKey()
+
+	var stored uint32
+	for i := 0; i < 3; i++ {
+		challenge := mustChallenge(t)
+		assertion, err := rp.VerifyAssertion(auth.assert(t, challenge), challenge, publicKey, stored)
+		if err != nil {
+			t.Fatalf("VerifyAssertion %d: %v", i, err)
+		}
+		if assertion.SignCount != stored+1 {
+			t.Errorf("sign count = %d, want %d", assertion.SignCount, stored+1)
+		}
+		if !bytes.Equal(assertion.CredentialID, auth.credID) {
+			t.Errorf("credential ID = %x, want %x", assertion.CredentialID, auth.credID)
+		}
+		if string(assertion.UserHandle) != "user-1" {
+			t.Errorf("user handle = %q, want %q", assertion.UserHandle, "user-1")
+		}
+		stored = assertion.SignCount
+	}
+}
+
+func TestVerifyAssertionWithoutCounter(t *testing.T) {
+	rp := testRelyingParty()
+	auth := newSoftAuthenticator(t)
+	challenge := mustChallenge(t)
+
+	auth.noCounter = true
+
+	cred := auth.assert(t, challenge)
+	if _, err := rp.VerifyAssertion(cred, challenge, auth.coseKey(), 0); err != nil {
+		t.Fatalf("VerifyAssertion: %v", err)
+	}
+}
+
+func TestVerifyAssertionRejects(t *testing.T) {
+	rp := testRelyingParty()
+	challenge := mustChallenge(t)
+
+	tests := []struct {
+		name    string
+		stored  uint32
+		modify  func(t *testing.T, a *softAuthenticator, cred *AssertionCredential)
+		wantErr error
+	}{
+		{
+			name: "bad signature",
+			modify: func(t *testing.T, a *softAuthenticator, cred *AssertionCredential) {
+				sig, _ := decodeBase64URL(cred.Response.Signature)
+				sig[len(sig)-1] ^= 0xff
+				cred.Response.Signature = base64.RawURLEncoding.EncodeToString(sig)
+			},
+			wantErr: ErrWebAuthnSignature,
+		},
+		{
+			name: "signed by another key",
+			modify: func(t *testing.T, a *softAuthenticator, cred *AssertionCredential) {
+				other := newSoftAuthenticator(t)
+				other.credID = a.credID
+				*cred = other.assert(t, challenge)
+			},
+			wantErr: ErrWebAuthnSignature,
+		},
+		{
+			name: "altered authenticator data",
+			modify: func(t *testing.T, a *softAuthenticator, cred *AssertionCredential) {
+				data, _ := decodeBase64URL(cred.Response.AuthenticatorData)
+				data[36]++
+				cred.Response.AuthenticatorData = base64.RawURLEncoding.EncodeToString(data)
+			},
+			wantErr: ErrWebAuthnSignature,
+		},
+		{
+			name: "wrong RP ID hash",
+			modify: func(t *testing.T, a *softAuthenticator, cred *AssertionCredential) {
+				a.rpID = "evil.example.com"
+				*cred = a.assert(t, challenge)
+			},
+			wantErr: ErrWebAuthnRP,
+		},
+		{
+			name: "wrong origin",
+			modify: func(t *testing.T, a *softAuthenticator, cred *AssertionCredential) {
+				a.origin = "https://mud.example.com.evil.net"
+				*cred = a.assert(t, challenge)
+			},
+			wantErr: ErrWebAuthnOrigin,
+		},
+		{
+			name: "wrong ceremony",
+			modify: func(t *testing.T, a *softAuthenticator, cred *AssertionCredential) {
+				cred.Response.ClientDataJSON = base64.RawURLEncoding.EncodeToString(a.clientData("webauthn.create", challenge))
+			},
+		},
+		{
+			name: "wrong challenge",
+			modify: func(t *testing.T, a *softAuthenticator, cred *AssertionCredential) {
+				*cred = a.assert(t, "another-challenge")
+			},
+			wantErr: ErrWebAuthnChallenge,
+		},
+		{
+			name:    "sign count repeated",
+			stored:  1,
+			modify:  func(t *testing.T, a *softAuthenticator, cred *AssertionCredential) {},
+			wantErr: ErrWebAuthnCounter,
+		},
+		{
+			name:    "sign count went backwards",
+			stored:  10,
+			modify:  func(t *testing.T, a *softAuthenticator, cred *AssertionCredential) {},
+			wantErr: ErrWebAuthnCounter,
+		},
+		{
+			name:   "counter dropped to zero",
+			stored: 5,
+			modify: func(t *testing.T, a *softAuthenticator, cred *AssertionCredential) {
+				a.count = 0
+				a.noCounter = true
+				*cred = a.assert(t, challenge)
+			},
+			wantErr: ErrWebAuthnCounter,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth := newSoftAuthenticator(t)
+			cred := auth.assert(t, challenge)
+			tt.modify(t, auth, &cred)
+
+			_, err := rp.VerifyAssertion(cred, challenge, auth.coseKey(), tt.stored)
+			if err == nil {
+				t.Fatal("VerifyAssertion succeeded, want error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDecodeCBORMalformed(t *testing.T) {
+	deep := bytes.Repeat([]byte{0x81}, cborMaxDepth+2) // nested one-item arrays
+	deep = append(deep, 0x00)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"truncated head", []byte{0x19, 0x01}},
+		{"truncated byte string", []byte{0x44, 0x01, 0x02}},
+		{"truncated map", []byte{0xa2, 0x01, 0x02, 0x03}},
+		{"deep nesting", deep},
+		{"deep tags", append(bytes.Repeat([]byte{0xc6}, cborMaxDepth+2), 0x00)},
+		{"byte string longer than input", []byte{0x5a, 0xff, 0xff, 0xff, 0xff, 0x00}},
+		{"byte string length over int64", []byte{0x5b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"text length over int64", []byte{0x7b, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+		{"huge array", []byte{0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"huge map", []byte{0xba, 0xff, 0xff, 0xff, 0xff}},
+		{"array over item limit", []byte{0x99, 0x04, 0x01}},
+		{"integer over int64", []byte{0x1b, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+		{"indefinite length", []byte{0x5f, 0x41, 0x00, 0xff}},
+		{"reserved argument", []byte{0x1c}},
+		{"float", []byte{0xf9, 0x3c, 0x00}},
+		{"byte string key", []byte{0xa1, 0x41, 0x00, 0x00}},
+		{"duplicate key", []byte{0xa2, 0x01, 0x00, 0x01, 0x00}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := decodeCBOR(tt.data); err == nil {
+				t.Errorf("decodeCBOR(%x) succeeded, want error", tt.data)
+			}
+		})
+	}
+}
+
+func TestDecodeCBORTruncatedAttestation(t *testing.T) {
+	auth := newSoftAuthenticator(t)
+	raw, _ := decodeBase64URL(auth.register(mustChallenge(t)).Response.AttestationObject)
+
+	if _, n, err := decodeCBOR(raw); err != nil || n != len(raw) {
+		t.Fatalf("decodeCBOR(full) = %d, %v; want %d, nil", n, err, len(raw))
+	}
+	// Every prefix of a valid object must fail cleanly
+	for i := 0; i < len(raw); i++ {
+		if _, _, err := decodeCBOR(raw[:i]); err == nil {
+			t.Fatalf("decodeCBOR of %d/%d bytes succeeded, want error", i, len(raw))
+		}
+	}
+}
+
+func TestParseAuthenticatorDataMalformed(t *testing.T) {
+	rp := testRelyingParty()
+	auth := newSoftAuthenticator(t)
+	full := auth.authData(true)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"too short", full[:36]},
+		{"attested data cut off", full[:37+10]},
+		{"credential ID past end", full[:37+18+len(auth.credID)-1]},
+		{"public key cut off", full[:len(full)-1]},
+		{"credential ID length too large", func() []byte {
+			data := append([]byte(nil), full...)
+			binary.BigEndian.PutUint16(data[37+16:], 0xffff)
+			return data
+		}()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := rp.parseAuthenticatorData(tt.data); err == nil {
+				t.Error("parseAuthenticatorData succeeded, want error")
+			}
+		})
+	}
+}
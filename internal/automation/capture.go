@@ -0,0 +1,104 @@
+package automation
+
+import (
+	"log"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Capture limits
+const (
+	CaptureScrollback = 500 // Lines kept per stream for clients that attach later
+	MaxCaptureStreams = 20  // Streams per session; lines for further streams are not captured
+)
+
+// StreamNameRegex validates capture stream names
+var StreamNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,50}$`)
+
+// CaptureLine is a line of MUD output sent to a capture stream
+// Time is in unix milliseconds; Text keeps the line's ANSI colours
+type CaptureLine struct {
+	Time int64  `json:"t"`
+	Text string `json:"text"`
+}
+
+// CaptureEvent carries new lines of a capture stream, or its scrollback when History is set
+type CaptureEvent struct {
+	Stream  string        `json:"stream"`
+	Lines   []CaptureLine `json:"lines"`
+	History bool          `json:"history,omitempty"`
+}
+
+// capture runs the active capture triggers against a complete line of output
+// raw is the line as received and plain the line without ANSI codes, which triggers match against.
+// It reports whether a trigger moved the line out of the main output.
+func (e *Engine) capture(raw, plain string) bool {
+	var streams []string
+	moved := false
+
+	for _, trigger := range e.ActiveTriggers() {
+		if trigger.Capture == nil {
+			continue
+		}
+		if trigger.Type != "contains" || trigger.Match == "" || !strings.Contains(plain, trigger.Match) {
+			continue
+		}
+		if !slices.Contains(streams, trigger.Capture.Stream) {
+			streams = append(streams, trigger.Capture.Stream)
+		}
+		moved = moved || trigger.Capture.Move
+	}
+
+	if len(streams) == 0 {
+		return false
+	}
+
+	line := CaptureLine{Time: time.Now().UnixMilli(), Text: raw}
+	var stored []string
+	e.captureMu.Lock()
+	for _, stream := range streams {
+		lines, ok := e.captures[stream]
+		if !ok && len(e.captures) >= MaxCaptureStreams {
+			log.Printf("Capture stream %q not created for user=%s: limit of %d streams reached", stream, e.userID, MaxCaptureStreams)
+			continue
+		}
+		lines = append(lines, line)
+		// Trim in batches so appending stays cheap
+		if len(lines) >= 2*CaptureScrollback {
+			lines = append([]CaptureLine(nil), lines[len(lines)-CaptureScrollback:]...)
+		}
+		e.captures[stream] = lines
+		stored = append(stored, stream)
+	}
+	e.captureMu.Unlock()
+
+	// Streams over the limit were not created, so clients are not told about them either
+	for _, stream := range stored {
+		e.publish(Event{Type: EventCapture, Capture: &CaptureEvent{Stream: stream, Lines: []CaptureLine{line}}})
+	}
+	// A line is only taken out of the main output once it is safely in a stream
+	return moved && len(stored) > 0
+}
+
+// CaptureHistory returns the recent lines of each capture stream, oldest first
+func (e *Engine) CaptureHistory() []CaptureEvent {
+	e.captureMu.Lock()
+	defer e.captureMu.Unlock()
+
+	history := make([]CaptureEvent, 0, len(e.captures))
+	for stream, lines := range e.captures {
+		if len(lines) > CaptureScrollback {
+			lines = lines[len(lines)-CaptureScrollback:]
+		}
+		history = append(history, CaptureEvent{
+			Stream:  stream,
+			Lines:   append([]CaptureLine(nil), lines...),
+			History: true,
+		})
+	}
+	sort.Slice(history, func(i, j int) bool { return history[i].Stream < history[j].Stream })
+	return history
+}
@@ -0,0 +1,302 @@
+package automation
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/amaranth494/MudPuppy/internal/script"
+)
+
+// CommandPrefix marks client input that is handled by the server instead of being sent to the MUD
+const CommandPrefix = "#"
+
+// ClassNameRegex validates class (group) names
+var ClassNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,50}$`)
+
+// VariableNameRegex validates variable names, matching the profile environment rules
+var VariableNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// commandNames lists the # commands understood by the engine
+// Anything else starting with # is passed through to the MUD unchanged
+var commandNames = map[string]bool{
+	"class":  true,
+	"script": true,
+	"var":    true,
+	"walk":   true,
+	"path":   true,
+	"travel": true,
+}
+
+// Result is the outcome of a command or script
+type Result struct {
+	Commands []string // Lines to send to the MUD
+	Messages []string // Feedback shown to the client only
+}
+
+// message wraps a single feedback line in a Result
+func message(text string) *Result {
+	return &Result{Messages: []string{text}}
+}
+
+// IsCommand reports whether input is a server-side automation command such as "#class combat off"
+func IsCommand(input string) bool {
+	name, _ := splitCommand(input)
+	return commandNames[name]
+}
+
+// HandleCommand executes a server-side automation command
+// ctx is set when an alias or trigger sent a script action; the result holds any commands
+// to send to the MUD and feedback for the client
+func (e *Engine) HandleCommand(input string, ctx *ScriptContext) (*Result, error) {
+	name, args := splitCommand(input)
+	switch name {
+	case "class":
+		reply, err := e.classCommand(args)
+		if err != nil {
+			return nil, err
+		}
+		return message(reply), nil
+	case "script":
+		src, _ := ScriptSource(input)
+		if src == "" {
+			return nil, fmt.Errorf("usage: %s <code>", ScriptPrefix)
+		}
+		return e.RunScript(src, ctx.Locals())
+	case "var":
+		reply, err := e.varCommand(args)
+		if err != nil {
+			return nil, err
+		}
+		return message(reply), nil
+	case "walk":
+		reply, err := e.walkCommand(args)
+		if err != nil {
+			return nil, err
+		}
+		return message(reply), nil
+	case "travel":
+		reply, err := e.travelCommand(args)
+		if err != nil {
+			return nil, err
+		}
+		return message(reply), nil
+	case "path":
+		reply, err := e.pathCommand(args)
+		if err != nil {
+			return nil, err
+		}
+		return message(reply), nil
+	default:
+		return nil, fmt.Errorf("unknown command: %s%s", CommandPrefix, name)
+	}
+}
+
+// classCommand implements "#class", "#class <name>" and "#class <name> on|off"
+func (e *Engine) classCommand(args []string) (string, error) {
+	if len(args) == 0 {
+		classes := e.Classes()
+		if len(classes) == 0 {
+			return "No classes defined", nil
+		}
+		parts := make([]string, 0, len(classes))
+		for _, c := range classes {
+			parts = append(parts, fmt.Sprintf("%s (%s)", c.Name, onOff(c.Enabled)))
+		}
+		return "Classes: " + strings.Join(parts, ", "), nil
+	}
+
+	name := args[0]
+	if !ClassNameRegex.MatchString(name) {
+		return "", fmt.Errorf("invalid class name: %s", name)
+	}
+
+	if len(args) == 1 {
+		for _, c := range e.Classes() {
+			if c.Name == name {
+				return fmt.Sprintf("Class %s is %s", name, onOff(c.Enabled)), nil
+			}
+		}
+		return "", fmt.Errorf("unknown class: %s", name)
+	}
+
+	var enabled bool
+	switch strings.ToLower(args[1]) {
+	case "on", "enable", "1":
+		enabled = true
+	case "off", "disable", "0":
+		enabled = false
+	default:
+		return "", fmt.Errorf("usage: %sclass <name> on|off", CommandPrefix)
+	}
+
+	if err := e.SetClassEnabled(name, enabled); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Class %s %s", name, onOff(enabled)), nil
+}
+
+// walkCommand implements "#walk <route>", "#walk <path name>" and "#walk stop"
+func (e *Engine) walkCommand(args []string) (string, error) {
+	if len(args) == 0 {
+		return "", fmt.Errorf("usage: %swalk <route>|<path>|stop", CommandPrefix)
+	}
+	if len(args) == 1 && strings.EqualFold(args[0], "stop") {
+		if e.StopWalk() {
+			return "Walk stopped", nil
+		}
+		return "Not walking", nil
+	}
+
+	route := strings.Join(args, " ")
+	label := route
+	if len(args) == 1 {
+		if path, ok := e.Paths().Find(args[0]); ok {
+			route = path.Route
+			label = path.Name
+		}
+	}
+
+	steps, err := ParseSpeedwalk(route)
+	if err != nil {
+		return "", err
+	}
+	if err := e.Walk(label, steps); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Walking %s (%d steps)", label, len(steps)), nil
+}
+
+// travelCommand implements "#travel <room>" and "#travel stop"
+// The room is given by its key, ID or name on the map
+func (e *Engine) travelCommand(args []string) (string, error) {
+	if len(args) == 0 {
+		return "", fmt.Errorf("usage: %stravel <room>|stop", CommandPrefix)
+	}
+	if len(args) == 1 && strings.EqualFold(args[0], "stop") {
+		if e.StopWalk() {
+			return "Travel stopped", nil
+		}
+		return "Not travelling", nil
+	}
+
+	target := strings.Join(args, " ")
+	path, err := e.Travel(target)
+	if err != nil {
+		return "", err
+	}
+	if len(path.Steps) == 0 {
+		return "You are already there", nil
+	}
+	return fmt.Sprintf("Travelling to %s (%d steps)", target, len(path.Steps)), nil
+}
+
+// pathCommand implements "#path", "#path <name>" and "#path <name> <route>"
+func (e *Engine) pathCommand(args []string) (string, error) {
+	paths := e.Paths()
+	if len(args) == 0 {
+		if len(paths.Items) == 0 {
+			return "No paths defined", nil
+		}
+		lines := make([]string, 0, len(paths.Items))
+		for _, p := range paths.Items {
+			lines = append(lines, fmt.Sprintf("%s: %s", p.Name, p.Route))
+		}
+		return "Paths:\n" + strings.Join(lines, "\n"), nil
+	}
+
+	name := args[0]
+	if !PathNameRegex.MatchString(name) {
+		return "", fmt.Errorf("invalid path name: %s", name)
+	}
+
+	if len(args) == 1 {
+		p, ok := paths.Find(name)
+		if !ok {
+			return "", fmt.Errorf("unknown path: %s", name)
+		}
+		return fmt.Sprintf("%s: %s", p.Name, p.Route), nil
+	}
+
+	route := strings.Join(args[1:], " ")
+	if err := e.SavePath(name, route); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Path %s saved", name), nil
+}
+
+// maxVarDisplay truncates long values in #var listings
+const maxVarDisplay = 80
+
+// varCommand implements "#var", "#var <name>" and "#var <name> <value>"
+// Values set this way are runtime variables; numbers are stored as numbers
+func (e *Engine) varCommand(args []string) (string, error) {
+	if len(args) == 0 {
+		vars := e.Variables()
+		if len(vars) == 0 {
+			return "No variables set", nil
+		}
+		names := make([]string, 0, len(vars))
+		for name := range vars {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		lines := make([]string, 0, len(names))
+		for _, name := range names {
+			lines = append(lines, fmt.Sprintf("%s = %s", name, truncate(vars[name].String(), maxVarDisplay)))
+		}
+		return "Variables:\n" + strings.Join(lines, "\n"), nil
+	}
+
+	name := args[0]
+	if !VariableNameRegex.MatchString(name) {
+		return "", fmt.Errorf("invalid variable name: %s", name)
+	}
+
+	if len(args) == 1 {
+		v, ok := e.Variables()[name]
+		if !ok {
+			return "", fmt.Errorf("unknown variable: %s", name)
+		}
+		return fmt.Sprintf("%s = %s", name, v.String()), nil
+	}
+
+	text := strings.Join(args[1:], " ")
+	value, ok := script.ParseNumber(text)
+	if !ok {
+		value = script.String(text)
+	}
+	if err := e.SetVariable(name, value); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s = %s", name, value.String()), nil
+}
+
+func truncate(s string, max int) string {
+	r := []rune(s)
+	if len(r) <= max {
+		return s
+	}
+	return string(r[:max]) + "..."
+}
+
+// splitCommand splits "#name arg1 arg2" into its lower-cased name and arguments
+func splitCommand(input string) (string, []string) {
+	input = strings.TrimSpace(input)
+	if !strings.HasPrefix(input, CommandPrefix) {
+		return "", nil
+	}
+	fields := strings.Fields(strings.TrimPrefix(input, CommandPrefix))
+	if len(fields) == 0 {
+		return "", nil
+	}
+	return strings.ToLower(fields[0]), fields[1:]
+}
+
+func onOff(enabled bool) string {
+	if enabled {
+		return "on"
+	}
+	return "off"
+}
@@ -0,0 +1,281 @@
+package automation
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/amaranth494/MudPuppy/internal/mapper"
+	"github.com/amaranth494/MudPuppy/internal/script"
+	"github.com/amaranth494/MudPuppy/internal/store"
+	"github.com/google/uuid"
+)
+
+// ErrProfileNotFound indicates the profile backing an engine no longer exists
+var ErrProfileNotFound = errors.New("profile not found")
+
+// Event types pushed to clients attached to a live session
+const (
+	EventClasses = "classes"
+	EventMessage = "message"
+	EventWalk    = "walk"
+	EventRoom    = "room"
+	EventCapture = "capture"
+)
+
+// Event is a server-side automation event delivered over the session WebSocket
+type Event struct {
+	Type    string               `json:"type"`
+	Classes []store.ClassSummary `json:"classes,omitempty"`
+	Message string               `json:"message,omitempty"`
+	Walk    *WalkStatus          `json:"walk,omitempty"`
+	Room    *store.MapRoom       `json:"room,omitempty"`
+	Capture *CaptureEvent        `json:"capture,omitempty"`
+}
+
+// ProfileStore is the subset of store.ProfileStore the engine needs
+type ProfileStore interface {
+	GetProfileByConnection(userID, connectionID uuid.UUID) (*store.Profile, error)
+	UpdateProfile(userID, profileID uuid.UUID, updates *store.ProfileUpdate) (*store.Profile, error)
+	SetClassState(userID, profileID uuid.UUID, name string, enabled bool) (*store.Profile, error)
+}
+
+// Options configures optional engine behaviour
+type Options struct {
+	Runtime       RuntimeStore  // Shared store for runtime variables; nil keeps them in memory only
+	FlushInterval time.Duration // How often persistent variables are written back (default DefaultFlushInterval)
+	Send          Sender        // Sends commands to the MUD for speedwalks; nil disables walking
+	MinStepDelay  time.Duration // Lower bound on the pause between walk steps
+	Maps          MapStore      // Automapper storage; nil disables mapping
+}
+
+// Engine holds the live automation state for one MUD session
+// It is created when a session is bound to a saved connection and discarded on disconnect
+type Engine struct {
+	profiles     ProfileStore
+	userID       uuid.UUID
+	connectionID uuid.UUID
+
+	mu      sync.RWMutex
+	profile *store.Profile
+	vars    map[string]script.Value // Session variables visible to scripts
+
+	// Runtime variable bookkeeping (see variables.go)
+	runtime         RuntimeStore
+	persistent      map[string]bool // Profile variables written back when changed
+	dirtyRuntime    map[string]bool
+	dirtyPersistent map[string]bool
+	varSizes        map[string]int // Encoded size of variables scripts changed, for maxSessionVariableBytes
+	done            chan struct{}
+	closeOnce       sync.Once
+
+	scriptMu sync.Mutex
+	classMu  sync.Mutex // Serializes class toggles so their profiles are loaded in order
+
+	// Speedwalking (see walk.go)
+	send         Sender
+	minStepDelay time.Duration
+	walkMu       sync.Mutex
+	walkCancel   context.CancelFunc
+	walkID       int
+	arrivals     chan store.MapRoom // Rooms entered during auto-travel (see travel.go)
+
+	// Automapper (see mapping.go)
+	mapper  *mapper.Mapper
+	lineMu  sync.Mutex
+	lineBuf []byte
+
+	// Capture streams (see capture.go)
+	captureMu sync.Mutex
+	captures  map[string][]CaptureLine // stream -> recent lines
+
+	subsMu sync.Mutex
+	subs   map[chan Event]struct{}
+	closed bool
+}
+
+// NewEngine creates an engine loaded from the profile of a saved connection
+func NewEngine(profiles ProfileStore, userID, connectionID uuid.UUID, opts Options) (*Engine, error) {
+	profile, err := profiles.GetProfileByConnection(userID, connectionID)
+	if err != nil {
+		return nil, err
+	}
+	if profile == nil {
+		return nil, ErrProfileNotFound
+	}
+
+	e := &Engine{
+		profiles:        profiles,
+		userID:          userID,
+		connectionID:    connectionID,
+		profile:         profile,
+		vars:            seedVariables(nil, nil, profile.Variables),
+		runtime:         opts.Runtime,
+		persistent:      persistentNames(profile.Variables),
+		dirtyRuntime:    make(map[string]bool),
+		dirtyPersistent: make(map[string]bool),
+		varSizes:        make(map[string]int),
+		done:            make(chan struct{}),
+		send:            opts.Send,
+		minStepDelay:    opts.MinStepDelay,
+		captures:        make(map[string][]CaptureLine),
+		subs:            make(map[chan Event]struct{}),
+	}
+
+	e.resetRuntime()
+	e.startMapper(opts.Maps)
+
+	interval := opts.FlushInterval
+	if interval <= 0 {
+		interval = DefaultFlushInterval
+	}
+	go e.runFlusher(interval)
+
+	return e, nil
+}
+
+// ConnectionID returns the saved connection the engine was loaded from
+func (e *Engine) ConnectionID() uuid.UUID {
+	return e.connectionID
+}
+
+// Load replaces the engine's profile snapshot, e.g. after automation was edited via the REST API
+func (e *Engine) Load(profile *store.Profile) {
+	if profile == nil {
+		return
+	}
+
+	e.mu.Lock()
+	e.vars = seedVariables(e.vars, e.profile, profile.Variables)
+	e.profile = profile
+	e.persistent = persistentNames(profile.Variables)
+	e.mu.Unlock()
+
+	e.publish(Event{Type: EventClasses, Classes: e.Classes()})
+}
+
+// Classes returns the class summaries for the loaded profile
+func (e *Engine) Classes() []store.ClassSummary {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return store.SummarizeClasses(e.profile.Aliases, e.profile.Triggers, e.profile.Classes)
+}
+
+// ActiveAliases returns the aliases that are enabled and whose class is enabled
+func (e *Engine) ActiveAliases() []store.Alias {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	active := make([]store.Alias, 0, len(e.profile.Aliases.Items))
+	for _, alias := range e.profile.Aliases.Items {
+		if alias.Enabled && e.profile.Classes.IsEnabled(alias.Class) {
+			active = append(active, alias)
+		}
+	}
+	return active
+}
+
+// ActiveTriggers returns the triggers that are enabled and whose class is enabled
+func (e *Engine) ActiveTriggers() []store.Trigger {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	active := make([]store.Trigger, 0, len(e.profile.Triggers.Items))
+	for _, trigger := range e.profile.Triggers.Items {
+		if trigger.Enabled && e.profile.Classes.IsEnabled(trigger.Class) {
+			active = append(active, trigger)
+		}
+	}
+	return active
+}
+
+// SetClassEnabled switches a class on or off, persists the change and notifies subscribers
+// The change is made to the stored class list rather than the engine's snapshot, so toggles from
+// the REST API and other sessions are not overwritten.
+func (e *Engine) SetClassEnabled(name string, enabled bool) error {
+	e.classMu.Lock()
+	defer e.classMu.Unlock()
+
+	e.mu.RLock()
+	profileID := e.profile.ID
+	e.mu.RUnlock()
+
+	updated, err := e.profiles.SetClassState(e.userID, profileID, name, enabled)
+	if err != nil {
+		return err
+	}
+	if updated == nil {
+		return ErrProfileNotFound
+	}
+
+	log.Printf("Class %q set enabled=%t for user=%s", name, enabled, e.userID)
+	e.Load(updated)
+	return nil
+}
+
+// Subscribe registers a listener for engine events
+// The current class list, room and capture scrollback are delivered immediately; call the returned func to unsubscribe
+func (e *Engine) Subscribe() (<-chan Event, func()) {
+	history := e.CaptureHistory()
+	ch := make(chan Event, 16+len(history))
+	ch <- Event{Type: EventClasses, Classes: e.Classes()}
+	if room := e.CurrentRoom(); room != nil {
+		ch <- Event{Type: EventRoom, Room: room}
+	}
+	for i := range history {
+		ch <- Event{Type: EventCapture, Capture: &history[i]}
+	}
+
+	e.subsMu.Lock()
+	if e.closed {
+		close(ch)
+	} else {
+		e.subs[ch] = struct{}{}
+	}
+	e.subsMu.Unlock()
+
+	return ch, func() {
+		e.subsMu.Lock()
+		defer e.subsMu.Unlock()
+		if _, ok := e.subs[ch]; ok {
+			delete(e.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Close detaches all subscribers and stops the variable flusher after a final flush
+// The engine must not be used afterwards
+func (e *Engine) Close() {
+	e.closeOnce.Do(func() { close(e.done) })
+	e.StopWalk()
+	if e.mapper != nil {
+		e.mapper.Close()
+	}
+
+	e.subsMu.Lock()
+	defer e.subsMu.Unlock()
+
+	e.closed = true
+	for ch := range e.subs {
+		delete(e.subs, ch)
+		close(ch)
+	}
+}
+
+// publish delivers an event to all subscribers without blocking
+// Slow subscribers miss events rather than stalling the session
+func (e *Engine) publish(ev Event) {
+	e.subsMu.Lock()
+	defer e.subsMu.Unlock()
+
+	for ch := range e.subs {
+		select {
+		case ch <- ev:
+		default:
+			log.Printf("Dropping automation event %q for user=%s: subscriber full", ev.Type, e.userID)
+		}
+	}
+}
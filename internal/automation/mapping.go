@@ -0,0 +1,117 @@
+package automation
+
+import (
+	"bytes"
+	"log"
+	"regexp"
+
+	"github.com/amaranth494/MudPuppy/internal/mapper"
+	"github.com/amaranth494/MudPuppy/internal/store"
+	"github.com/google/uuid"
+)
+
+// maxLineLength bounds the partial output line buffered between reads
+const maxLineLength = 8192
+
+// ansiRegex matches ANSI escape sequences (colours, cursor movement)
+var ansiRegex = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]`)
+
+// MapStore is the subset of store.MapStore the automapper needs
+type MapStore interface {
+	mapper.Store
+	EnsureMap(userID, connectionID uuid.UUID) (*store.Map, error)
+}
+
+// startMapper attaches the automapper for the engine's connection
+// Mapping is skipped, not fatal, if the map cannot be loaded
+func (e *Engine) startMapper(maps MapStore) {
+	if maps == nil {
+		return
+	}
+	m, err := maps.EnsureMap(e.userID, e.connectionID)
+	if err != nil || m == nil {
+		log.Printf("Automapper disabled for connection=%s: %v", e.connectionID, err)
+		return
+	}
+	e.mapper = mapper.New(maps, m, func(room store.MapRoom) {
+		e.publish(Event{Type: EventRoom, Room: &room})
+		e.notifyArrival(room)
+	})
+}
+
+// Mapper returns the session's automapper, or nil if mapping is disabled
+func (e *Engine) Mapper() *mapper.Mapper {
+	return e.mapper
+}
+
+// CurrentRoom returns the room the player is in, if known
+func (e *Engine) CurrentRoom() *store.MapRoom {
+	if e.mapper == nil {
+		return nil
+	}
+	return e.mapper.Current()
+}
+
+// HandleOutput processes text received from the MUD, with telnet commands already removed
+// Output is split into lines; a trailing partial line is kept until the rest arrives.
+// It returns the output to show in the main window: data without any lines moved to capture streams.
+func (e *Engine) HandleOutput(data []byte) []byte {
+	e.lineMu.Lock()
+	defer e.lineMu.Unlock()
+
+	// Bytes of lineBuf before data were shown with earlier output and cannot be taken back
+	carried := len(e.lineBuf)
+	e.lineBuf = append(e.lineBuf, data...)
+
+	var shown []byte
+	kept, start := 0, 0 // data[kept:lineStart] is still to be copied to shown
+	for {
+		i := bytes.IndexByte(e.lineBuf[start:], '\n')
+		if i < 0 {
+			break
+		}
+		end := start + i + 1
+		raw := bytes.TrimRight(e.lineBuf[start:end-1], "\r")
+		moved := e.handleLine(string(raw), string(ansiRegex.ReplaceAll(raw, nil)))
+		if moved && start >= carried {
+			shown = append(shown, data[kept:start-carried]...)
+			kept = end - carried
+		}
+		start = end
+	}
+	e.lineBuf = e.lineBuf[start:]
+	if len(e.lineBuf) > maxLineLength {
+		e.lineBuf = nil
+	}
+	if len(e.lineBuf) == 0 {
+		e.lineBuf = nil
+	}
+
+	if kept == 0 {
+		return data
+	}
+	return append(shown, data[kept:]...)
+}
+
+// handleLine processes one complete line of MUD output, as received and with ANSI codes removed
+// It reports whether the line was moved to a capture stream
+func (e *Engine) handleLine(raw, plain string) bool {
+	if e.mapper != nil {
+		e.mapper.HandleLine(plain)
+	}
+	return e.capture(raw, plain)
+}
+
+// HandleGMCP processes a GMCP message from the MUD, e.g. "Room.Info" with its JSON payload
+func (e *Engine) HandleGMCP(pkg string, data []byte) {
+	if e.mapper != nil {
+		e.mapper.HandleGMCP(pkg, data)
+	}
+}
+
+// HandleSent notes a command that was sent to the MUD
+func (e *Engine) HandleSent(command string) {
+	if e.mapper != nil {
+		e.mapper.HandleCommand(command)
+	}
+}
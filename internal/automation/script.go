@@ -0,0 +1,92 @@
+package automation
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/amaranth494/MudPuppy/internal/script"
+)
+
+// ScriptPrefix marks an alias, trigger or timer action that is a script rather than plain text
+const ScriptPrefix = CommandPrefix + "script"
+
+// ScriptSource returns the script body if action is a "#script ..." action
+func ScriptSource(action string) (string, bool) {
+	trimmed := strings.TrimSpace(action)
+	if len(trimmed) < len(ScriptPrefix) || !strings.EqualFold(trimmed[:len(ScriptPrefix)], ScriptPrefix) {
+		return "", false
+	}
+	rest := trimmed[len(ScriptPrefix):]
+	if rest != "" && rest[0] != ' ' && rest[0] != '\t' && rest[0] != '\n' {
+		return "", false
+	}
+	return strings.TrimSpace(rest), true
+}
+
+// ScriptContext describes what ran a script action sent by the client
+// It reaches the script as the locals line and args
+type ScriptContext struct {
+	Line string   `json:"line,omitempty"` // Output line that fired a trigger, or input that matched an alias
+	Args []string `json:"args,omitempty"` // Words following an alias pattern
+}
+
+// Locals returns the script locals for the context; a nil context has none
+func (c *ScriptContext) Locals() map[string]script.Value {
+	if c == nil {
+		return nil
+	}
+	args := make([]script.Value, len(c.Args))
+	for i, arg := range c.Args {
+		args[i] = script.String(arg)
+	}
+	return map[string]script.Value{
+		"line": script.String(c.Line),
+		"args": script.List(args),
+	}
+}
+
+// ValidateAction checks the syntax of an action if it is a script
+// Plain text actions are always valid
+func ValidateAction(action string) error {
+	src, ok := ScriptSource(action)
+	if !ok {
+		return nil
+	}
+	return script.Check(src, script.DefaultLimits())
+}
+
+// RunScript executes a script against the session variables
+// locals are visible to the script only, e.g. the line that fired a trigger
+func (e *Engine) RunScript(src string, locals map[string]script.Value) (*Result, error) {
+	// Lists and tables are shared references, so scripts run one at a time
+	e.scriptMu.Lock()
+	defer e.scriptMu.Unlock()
+
+	out, err := script.Run(src, engineEnv{e}, locals, script.DefaultLimits())
+	if out != nil && out.Mutated {
+		e.markContainersDirty()
+	}
+	if dropped := e.syncRuntime(); len(dropped) > 0 {
+		msg := fmt.Sprintf("%v; dropped %s", errSessionVariablesTooLarge, strings.Join(dropped, ", "))
+		if out == nil {
+			out = &script.Output{}
+		}
+		out.Messages = append(out.Messages, msg)
+	}
+	if err != nil {
+		log.Printf("Script failed for user=%s: %v", e.userID, err)
+		var syntaxErr *script.SyntaxError
+		var runtimeErr *script.RuntimeError
+		if !errors.As(err, &syntaxErr) && !errors.As(err, &runtimeErr) {
+			// Limit errors discard partial output so a runaway script sends nothing
+			return nil, err
+		}
+		if out == nil {
+			return nil, err
+		}
+		return &Result{Commands: out.Commands, Messages: append(out.Messages, err.Error())}, nil
+	}
+	return &Result{Commands: out.Commands, Messages: out.Messages}, nil
+}
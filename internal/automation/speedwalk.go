@@ -0,0 +1,93 @@
+package automation
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Speedwalk limits
+const (
+	MaxWalkSteps  = 500 // Maximum steps in one expanded walk
+	maxStepRepeat = 99  // Maximum repeat count for a single direction
+)
+
+// speedwalkDirections lists the direction shorthands, longest first so "ne" wins over "n"
+var speedwalkDirections = []string{"ne", "nw", "se", "sw", "n", "s", "e", "w", "u", "d"}
+
+// ParseSpeedwalk expands a speedwalk route such as "3n2e4s" into single steps
+// Digits repeat the following direction; "(open door)" sends a literal command.
+// Spaces and commas are ignored, so "n,e" can be used to avoid reading "ne" as northeast.
+func ParseSpeedwalk(route string) ([]string, error) {
+	var steps []string
+	i := 0
+	for i < len(route) {
+		c := route[i]
+		if c == ' ' || c == '\t' || c == ',' {
+			i++
+			continue
+		}
+
+		count := 1
+		if c >= '0' && c <= '9' {
+			start := i
+			for i < len(route) && route[i] >= '0' && route[i] <= '9' {
+				i++
+			}
+			if i-start > 2 {
+				return nil, fmt.Errorf("repeat count too large at position %d", start+1)
+			}
+			count = int(route[start] - '0')
+			if i-start == 2 {
+				count = count*10 + int(route[start+1]-'0')
+			}
+			if count < 1 || count > maxStepRepeat {
+				return nil, fmt.Errorf("repeat count must be between 1 and %d at position %d", maxStepRepeat, start+1)
+			}
+			if i >= len(route) {
+				return nil, errors.New("repeat count must be followed by a direction")
+			}
+		}
+
+		var step string
+		if route[i] == '(' {
+			end := strings.IndexByte(route[i:], ')')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed ( at position %d", i+1)
+			}
+			step = strings.TrimSpace(route[i+1 : i+end])
+			if step == "" {
+				return nil, fmt.Errorf("empty command at position %d", i+1)
+			}
+			i += end + 1
+		} else {
+			for _, dir := range speedwalkDirections {
+				if len(route)-i >= len(dir) && strings.EqualFold(route[i:i+len(dir)], dir) {
+					step = dir
+					break
+				}
+			}
+			if step == "" {
+				r := rune(route[i])
+				if unicode.IsPrint(r) {
+					return nil, fmt.Errorf("unknown direction %q at position %d", r, i+1)
+				}
+				return nil, fmt.Errorf("unexpected character at position %d", i+1)
+			}
+			i += len(step)
+		}
+
+		if len(steps)+count > MaxWalkSteps {
+			return nil, fmt.Errorf("walk exceeds %d steps", MaxWalkSteps)
+		}
+		for n := 0; n < count; n++ {
+			steps = append(steps, step)
+		}
+	}
+
+	if len(steps) == 0 {
+		return nil, errors.New("empty route")
+	}
+	return steps, nil
+}
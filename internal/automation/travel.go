@@ -0,0 +1,119 @@
+package automation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/amaranth494/MudPuppy/internal/mapper"
+	"github.com/amaranth494/MudPuppy/internal/store"
+)
+
+// TravelStepTimeout bounds how long auto-travel waits to arrive in the next room
+const TravelStepTimeout = 10 * time.Second
+
+// ErrMappingUnavailable indicates the session has no automapper
+var ErrMappingUnavailable = errors.New("the automapper is not available for this session")
+
+// Travel walks the cheapest mapped path from the current room to target (a room key, ID or name)
+// Steps are paced like a speedwalk, but each step waits for the mapper to report the
+// expected room; travel stops if the player ends up somewhere else. Progress and the
+// result are reported as walk events.
+func (e *Engine) Travel(target string) (*mapper.Path, error) {
+	if e.send == nil {
+		return nil, ErrWalkUnavailable
+	}
+	if e.mapper == nil {
+		return nil, ErrMappingUnavailable
+	}
+
+	path, err := e.mapper.FindPath("", target)
+	if err != nil {
+		return nil, err
+	}
+	if len(path.Steps) == 0 {
+		return path, nil
+	}
+
+	arrivals := make(chan store.MapRoom, 8)
+	ctx, id := e.beginWalk(arrivals)
+	go e.runTravel(ctx, id, "travel to "+target, path, arrivals, e.walkDelay())
+	return path, nil
+}
+
+func (e *Engine) runTravel(ctx context.Context, id int, label string, path *mapper.Path, arrivals <-chan store.MapRoom, delay time.Duration) {
+	status := WalkStatus{Label: label, State: WalkStarted, Total: len(path.Steps)}
+	e.publishWalk(status)
+	log.Printf("Travel %s -> %s started for user=%s: %d steps", path.From, path.To, e.userID, len(path.Steps))
+
+	defer e.endWalk(id)
+
+	fail := func(err error) {
+		log.Printf("Travel failed for user=%s at step %d: %v", e.userID, status.Step+1, err)
+		status.State = WalkFailed
+		status.Error = err.Error()
+		e.publishWalk(status)
+	}
+
+	for i, step := range path.Steps {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				status.State = WalkStopped
+				e.publishWalk(status)
+				return
+			case <-time.After(delay):
+			}
+		}
+
+		// Discard arrivals reported before this step was sent
+	drain:
+		for {
+			select {
+			case <-arrivals:
+			default:
+				break drain
+			}
+		}
+
+		if err := e.send(step.Direction); err != nil {
+			fail(err)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			status.State = WalkStopped
+			e.publishWalk(status)
+			return
+		case room := <-arrivals:
+			if room.Key != step.To {
+				fail(fmt.Errorf("unexpected room %q after %s", room.Name, step.Direction))
+				return
+			}
+		case <-time.After(TravelStepTimeout):
+			fail(fmt.Errorf("no room change after %s", step.Direction))
+			return
+		}
+		status.Step = i + 1
+		e.publishWalk(WalkStatus{Label: label, State: WalkStarted, Step: status.Step, Total: status.Total})
+	}
+
+	status.State = WalkCompleted
+	e.publishWalk(status)
+}
+
+// notifyArrival passes a room change to the auto-travel in progress, if any
+func (e *Engine) notifyArrival(room store.MapRoom) {
+	e.walkMu.Lock()
+	defer e.walkMu.Unlock()
+	if e.arrivals == nil {
+		return
+	}
+	select {
+	case e.arrivals <- room:
+	default:
+	}
+}
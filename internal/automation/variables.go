@@ -0,0 +1,406 @@
+package automation
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/amaranth494/MudPuppy/internal/redis"
+	"github.com/amaranth494/MudPuppy/internal/script"
+	"github.com/amaranth494/MudPuppy/internal/store"
+	"github.com/google/uuid"
+)
+
+// maxSessionVariables bounds how many variables scripts may create in one session
+const maxSessionVariables = 500
+
+// maxSessionVariableBytes bounds the JSON encoded size of the variables scripts change in one session
+// Checked after each script, so a list that grows a little on every run cannot grow without limit.
+const maxSessionVariableBytes = 256 << 10
+
+// errSessionVariablesTooLarge is reported for variables dropped for exceeding maxSessionVariableBytes
+var errSessionVariablesTooLarge = fmt.Errorf("session variables are limited to %d KB in total", maxSessionVariableBytes>>10)
+
+// DefaultFlushInterval is how often changed persistent variables are written to the profile
+const DefaultFlushInterval = 10 * time.Second
+
+// runtimeTimeout bounds each round trip to the runtime variable store
+const runtimeTimeout = 2 * time.Second
+
+// RuntimeStore shares a session's runtime variables outside the engine, e.g. in Redis so
+// other server instances can read them. Values are JSON encoded script values.
+// Engines without a RuntimeStore keep runtime variables in memory only.
+type RuntimeStore interface {
+	SaveVariables(ctx context.Context, userID uuid.UUID, set map[string]string, deleted []string) error
+	LoadVariables(ctx context.Context, userID uuid.UUID) (map[string]string, error)
+	ClearVariables(ctx context.Context, userID uuid.UUID) error
+}
+
+// redisRuntimeStore keeps runtime variables in a Redis hash per user session
+type redisRuntimeStore struct {
+	client *redis.Client
+}
+
+// NewRedisRuntimeStore returns a RuntimeStore backed by Redis
+func NewRedisRuntimeStore(client *redis.Client) RuntimeStore {
+	return &redisRuntimeStore{client: client}
+}
+
+func (s *redisRuntimeStore) SaveVariables(ctx context.Context, userID uuid.UUID, set map[string]string, deleted []string) error {
+	return s.client.SaveSessionVars(ctx, userID.String(), set, deleted)
+}
+
+func (s *redisRuntimeStore) LoadVariables(ctx context.Context, userID uuid.UUID) (map[string]string, error) {
+	return s.client.GetSessionVars(ctx, userID.String())
+}
+
+func (s *redisRuntimeStore) ClearVariables(ctx context.Context, userID uuid.UUID) error {
+	return s.client.DeleteSessionVars(ctx, userID.String())
+}
+
+// Variables returns a snapshot of the session variables, profile defaults included
+func (e *Engine) Variables() map[string]script.Value {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	vars := make(map[string]script.Value, len(e.vars))
+	for name, v := range e.vars {
+		vars[name] = v
+	}
+	return vars
+}
+
+// EncodedVariables returns the session variables JSON encoded, skipping any that cannot be
+func (e *Engine) EncodedVariables() map[string]string {
+	// Scripts change lists and tables in place, so none may run while they are encoded
+	e.scriptMu.Lock()
+	defer e.scriptMu.Unlock()
+
+	vars := make(map[string]string)
+	for name, v := range e.Variables() {
+		data, err := v.MarshalJSON()
+		if err != nil {
+			continue
+		}
+		vars[name] = string(data)
+	}
+	return vars
+}
+
+// SetVariable sets a session variable as if a script had assigned it
+func (e *Engine) SetVariable(name string, value script.Value) error {
+	e.scriptMu.Lock()
+	defer e.scriptMu.Unlock()
+	if err := (engineEnv{e}).Set(name, value); err != nil {
+		return err
+	}
+	if dropped := e.syncRuntime(); len(dropped) > 0 {
+		return errSessionVariablesTooLarge
+	}
+	return nil
+}
+
+// engineEnv exposes the engine's session variables to scripts
+type engineEnv struct {
+	e *Engine
+}
+
+func (env engineEnv) Get(name string) (script.Value, bool) {
+	env.e.mu.RLock()
+	defer env.e.mu.RUnlock()
+	v, ok := env.e.vars[name]
+	return v, ok
+}
+
+// Set records the new value and marks it for the runtime store and, if the
+// profile declares the variable persistent, for the next profile flush
+func (env engineEnv) Set(name string, value script.Value) error {
+	e := env.e
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.vars[name]; !ok && len(e.vars) >= maxSessionVariables {
+		return errors.New("too many variables")
+	}
+	e.vars[name] = value
+	e.dirtyRuntime[name] = true
+	if e.persistent[name] {
+		e.dirtyPersistent[name] = true
+	}
+	return nil
+}
+
+// markContainersDirty marks every list and table variable as changed
+// Called after a script changed a list or table in place: it may have done so through a local
+// or another variable, so any of them may have grown.
+func (e *Engine) markContainersDirty() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for name, v := range e.vars {
+		if v.Kind() != script.KindList && v.Kind() != script.KindTable {
+			continue
+		}
+		e.dirtyRuntime[name] = true
+		if e.persistent[name] {
+			e.dirtyPersistent[name] = true
+		}
+	}
+}
+
+// resetRuntime discards runtime variables left over from the user's previous session
+// Runtime variables expire with the session, so this only matters on reconnect
+func (e *Engine) resetRuntime() {
+	if e.runtime == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), runtimeTimeout)
+	defer cancel()
+	if err := e.runtime.ClearVariables(ctx, e.userID); err != nil {
+		log.Printf("Failed to clear runtime variables for user=%s: %v", e.userID, err)
+	}
+}
+
+// syncRuntime encodes the variables changed since the last sync and writes them to the runtime store in one batch
+// Variables that would take the session past maxSessionVariableBytes are dropped; their names are returned.
+// Callers hold scriptMu, so the values cannot change while they are encoded.
+func (e *Engine) syncRuntime() []string {
+	e.mu.Lock()
+	if len(e.dirtyRuntime) == 0 {
+		e.mu.Unlock()
+		return nil
+	}
+	changed := make(map[string]script.Value, len(e.dirtyRuntime))
+	for name := range e.dirtyRuntime {
+		changed[name] = e.vars[name]
+	}
+	total := 0
+	for name, n := range e.varSizes {
+		if _, ok := changed[name]; !ok {
+			total += n
+		}
+	}
+	e.dirtyRuntime = make(map[string]bool)
+	e.mu.Unlock()
+
+	// Encode outside the lock; in name order so which variable goes over the limit is predictable
+	names := make([]string, 0, len(changed))
+	for name := range changed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	set := make(map[string]string, len(changed))
+	sizes := make(map[string]int, len(changed))
+	var deleted, dropped []string
+	for _, name := range names {
+		v := changed[name]
+		if v.Kind() == script.KindNil {
+			deleted = append(deleted, name)
+			continue
+		}
+		data, err := v.MarshalJSON()
+		if err == nil && total+len(data) > maxSessionVariableBytes {
+			err = errSessionVariablesTooLarge
+		}
+		if err == script.ErrValueTooLarge || err == errSessionVariablesTooLarge {
+			log.Printf("Dropping variable %q for user=%s: %v", name, e.userID, err)
+			dropped = append(dropped, name)
+			deleted = append(deleted, name)
+			continue
+		}
+		if err != nil {
+			log.Printf("Cannot store variable %q for user=%s: %v", name, e.userID, err)
+			continue
+		}
+		total += len(data)
+		sizes[name] = len(data)
+		set[name] = string(data)
+	}
+
+	e.mu.Lock()
+	for name := range changed {
+		delete(e.varSizes, name)
+	}
+	for name, n := range sizes {
+		e.varSizes[name] = n
+	}
+	for _, name := range dropped {
+		delete(e.vars, name)
+		delete(e.dirtyPersistent, name)
+	}
+	e.mu.Unlock()
+
+	if e.runtime == nil {
+		return dropped
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), runtimeTimeout)
+	defer cancel()
+	if err := e.runtime.SaveVariables(ctx, e.userID, set, deleted); err != nil {
+		log.Printf("Failed to save runtime variables for user=%s: %v", e.userID, err)
+	}
+	return dropped
+}
+
+// runFlusher periodically writes changed persistent variables back to the profile
+// It exits when the engine is closed, after a final flush
+func (e *Engine) runFlusher(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			e.flushPersistent()
+		case <-e.done:
+			e.flushPersistent()
+			return
+		}
+	}
+}
+
+// flushPersistent writes all pending persistent variables to the profile in a single update
+func (e *Engine) flushPersistent() {
+	e.mu.Lock()
+	if len(e.dirtyPersistent) == 0 {
+		e.mu.Unlock()
+		return
+	}
+	pending := make(map[string]script.Value, len(e.dirtyPersistent))
+	for name := range e.dirtyPersistent {
+		pending[name] = e.vars[name]
+	}
+	e.dirtyPersistent = make(map[string]bool)
+	e.mu.Unlock()
+
+	if err := e.writePersistent(pending); err != nil {
+		log.Printf("Failed to flush variables for user=%s: %v", e.userID, err)
+		// Retry on the next tick unless a newer change is already pending
+		e.mu.Lock()
+		for name := range pending {
+			e.dirtyPersistent[name] = true
+		}
+		e.mu.Unlock()
+	}
+}
+
+func (e *Engine) writePersistent(pending map[string]script.Value) error {
+	// Re-read the profile so edits made through the REST API are not overwritten
+	profile, err := e.profiles.GetProfileByConnection(e.userID, e.connectionID)
+	if err != nil {
+		return err
+	}
+	if profile == nil {
+		return ErrProfileNotFound
+	}
+
+	items := make([]store.Variable, len(profile.Variables.Items))
+	copy(items, profile.Variables.Items)
+	written := make([]string, 0, len(pending))
+	for i, item := range items {
+		value, ok := pending[item.Name]
+		if !ok || !item.Persistent {
+			continue
+		}
+		updated, err := variableFromValue(item, value)
+		if err != nil {
+			log.Printf("Not persisting variable %q for user=%s: %v", item.Name, e.userID, err)
+			continue
+		}
+		items[i] = updated
+		written = append(written, item.Name)
+	}
+	if len(written) == 0 {
+		return nil
+	}
+
+	variables := store.Variables{Items: items}
+	updated, err := e.profiles.UpdateProfile(e.userID, profile.ID, &store.ProfileUpdate{Variables: &variables})
+	if err != nil {
+		return err
+	}
+	if updated == nil {
+		return ErrProfileNotFound
+	}
+
+	e.mu.Lock()
+	e.profile = updated
+	e.persistent = persistentNames(updated.Variables)
+	e.mu.Unlock()
+
+	sort.Strings(written)
+	log.Printf("Flushed %d persistent variables for user=%s: %v", len(written), e.userID, written)
+	return nil
+}
+
+// variableFromValue stores a script value into a profile variable, keeping its ID, name and type
+func variableFromValue(v store.Variable, value script.Value) (store.Variable, error) {
+	if value.Kind() == script.KindNil {
+		return v, errors.New("value is nil")
+	}
+	data, err := value.MarshalJSON()
+	if err != nil {
+		return v, err
+	}
+	v.Value = data
+	if err := v.Normalize(); err != nil {
+		return v, err
+	}
+	return v, nil
+}
+
+// VariableValue converts a stored profile variable into a script value
+func VariableValue(v store.Variable) (script.Value, error) {
+	value, err := script.FromJSON(v.Value)
+	if err != nil {
+		return script.Nil, err
+	}
+	// Whole numbers stored in float variables stay floats
+	if v.Type == store.VariableTypeFloat && value.Kind() == script.KindInt {
+		return script.Float(value.Float64()), nil
+	}
+	return value, nil
+}
+
+// persistentNames returns the names of the profile variables marked persistent
+func persistentNames(profileVars store.Variables) map[string]bool {
+	names := make(map[string]bool)
+	for _, v := range profileVars.Items {
+		if v.Persistent {
+			names[v.Name] = true
+		}
+	}
+	return names
+}
+
+// seedVariables copies profile variables into the session variable map
+// Values set during the session survive profile reloads unless the variable itself
+// was edited in the profile; previous is the profile snapshot being replaced (nil on start)
+func seedVariables(vars map[string]script.Value, previous *store.Profile, profileVars store.Variables) map[string]script.Value {
+	if vars == nil {
+		vars = make(map[string]script.Value, len(profileVars.Items))
+	}
+	before := make(map[string]store.Variable)
+	if previous != nil {
+		for _, v := range previous.Variables.Items {
+			before[v.Name] = v
+		}
+	}
+	for _, v := range profileVars.Items {
+		if _, ok := vars[v.Name]; ok {
+			old, existed := before[v.Name]
+			if existed && old.Type == v.Type && bytes.Equal(old.Value, v.Value) {
+				continue
+			}
+		}
+		value, err := VariableValue(v)
+		if err != nil {
+			log.Printf("Skipping variable %q: %v", v.Name, err)
+			continue
+		}
+		vars[v.Name] = value
+	}
+	return vars
+}
@@ -0,0 +1,193 @@
+package automation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/amaranth494/MudPuppy/internal/store"
+	"github.com/google/uuid"
+)
+
+// MaxPaths is the maximum number of named paths per profile
+const MaxPaths = 200
+
+// PathNameRegex validates path names such as "bank-guild"
+var PathNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,50}$`)
+
+// Walk states reported in walk events
+const (
+	WalkStarted   = "started"
+	WalkCompleted = "completed"
+	WalkStopped   = "stopped"
+	WalkFailed    = "failed"
+)
+
+// ErrWalkUnavailable indicates the engine has no way to send commands to the MUD
+var ErrWalkUnavailable = errors.New("walking is not available for this session")
+
+// Sender sends a single command to the MUD
+type Sender func(command string) error
+
+// WalkStatus reports the progress of a speedwalk
+type WalkStatus struct {
+	Label string `json:"label"`
+	State string `json:"state"`
+	Step  int    `json:"step"`
+	Total int    `json:"total"`
+	Error string `json:"error,omitempty"`
+}
+
+// Walk sends steps to the MUD one at a time, paced by the profile's walk delay
+// Any walk already in progress is stopped first
+func (e *Engine) Walk(label string, steps []string) error {
+	if e.send == nil {
+		return ErrWalkUnavailable
+	}
+
+	ctx, id := e.beginWalk(nil)
+	go e.runWalk(ctx, id, label, steps, e.walkDelay())
+	return nil
+}
+
+// beginWalk stops any walk or travel in progress and registers a new one
+// arrivals, if not nil, receives the rooms entered while the new walk runs
+func (e *Engine) beginWalk(arrivals chan store.MapRoom) (context.Context, int) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	e.walkMu.Lock()
+	defer e.walkMu.Unlock()
+	if e.walkCancel != nil {
+		e.walkCancel()
+	}
+	e.walkID++
+	e.walkCancel = cancel
+	e.arrivals = arrivals
+	return ctx, e.walkID
+}
+
+// endWalk clears the walk registration if it still belongs to walk id
+func (e *Engine) endWalk(id int) {
+	e.walkMu.Lock()
+	defer e.walkMu.Unlock()
+	if e.walkID == id {
+		e.walkCancel = nil
+		e.arrivals = nil
+	}
+}
+
+// StopWalk stops the walk in progress, reporting whether there was one
+func (e *Engine) StopWalk() bool {
+	e.walkMu.Lock()
+	defer e.walkMu.Unlock()
+
+	if e.walkCancel == nil {
+		return false
+	}
+	e.walkCancel()
+	e.walkCancel = nil
+	return true
+}
+
+// walkDelay returns the pause between steps: the profile setting, but never faster
+// than the session's command rate limit allows
+func (e *Engine) walkDelay() time.Duration {
+	e.mu.RLock()
+	delay := time.Duration(e.profile.Settings.WalkDelayMs) * time.Millisecond
+	e.mu.RUnlock()
+
+	if delay < e.minStepDelay {
+		delay = e.minStepDelay
+	}
+	return delay
+}
+
+func (e *Engine) runWalk(ctx context.Context, id int, label string, steps []string, delay time.Duration) {
+	status := WalkStatus{Label: label, State: WalkStarted, Total: len(steps)}
+	e.publishWalk(status)
+	log.Printf("Walk %q started for user=%s: %d steps, %v apart", label, e.userID, len(steps), delay)
+
+	defer e.endWalk(id)
+
+	for i, step := range steps {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				status.State = WalkStopped
+				e.publishWalk(status)
+				return
+			case <-time.After(delay):
+			}
+		}
+		if ctx.Err() != nil {
+			status.State = WalkStopped
+			e.publishWalk(status)
+			return
+		}
+
+		if err := e.send(step); err != nil {
+			log.Printf("Walk %q failed for user=%s at step %d: %v", label, e.userID, i+1, err)
+			status.State = WalkFailed
+			status.Error = err.Error()
+			e.publishWalk(status)
+			return
+		}
+		status.Step = i + 1
+	}
+
+	status.State = WalkCompleted
+	e.publishWalk(status)
+}
+
+func (e *Engine) publishWalk(status WalkStatus) {
+	e.publish(Event{Type: EventWalk, Walk: &status})
+}
+
+// SavePath creates or replaces a named path on the profile
+func (e *Engine) SavePath(name, route string) error {
+	if _, err := ParseSpeedwalk(route); err != nil {
+		return err
+	}
+
+	e.mu.RLock()
+	profileID := e.profile.ID
+	items := make([]store.Path, 0, len(e.profile.Paths.Items)+1)
+	replaced := false
+	for _, p := range e.profile.Paths.Items {
+		if strings.EqualFold(p.Name, name) {
+			p.Route = route
+			replaced = true
+		}
+		items = append(items, p)
+	}
+	e.mu.RUnlock()
+
+	if !replaced {
+		if len(items) >= MaxPaths {
+			return fmt.Errorf("maximum %d paths allowed", MaxPaths)
+		}
+		items = append(items, store.Path{ID: uuid.NewString(), Name: name, Route: route})
+	}
+
+	paths := store.Paths{Items: items}
+	updated, err := e.profiles.UpdateProfile(e.userID, profileID, &store.ProfileUpdate{Paths: &paths})
+	if err != nil {
+		return err
+	}
+	if updated == nil {
+		return ErrProfileNotFound
+	}
+	e.Load(updated)
+	return nil
+}
+
+// Paths returns the named paths stored on the profile
+func (e *Engine) Paths() store.Paths {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.profile.Paths
+}
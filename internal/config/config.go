@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 )
 
 // Config holds all configuration values
@@ -24,6 +25,7 @@ type Config struct {
 
 	// OTP
 	OTPExpiryMinutes int
+	DevLogOTPCodes   bool // Log codes when SMTP is not configured; for local development only
 
 	// MUD Session Proxy (SP02)
 	PortWhitelist          string
@@ -41,6 +43,34 @@ type Config struct {
 
 	// Admin
 	AdminMetricsSecret string
+
+	// Automation runtime variables
+	SessionVarsBackend   string
+	VariableFlushSeconds int
+
+	// Session transcripts
+	LogRetentionDays     int
+	LogMaxMBPerUser      int
+	LogPruneIntervalMins int
+
+	// Lines of output the server keeps for each session
+	ScrollbackLines int
+
+	// Public URL of the app, used in emailed login links; links are disabled when empty
+	AppBaseURL string
+
+	// Passkeys (WebAuthn); disabled unless WebAuthnRPID is set
+	WebAuthnRPID    string
+	WebAuthnRPName  string
+	WebAuthnOrigins []string
+
+	// Single sign-on (OpenID Connect); disabled unless OIDCIssuer and OIDCClientID are set
+	OIDCIssuer       string
+	OIDCClientID     string
+	OIDCClientSecret string
+	OIDCRedirectURL  string
+	OIDCScopes       []string
+	OIDCProviderName string
 }
 
 // Load loads configuration from environment variables
@@ -87,6 +117,7 @@ func Load() (*Config, error) {
 			cfg.OTPExpiryMinutes = expiry
 		}
 	}
+	cfg.DevLogOTPCodes = os.Getenv("DEV_LOG_OTP_CODES") == "true"
 
 	// MUD Session Proxy configuration (SP02PH01)
 	// Port whitelist (comma-separated, defaults to 23 for telnet)
@@ -159,5 +190,111 @@ func Load() (*Config, error) {
 	cfg.EncryptionKeyV2 = os.Getenv("ENCRYPTION_KEY_V2")
 	cfg.EncryptionKeyV3 = os.Getenv("ENCRYPTION_KEY_V3")
 
+	// Runtime variable storage: "memory" (default) or "redis" for multi-instance deployments
+	cfg.SessionVarsBackend = os.Getenv("SESSION_VARS_BACKEND")
+	switch cfg.SessionVarsBackend {
+	case "":
+		cfg.SessionVarsBackend = "memory"
+	case "memory", "redis":
+	default:
+		log.Printf("Warning: Invalid SESSION_VARS_BACKEND '%s', using default memory", cfg.SessionVarsBackend)
+		cfg.SessionVarsBackend = "memory"
+	}
+
+	// How often changed persistent variables are written to the profile (defaults to 10 seconds)
+	cfg.VariableFlushSeconds = 10
+	if flushStr := os.Getenv("VARIABLE_FLUSH_SECONDS"); flushStr != "" {
+		flush, err := strconv.Atoi(flushStr)
+		if err != nil || flush <= 0 {
+			log.Printf("Warning: Invalid VARIABLE_FLUSH_SECONDS '%s', using default 10", flushStr)
+		} else {
+			cfg.VariableFlushSeconds = flush
+		}
+	}
+
+	// Default session log retention; users may choose shorter limits
+	cfg.LogRetentionDays = 30
+	if daysStr := os.Getenv("LOG_RETENTION_DAYS"); daysStr != "" {
+		days, err := strconv.Atoi(daysStr)
+		if err != nil || days <= 0 {
+			log.Printf("Warning: Invalid LOG_RETENTION_DAYS '%s', using default 30", daysStr)
+		} else {
+			cfg.LogRetentionDays = days
+		}
+	}
+
+	cfg.LogMaxMBPerUser = 100
+	if mbStr := os.Getenv("LOG_MAX_MB_PER_USER"); mbStr != "" {
+		mb, err := strconv.Atoi(mbStr)
+		if err != nil || mb <= 0 {
+			log.Printf("Warning: Invalid LOG_MAX_MB_PER_USER '%s', using default 100", mbStr)
+		} else {
+			cfg.LogMaxMBPerUser = mb
+		}
+	}
+
+	// How often the pruning job deletes logs past their retention limits (defaults to 60 minutes)
+	cfg.LogPruneIntervalMins = 60
+	if pruneStr := os.Getenv("LOG_PRUNE_INTERVAL_MINUTES"); pruneStr != "" {
+		prune, err := strconv.Atoi(pruneStr)
+		if err != nil || prune <= 0 {
+			log.Printf("Warning: Invalid LOG_PRUNE_INTERVAL_MINUTES '%s', using default 60", pruneStr)
+		} else {
+			cfg.LogPruneIntervalMins = prune
+		}
+	}
+
+	// Server-side scrollback, so clients that attach to a running session can page back (defaults to 5000 lines)
+	cfg.ScrollbackLines = 5000
+	if linesStr := os.Getenv("SCROLLBACK_LINES"); linesStr != "" {
+		lines, err := strconv.Atoi(linesStr)
+		if err != nil || lines <= 0 {
+			log.Printf("Warning: Invalid SCROLLBACK_LINES '%s', using default 5000", linesStr)
+		} else {
+			cfg.ScrollbackLines = lines
+		}
+	}
+
+	// Passkey relying party: the domain passkeys belong to and the origins the app is served from
+	cfg.WebAuthnRPID = os.Getenv("WEBAUTHN_RP_ID")
+	cfg.WebAuthnRPName = os.Getenv("WEBAUTHN_RP_NAME")
+	if cfg.WebAuthnRPName == "" {
+		cfg.WebAuthnRPName = "MUDPuppy"
+	}
+	for _, origin := range strings.Split(os.Getenv("WEBAUTHN_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			cfg.WebAuthnOrigins = append(cfg.WebAuthnOrigins, origin)
+		}
+	}
+	if cfg.WebAuthnRPID != "" && len(cfg.WebAuthnOrigins) == 0 {
+		cfg.WebAuthnOrigins = []string{"https://" + cfg.WebAuthnRPID}
+	}
+
+	// App base URL for links in emails; never taken from the request Host header, which clients control
+	cfg.AppBaseURL = strings.TrimSuffix(os.Getenv("APP_BASE_URL"), "/")
+	if cfg.AppBaseURL == "" && len(cfg.WebAuthnOrigins) > 0 {
+		cfg.AppBaseURL = cfg.WebAuthnOrigins[0]
+	}
+
+	// OpenID Connect provider; endpoints and signing keys are discovered from the issuer
+	cfg.OIDCIssuer = os.Getenv("OIDC_ISSUER")
+	cfg.OIDCClientID = os.Getenv("OIDC_CLIENT_ID")
+	cfg.OIDCClientSecret = os.Getenv("OIDC_CLIENT_SECRET")
+	cfg.OIDCRedirectURL = os.Getenv("OIDC_REDIRECT_URL")
+	if cfg.OIDCRedirectURL == "" && cfg.AppBaseURL != "" {
+		cfg.OIDCRedirectURL = cfg.AppBaseURL + "/api/v1/oidc/callback"
+	}
+	cfg.OIDCScopes = strings.Fields(os.Getenv("OIDC_SCOPES"))
+	if len(cfg.OIDCScopes) == 0 {
+		cfg.OIDCScopes = []string{"openid", "email", "profile"}
+	}
+	cfg.OIDCProviderName = os.Getenv("OIDC_PROVIDER_NAME")
+	if cfg.OIDCProviderName == "" {
+		cfg.OIDCProviderName = "Single Sign-On"
+	}
+	if cfg.OIDCIssuer != "" && cfg.OIDCClientID != "" && cfg.OIDCRedirectURL == "" {
+		log.Printf("Warning: OIDC_ISSUER is set but neither OIDC_REDIRECT_URL nor APP_BASE_URL is, single sign-on is disabled")
+	}
+
 	return cfg, nil
 }
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/amaranth494/MudPuppy/internal/audit"
 	"github.com/amaranth494/MudPuppy/internal/crypto"
 	"github.com/amaranth494/MudPuppy/internal/session"
 	"github.com/amaranth494/MudPuppy/internal/store"
@@ -21,6 +22,7 @@ type Handler struct {
 	credStore  *store.CredentialsStore
 	crypto     *crypto.KeyStore
 	sessionMgr *session.Manager
+	auditLog   *audit.Log
 }
 
 // NewHandler creates a new connections handler
@@ -33,6 +35,11 @@ func NewHandler(connStore *store.ConnectionStore, credStore *store.CredentialsSt
 	}
 }
 
+// SetAuditLog sets the log that saved credential and auto-login changes are recorded in
+func (h *Handler) SetAuditLog(l *audit.Log) {
+	h.auditLog = l
+}
+
 // GetCredentialsForAutoLogin retrieves credentials for auto-login
 // Returns username, password if auto_login is enabled; otherwise returns empty strings
 func (h *Handler) GetCredentialsForAutoLogin(connectionID uuid.UUID) (username, password string, err error) {
@@ -475,6 +482,12 @@ func (h *Handler) SetCredentials(w http.ResponseWriter, r *http.Request) {
 		version = ver
 	}
 
+	// Compared with the new values below, to record only what changed
+	previous, err := h.credStore.GetStatus(connID)
+	if err != nil || previous == nil {
+		previous = &store.CredentialStatus{}
+	}
+
 	cred := &store.ConnectionCredential{
 		ConnectionID:      connID,
 		Username:          req.Username,
@@ -489,6 +502,16 @@ func (h *Handler) SetCredentials(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	details := map[string]string{"connection_id": connID.String(), "connection": conn.Name}
+	if !previous.HasCredentials || req.Password != "" || req.Username != previous.Username {
+		h.auditLog.Record(r, userUUID, audit.EventCredentialsSet, details)
+	}
+	if req.AutoLogin && !previous.AutoLoginEnabled {
+		h.auditLog.Record(r, userUUID, audit.EventAutoLoginEnabled, details)
+	} else if !req.AutoLogin && previous.AutoLoginEnabled {
+		h.auditLog.Record(r, userUUID, audit.EventAutoLoginDisabled, details)
+	}
+
 	// Return status only, never the actual credentials
 	// Fetch the latest status to get the username
 	status, _ := h.credStore.GetStatus(connID)
@@ -604,6 +627,7 @@ func (h *Handler) DeleteCredentials(w http.ResponseWriter, r *http.Request) {
 		h.sendError(w, "Failed to delete credentials")
 		return
 	}
+	h.auditLog.Record(r, userUUID, audit.EventCredentialsDeleted, map[string]string{"connection_id": connID.String(), "connection": conn.Name})
 
 	h.sendJSON(w, CredentialStatusResponse{
 		HasCredentials:   false,
@@ -692,6 +716,16 @@ func (h *Handler) Connect(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Bind the connection's automation profile to the live session
+	if err := h.sessionMgr.StartAutomation(userUUID.String(), connID); err != nil {
+		log.Printf("Start automation failed: %v", err)
+	}
+
+	// Record the session if logging is turned on for the connection
+	if err := h.sessionMgr.StartLogging(userUUID.String(), connID); err != nil {
+		log.Printf("Start session logging failed: %v", err)
+	}
+
 	// If auto-login is enabled, send credentials
 	if autoLogin && username != "" && password != "" {
 		err = h.sessionMgr.SendCredentials(userUUID.String(), username, password)
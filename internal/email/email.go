@@ -40,6 +40,69 @@ If you didn't request this code, please ignore this email.`, otpCode)
 	return s.sendEmail(recipientEmail, subject, body)
 }
 
+// SendLoginLink sends a login email with both a one-click link and the code, for when the link can't be used
+func (s *Sender) SendLoginLink(recipientEmail, otpCode, link string) error {
+	subject := "Your MUDPuppy Login Link"
+	body := fmt.Sprintf(`Tap the link below to log in to MUDPuppy:
+
+%s
+
+The link only works in the browser where you asked to log in. You can also enter this code instead: %s
+
+The link and code expire in 15 minutes and can only be used once.
+
+If you didn't request this, please ignore this email.`, link, otpCode)
+
+	return s.sendEmail(recipientEmail, subject, body)
+}
+
+// SendEmailChangeCode sends the code confirming a new address to that address
+func (s *Sender) SendEmailChangeCode(recipientEmail, otpCode string) error {
+	subject := "Confirm Your New MUDPuppy Email Address"
+	body := fmt.Sprintf(`Your code to confirm this email address for your MUDPuppy account is: %s
+
+This code will expire in 15 minutes.
+
+If you didn't ask to use this address with MUDPuppy, please ignore this email.`, otpCode)
+
+	return s.sendEmail(recipientEmail, subject, body)
+}
+
+// SendEmailChanged tells the old address that the account's email was changed, with a link to undo it
+// revertLink may be empty when the app's public URL is not configured.
+func (s *Sender) SendEmailChanged(recipientEmail, newEmail, revertLink string, revertDays int) error {
+	subject := "Your MUDPuppy Email Address Was Changed"
+	undo := "If you didn't make this change, please contact support straight away."
+	if revertLink != "" {
+		undo = fmt.Sprintf(`If you didn't make this change, open the link below within %d days to change it back and log out everywhere:
+
+%s`, revertDays, revertLink)
+	}
+	body := fmt.Sprintf(`The email address of your MUDPuppy account was changed to %s.
+
+%s`, newEmail, undo)
+
+	return s.sendEmail(recipientEmail, subject, body)
+}
+
+// SendEmailReverted tells the restored address that an email change was undone
+// Passkeys and sign-in providers added since the change are listed so the owner can remove them.
+func (s *Sender) SendEmailReverted(recipientEmail string, passkeys, identities []string) error {
+	subject := "Your MUDPuppy Email Address Was Changed Back"
+	body := `The email address of your MUDPuppy account was changed back to this address. Every session was logged out and all API tokens were revoked.`
+	if len(passkeys) > 0 || len(identities) > 0 {
+		body += "\n\nThese were added to the account since the change and can still be used to log in. If you don't recognise them, remove them in your account settings:\n"
+		for _, name := range passkeys {
+			body += "\n- Passkey: " + name
+		}
+		for _, name := range identities {
+			body += "\n- Sign-in provider: " + name
+		}
+	}
+
+	return s.sendEmail(recipientEmail, subject, body)
+}
+
 // sendEmail sends an email with the given subject and body
 func (s *Sender) sendEmail(recipient, subject, body string) error {
 	// Build email headers
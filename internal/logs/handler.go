@@ -0,0 +1,486 @@
+package logs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/amaranth494/MudPuppy/internal/session"
+	"github.com/amaranth494/MudPuppy/internal/sessionlog"
+	"github.com/amaranth494/MudPuppy/internal/store"
+	"github.com/google/uuid"
+)
+
+// Paging limits for log listings and searches
+const (
+	defaultPageSize    = 50
+	maxPageSize        = 200
+	defaultSearchLimit = 20
+	defaultContext     = 2
+)
+
+// Handler handles session transcript and recording HTTP requests
+type Handler struct {
+	logStore       *store.SessionLogStore
+	recordingStore *store.SessionRecordingStore
+	sessionMgr     *session.Manager
+	defaults       sessionlog.Limits
+}
+
+// NewHandler creates a new logs handler
+// defaults are the server's retention limits, which users may lower but not raise
+// sessionMgr is used to apply logging changes to a live session and to record it; it may be nil
+func NewHandler(logStore *store.SessionLogStore, recordingStore *store.SessionRecordingStore, sessionMgr *session.Manager, defaults sessionlog.Limits) *Handler {
+	return &Handler{
+		logStore:       logStore,
+		recordingStore: recordingStore,
+		sessionMgr:     sessionMgr,
+		defaults:       defaults,
+	}
+}
+
+// Request/Response types
+
+type LogsResponse struct {
+	Items []store.SessionLog `json:"items"`
+}
+
+type LoggingRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+type LoggingResponse struct {
+	ConnectionID uuid.UUID `json:"connection_id"`
+	Enabled      bool      `json:"enabled"`
+}
+
+type SettingsRequest struct {
+	RetentionDays int `json:"retention_days"`
+	MaxMegabytes  int `json:"max_megabytes"`
+}
+
+type SettingsResponse struct {
+	RetentionDays    int  `json:"retention_days"`
+	MaxMegabytes     int  `json:"max_megabytes"`
+	MaxRetentionDays int  `json:"max_retention_days"`
+	MaxAllowedMB     int  `json:"max_allowed_megabytes"`
+	Custom           bool `json:"custom"`
+}
+
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// List handles GET /api/v1/logs?connection_id=&limit=&offset=
+func (h *Handler) List(w http.ResponseWriter, r *http.Request) {
+	userUUID, err := h.getUserID(r)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+
+	query := r.URL.Query()
+	var connectionID *uuid.UUID
+	if s := query.Get("connection_id"); s != "" {
+		id, err := uuid.Parse(s)
+		if err != nil {
+			h.sendError(w, "Invalid connection ID")
+			return
+		}
+		connectionID = &id
+	}
+	limit, offset, err := parsePage(query.Get("limit"), query.Get("offset"))
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+
+	items, err := h.logStore.ListLogs(userUUID, connectionID, limit, offset)
+	if err != nil {
+		log.Printf("List session logs failed: %v", err)
+		h.sendError(w, "Failed to list logs")
+		return
+	}
+	h.sendJSON(w, LogsResponse{Items: items})
+}
+
+// Get handles GET /api/v1/logs/:id
+func (h *Handler) Get(w http.ResponseWriter, r *http.Request) {
+	userUUID, err := h.getUserID(r)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+	logID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		h.sendError(w, "Invalid log ID")
+		return
+	}
+
+	l, err := h.logStore.GetLog(userUUID, logID)
+	if err != nil {
+		log.Printf("Get session log failed: %v", err)
+		h.sendError(w, "Failed to get log")
+		return
+	}
+	if l == nil {
+		h.sendError(w, "Log not found")
+		return
+	}
+	h.sendJSON(w, l)
+}
+
+// Export handles GET /api/v1/logs/:id/export?format=&from=&to=
+// format is html (default), text or ans; from and to are RFC 3339 times limiting the export to part of the log
+func (h *Handler) Export(w http.ResponseWriter, r *http.Request) {
+	userUUID, err := h.getUserID(r)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+	logID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		h.sendError(w, "Invalid log ID")
+		return
+	}
+
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = sessionlog.FormatHTML
+	}
+	if !sessionlog.ValidFormat(format) {
+		h.sendError(w, sessionlog.ErrUnknownFormat.Error())
+		return
+	}
+	from, err := parseTime(query.Get("from"))
+	if err != nil {
+		h.sendError(w, "from must be an RFC 3339 time")
+		return
+	}
+	to, err := parseTime(query.Get("to"))
+	if err != nil {
+		h.sendError(w, "to must be an RFC 3339 time")
+		return
+	}
+	if from != nil && to != nil && to.Before(*from) {
+		h.sendError(w, "to must not be before from")
+		return
+	}
+
+	l, err := h.logStore.GetLog(userUUID, logID)
+	if err != nil {
+		log.Printf("Get session log for export failed: %v", err)
+		h.sendError(w, "Failed to export log")
+		return
+	}
+	if l == nil {
+		h.sendError(w, "Log not found")
+		return
+	}
+
+	// The export is streamed, so once it has started errors can only be logged
+	w.Header().Set("Content-Type", sessionlog.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="log-%s%s"`, l.ID, sessionlog.FileExtension(format)))
+	if err := sessionlog.Export(w, h.logStore, l, format, from, to); err != nil {
+		log.Printf("Export session log %s failed: %v", l.ID, err)
+	}
+}
+
+// Delete handles DELETE /api/v1/logs/:id
+func (h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
+	userUUID, err := h.getUserID(r)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+	logID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		h.sendError(w, "Invalid log ID")
+		return
+	}
+
+	deleted, err := h.logStore.DeleteLog(userUUID, logID)
+	if err != nil {
+		log.Printf("Delete session log failed: %v", err)
+		h.sendError(w, "Failed to delete log")
+		return
+	}
+	if !deleted {
+		h.sendError(w, "Log not found")
+		return
+	}
+	h.sendJSON(w, map[string]string{"status": "deleted"})
+}
+
+// Search handles GET /api/v1/logs/search?q=&connection_id=&from=&to=&direction=&context=&limit=&cursor=
+// from and to are RFC 3339 times; direction is "sent" or "received"
+func (h *Handler) Search(w http.ResponseWriter, r *http.Request) {
+	userUUID, err := h.getUserID(r)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+
+	query := r.URL.Query()
+	opts := sessionlog.SearchOptions{
+		Query:     query.Get("q"),
+		Direction: query.Get("direction"),
+		Context:   defaultContext,
+		Limit:     defaultSearchLimit,
+		Cursor:    query.Get("cursor"),
+	}
+	if len(opts.Query) > sessionlog.MaxQueryLength {
+		h.sendError(w, fmt.Sprintf("Query must be at most %d characters", sessionlog.MaxQueryLength))
+		return
+	}
+	if sessionlog.ParseQuery(opts.Query).Empty() {
+		h.sendError(w, "Query is required")
+		return
+	}
+	if s := query.Get("connection_id"); s != "" {
+		id, err := uuid.Parse(s)
+		if err != nil {
+			h.sendError(w, "Invalid connection ID")
+			return
+		}
+		opts.Filter.ConnectionID = &id
+	}
+	if opts.Filter.From, err = parseTime(query.Get("from")); err != nil {
+		h.sendError(w, "from must be an RFC 3339 time")
+		return
+	}
+	if opts.Filter.To, err = parseTime(query.Get("to")); err != nil {
+		h.sendError(w, "to must be an RFC 3339 time")
+		return
+	}
+	switch opts.Direction {
+	case "", store.LogDirectionSent, store.LogDirectionReceived:
+	default:
+		h.sendError(w, "direction must be sent or received")
+		return
+	}
+	if s := query.Get("context"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 || n > sessionlog.MaxContextLines {
+			h.sendError(w, fmt.Sprintf("context must be between 0 and %d", sessionlog.MaxContextLines))
+			return
+		}
+		opts.Context = n
+	}
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > sessionlog.MaxSearchResults {
+			h.sendError(w, fmt.Sprintf("limit must be between 1 and %d", sessionlog.MaxSearchResults))
+			return
+		}
+		opts.Limit = n
+	}
+
+	result, err := sessionlog.Search(h.logStore, userUUID, opts)
+	if errors.Is(err, sessionlog.ErrInvalidCursor) {
+		h.sendError(w, "Invalid cursor")
+		return
+	}
+	if err != nil {
+		log.Printf("Search session logs failed: %v", err)
+		h.sendError(w, "Failed to search logs")
+		return
+	}
+	h.sendJSON(w, result)
+}
+
+// GetSettings handles GET /api/v1/logs/settings
+func (h *Handler) GetSettings(w http.ResponseWriter, r *http.Request) {
+	userUUID, err := h.getUserID(r)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+
+	settings, err := h.logStore.GetSettings(userUUID)
+	if err != nil {
+		log.Printf("Get log settings failed: %v", err)
+		h.sendError(w, "Failed to get log settings")
+		return
+	}
+	h.sendJSON(w, h.settingsResponse(settings))
+}
+
+// PutSettings handles PUT /api/v1/logs/settings
+// Limits may be lower than the server defaults but not higher
+func (h *Handler) PutSettings(w http.ResponseWriter, r *http.Request) {
+	userUUID, err := h.getUserID(r)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+
+	var req SettingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendError(w, "Invalid request body")
+		return
+	}
+	if req.RetentionDays < 1 || req.RetentionDays > h.defaults.RetentionDays {
+		h.sendError(w, fmt.Sprintf("Retention must be between 1 and %d days", h.defaults.RetentionDays))
+		return
+	}
+	maxMB := int(h.defaults.MaxBytes >> 20)
+	if req.MaxMegabytes < 1 || req.MaxMegabytes > maxMB {
+		h.sendError(w, fmt.Sprintf("Storage limit must be between 1 and %d MB", maxMB))
+		return
+	}
+
+	settings := &store.SessionLogSettings{
+		RetentionDays: req.RetentionDays,
+		MaxBytes:      int64(req.MaxMegabytes) << 20,
+	}
+	if err := h.logStore.SaveSettings(userUUID, settings); err != nil {
+		log.Printf("Save log settings failed: %v", err)
+		h.sendError(w, "Failed to save log settings")
+		return
+	}
+	h.sendJSON(w, h.settingsResponse(settings))
+}
+
+// GetConnectionLogging handles GET /api/v1/connections/:id/logging
+func (h *Handler) GetConnectionLogging(w http.ResponseWriter, r *http.Request) {
+	userUUID, err := h.getUserID(r)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+	connectionID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		h.sendError(w, "Invalid connection ID")
+		return
+	}
+
+	enabled, err := h.logStore.LoggingEnabled(userUUID, connectionID)
+	if err != nil {
+		log.Printf("Get connection logging failed: %v", err)
+		h.sendError(w, "Failed to get logging setting")
+		return
+	}
+	h.sendJSON(w, LoggingResponse{ConnectionID: connectionID, Enabled: enabled})
+}
+
+// PutConnectionLogging handles PUT /api/v1/connections/:id/logging
+// The change applies at once to a live session on the connection
+func (h *Handler) PutConnectionLogging(w http.ResponseWriter, r *http.Request) {
+	userUUID, err := h.getUserID(r)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+	connectionID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		h.sendError(w, "Invalid connection ID")
+		return
+	}
+
+	var req LoggingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendError(w, "Invalid request body")
+		return
+	}
+
+	found, err := h.logStore.SetLoggingEnabled(userUUID, connectionID, req.Enabled)
+	if err != nil {
+		log.Printf("Set connection logging failed: %v", err)
+		h.sendError(w, "Failed to update logging setting")
+		return
+	}
+	if !found {
+		h.sendError(w, "Connection not found")
+		return
+	}
+
+	if h.sessionMgr != nil {
+		if err := h.sessionMgr.UpdateLogging(userUUID.String(), connectionID, req.Enabled); err != nil {
+			log.Printf("Apply logging setting to live session failed: %v", err)
+		}
+	}
+	h.sendJSON(w, LoggingResponse{ConnectionID: connectionID, Enabled: req.Enabled})
+}
+
+// settingsResponse reports a user's limits, falling back to the server defaults
+func (h *Handler) settingsResponse(settings *store.SessionLogSettings) SettingsResponse {
+	resp := SettingsResponse{
+		RetentionDays:    h.defaults.RetentionDays,
+		MaxMegabytes:     int(h.defaults.MaxBytes >> 20),
+		MaxRetentionDays: h.defaults.RetentionDays,
+		MaxAllowedMB:     int(h.defaults.MaxBytes >> 20),
+	}
+	if settings != nil {
+		resp.RetentionDays = settings.RetentionDays
+		resp.MaxMegabytes = int(settings.MaxBytes >> 20)
+		resp.Custom = true
+	}
+	return resp
+}
+
+// parsePage validates limit and offset query parameters
+func parsePage(limitStr, offsetStr string) (int, int, error) {
+	limit := defaultPageSize
+	if limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n < 1 || n > maxPageSize {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+		limit = n
+	}
+	offset := 0
+	if offsetStr != "" {
+		n, err := strconv.Atoi(offsetStr)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("offset must be 0 or more")
+		}
+		offset = n
+	}
+	return limit, offset, nil
+}
+
+// parseTime parses an optional RFC 3339 time
+func parseTime(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// getUserID returns the authenticated user
+func (h *Handler) getUserID(r *http.Request) (uuid.UUID, error) {
+	userID := r.Context().Value("user_id")
+	if userID == nil {
+		return uuid.Nil, fmt.Errorf("Unauthorized")
+	}
+	userUUID, err := uuid.Parse(userID.(string))
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("Invalid user ID")
+	}
+	return userUUID, nil
+}
+
+// sendJSON sends a JSON response
+func (h *Handler) sendJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		log.Printf("Failed to encode JSON: %v", err)
+	}
+}
+
+// sendError sends an error response
+func (h *Handler) sendError(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: message})
+}
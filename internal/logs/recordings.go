@@ -0,0 +1,214 @@
+package logs
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/amaranth494/MudPuppy/internal/sessionlog"
+	"github.com/amaranth494/MudPuppy/internal/store"
+	"github.com/google/uuid"
+)
+
+// Request/Response types for timed recordings
+
+type RecordingsResponse struct {
+	Items []store.SessionRecording `json:"items"`
+}
+
+type StartRecordingRequest struct {
+	Title  string `json:"title"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+type RecordingStatusResponse struct {
+	Recording   bool       `json:"recording"`
+	RecordingID *uuid.UUID `json:"recording_id,omitempty"`
+}
+
+// GetRecordingStatus handles GET /api/v1/session/recording
+func (h *Handler) GetRecordingStatus(w http.ResponseWriter, r *http.Request) {
+	userUUID, err := h.getUserID(r)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+	if h.sessionMgr == nil {
+		h.sendError(w, "Recording is not available")
+		return
+	}
+
+	resp := RecordingStatusResponse{}
+	if id, ok := h.sessionMgr.Recording(userUUID.String()); ok {
+		resp.Recording = true
+		resp.RecordingID = &id
+	}
+	h.sendJSON(w, resp)
+}
+
+// StartRecording handles POST /api/v1/session/recording
+// Starts a timed recording of the live session; width and height are the client's terminal size
+func (h *Handler) StartRecording(w http.ResponseWriter, r *http.Request) {
+	userUUID, err := h.getUserID(r)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+	if h.sessionMgr == nil {
+		h.sendError(w, "Recording is not available")
+		return
+	}
+
+	var req StartRecordingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendError(w, "Invalid request body")
+		return
+	}
+	req.Title = strings.TrimSpace(req.Title)
+	if len(req.Title) > sessionlog.MaxCastTitle {
+		h.sendError(w, fmt.Sprintf("Title must be %d characters or less", sessionlog.MaxCastTitle))
+		return
+	}
+	if req.Width == 0 {
+		req.Width = sessionlog.DefaultCastWidth
+	}
+	if req.Height == 0 {
+		req.Height = sessionlog.DefaultCastHeight
+	}
+	if req.Width < 1 || req.Width > sessionlog.MaxCastSize || req.Height < 1 || req.Height > sessionlog.MaxCastSize {
+		h.sendError(w, fmt.Sprintf("Width and height must be between 1 and %d", sessionlog.MaxCastSize))
+		return
+	}
+
+	rec, err := h.sessionMgr.StartRecording(userUUID.String(), req.Title, req.Width, req.Height)
+	if err != nil {
+		log.Printf("Start recording failed: %v", err)
+		h.sendError(w, err.Error())
+		return
+	}
+	h.sendJSON(w, rec)
+}
+
+// StopRecording handles DELETE /api/v1/session/recording
+func (h *Handler) StopRecording(w http.ResponseWriter, r *http.Request) {
+	userUUID, err := h.getUserID(r)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+	if h.sessionMgr == nil {
+		h.sendError(w, "Recording is not available")
+		return
+	}
+
+	id, ok := h.sessionMgr.StopRecording(userUUID.String())
+	if !ok {
+		h.sendError(w, "Session is not being recorded")
+		return
+	}
+	h.sendJSON(w, map[string]string{"status": "stopped", "recording_id": id.String()})
+}
+
+// ListRecordings handles GET /api/v1/recordings?limit=&offset=
+func (h *Handler) ListRecordings(w http.ResponseWriter, r *http.Request) {
+	userUUID, err := h.getUserID(r)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+
+	query := r.URL.Query()
+	limit, offset, err := parsePage(query.Get("limit"), query.Get("offset"))
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+
+	items, err := h.recordingStore.ListRecordings(userUUID, limit, offset)
+	if err != nil {
+		log.Printf("List recordings failed: %v", err)
+		h.sendError(w, "Failed to list recordings")
+		return
+	}
+	h.sendJSON(w, RecordingsResponse{Items: items})
+}
+
+// GetRecording handles GET /api/v1/recordings/:id
+func (h *Handler) GetRecording(w http.ResponseWriter, r *http.Request) {
+	rec, ok := h.loadRecording(w, r)
+	if !ok {
+		return
+	}
+	h.sendJSON(w, rec)
+}
+
+// DeleteRecording handles DELETE /api/v1/recordings/:id
+func (h *Handler) DeleteRecording(w http.ResponseWriter, r *http.Request) {
+	userUUID, err := h.getUserID(r)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+	recordingID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		h.sendError(w, "Invalid recording ID")
+		return
+	}
+
+	deleted, err := h.recordingStore.DeleteRecording(userUUID, recordingID)
+	if err != nil {
+		log.Printf("Delete recording failed: %v", err)
+		h.sendError(w, "Failed to delete recording")
+		return
+	}
+	if !deleted {
+		h.sendError(w, "Recording not found")
+		return
+	}
+	h.sendJSON(w, map[string]string{"status": "deleted"})
+}
+
+// ExportRecording handles GET /api/v1/recordings/:id/export
+// The recording is streamed as an asciicast v2 (.cast) file
+func (h *Handler) ExportRecording(w http.ResponseWriter, r *http.Request) {
+	rec, ok := h.loadRecording(w, r)
+	if !ok {
+		return
+	}
+
+	// The export is streamed, so once it has started errors can only be logged
+	w.Header().Set("Content-Type", "application/x-asciicast")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="recording-%s.cast"`, rec.ID))
+	if err := sessionlog.ExportCast(w, h.recordingStore, rec); err != nil {
+		log.Printf("Export recording %s failed: %v", rec.ID, err)
+	}
+}
+
+// loadRecording returns the recording named in the path, sending an error if it is not the user's
+func (h *Handler) loadRecording(w http.ResponseWriter, r *http.Request) (*store.SessionRecording, bool) {
+	userUUID, err := h.getUserID(r)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return nil, false
+	}
+	recordingID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		h.sendError(w, "Invalid recording ID")
+		return nil, false
+	}
+
+	rec, err := h.recordingStore.GetRecording(userUUID, recordingID)
+	if err != nil {
+		log.Printf("Get recording failed: %v", err)
+		h.sendError(w, "Failed to get recording")
+		return nil, false
+	}
+	if rec == nil {
+		h.sendError(w, "Recording not found")
+		return nil, false
+	}
+	return rec, true
+}
@@ -0,0 +1,174 @@
+// Package mapformat converts automapper rooms to and from other clients' map formats
+// Supported formats are Mudlet's JSON map, the Mudlet/IRE XML map and the tables of a zMUD/CMUD
+// map exported to CSV. Fields our room model has no place for are kept in MapRoom.Extra and MapExit.Extra
+// under the format's name, and written back out when exporting to the same format.
+package mapformat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/amaranth494/MudPuppy/internal/store"
+)
+
+// Supported formats
+const (
+	FormatMudletJSON = "mudlet-json"
+	FormatMudletXML  = "mudlet-xml"
+	FormatZMUDTables = "zmud-tables"
+)
+
+// Limits on imported maps
+const (
+	MaxImportBytes = 32 << 20
+	MaxImportRooms = 100000
+)
+
+// ErrUnknownFormat indicates an unsupported format name
+var ErrUnknownFormat = errors.New("unknown map format: use mudlet-json, mudlet-xml or zmud-tables")
+
+// ErrTooManyRooms indicates an import exceeds MaxImportRooms
+var ErrTooManyRooms = fmt.Errorf("maps are limited to %d rooms", MaxImportRooms)
+
+// Import parses a map in the given format into rooms keyed by the source's room IDs
+func Import(format string, data []byte) ([]store.MapRoom, error) {
+	var rooms []store.MapRoom
+	var err error
+	switch format {
+	case FormatMudletJSON:
+		rooms, err = importMudletJSON(data)
+	case FormatMudletXML:
+		rooms, err = importMudletXML(data)
+	case FormatZMUDTables:
+		rooms, err = importZMUD(data)
+	default:
+		return nil, ErrUnknownFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(rooms) > MaxImportRooms {
+		return nil, ErrTooManyRooms
+	}
+	if err := checkDuplicateKeys(rooms); err != nil {
+		return nil, err
+	}
+	return rooms, nil
+}
+
+// Export writes rooms in the given format
+func Export(format string, rooms []store.MapRoom, w io.Writer) error {
+	switch format {
+	case FormatMudletJSON:
+		return exportMudletJSON(rooms, w)
+	case FormatMudletXML:
+		return exportMudletXML(rooms, w)
+	case FormatZMUDTables:
+		return exportZMUD(rooms, w)
+	default:
+		return ErrUnknownFormat
+	}
+}
+
+// ContentType returns the MIME type of an exported map
+func ContentType(format string) string {
+	switch format {
+	case FormatMudletXML:
+		return "application/xml"
+	case FormatZMUDTables:
+		return "application/zip"
+	default:
+		return "application/json"
+	}
+}
+
+// FileExtension returns the file extension of an exported map
+func FileExtension(format string) string {
+	switch format {
+	case FormatMudletXML:
+		return ".xml"
+	case FormatZMUDTables:
+		return ".zip"
+	default:
+		return ".json"
+	}
+}
+
+func checkDuplicateKeys(rooms []store.MapRoom) error {
+	seen := make(map[string]bool, len(rooms))
+	for _, room := range rooms {
+		if room.Key == "" {
+			return errors.New("room without an ID")
+		}
+		if seen[room.Key] {
+			return fmt.Errorf("duplicate room ID %s", room.Key)
+		}
+		seen[room.Key] = true
+	}
+	return nil
+}
+
+// numericIDs assigns the integer room IDs other clients require
+// Keys that are already positive integers (e.g. GMCP room numbers) are kept
+func numericIDs(rooms []store.MapRoom) map[string]int {
+	ids := make(map[string]int, len(rooms))
+	next := 1
+	for _, room := range rooms {
+		if n, err := strconv.Atoi(room.Key); err == nil && n > 0 {
+			ids[room.Key] = n
+			if n >= next {
+				next = n + 1
+			}
+		}
+	}
+	for _, room := range rooms {
+		if _, ok := ids[room.Key]; !ok {
+			ids[room.Key] = next
+			next++
+		}
+	}
+	return ids
+}
+
+// areaIDs numbers the areas of a map in name order
+func areaIDs(rooms []store.MapRoom) ([]string, map[string]int) {
+	seen := make(map[string]bool)
+	var names []string
+	for _, room := range rooms {
+		if !seen[room.Area] {
+			seen[room.Area] = true
+			names = append(names, room.Area)
+		}
+	}
+	sort.Strings(names)
+	ids := make(map[string]int, len(names))
+	for i, name := range names {
+		ids[name] = i + 1
+	}
+	return names, ids
+}
+
+// setExtra stores v as the preserved fields of a format
+func setExtra(extra *map[string]json.RawMessage, format string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	if *extra == nil {
+		*extra = make(map[string]json.RawMessage)
+	}
+	(*extra)[format] = data
+}
+
+// getExtra decodes the preserved fields of a format into v, reporting whether there were any
+func getExtra(extra map[string]json.RawMessage, format string, v interface{}) bool {
+	data, ok := extra[format]
+	if !ok {
+		return false
+	}
+	return json.Unmarshal(data, v) == nil
+}
@@ -0,0 +1,89 @@
+package mapformat
+
+import (
+	"sort"
+
+	"github.com/amaranth494/MudPuppy/internal/store"
+)
+
+// Conflict is an imported room whose key already exists in the map with different contents
+type Conflict struct {
+	Key      string         `json:"key"`
+	Fields   []string       `json:"fields"`
+	Existing *store.MapRoom `json:"existing"`
+	Incoming *store.MapRoom `json:"incoming"`
+}
+
+// Plan describes how imported rooms would merge into an existing map
+type Plan struct {
+	New       []store.MapRoom
+	Unchanged int
+	Conflicts []Conflict
+}
+
+// PlanMerge compares imported rooms with the rooms already in a map
+func PlanMerge(existing, incoming []store.MapRoom) *Plan {
+	byKey := make(map[string]*store.MapRoom, len(existing))
+	for i := range existing {
+		byKey[existing[i].Key] = &existing[i]
+	}
+
+	plan := &Plan{Conflicts: []Conflict{}}
+	for i := range incoming {
+		room := &incoming[i]
+		current, ok := byKey[room.Key]
+		if !ok {
+			plan.New = append(plan.New, *room)
+			continue
+		}
+		fields := diffRooms(current, room)
+		if len(fields) == 0 {
+			plan.Unchanged++
+			continue
+		}
+		plan.Conflicts = append(plan.Conflicts, Conflict{Key: room.Key, Fields: fields, Existing: current, Incoming: room})
+	}
+	return plan
+}
+
+// diffRooms lists the fields that differ between two versions of a room
+func diffRooms(a, b *store.MapRoom) []string {
+	var fields []string
+	if a.Name != b.Name {
+		fields = append(fields, "name")
+	}
+	if a.Area != b.Area {
+		fields = append(fields, "area")
+	}
+	if a.Environment != b.Environment {
+		fields = append(fields, "environment")
+	}
+	if a.X != b.X || a.Y != b.Y || a.Z != b.Z {
+		fields = append(fields, "coordinates")
+	}
+	if !sameExits(a.Exits, b.Exits) {
+		fields = append(fields, "exits")
+	}
+	return fields
+}
+
+func sameExits(a, b []store.MapExit) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	key := func(e store.MapExit) string { return e.Direction + "\x00" + e.To }
+	as := make([]string, len(a))
+	bs := make([]string, len(b))
+	for i := range a {
+		as[i] = key(a[i])
+		bs[i] = key(b[i])
+	}
+	sort.Strings(as)
+	sort.Strings(bs)
+	for i := range as {
+		if as[i] != bs[i] {
+			return false
+		}
+	}
+	return true
+}
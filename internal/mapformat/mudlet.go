@@ -0,0 +1,367 @@
+package mapformat
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/amaranth494/MudPuppy/internal/mapper"
+	"github.com/amaranth494/MudPuppy/internal/store"
+)
+
+// mudletFormatVersion is written to exported Mudlet JSON maps
+const mudletFormatVersion = 1.001
+
+// Room and exit fields of the Mudlet JSON map that map onto our room model
+var (
+	mudletRoomFields = map[string]bool{"id": true, "name": true, "coordinates": true, "environment": true, "exits": true, "locked": true}
+	mudletExitFields = map[string]bool{"exitId": true, "name": true, "weight": true}
+)
+
+// importMudletJSON reads a map saved with Mudlet's saveJsonMap()
+func importMudletJSON(data []byte) ([]store.MapRoom, error) {
+	var doc struct {
+		Areas []struct {
+			Name  string                       `json:"name"`
+			Rooms []map[string]json.RawMessage `json:"rooms"`
+		} `json:"areas"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid Mudlet JSON map: %v", err)
+	}
+
+	var rooms []store.MapRoom
+	for _, area := range doc.Areas {
+		for _, raw := range area.Rooms {
+			room, err := mudletJSONRoom(raw, area.Name)
+			if err != nil {
+				return nil, err
+			}
+			rooms = append(rooms, room)
+			if len(rooms) > MaxImportRooms {
+				return nil, ErrTooManyRooms
+			}
+		}
+	}
+	return rooms, nil
+}
+
+func mudletJSONRoom(raw map[string]json.RawMessage, area string) (store.MapRoom, error) {
+	room := store.MapRoom{Area: area, Exits: []store.MapExit{}}
+
+	var id json.Number
+	if err := json.Unmarshal(raw["id"], &id); err != nil || id == "" {
+		return room, fmt.Errorf("invalid Mudlet room id")
+	}
+	room.Key = id.String()
+	if room.Area == "" {
+		room.Area = mapper.DefaultArea
+	}
+
+	json.Unmarshal(raw["name"], &room.Name)
+	var coords []int
+	if json.Unmarshal(raw["coordinates"], &coords) == nil && len(coords) == 3 {
+		room.X, room.Y, room.Z = coords[0], coords[1], coords[2]
+	}
+	var env json.Number
+	if json.Unmarshal(raw["environment"], &env) == nil {
+		room.Environment = env.String()
+	}
+	json.Unmarshal(raw["locked"], &room.Avoid)
+
+	var exits []map[string]json.RawMessage
+	json.Unmarshal(raw["exits"], &exits)
+	for _, e := range exits {
+		var name string
+		var to json.Number
+		if json.Unmarshal(e["name"], &name) != nil || json.Unmarshal(e["exitId"], &to) != nil {
+			continue
+		}
+		exit := store.MapExit{Direction: mapper.NormalizeDirection(name), To: to.String()}
+		json.Unmarshal(e["weight"], &exit.Weight)
+		if unknown := unknownFields(e, mudletExitFields); len(unknown) > 0 {
+			setExtra(&exit.Extra, FormatMudletJSON, unknown)
+		}
+		room.SetExit(exit)
+	}
+
+	if unknown := unknownFields(raw, mudletRoomFields); len(unknown) > 0 {
+		setExtra(&room.Extra, FormatMudletJSON, unknown)
+	}
+	return room, nil
+}
+
+// exportMudletJSON writes rooms in the layout read by Mudlet's loadJsonMap()
+func exportMudletJSON(rooms []store.MapRoom, w io.Writer) error {
+	ids := numericIDs(rooms)
+	names, areaIDs := areaIDs(rooms)
+
+	type mudletArea struct {
+		ID        int                          `json:"id"`
+		Name      string                       `json:"name"`
+		RoomCount int                          `json:"roomCount"`
+		Rooms     []map[string]json.RawMessage `json:"rooms"`
+	}
+	areas := make(map[string]*mudletArea, len(names))
+	for _, name := range names {
+		areas[name] = &mudletArea{ID: areaIDs[name], Name: name, Rooms: []map[string]json.RawMessage{}}
+	}
+
+	for _, room := range rooms {
+		out := make(map[string]json.RawMessage)
+		getExtra(room.Extra, FormatMudletJSON, &out)
+		if out == nil {
+			out = make(map[string]json.RawMessage)
+		}
+		out["id"] = mustJSON(ids[room.Key])
+		out["name"] = mustJSON(room.Name)
+		out["coordinates"] = mustJSON([]int{room.X, room.Y, room.Z})
+		if env, err := strconv.Atoi(room.Environment); err == nil {
+			out["environment"] = mustJSON(env)
+		}
+		if room.Avoid {
+			out["locked"] = mustJSON(true)
+		}
+
+		exits := []map[string]json.RawMessage{}
+		for _, exit := range room.Exits {
+			to, ok := ids[exit.To]
+			if !ok {
+				continue
+			}
+			e := make(map[string]json.RawMessage)
+			getExtra(exit.Extra, FormatMudletJSON, &e)
+			if e == nil {
+				e = make(map[string]json.RawMessage)
+			}
+			e["exitId"] = mustJSON(to)
+			e["name"] = mustJSON(mapper.LongDirection(exit.Direction))
+			if exit.Weight > 0 {
+				e["weight"] = mustJSON(exit.Weight)
+			}
+			exits = append(exits, e)
+		}
+		out["exits"] = mustJSON(exits)
+
+		area := areas[room.Area]
+		area.Rooms = append(area.Rooms, out)
+		area.RoomCount++
+	}
+
+	doc := struct {
+		FormatVersion float64       `json:"formatVersion"`
+		AreaCount     int           `json:"areaCount"`
+		RoomCount     int           `json:"roomCount"`
+		Areas         []*mudletArea `json:"areas"`
+	}{FormatVersion: mudletFormatVersion, AreaCount: len(names), RoomCount: len(rooms)}
+	for _, name := range names {
+		doc.Areas = append(doc.Areas, areas[name])
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+// XML map as published by IRE games and read by Mudlet's XML map loader
+
+type xmlMap struct {
+	XMLName xml.Name  `xml:"map"`
+	Areas   []xmlArea `xml:"areas>area"`
+	Rooms   []xmlRoom `xml:"rooms>room"`
+}
+
+type xmlArea struct {
+	ID   string `xml:"id,attr"`
+	Name string `xml:"name,attr"`
+}
+
+type xmlRoom struct {
+	ID          string       `xml:"id,attr"`
+	Area        string       `xml:"area,attr"`
+	Title       string       `xml:"title,attr"`
+	Environment string       `xml:"environment,attr,omitempty"`
+	Attrs       []xml.Attr   `xml:",any,attr"`
+	Coord       xmlCoord     `xml:"coord"`
+	Exits       []xmlExit    `xml:"exit"`
+	Other       []xmlElement `xml:",any"`
+}
+
+type xmlCoord struct {
+	X     int        `xml:"x,attr"`
+	Y     int        `xml:"y,attr"`
+	Z     int        `xml:"z,attr"`
+	Attrs []xml.Attr `xml:",any,attr"`
+}
+
+type xmlExit struct {
+	Direction string     `xml:"direction,attr"`
+	Target    string     `xml:"target,attr"`
+	Attrs     []xml.Attr `xml:",any,attr"`
+}
+
+type xmlElement struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Inner   string     `xml:",innerxml"`
+}
+
+// xmlRoomExtra is the preserved part of an XML room
+type xmlRoomExtra struct {
+	Attrs    map[string]string `json:"attrs,omitempty"`
+	Coord    map[string]string `json:"coord,omitempty"`
+	Elements []xmlElementExtra `json:"elements,omitempty"`
+}
+
+type xmlElementExtra struct {
+	Name  string            `json:"name"`
+	Attrs map[string]string `json:"attrs,omitempty"`
+	Inner string            `json:"inner,omitempty"`
+}
+
+// importMudletXML reads an IRE/Mudlet XML map
+func importMudletXML(data []byte) ([]store.MapRoom, error) {
+	var doc xmlMap
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid XML map: %v", err)
+	}
+	if len(doc.Rooms) > MaxImportRooms {
+		return nil, ErrTooManyRooms
+	}
+
+	areaNames := make(map[string]string, len(doc.Areas))
+	for _, area := range doc.Areas {
+		areaNames[area.ID] = area.Name
+	}
+
+	rooms := make([]store.MapRoom, 0, len(doc.Rooms))
+	for _, r := range doc.Rooms {
+		room := store.MapRoom{
+			Key:         r.ID,
+			Name:        r.Title,
+			Area:        areaNames[r.Area],
+			Environment: r.Environment,
+			X:           r.Coord.X,
+			Y:           r.Coord.Y,
+			Z:           r.Coord.Z,
+			Exits:       []store.MapExit{},
+		}
+		if room.Area == "" {
+			room.Area = mapper.DefaultArea
+		}
+		for _, e := range r.Exits {
+			exit := store.MapExit{Direction: mapper.NormalizeDirection(e.Direction), To: e.Target}
+			if len(e.Attrs) > 0 {
+				setExtra(&exit.Extra, FormatMudletXML, attrMap(e.Attrs))
+			}
+			room.SetExit(exit)
+		}
+
+		extra := xmlRoomExtra{Attrs: attrMap(r.Attrs), Coord: attrMap(r.Coord.Attrs)}
+		for _, el := range r.Other {
+			extra.Elements = append(extra.Elements, xmlElementExtra{Name: el.XMLName.Local, Attrs: attrMap(el.Attrs), Inner: el.Inner})
+		}
+		if extra.Attrs != nil || extra.Coord != nil || extra.Elements != nil {
+			setExtra(&room.Extra, FormatMudletXML, extra)
+		}
+		rooms = append(rooms, room)
+	}
+	return rooms, nil
+}
+
+// exportMudletXML writes rooms as an IRE/Mudlet XML map
+func exportMudletXML(rooms []store.MapRoom, w io.Writer) error {
+	ids := numericIDs(rooms)
+	names, areaIDs := areaIDs(rooms)
+
+	doc := xmlMap{}
+	for _, name := range names {
+		doc.Areas = append(doc.Areas, xmlArea{ID: strconv.Itoa(areaIDs[name]), Name: name})
+	}
+	for _, room := range rooms {
+		r := xmlRoom{
+			ID:          strconv.Itoa(ids[room.Key]),
+			Area:        strconv.Itoa(areaIDs[room.Area]),
+			Title:       room.Name,
+			Environment: room.Environment,
+			Coord:       xmlCoord{X: room.X, Y: room.Y, Z: room.Z},
+		}
+		var extra xmlRoomExtra
+		if getExtra(room.Extra, FormatMudletXML, &extra) {
+			r.Attrs = xmlAttrs(extra.Attrs)
+			r.Coord.Attrs = xmlAttrs(extra.Coord)
+			for _, el := range extra.Elements {
+				r.Other = append(r.Other, xmlElement{XMLName: xml.Name{Local: el.Name}, Attrs: xmlAttrs(el.Attrs), Inner: el.Inner})
+			}
+		}
+		for _, exit := range room.Exits {
+			to, ok := ids[exit.To]
+			if !ok {
+				continue
+			}
+			e := xmlExit{Direction: mapper.LongDirection(exit.Direction), Target: strconv.Itoa(to)}
+			var attrs map[string]string
+			if getExtra(exit.Extra, FormatMudletXML, &attrs) {
+				e.Attrs = xmlAttrs(attrs)
+			}
+			r.Exits = append(r.Exits, e)
+		}
+		doc.Rooms = append(doc.Rooms, r)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// unknownFields returns the entries of obj not listed in known
+func unknownFields(obj map[string]json.RawMessage, known map[string]bool) map[string]json.RawMessage {
+	var unknown map[string]json.RawMessage
+	for k, v := range obj {
+		if known[k] {
+			continue
+		}
+		if unknown == nil {
+			unknown = make(map[string]json.RawMessage)
+		}
+		unknown[k] = v
+	}
+	return unknown
+}
+
+func attrMap(attrs []xml.Attr) map[string]string {
+	if len(attrs) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(attrs))
+	for _, a := range attrs {
+		m[a.Name.Local] = a.Value
+	}
+	return m
+}
+
+func xmlAttrs(m map[string]string) []xml.Attr {
+	if len(m) == 0 {
+		return nil
+	}
+	names := sortedKeys(m)
+	attrs := make([]xml.Attr, 0, len(names))
+	for _, name := range names {
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: name}, Value: m[name]})
+	}
+	return attrs
+}
+
+func mustJSON(v interface{}) json.RawMessage {
+	data, _ := json.Marshal(v)
+	return data
+}
@@ -0,0 +1,312 @@
+package mapformat
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/amaranth494/MudPuppy/internal/mapper"
+	"github.com/amaranth494/MudPuppy/internal/store"
+)
+
+// zMUD and CMUD keep maps in a database (Access .mdb for zMUD, SQLite .dbm for CMUD), which
+// we do not read. The zmud-tables format is the tables of that database instead: a zip archive
+// holding ObjectTbl.csv (rooms), ExitTbl.csv (exits) and optionally ZoneTbl.csv (areas), each
+// with a header row. Converting a map means exporting these tables with a database tool first,
+// and loading an exported zip back into the database to use it in zMUD or CMUD again.
+const (
+	zmudRoomsFile = "ObjectTbl.csv"
+	zmudExitsFile = "ExitTbl.csv"
+	zmudZonesFile = "ZoneTbl.csv"
+)
+
+// zmudDirections lists directions in the order of the DirType column; -1 marks a named exit
+var zmudDirections = []string{"n", "ne", "e", "se", "s", "sw", "w", "nw", "u", "d", "in", "out"}
+
+// Columns we map onto our room model; all other columns are preserved
+var (
+	zmudRoomColumns = []string{"ObjID", "Name", "ZoneID", "X", "Y", "Z"}
+	zmudExitColumns = []string{"ExitID", "FromID", "ToID", "DirType", "Name"}
+	zmudZoneColumns = []string{"ZoneID", "Name"}
+)
+
+// importZMUD reads a zip of zMUD/CMUD map tables exported to CSV
+func importZMUD(data []byte) ([]store.MapRoom, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid zMUD map archive: %v", err)
+	}
+
+	tables := make(map[string][]map[string]string)
+	for _, f := range archive.File {
+		name := strings.ToLower(path.Base(f.Name))
+		for _, table := range []string{zmudRoomsFile, zmudExitsFile, zmudZonesFile} {
+			if name != strings.ToLower(table) {
+				continue
+			}
+			rows, err := readCSVFile(f)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", table, err)
+			}
+			tables[table] = rows
+		}
+	}
+	if tables[zmudRoomsFile] == nil {
+		return nil, errors.New("zMUD map archive has no " + zmudRoomsFile)
+	}
+	if len(tables[zmudRoomsFile]) > MaxImportRooms {
+		return nil, ErrTooManyRooms
+	}
+
+	zones := make(map[string]string)
+	for _, row := range tables[zmudZonesFile] {
+		zones[row["ZoneID"]] = row["Name"]
+	}
+
+	rooms := make([]store.MapRoom, 0, len(tables[zmudRoomsFile]))
+	index := make(map[string]int)
+	for _, row := range tables[zmudRoomsFile] {
+		room := store.MapRoom{
+			Key:   strings.TrimSpace(row["ObjID"]),
+			Name:  row["Name"],
+			Area:  zones[row["ZoneID"]],
+			X:     atoi(row["X"]),
+			Y:     -atoi(row["Y"]), // zMUD's y axis points south
+			Z:     atoi(row["Z"]),
+			Exits: []store.MapExit{},
+		}
+		if room.Area == "" {
+			room.Area = mapper.DefaultArea
+		}
+		if unknown := withoutColumns(row, zmudRoomColumns); len(unknown) > 0 {
+			setExtra(&room.Extra, FormatZMUDTables, unknown)
+		}
+		index[room.Key] = len(rooms)
+		rooms = append(rooms, room)
+	}
+
+	for _, row := range tables[zmudExitsFile] {
+		i, ok := index[strings.TrimSpace(row["FromID"])]
+		if !ok {
+			continue
+		}
+		direction := ""
+		if n, err := strconv.Atoi(strings.TrimSpace(row["DirType"])); err == nil && n >= 0 && n < len(zmudDirections) {
+			direction = zmudDirections[n]
+		} else {
+			direction = mapper.NormalizeDirection(row["Name"])
+		}
+		if direction == "" {
+			continue
+		}
+		exit := store.MapExit{Direction: direction}
+		if to := strings.TrimSpace(row["ToID"]); to != "" && to != "0" && to != "-1" {
+			exit.To = to
+		}
+		if unknown := withoutColumns(row, zmudExitColumns); len(unknown) > 0 {
+			setExtra(&exit.Extra, FormatZMUDTables, unknown)
+		}
+		rooms[i].SetExit(exit)
+	}
+	return rooms, nil
+}
+
+// exportZMUD writes rooms as a zip of zMUD/CMUD map tables
+func exportZMUD(rooms []store.MapRoom, w io.Writer) error {
+	ids := numericIDs(rooms)
+	names, areaIDs := areaIDs(rooms)
+
+	zoneRows := make([]map[string]string, 0, len(names))
+	for _, name := range names {
+		zoneRows = append(zoneRows, map[string]string{"ZoneID": strconv.Itoa(areaIDs[name]), "Name": name})
+	}
+
+	var roomRows, exitRows []map[string]string
+	exitID := 1
+	for _, room := range rooms {
+		row := map[string]string{}
+		getExtra(room.Extra, FormatZMUDTables, &row)
+		if row == nil {
+			row = map[string]string{}
+		}
+		row["ObjID"] = strconv.Itoa(ids[room.Key])
+		row["Name"] = room.Name
+		row["ZoneID"] = strconv.Itoa(areaIDs[room.Area])
+		row["X"] = strconv.Itoa(room.X)
+		row["Y"] = strconv.Itoa(-room.Y)
+		row["Z"] = strconv.Itoa(room.Z)
+		roomRows = append(roomRows, row)
+
+		for _, exit := range room.Exits {
+			e := map[string]string{}
+			getExtra(exit.Extra, FormatZMUDTables, &e)
+			if e == nil {
+				e = map[string]string{}
+			}
+			e["ExitID"] = strconv.Itoa(exitID)
+			e["FromID"] = strconv.Itoa(ids[room.Key])
+			e["ToID"] = "0"
+			if to, ok := ids[exit.To]; ok {
+				e["ToID"] = strconv.Itoa(to)
+			}
+			e["DirType"] = strconv.Itoa(zmudDirType(exit.Direction))
+			e["Name"] = mapper.LongDirection(exit.Direction)
+			exitRows = append(exitRows, e)
+			exitID++
+		}
+	}
+
+	archive := zip.NewWriter(w)
+	if err := writeCSVFile(archive, zmudZonesFile, zmudZoneColumns, zoneRows); err != nil {
+		return err
+	}
+	if err := writeCSVFile(archive, zmudRoomsFile, zmudRoomColumns, roomRows); err != nil {
+		return err
+	}
+	if err := writeCSVFile(archive, zmudExitsFile, zmudExitColumns, exitRows); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+func zmudDirType(direction string) int {
+	for i, d := range zmudDirections {
+		if d == direction {
+			return i
+		}
+	}
+	return -1
+}
+
+// readCSVFile reads a CSV file with a header row into one map per row
+func readCSVFile(f *zip.File) ([]map[string]string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	r := csv.NewReader(io.LimitReader(rc, MaxImportBytes))
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+	for i := range header {
+		header[i] = canonicalColumn(strings.TrimSpace(strings.TrimPrefix(header[i], "\ufeff")))
+	}
+
+	var rows []map[string]string
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		row := make(map[string]string, len(header))
+		for i, col := range header {
+			if i < len(record) {
+				row[col] = record[i]
+			}
+		}
+		rows = append(rows, row)
+		if len(rows) > MaxImportRooms*4 {
+			return nil, ErrTooManyRooms
+		}
+	}
+	return rows, nil
+}
+
+// writeCSVFile adds a CSV file to the archive: the known columns first, then any preserved ones
+func writeCSVFile(archive *zip.Writer, name string, columns []string, rows []map[string]string) error {
+	known := make(map[string]bool, len(columns))
+	for _, col := range columns {
+		known[col] = true
+	}
+	extra := make(map[string]bool)
+	for _, row := range rows {
+		for col := range row {
+			if !known[col] {
+				extra[col] = true
+			}
+		}
+	}
+	header := append(append([]string{}, columns...), sortedKeys(extra)...)
+
+	f, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(f)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	record := make([]string, len(header))
+	for _, row := range rows {
+		for i, col := range header {
+			record[i] = row[col]
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// canonicalColumn matches known column names case-insensitively ("objid" -> "ObjID")
+func canonicalColumn(name string) string {
+	for _, cols := range [][]string{zmudRoomColumns, zmudExitColumns, zmudZoneColumns} {
+		for _, col := range cols {
+			if strings.EqualFold(col, name) {
+				return col
+			}
+		}
+	}
+	return name
+}
+
+// withoutColumns returns the entries of row not in columns
+func withoutColumns(row map[string]string, columns []string) map[string]string {
+	var rest map[string]string
+	for k, v := range row {
+		known := false
+		for _, col := range columns {
+			if k == col {
+				known = true
+				break
+			}
+		}
+		if known {
+			continue
+		}
+		if rest == nil {
+			rest = make(map[string]string)
+		}
+		rest[k] = v
+	}
+	return rest
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(strings.TrimSpace(s))
+	return n
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,82 @@
+package mapper
+
+import "strings"
+
+// directionAliases maps long and short direction names to their canonical short form
+var directionAliases = map[string]string{
+	"n": "n", "north": "n",
+	"s": "s", "south": "s",
+	"e": "e", "east": "e",
+	"w": "w", "west": "w",
+	"ne": "ne", "northeast": "ne",
+	"nw": "nw", "northwest": "nw",
+	"se": "se", "southeast": "se",
+	"sw": "sw", "southwest": "sw",
+	"u": "u", "up": "u",
+	"d": "d", "down": "d",
+	"in": "in", "out": "out",
+}
+
+// offset is a grid displacement
+type offset struct{ x, y, z int }
+
+// directionOffsets gives the grid step for each compass direction; north is +y
+var directionOffsets = map[string]offset{
+	"n":  {0, 1, 0},
+	"s":  {0, -1, 0},
+	"e":  {1, 0, 0},
+	"w":  {-1, 0, 0},
+	"ne": {1, 1, 0},
+	"nw": {-1, 1, 0},
+	"se": {1, -1, 0},
+	"sw": {-1, -1, 0},
+	"u":  {0, 0, 1},
+	"d":  {0, 0, -1},
+}
+
+// reverseDirections gives the opposite of each direction
+var reverseDirections = map[string]string{
+	"n": "s", "s": "n",
+	"e": "w", "w": "e",
+	"ne": "sw", "sw": "ne",
+	"nw": "se", "se": "nw",
+	"u": "d", "d": "u",
+	"in": "out", "out": "in",
+}
+
+// longDirections gives the full name of each short direction
+var longDirections = map[string]string{
+	"n": "north", "s": "south", "e": "east", "w": "west",
+	"ne": "northeast", "nw": "northwest", "se": "southeast", "sw": "southwest",
+	"u": "up", "d": "down", "in": "in", "out": "out",
+}
+
+// LongDirection returns the full name of a direction ("n" -> "north")
+// Custom exits are returned unchanged
+func LongDirection(direction string) string {
+	if long, ok := longDirections[NormalizeDirection(direction)]; ok {
+		return long
+	}
+	return direction
+}
+
+// NormalizeDirection returns the canonical short form of a direction ("north" -> "n")
+// Unknown names are returned lower-cased so custom exits such as "portal" are kept
+func NormalizeDirection(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if short, ok := directionAliases[name]; ok {
+		return short
+	}
+	return name
+}
+
+// MovementDirection reports the direction a command moves in, if it is a plain movement command
+func MovementDirection(command string) (string, bool) {
+	short, ok := directionAliases[strings.ToLower(strings.TrimSpace(command))]
+	return short, ok
+}
+
+// ReverseDirection returns the opposite direction, or "" if there is none
+func ReverseDirection(direction string) string {
+	return reverseDirections[NormalizeDirection(direction)]
+}
@@ -0,0 +1,472 @@
+// Package mapper records the rooms a player walks through into a per-connection map
+// Rooms come from GMCP Room.Info when the MUD supports it, otherwise from
+// configurable room-name and exit-line regexes combined with the player's movement commands.
+package mapper
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/amaranth494/MudPuppy/internal/store"
+	"github.com/google/uuid"
+)
+
+// DefaultArea is used for rooms whose area is unknown
+const DefaultArea = "default"
+
+// GMCP packages understood by the mapper
+const (
+	GMCPRoomInfo = "Room.Info"
+)
+
+// MaxPatternLength bounds user-supplied room-name and exit-line regexes
+const MaxPatternLength = 500
+
+const (
+	queueSize    = 256 // Pending inputs before new ones are dropped
+	maxMoveQueue = 32  // Movement commands awaiting a room
+)
+
+// ErrPatternTooLong indicates a room-name or exit-line regex is too long
+var ErrPatternTooLong = errors.New("pattern is too long")
+
+// exitSplitRegex separates entries of an exit list such as "north, east and up."
+var exitSplitRegex = regexp.MustCompile(`(?i)[\s,;.]+|\band\b`)
+
+// Store is the subset of store.MapStore the mapper needs
+type Store interface {
+	GetRoomByKey(mapID uuid.UUID, key string) (*store.MapRoom, error)
+	FindRoomAt(mapID uuid.UUID, area, name string, x, y, z int) (*store.MapRoom, error)
+	UpsertRoom(room *store.MapRoom) error
+	ListRooms(mapID uuid.UUID, area string) ([]store.MapRoom, error)
+}
+
+// Mapper builds the map of one live session
+// Inputs are queued and processed in order on a single goroutine so the relay never waits on the database
+type Mapper struct {
+	store  Store
+	mapID  uuid.UUID
+	onRoom func(room store.MapRoom)
+
+	queue     chan func()
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu      sync.RWMutex
+	current *store.MapRoom
+
+	// Owned by the worker goroutine
+	moves       []string
+	gmcp        bool // Room.Info seen; regex mapping is disabled
+	roomNameRe  *regexp.Regexp
+	exitsRe     *regexp.Regexp
+	pendingName string
+}
+
+// New creates a mapper for a map; onRoom is called whenever the current room changes
+func New(s Store, m *store.Map, onRoom func(room store.MapRoom)) *Mapper {
+	mp := &Mapper{
+		store:  s,
+		mapID:  m.ID,
+		onRoom: onRoom,
+		queue:  make(chan func(), queueSize),
+		done:   make(chan struct{}),
+	}
+	mp.roomNameRe, mp.exitsRe = compilePatterns(m.RoomNamePattern, m.ExitsPattern)
+	go mp.run()
+	return mp
+}
+
+// CompilePattern validates a room-name or exit-line regex; an empty pattern is allowed
+func CompilePattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	if len(pattern) > MaxPatternLength {
+		return nil, ErrPatternTooLong
+	}
+	return regexp.Compile(pattern)
+}
+
+func compilePatterns(roomName, exits string) (*regexp.Regexp, *regexp.Regexp) {
+	roomNameRe, err := CompilePattern(roomName)
+	if err != nil {
+		log.Printf("Ignoring invalid room name pattern: %v", err)
+	}
+	exitsRe, err := CompilePattern(exits)
+	if err != nil {
+		log.Printf("Ignoring invalid exits pattern: %v", err)
+	}
+	return roomNameRe, exitsRe
+}
+
+// MapID returns the map the mapper writes to
+func (m *Mapper) MapID() uuid.UUID {
+	return m.mapID
+}
+
+// Current returns the room the player is in, if known
+func (m *Mapper) Current() *store.MapRoom {
+	return m.currentRoom()
+}
+
+// SetPatterns replaces the room-name and exit-line regexes
+func (m *Mapper) SetPatterns(roomName, exits string) {
+	roomNameRe, exitsRe := compilePatterns(roomName, exits)
+	m.enqueue(func() {
+		m.roomNameRe, m.exitsRe = roomNameRe, exitsRe
+		m.pendingName = ""
+	})
+}
+
+// HandleCommand notes a command sent to the MUD so the next room can be placed relative to the current one
+func (m *Mapper) HandleCommand(command string) {
+	direction, ok := MovementDirection(command)
+	if !ok {
+		return
+	}
+	m.enqueue(func() {
+		if len(m.moves) >= maxMoveQueue {
+			m.moves = m.moves[1:]
+		}
+		m.moves = append(m.moves, direction)
+	})
+}
+
+// HandleGMCP processes a GMCP message; packages other than Room.Info are ignored
+func (m *Mapper) HandleGMCP(pkg string, data []byte) {
+	if !strings.EqualFold(pkg, GMCPRoomInfo) {
+		return
+	}
+	payload := append([]byte(nil), data...)
+	m.enqueue(func() {
+		m.gmcp = true
+		if err := m.roomInfo(payload); err != nil {
+			log.Printf("Failed to map GMCP room: %v", err)
+		}
+	})
+}
+
+// HandleLine processes a line of MUD output (ANSI codes already removed) for regex mapping
+func (m *Mapper) HandleLine(line string) {
+	m.enqueue(func() {
+		if m.gmcp || m.roomNameRe == nil || m.exitsRe == nil {
+			return
+		}
+		if err := m.regexLine(line); err != nil {
+			log.Printf("Failed to map room: %v", err)
+		}
+	})
+}
+
+// Close stops the mapper; queued inputs are discarded
+func (m *Mapper) Close() {
+	m.closeOnce.Do(func() { close(m.done) })
+}
+
+func (m *Mapper) enqueue(fn func()) {
+	select {
+	case <-m.done:
+	case m.queue <- fn:
+	default:
+		log.Printf("Mapper queue full for map=%s, dropping input", m.mapID)
+	}
+}
+
+func (m *Mapper) run() {
+	for {
+		select {
+		case <-m.done:
+			return
+		case fn := <-m.queue:
+			fn()
+		}
+	}
+}
+
+// roomInfoMessage is the GMCP Room.Info payload as sent by IRE-style servers
+type roomInfoMessage struct {
+	Num         json.RawMessage            `json:"num"`
+	Name        string                     `json:"name"`
+	Area        string                     `json:"area"`
+	Environment string                     `json:"environment"`
+	Coords      json.RawMessage            `json:"coords"`
+	Exits       map[string]json.RawMessage `json:"exits"`
+}
+
+// roomInfo records a room from a GMCP Room.Info payload
+func (m *Mapper) roomInfo(data []byte) error {
+	var msg roomInfoMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return err
+	}
+	key := rawKey(msg.Num)
+	if key == "" {
+		return errors.New("room without num")
+	}
+
+	existing, err := m.store.GetRoomByKey(m.mapID, key)
+	if err != nil {
+		return err
+	}
+
+	direction := m.arrivalDirection(key)
+	room := &store.MapRoom{
+		MapID:       m.mapID,
+		Key:         key,
+		Name:        msg.Name,
+		Area:        msg.Area,
+		Environment: msg.Environment,
+	}
+	if room.Area == "" {
+		room.Area = DefaultArea
+	}
+	dirs := make([]string, 0, len(msg.Exits))
+	for dir := range msg.Exits {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		exit := store.MapExit{Direction: NormalizeDirection(dir), To: rawKey(msg.Exits[dir])}
+		if existing != nil {
+			// Exit weights and imported fields are unknown to the MUD
+			if known, ok := existing.Exit(exit.Direction); ok {
+				exit.Weight = known.Weight
+				exit.Extra = known.Extra
+			}
+		}
+		room.SetExit(exit)
+	}
+
+	// Keep the position of known rooms so manual layout edits survive revisits
+	switch {
+	case existing != nil:
+		room.X, room.Y, room.Z = existing.X, existing.Y, existing.Z
+	case parseCoords(msg.Coords, room):
+	default:
+		m.placeFrom(room, direction)
+	}
+
+	if err := m.store.UpsertRoom(room); err != nil {
+		return err
+	}
+	m.setCurrent(room)
+	return nil
+}
+
+// regexLine feeds one output line through the room-name and exit-line regexes
+// A room is recorded when an exit line follows a room-name line
+func (m *Mapper) regexLine(line string) error {
+	if match := m.exitsRe.FindStringSubmatch(line); match != nil && m.pendingName != "" {
+		name := m.pendingName
+		m.pendingName = ""
+		list := match[0]
+		if len(match) > 1 {
+			list = match[1]
+		}
+		return m.regexRoom(name, parseExitList(list))
+	}
+	if match := m.roomNameRe.FindStringSubmatch(line); match != nil {
+		name := match[0]
+		if len(match) > 1 {
+			name = match[1]
+		}
+		m.pendingName = strings.TrimSpace(name)
+	}
+	return nil
+}
+
+// regexRoom records a room seen through the regexes
+// Without room numbers, a room is identified by following a known exit from the
+// current room or by finding a room with the same name at the expected position
+func (m *Mapper) regexRoom(name string, exits []string) error {
+	direction := m.nextMove()
+	previous := m.currentRoom()
+
+	var room *store.MapRoom
+	if previous != nil && direction != "" {
+		if exit, ok := previous.Exit(direction); ok && exit.To != "" {
+			known, err := m.store.GetRoomByKey(m.mapID, exit.To)
+			if err != nil {
+				return err
+			}
+			if known != nil && known.Name == name {
+				room = known
+			}
+		}
+	}
+	if room == nil {
+		candidate := &store.MapRoom{MapID: m.mapID, Name: name, Area: DefaultArea}
+		m.placeFrom(candidate, direction)
+		known, err := m.store.FindRoomAt(m.mapID, candidate.Area, name, candidate.X, candidate.Y, candidate.Z)
+		if err != nil {
+			return err
+		}
+		if known != nil {
+			room = known
+		} else {
+			candidate.Key = uuid.NewString()
+			room = candidate
+		}
+	}
+
+	// Refresh exits, keeping destinations already learned
+	updated := make([]store.MapExit, 0, len(exits))
+	for _, dir := range exits {
+		exit, _ := room.Exit(dir)
+		exit.Direction = dir
+		updated = append(updated, exit)
+	}
+	room.Exits = updated
+
+	if previous != nil && direction != "" && previous.Key != room.Key {
+		if back := ReverseDirection(direction); back != "" {
+			if exit, ok := room.Exit(back); ok && exit.To == "" {
+				room.SetExit(store.MapExit{Direction: back, To: previous.Key})
+			}
+		}
+		previous.SetExit(store.MapExit{Direction: direction, To: room.Key})
+		if err := m.store.UpsertRoom(previous); err != nil {
+			return err
+		}
+	}
+
+	if err := m.store.UpsertRoom(room); err != nil {
+		return err
+	}
+	m.setCurrent(room)
+	return nil
+}
+
+// arrivalDirection works out how the player reached the room with the given key:
+// by an exit of the current room leading there, or else by the oldest pending movement
+func (m *Mapper) arrivalDirection(key string) string {
+	direction := m.nextMove()
+	if previous := m.currentRoom(); previous != nil {
+		if exit, ok := previous.Exit(direction); ok && exit.To == key {
+			return direction
+		}
+		for _, exit := range previous.Exits {
+			if exit.To == key {
+				return exit.Direction
+			}
+		}
+	}
+	return direction
+}
+
+// placeFrom positions a new room one step from the current room, or at the origin
+func (m *Mapper) placeFrom(room *store.MapRoom, direction string) {
+	previous := m.currentRoom()
+	if previous == nil {
+		return
+	}
+	if room.Area == DefaultArea || room.Area == "" {
+		room.Area = previous.Area
+	}
+	delta := directionOffsets[direction]
+	room.X = previous.X + delta.x
+	room.Y = previous.Y + delta.y
+	room.Z = previous.Z + delta.z
+}
+
+// nextMove pops the oldest pending movement, or returns "" if there is none
+func (m *Mapper) nextMove() string {
+	if len(m.moves) == 0 {
+		return ""
+	}
+	direction := m.moves[0]
+	m.moves = m.moves[1:]
+	return direction
+}
+
+// currentRoom returns a copy of the current room that the caller may modify
+func (m *Mapper) currentRoom() *store.MapRoom {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return copyRoom(m.current)
+}
+
+func (m *Mapper) setCurrent(room *store.MapRoom) {
+	m.mu.Lock()
+	m.current = copyRoom(room)
+	m.mu.Unlock()
+
+	if m.onRoom != nil {
+		m.onRoom(*copyRoom(room))
+	}
+}
+
+func copyRoom(room *store.MapRoom) *store.MapRoom {
+	if room == nil {
+		return nil
+	}
+	c := *room
+	c.Exits = append([]store.MapExit{}, room.Exits...)
+	return &c
+}
+
+// rawKey turns a JSON room number or string into a room key
+func rawKey(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return strings.TrimSpace(s)
+	}
+	var n json.Number
+	if err := json.Unmarshal(raw, &n); err == nil {
+		return n.String()
+	}
+	return ""
+}
+
+// parseCoords reads "area,x,y,z" or "x,y,z" style coordinates, reporting whether they were usable
+func parseCoords(raw json.RawMessage, room *store.MapRoom) bool {
+	var s string
+	if len(raw) == 0 || json.Unmarshal(raw, &s) != nil {
+		return false
+	}
+	parts := strings.Split(s, ",")
+	if len(parts) == 4 {
+		parts = parts[1:]
+	}
+	if len(parts) != 3 {
+		return false
+	}
+	var coords [3]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil {
+			return false
+		}
+		coords[i] = n
+	}
+	room.X, room.Y, room.Z = coords[0], coords[1], coords[2]
+	return true
+}
+
+// parseExitList splits an exit list such as "north, east and up" into directions
+func parseExitList(list string) []string {
+	seen := make(map[string]bool)
+	var exits []string
+	for _, part := range exitSplitRegex.Split(list, -1) {
+		part = strings.Trim(part, "[]()<>'\"!")
+		if part == "" || strings.EqualFold(part, "none") {
+			continue
+		}
+		dir := NormalizeDirection(part)
+		if !seen[dir] {
+			seen[dir] = true
+			exits = append(exits, dir)
+		}
+	}
+	return exits
+}
@@ -0,0 +1,181 @@
+package mapper
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/amaranth494/MudPuppy/internal/store"
+	"github.com/google/uuid"
+)
+
+// ErrNoPath indicates the destination cannot be reached from the start room
+var ErrNoPath = errors.New("no path between these rooms")
+
+// ErrUnknownLocation indicates the player's current room is not known
+var ErrUnknownLocation = errors.New("current room is unknown")
+
+// PathStep is one exit taken along a path
+type PathStep struct {
+	Direction string `json:"direction"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+}
+
+// Path is the cheapest route between two rooms
+type Path struct {
+	From  string     `json:"from"`
+	To    string     `json:"to"`
+	Cost  int        `json:"cost"`
+	Steps []PathStep `json:"steps"`
+}
+
+// Commands returns the commands to send to walk the path
+func (p *Path) Commands() []string {
+	commands := make([]string, len(p.Steps))
+	for i, step := range p.Steps {
+		commands[i] = step.Direction
+	}
+	return commands
+}
+
+// ResolveRoom finds a room by key, ID or (unique, case-insensitive) name
+func ResolveRoom(rooms []store.MapRoom, ref string) (*store.MapRoom, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return nil, errors.New("room is required")
+	}
+	for i := range rooms {
+		if rooms[i].Key == ref {
+			return &rooms[i], nil
+		}
+	}
+	if id, err := uuid.Parse(ref); err == nil {
+		for i := range rooms {
+			if rooms[i].ID == id {
+				return &rooms[i], nil
+			}
+		}
+	}
+
+	var match *store.MapRoom
+	for i := range rooms {
+		if strings.EqualFold(rooms[i].Name, ref) {
+			if match != nil {
+				return nil, fmt.Errorf("more than one room is named %q; use its room key", ref)
+			}
+			match = &rooms[i]
+		}
+	}
+	if match == nil {
+		return nil, fmt.Errorf("unknown room: %s", ref)
+	}
+	return match, nil
+}
+
+// FindPath computes the cheapest path between two rooms with Dijkstra's algorithm
+// Exits cost their weight (default 1); rooms flagged avoid are never passed through,
+// although they may still be the start or the destination
+func FindPath(rooms []store.MapRoom, from, to string) (*Path, error) {
+	byKey := make(map[string]*store.MapRoom, len(rooms))
+	for i := range rooms {
+		byKey[rooms[i].Key] = &rooms[i]
+	}
+	if byKey[from] == nil {
+		return nil, fmt.Errorf("unknown room: %s", from)
+	}
+	if byKey[to] == nil {
+		return nil, fmt.Errorf("unknown room: %s", to)
+	}
+
+	dist := map[string]int{from: 0}
+	prev := make(map[string]PathStep)
+	done := make(map[string]bool)
+	queue := &pathQueue{{key: from}}
+
+	for queue.Len() > 0 {
+		item := heap.Pop(queue).(pathItem)
+		if done[item.key] {
+			continue
+		}
+		done[item.key] = true
+		if item.key == to {
+			break
+		}
+
+		room := byKey[item.key]
+		if room.Avoid && item.key != from {
+			continue
+		}
+		for _, exit := range room.Exits {
+			next, ok := byKey[exit.To]
+			if !ok || done[next.Key] {
+				continue
+			}
+			cost := item.cost + exit.Cost()
+			if d, seen := dist[next.Key]; seen && d <= cost {
+				continue
+			}
+			dist[next.Key] = cost
+			prev[next.Key] = PathStep{Direction: exit.Direction, From: room.Key, To: next.Key}
+			heap.Push(queue, pathItem{key: next.Key, cost: cost})
+		}
+	}
+
+	if !done[to] {
+		return nil, ErrNoPath
+	}
+
+	path := &Path{From: from, To: to, Cost: dist[to], Steps: []PathStep{}}
+	for key := to; key != from; key = prev[key].From {
+		path.Steps = append(path.Steps, prev[key])
+	}
+	for i, j := 0, len(path.Steps)-1; i < j; i, j = i+1, j-1 {
+		path.Steps[i], path.Steps[j] = path.Steps[j], path.Steps[i]
+	}
+	return path, nil
+}
+
+// FindPath computes the path between two rooms of the mapper's map
+// An empty from starts at the player's current room
+func (m *Mapper) FindPath(from, to string) (*Path, error) {
+	rooms, err := m.store.ListRooms(m.mapID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var start *store.MapRoom
+	if from == "" {
+		if start = m.Current(); start == nil {
+			return nil, ErrUnknownLocation
+		}
+	} else if start, err = ResolveRoom(rooms, from); err != nil {
+		return nil, err
+	}
+	target, err := ResolveRoom(rooms, to)
+	if err != nil {
+		return nil, err
+	}
+	return FindPath(rooms, start.Key, target.Key)
+}
+
+// pathItem is a room waiting in the Dijkstra queue
+type pathItem struct {
+	key  string
+	cost int
+}
+
+// pathQueue is a min-heap of rooms ordered by cost
+type pathQueue []pathItem
+
+func (q pathQueue) Len() int            { return len(q) }
+func (q pathQueue) Less(i, j int) bool  { return q[i].cost < q[j].cost }
+func (q pathQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *pathQueue) Push(x interface{}) { *q = append(*q, x.(pathItem)) }
+func (q *pathQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
@@ -0,0 +1,695 @@
+package maps
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/amaranth494/MudPuppy/internal/automation"
+	"github.com/amaranth494/MudPuppy/internal/mapformat"
+	"github.com/amaranth494/MudPuppy/internal/mapper"
+	"github.com/amaranth494/MudPuppy/internal/session"
+	"github.com/amaranth494/MudPuppy/internal/store"
+	"github.com/google/uuid"
+)
+
+// Limits on user edits to map rooms
+const (
+	maxRoomNameLength = 200
+	maxAreaNameLength = 100
+	maxRoomExits      = 50
+	maxExitWeight     = 10000
+)
+
+// maxReportedConflicts caps the conflicts listed in an import report; the count is always exact
+const maxReportedConflicts = 200
+
+// Handler handles automapper HTTP requests
+type Handler struct {
+	mapStore   *store.MapStore
+	sessionMgr *session.Manager
+}
+
+// NewHandler creates a new maps handler
+// sessionMgr is used to push setting changes to a live session and may be nil
+func NewHandler(mapStore *store.MapStore, sessionMgr *session.Manager) *Handler {
+	return &Handler{
+		mapStore:   mapStore,
+		sessionMgr: sessionMgr,
+	}
+}
+
+// Request/Response types
+
+type MapResponse struct {
+	Map         *store.Map      `json:"map"`
+	Areas       []store.MapArea `json:"areas"`
+	CurrentRoom *store.MapRoom  `json:"current_room,omitempty"`
+}
+
+type MapSettingsRequest struct {
+	RoomNamePattern string `json:"room_name_pattern"`
+	ExitsPattern    string `json:"exits_pattern"`
+}
+
+type AreasResponse struct {
+	Items []store.MapArea `json:"items"`
+}
+
+type RoomsResponse struct {
+	Items []store.MapRoom `json:"items"`
+}
+
+type UpdateRoomRequest struct {
+	Name        *string          `json:"name,omitempty"`
+	Area        *string          `json:"area,omitempty"`
+	Environment *string          `json:"environment,omitempty"`
+	X           *int             `json:"x,omitempty"`
+	Y           *int             `json:"y,omitempty"`
+	Z           *int             `json:"z,omitempty"`
+	Exits       *[]store.MapExit `json:"exits,omitempty"`
+	Avoid       *bool            `json:"avoid,omitempty"`
+}
+
+type RenameAreaRequest struct {
+	Name string `json:"name"`
+}
+
+type AreaChangeResponse struct {
+	Area  string `json:"area"`
+	Rooms int64  `json:"rooms"`
+}
+
+type TravelRequest struct {
+	To string `json:"to"`
+}
+
+type ImportResponse struct {
+	Format    string               `json:"format"`
+	DryRun    bool                 `json:"dry_run"`
+	Rooms     int                  `json:"rooms"`
+	New       int                  `json:"new"`
+	Unchanged int                  `json:"unchanged"`
+	Conflicts int                  `json:"conflicts"`
+	Details   []mapformat.Conflict `json:"conflict_details"`
+	Imported  int                  `json:"imported"`
+	Skipped   int                  `json:"skipped"`
+}
+
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// Get handles GET /api/v1/connections/:id/map
+func (h *Handler) Get(w http.ResponseWriter, r *http.Request) {
+	userUUID, m, err := h.getMap(r)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+
+	areas, err := h.mapStore.ListAreas(m.ID)
+	if err != nil {
+		log.Printf("List map areas failed: %v", err)
+		h.sendError(w, "Failed to get map")
+		return
+	}
+
+	resp := MapResponse{Map: m, Areas: areas}
+	if engine := h.liveEngine(userUUID, m.ConnectionID); engine != nil {
+		resp.CurrentRoom = engine.CurrentRoom()
+	}
+	h.sendJSON(w, resp)
+}
+
+// PutSettings handles PUT /api/v1/connections/:id/map/settings
+// The patterns configure regex mapping for MUDs without GMCP; both must be set for it to run
+func (h *Handler) PutSettings(w http.ResponseWriter, r *http.Request) {
+	userUUID, m, err := h.getMap(r)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+
+	var req MapSettingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendError(w, "Invalid request body")
+		return
+	}
+	if _, err := mapper.CompilePattern(req.RoomNamePattern); err != nil {
+		h.sendError(w, "Invalid room name pattern: "+err.Error())
+		return
+	}
+	if _, err := mapper.CompilePattern(req.ExitsPattern); err != nil {
+		h.sendError(w, "Invalid exits pattern: "+err.Error())
+		return
+	}
+
+	updated, err := h.mapStore.UpdateMapSettings(userUUID, m.ID, req.RoomNamePattern, req.ExitsPattern)
+	if err != nil {
+		log.Printf("Update map settings failed: %v", err)
+		h.sendError(w, "Failed to update map settings")
+		return
+	}
+	if updated == nil {
+		h.sendError(w, "Map not found")
+		return
+	}
+
+	if engine := h.liveEngine(userUUID, m.ConnectionID); engine != nil && engine.Mapper() != nil {
+		engine.Mapper().SetPatterns(updated.RoomNamePattern, updated.ExitsPattern)
+	}
+	h.sendJSON(w, updated)
+}
+
+// ListAreas handles GET /api/v1/connections/:id/map/areas
+func (h *Handler) ListAreas(w http.ResponseWriter, r *http.Request) {
+	_, m, err := h.getMap(r)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+
+	areas, err := h.mapStore.ListAreas(m.ID)
+	if err != nil {
+		log.Printf("List map areas failed: %v", err)
+		h.sendError(w, "Failed to list areas")
+		return
+	}
+	h.sendJSON(w, AreasResponse{Items: areas})
+}
+
+// GetArea handles GET /api/v1/connections/:id/map/areas/:area
+// Returns the rooms of the area
+func (h *Handler) GetArea(w http.ResponseWriter, r *http.Request) {
+	_, m, err := h.getMap(r)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+
+	area := r.PathValue("area")
+	rooms, err := h.mapStore.ListRooms(m.ID, area)
+	if err != nil {
+		log.Printf("List area rooms failed: %v", err)
+		h.sendError(w, "Failed to get area")
+		return
+	}
+	if len(rooms) == 0 {
+		h.sendError(w, "Area not found")
+		return
+	}
+	h.sendJSON(w, RoomsResponse{Items: rooms})
+}
+
+// RenameArea handles PUT /api/v1/connections/:id/map/areas/:area
+func (h *Handler) RenameArea(w http.ResponseWriter, r *http.Request) {
+	_, m, err := h.getMap(r)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+
+	var req RenameAreaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendError(w, "Invalid request body")
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if err := validateAreaName(name); err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+
+	n, err := h.mapStore.RenameArea(m.ID, r.PathValue("area"), name)
+	if err != nil {
+		log.Printf("Rename area failed: %v", err)
+		h.sendError(w, "Failed to rename area")
+		return
+	}
+	if n == 0 {
+		h.sendError(w, "Area not found")
+		return
+	}
+	h.sendJSON(w, AreaChangeResponse{Area: name, Rooms: n})
+}
+
+// DeleteArea handles DELETE /api/v1/connections/:id/map/areas/:area
+func (h *Handler) DeleteArea(w http.ResponseWriter, r *http.Request) {
+	_, m, err := h.getMap(r)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+
+	area := r.PathValue("area")
+	n, err := h.mapStore.DeleteArea(m.ID, area)
+	if err != nil {
+		log.Printf("Delete area failed: %v", err)
+		h.sendError(w, "Failed to delete area")
+		return
+	}
+	if n == 0 {
+		h.sendError(w, "Area not found")
+		return
+	}
+	h.sendJSON(w, AreaChangeResponse{Area: area, Rooms: n})
+}
+
+// ListRooms handles GET /api/v1/connections/:id/map/rooms?area=
+func (h *Handler) ListRooms(w http.ResponseWriter, r *http.Request) {
+	_, m, err := h.getMap(r)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+
+	rooms, err := h.mapStore.ListRooms(m.ID, r.URL.Query().Get("area"))
+	if err != nil {
+		log.Printf("List rooms failed: %v", err)
+		h.sendError(w, "Failed to list rooms")
+		return
+	}
+	h.sendJSON(w, RoomsResponse{Items: rooms})
+}
+
+// GetRoom handles GET /api/v1/connections/:id/map/rooms/:room_id
+func (h *Handler) GetRoom(w http.ResponseWriter, r *http.Request) {
+	_, m, err := h.getMap(r)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+
+	room, err := h.getRoom(r, m)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+	h.sendJSON(w, room)
+}
+
+// UpdateRoom handles PUT /api/v1/connections/:id/map/rooms/:room_id
+// Only the fields present in the request are changed
+func (h *Handler) UpdateRoom(w http.ResponseWriter, r *http.Request) {
+	_, m, err := h.getMap(r)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+
+	room, err := h.getRoom(r, m)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+
+	var req UpdateRoomRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendError(w, "Invalid request body")
+		return
+	}
+	if err := applyRoomUpdate(room, &req); err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+
+	updated, err := h.mapStore.UpdateRoom(room)
+	if err != nil {
+		log.Printf("Update room failed: %v", err)
+		h.sendError(w, "Failed to update room")
+		return
+	}
+	if updated == nil {
+		h.sendError(w, "Room not found")
+		return
+	}
+	h.sendJSON(w, updated)
+}
+
+// DeleteRoom handles DELETE /api/v1/connections/:id/map/rooms/:room_id
+func (h *Handler) DeleteRoom(w http.ResponseWriter, r *http.Request) {
+	_, m, err := h.getMap(r)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+
+	roomID, err := uuid.Parse(r.PathValue("room_id"))
+	if err != nil {
+		h.sendError(w, "Invalid room ID")
+		return
+	}
+
+	deleted, err := h.mapStore.DeleteRoom(m.ID, roomID)
+	if err != nil {
+		log.Printf("Delete room failed: %v", err)
+		h.sendError(w, "Failed to delete room")
+		return
+	}
+	if !deleted {
+		h.sendError(w, "Room not found")
+		return
+	}
+	h.sendJSON(w, map[string]string{"status": "deleted"})
+}
+
+// FindPath handles GET /api/v1/connections/:id/map/path?from=&to=
+// from defaults to the current room of the live session; rooms are given by key, ID or name
+func (h *Handler) FindPath(w http.ResponseWriter, r *http.Request) {
+	userUUID, m, err := h.getMap(r)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+
+	from := r.URL.Query().Get("from")
+	to := r.URL.Query().Get("to")
+	if to == "" {
+		h.sendError(w, "to is required")
+		return
+	}
+
+	rooms, err := h.mapStore.ListRooms(m.ID, "")
+	if err != nil {
+		log.Printf("Load map for path failed: %v", err)
+		h.sendError(w, "Failed to find path")
+		return
+	}
+
+	var start *store.MapRoom
+	if from == "" {
+		if engine := h.liveEngine(userUUID, m.ConnectionID); engine != nil {
+			start = engine.CurrentRoom()
+		}
+		if start == nil {
+			h.sendError(w, "from is required when the current room is unknown")
+			return
+		}
+	} else if start, err = mapper.ResolveRoom(rooms, from); err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+	target, err := mapper.ResolveRoom(rooms, to)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+
+	path, err := mapper.FindPath(rooms, start.Key, target.Key)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+	h.sendJSON(w, path)
+}
+
+// Travel handles POST /api/v1/connections/:id/map/travel
+// Starts auto-travel in the live session; progress is reported over the WebSocket
+func (h *Handler) Travel(w http.ResponseWriter, r *http.Request) {
+	userUUID, m, err := h.getMap(r)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+
+	var req TravelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendError(w, "Invalid request body")
+		return
+	}
+
+	engine := h.liveEngine(userUUID, m.ConnectionID)
+	if engine == nil {
+		h.sendError(w, "Not connected to this connection")
+		return
+	}
+
+	path, err := engine.Travel(req.To)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+	h.sendJSON(w, path)
+}
+
+// Import handles POST /api/v1/connections/:id/map/import?format=&dry_run=&on_conflict=
+// The body is a map file in the given format: mudlet-json, mudlet-xml or zmud-tables (a zip of
+// CSV tables exported from a zMUD/CMUD map database, not the database file itself). Rooms are matched to existing rooms by key;
+// with dry_run=true nothing is written and the report lists the rooms that would conflict.
+// on_conflict=skip (default) keeps existing rooms, on_conflict=overwrite replaces them.
+func (h *Handler) Import(w http.ResponseWriter, r *http.Request) {
+	_, m, err := h.getMap(r)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+
+	query := r.URL.Query()
+	format := query.Get("format")
+	dryRun := query.Get("dry_run") == "true"
+	onConflict := query.Get("on_conflict")
+	if onConflict == "" {
+		onConflict = "skip"
+	}
+	if onConflict != "skip" && onConflict != "overwrite" {
+		h.sendError(w, "on_conflict must be skip or overwrite")
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, mapformat.MaxImportBytes))
+	if err != nil {
+		h.sendError(w, fmt.Sprintf("Map file must be %d MB or less", mapformat.MaxImportBytes>>20))
+		return
+	}
+
+	rooms, err := mapformat.Import(format, data)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+
+	existing, err := h.mapStore.ListRooms(m.ID, "")
+	if err != nil {
+		log.Printf("Load map for import failed: %v", err)
+		h.sendError(w, "Failed to import map")
+		return
+	}
+
+	// Rooms that match existing ones replace or skip them, so only new rooms grow the map
+	plan := mapformat.PlanMerge(existing, rooms)
+	if len(existing)+len(plan.New) > mapformat.MaxImportRooms {
+		h.sendError(w, mapformat.ErrTooManyRooms.Error())
+		return
+	}
+	resp := ImportResponse{
+		Format:    format,
+		DryRun:    dryRun,
+		Rooms:     len(rooms),
+		New:       len(plan.New),
+		Unchanged: plan.Unchanged,
+		Conflicts: len(plan.Conflicts),
+		Details:   plan.Conflicts,
+	}
+	if len(resp.Details) > maxReportedConflicts {
+		resp.Details = resp.Details[:maxReportedConflicts]
+	}
+
+	writes := plan.New
+	if onConflict == "overwrite" {
+		for _, c := range plan.Conflicts {
+			writes = append(writes, *c.Incoming)
+		}
+	}
+	if !dryRun {
+		if err := h.mapStore.ImportRooms(m.ID, writes); err != nil {
+			log.Printf("Import map failed: %v", err)
+			h.sendError(w, "Failed to import map")
+			return
+		}
+	}
+	resp.Imported = len(writes)
+	resp.Skipped = len(rooms) - len(writes) - plan.Unchanged
+	h.sendJSON(w, resp)
+}
+
+// Export handles GET /api/v1/connections/:id/map/export?format=
+func (h *Handler) Export(w http.ResponseWriter, r *http.Request) {
+	_, m, err := h.getMap(r)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = mapformat.FormatMudletJSON
+	}
+	rooms, err := h.mapStore.ListRooms(m.ID, "")
+	if err != nil {
+		log.Printf("Load map for export failed: %v", err)
+		h.sendError(w, "Failed to export map")
+		return
+	}
+
+	// Encode fully before writing so an error can still be reported as JSON
+	var buf bytes.Buffer
+	if err := mapformat.Export(format, rooms, &buf); err != nil {
+		if err == mapformat.ErrUnknownFormat {
+			h.sendError(w, err.Error())
+			return
+		}
+		log.Printf("Export map failed: %v", err)
+		h.sendError(w, "Failed to export map")
+		return
+	}
+
+	w.Header().Set("Content-Type", mapformat.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="map-%s%s"`, m.ConnectionID, mapformat.FileExtension(format)))
+	if _, err := buf.WriteTo(w); err != nil {
+		log.Printf("Write map export failed: %v", err)
+	}
+}
+
+// applyRoomUpdate validates a room edit and applies it to room
+func applyRoomUpdate(room *store.MapRoom, req *UpdateRoomRequest) error {
+	if req.Name != nil {
+		if len(*req.Name) > maxRoomNameLength {
+			return fmt.Errorf("Room name must be %d characters or less", maxRoomNameLength)
+		}
+		room.Name = *req.Name
+	}
+	if req.Area != nil {
+		area := strings.TrimSpace(*req.Area)
+		if err := validateAreaName(area); err != nil {
+			return err
+		}
+		room.Area = area
+	}
+	if req.Environment != nil {
+		if len(*req.Environment) > maxAreaNameLength {
+			return fmt.Errorf("Environment must be %d characters or less", maxAreaNameLength)
+		}
+		room.Environment = *req.Environment
+	}
+	if req.X != nil {
+		room.X = *req.X
+	}
+	if req.Y != nil {
+		room.Y = *req.Y
+	}
+	if req.Z != nil {
+		room.Z = *req.Z
+	}
+	if req.Exits != nil {
+		exits := *req.Exits
+		if len(exits) > maxRoomExits {
+			return fmt.Errorf("Maximum %d exits allowed", maxRoomExits)
+		}
+		seen := make(map[string]bool)
+		for i, exit := range exits {
+			dir := mapper.NormalizeDirection(exit.Direction)
+			if dir == "" {
+				return fmt.Errorf("Exit direction cannot be empty")
+			}
+			if seen[dir] {
+				return fmt.Errorf("Duplicate exit: %s", dir)
+			}
+			seen[dir] = true
+			exits[i].Direction = dir
+			if exit.Weight < 0 || exit.Weight > maxExitWeight {
+				return fmt.Errorf("Exit weight must be between 0 and %d", maxExitWeight)
+			}
+		}
+		room.Exits = exits
+	}
+	if req.Avoid != nil {
+		room.Avoid = *req.Avoid
+	}
+	return nil
+}
+
+func validateAreaName(name string) error {
+	if name == "" {
+		return fmt.Errorf("Area name is required")
+	}
+	if len(name) > maxAreaNameLength {
+		return fmt.Errorf("Area name must be %d characters or less", maxAreaNameLength)
+	}
+	return nil
+}
+
+// liveEngine returns the automation engine of the user's live session if it is bound to connectionID
+func (h *Handler) liveEngine(userID, connectionID uuid.UUID) *automation.Engine {
+	if h.sessionMgr == nil {
+		return nil
+	}
+	engine := h.sessionMgr.Automation(userID.String())
+	if engine == nil || engine.ConnectionID() != connectionID {
+		return nil
+	}
+	return engine
+}
+
+// getMap validates the user and returns the map of the connection in the path, creating it if needed
+func (h *Handler) getMap(r *http.Request) (uuid.UUID, *store.Map, error) {
+	userID := r.Context().Value("user_id")
+	if userID == nil {
+		return uuid.Nil, nil, fmt.Errorf("Unauthorized")
+	}
+	userUUID, err := uuid.Parse(userID.(string))
+	if err != nil {
+		return uuid.Nil, nil, fmt.Errorf("Invalid user ID")
+	}
+
+	connectionID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		return uuid.Nil, nil, fmt.Errorf("Invalid connection ID")
+	}
+
+	m, err := h.mapStore.EnsureMap(userUUID, connectionID)
+	if err != nil {
+		log.Printf("Get map failed: %v", err)
+		return uuid.Nil, nil, fmt.Errorf("Failed to get map")
+	}
+	if m == nil {
+		return uuid.Nil, nil, fmt.Errorf("Connection not found")
+	}
+	return userUUID, m, nil
+}
+
+// getRoom returns the room in the path
+func (h *Handler) getRoom(r *http.Request, m *store.Map) (*store.MapRoom, error) {
+	roomID, err := uuid.Parse(r.PathValue("room_id"))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid room ID")
+	}
+	room, err := h.mapStore.GetRoom(m.ID, roomID)
+	if err != nil {
+		log.Printf("Get room failed: %v", err)
+		return nil, fmt.Errorf("Failed to get room")
+	}
+	if room == nil {
+		return nil, fmt.Errorf("Room not found")
+	}
+	return room, nil
+}
+
+// sendJSON sends a JSON response
+func (h *Handler) sendJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		log.Printf("Failed to encode JSON: %v", err)
+	}
+}
+
+// sendError sends an error response
+func (h *Handler) sendError(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: message})
+}
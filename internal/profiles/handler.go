@@ -2,11 +2,15 @@ package profiles
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"regexp"
 	"strings"
 
+	"github.com/amaranth494/MudPuppy/internal/audit"
+	"github.com/amaranth494/MudPuppy/internal/automation"
+	"github.com/amaranth494/MudPuppy/internal/session"
 	"github.com/amaranth494/MudPuppy/internal/store"
 	"github.com/google/uuid"
 )
@@ -14,15 +18,24 @@ import (
 // Handler handles profiles HTTP requests
 type Handler struct {
 	profileStore *store.ProfileStore
+	sessionMgr   *session.Manager
+	auditLog     *audit.Log
 }
 
 // NewHandler creates a new profiles handler
-func NewHandler(profileStore *store.ProfileStore) *Handler {
+// sessionMgr is used to push automation changes to a live session and may be nil
+func NewHandler(profileStore *store.ProfileStore, sessionMgr *session.Manager) *Handler {
 	return &Handler{
 		profileStore: profileStore,
+		sessionMgr:   sessionMgr,
 	}
 }
 
+// SetAuditLog sets the log that profile changes are recorded in
+func (h *Handler) SetAuditLog(l *audit.Log) {
+	h.auditLog = l
+}
+
 // Request/Response types
 
 type UpdateProfileRequest struct {
@@ -57,6 +70,18 @@ type VariablesResponse struct {
 	Items []store.Variable `json:"items"`
 }
 
+type ClassesResponse struct {
+	Items []store.ClassSummary `json:"items"`
+}
+
+type SetClassRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+type PathsResponse struct {
+	Items []store.Path `json:"items"`
+}
+
 // Variable name validation regex
 var variableNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
 
@@ -191,6 +216,7 @@ func (h *Handler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	h.recordUpdate(r, userUUID, profile, "settings")
 	h.sendJSON(w, toResponse(profile))
 }
 
@@ -244,6 +270,14 @@ func (h *Handler) PutAliases(w http.ResponseWriter, r *http.Request) {
 			h.sendError(w, "Alias replacement cannot be empty")
 			return
 		}
+		if alias.Class != "" && !automation.ClassNameRegex.MatchString(alias.Class) {
+			h.sendError(w, "Alias class must be 1-50 letters, numbers, underscores or hyphens")
+			return
+		}
+		if err := automation.ValidateAction(alias.Replacement); err != nil {
+			h.sendError(w, "Alias script invalid: "+err.Error())
+			return
+		}
 	}
 
 	// Update aliases
@@ -257,6 +291,8 @@ func (h *Handler) PutAliases(w http.ResponseWriter, r *http.Request) {
 		h.sendError(w, "Failed to update aliases")
 		return
 	}
+	h.syncLiveEngine(userUUID, updatedProfile)
+	h.recordUpdate(r, userUUID, updatedProfile, "aliases")
 
 	h.sendJSON(w, AliasesResponse{Items: updatedProfile.Aliases.Items})
 }
@@ -307,10 +343,14 @@ func (h *Handler) PutTriggers(w http.ResponseWriter, r *http.Request) {
 			h.sendError(w, "Trigger match cannot be empty")
 			return
 		}
-		if strings.TrimSpace(trigger.Action) == "" {
+		if strings.TrimSpace(trigger.Action) == "" && trigger.Capture == nil {
 			h.sendError(w, "Trigger action cannot be empty")
 			return
 		}
+		if trigger.Capture != nil && !automation.StreamNameRegex.MatchString(trigger.Capture.Stream) {
+			h.sendError(w, "Trigger capture stream must be 1-50 letters, numbers, underscores or hyphens")
+			return
+		}
 		if trigger.Type != "contains" {
 			h.sendError(w, "Trigger type must be 'contains'")
 			return
@@ -319,6 +359,14 @@ func (h *Handler) PutTriggers(w http.ResponseWriter, r *http.Request) {
 			h.sendError(w, "Trigger cooldown must be non-negative")
 			return
 		}
+		if trigger.Class != "" && !automation.ClassNameRegex.MatchString(trigger.Class) {
+			h.sendError(w, "Trigger class must be 1-50 letters, numbers, underscores or hyphens")
+			return
+		}
+		if err := automation.ValidateAction(trigger.Action); err != nil {
+			h.sendError(w, "Trigger script invalid: "+err.Error())
+			return
+		}
 	}
 
 	// Update triggers
@@ -332,6 +380,8 @@ func (h *Handler) PutTriggers(w http.ResponseWriter, r *http.Request) {
 		h.sendError(w, "Failed to update triggers")
 		return
 	}
+	h.syncLiveEngine(userUUID, updatedProfile)
+	h.recordUpdate(r, userUUID, updatedProfile, "triggers")
 
 	h.sendJSON(w, TriggersResponse{Items: updatedProfile.Triggers.Items})
 }
@@ -377,9 +427,9 @@ func (h *Handler) PutEnvironment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check for duplicate names and validate names
+	// Check for duplicate names, validate names and check values against their types
 	seenNames := make(map[string]bool)
-	for _, v := range req.Items {
+	for i, v := range req.Items {
 		if strings.TrimSpace(v.Name) == "" {
 			h.sendError(w, "Variable name cannot be empty")
 			return
@@ -393,6 +443,10 @@ func (h *Handler) PutEnvironment(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		seenNames[v.Name] = true
+		if err := req.Items[i].Normalize(); err != nil {
+			h.sendError(w, "Variable "+v.Name+": "+err.Error())
+			return
+		}
 	}
 
 	// Update variables
@@ -407,9 +461,194 @@ func (h *Handler) PutEnvironment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	h.syncLiveEngine(userUUID, updatedProfile)
+	h.recordUpdate(r, userUUID, updatedProfile, "variables")
 	h.sendJSON(w, VariablesResponse{Items: updatedProfile.Variables.Items})
 }
 
+// GetPaths handles GET /api/v1/profiles/:connection_id/paths
+func (h *Handler) GetPaths(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	_, profile, err := h.getProfileByConnectionID(r)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+
+	h.sendJSON(w, PathsResponse{Items: profile.Paths.Items})
+}
+
+// PutPaths handles PUT /api/v1/profiles/:connection_id/paths
+func (h *Handler) PutPaths(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userUUID, profile, err := h.getProfileByConnectionID(r)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+
+	var req PathsResponse
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendError(w, "Invalid request body")
+		return
+	}
+
+	// Validate paths
+	if len(req.Items) > automation.MaxPaths {
+		h.sendError(w, fmt.Sprintf("Maximum %d paths allowed", automation.MaxPaths))
+		return
+	}
+
+	seenNames := make(map[string]bool)
+	for i, path := range req.Items {
+		if !automation.PathNameRegex.MatchString(path.Name) {
+			h.sendError(w, "Path name must be 1-50 letters, numbers, underscores or hyphens")
+			return
+		}
+		if seenNames[strings.ToLower(path.Name)] {
+			h.sendError(w, "Duplicate path name: "+path.Name)
+			return
+		}
+		seenNames[strings.ToLower(path.Name)] = true
+		if _, err := automation.ParseSpeedwalk(path.Route); err != nil {
+			h.sendError(w, "Path "+path.Name+": "+err.Error())
+			return
+		}
+		if path.ID == "" {
+			req.Items[i].ID = uuid.NewString()
+		}
+	}
+
+	// Update paths
+	updates := &store.ProfileUpdate{
+		Paths: &store.Paths{Items: req.Items},
+	}
+
+	updatedProfile, err := h.profileStore.UpdateProfile(userUUID, profile.ID, updates)
+	if err != nil {
+		log.Printf("Update paths failed: %v", err)
+		h.sendError(w, "Failed to update paths")
+		return
+	}
+	h.syncLiveEngine(userUUID, updatedProfile)
+	h.recordUpdate(r, userUUID, updatedProfile, "paths")
+
+	h.sendJSON(w, PathsResponse{Items: updatedProfile.Paths.Items})
+}
+
+// GetClasses handles GET /api/v1/profiles/:connection_id/classes
+func (h *Handler) GetClasses(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	_, profile, err := h.getProfileByConnectionID(r)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+
+	h.sendJSON(w, ClassesResponse{Items: store.SummarizeClasses(profile.Aliases, profile.Triggers, profile.Classes)})
+}
+
+// PutClass handles PUT /api/v1/profiles/:connection_id/classes/:name
+// Enables or disables every alias and trigger in the class at once
+func (h *Handler) PutClass(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userUUID, profile, err := h.getProfileByConnectionID(r)
+	if err != nil {
+		h.sendError(w, err.Error())
+		return
+	}
+
+	name := r.PathValue("name")
+	if !automation.ClassNameRegex.MatchString(name) {
+		h.sendError(w, "Class name must be 1-50 letters, numbers, underscores or hyphens")
+		return
+	}
+
+	var req SetClassRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendError(w, "Invalid request body")
+		return
+	}
+
+	// Apply through the live engine when this connection is currently being played,
+	// so the running session picks up the change immediately
+	if engine := h.liveEngine(userUUID, profile.ConnectionID); engine != nil {
+		err := engine.SetClassEnabled(name, req.Enabled)
+		if err == automation.ErrProfileNotFound {
+			h.sendError(w, "Profile not found")
+			return
+		}
+		if err != nil {
+			log.Printf("Toggle class failed: %v", err)
+			h.sendError(w, "Failed to update class")
+			return
+		}
+		h.recordUpdate(r, userUUID, profile, "classes")
+		h.sendJSON(w, ClassesResponse{Items: engine.Classes()})
+		return
+	}
+
+	updatedProfile, err := h.profileStore.SetClassState(userUUID, profile.ID, name, req.Enabled)
+	if err != nil {
+		log.Printf("Toggle class failed: %v", err)
+		h.sendError(w, "Failed to update class")
+		return
+	}
+	if updatedProfile == nil {
+		h.sendError(w, "Profile not found")
+		return
+	}
+	h.recordUpdate(r, userUUID, updatedProfile, "classes")
+
+	h.sendJSON(w, ClassesResponse{Items: store.SummarizeClasses(updatedProfile.Aliases, updatedProfile.Triggers, updatedProfile.Classes)})
+}
+
+// liveEngine returns the automation engine of the user's live session if it is bound to connectionID
+func (h *Handler) liveEngine(userID, connectionID uuid.UUID) *automation.Engine {
+	if h.sessionMgr == nil {
+		return nil
+	}
+	engine := h.sessionMgr.Automation(userID.String())
+	if engine == nil || engine.ConnectionID() != connectionID {
+		return nil
+	}
+	return engine
+}
+
+// syncLiveEngine reloads the live session's automation engine after its profile was edited
+func (h *Handler) syncLiveEngine(userID uuid.UUID, profile *store.Profile) {
+	if profile == nil {
+		return
+	}
+	if engine := h.liveEngine(userID, profile.ConnectionID); engine != nil {
+		engine.Load(profile)
+	}
+}
+
+// recordUpdate records a change to one section of a profile in the audit log
+func (h *Handler) recordUpdate(r *http.Request, userID uuid.UUID, profile *store.Profile, section string) {
+	h.auditLog.Record(r, userID, audit.EventProfileUpdated, map[string]string{
+		"connection_id": profile.ConnectionID.String(),
+		"section":       section,
+	})
+}
+
 // getProfileByConnectionID is a helper that validates the user and fetches the profile by connection ID
 func (h *Handler) getProfileByConnectionID(r *http.Request) (uuid.UUID, *store.Profile, error) {
 	userID := r.Context().Value("user_id")
@@ -486,6 +725,11 @@ func (h *Handler) validateUpdate(req *UpdateProfileRequest) error {
 		if settings.ScrollbackLimit < 100 || settings.ScrollbackLimit > 10000 {
 			return &ValidationError{Message: "Scrollback limit must be between 100 and 10000"}
 		}
+
+		// Validate speedwalk pacing (0 means default)
+		if settings.WalkDelayMs != 0 && (settings.WalkDelayMs < 50 || settings.WalkDelayMs > 5000) {
+			return &ValidationError{Message: "Walk delay must be between 50 and 5000 ms"}
+		}
 	}
 
 	return nil
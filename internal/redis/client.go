@@ -3,16 +3,13 @@ package redis
 import (
 	"context"
 	"errors"
+	"strconv"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
 var (
-	// ErrOTPExpired indicates the OTP has expired
-	ErrOTPExpired = errors.New("otp expired")
-	// ErrOTPNotFound indicates no OTP was found for the email
-	ErrOTPNotFound = errors.New("otp not found")
 	// ErrInvalidSession indicates the session is invalid or expired
 	ErrInvalidSession = errors.New("invalid session")
 	// ErrSessionExpired indicates the session has expired (hard cap)
@@ -21,6 +18,8 @@ var (
 	ErrSessionIdle = errors.New("session idle timeout exceeded")
 	// ErrRateLimited indicates the rate limit has been exceeded
 	ErrRateLimited = errors.New("rate limit exceeded")
+	// ErrKeyNotFound indicates a single-use key was missing or already used
+	ErrKeyNotFound = errors.New("key not found")
 )
 
 // Client wraps the Redis client and provides authentication-specific methods
@@ -54,46 +53,6 @@ func (c *Client) Close() error {
 	return c.rdb.Close()
 }
 
-// ============================================================================
-// OTP Operations (SP01PH02T02)
-// ============================================================================
-
-// StoreOTP stores an OTP in Redis with 15-minute TTL
-// The OTP is stored using atomic SET with EX option
-func (c *Client) StoreOTP(ctx context.Context, email string, otp string) error {
-	key := OTPKey(email)
-	return c.rdb.Set(ctx, key, otp, OTPTTL).Err()
-}
-
-// GetOTP retrieves and deletes an OTP from Redis (single-use)
-func (c *Client) GetOTP(ctx context.Context, email string) (string, error) {
-	key := OTPKey(email)
-
-	// Use GETDEL to atomically get and delete the OTP
-	result, err := c.rdb.GetDel(ctx, key).Result()
-	if err != nil {
-		if err == redis.Nil {
-			return "", ErrOTPNotFound
-		}
-		return "", err
-	}
-	return result, nil
-}
-
-// VerifyOTP verifies an OTP and consumes it (single-use)
-func (c *Client) VerifyOTP(ctx context.Context, email string, inputOTP string) error {
-	storedOTP, err := c.GetOTP(ctx, email)
-	if err != nil {
-		return err
-	}
-
-	if storedOTP != inputOTP {
-		return errors.New("invalid otp")
-	}
-
-	return nil
-}
-
 // ============================================================================
 // Session Operations (SP01PH02T03 - Dual Key)
 // ============================================================================
@@ -103,26 +62,42 @@ type SessionData struct {
 	UserID    string    `json:"user_id"`
 	Email     string    `json:"email"`
 	CreatedAt time.Time `json:"created_at"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+}
+
+// SessionInfo describes one of a user's logins, for listing and revoking them
+type SessionInfo struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
 }
 
 // StoreSession stores a session using dual-key approach:
 // - session:{id} - holds session data, hard cap 24h TTL
-// - session_idle:{id} - idle marker, sliding 30min TTL
+// - session_idle:{id} - idle marker holding the last activity time, sliding 30min TTL
+// The device details go in session_info:{id}, and the session is added to the user's index.
 func (c *Client) StoreSession(ctx context.Context, sessionID string, data SessionData) error {
 	sessionKey := SessionKey(sessionID)
 	idleKey := SessionIdleKey(sessionID)
+	infoKey := SessionInfoKey(sessionID)
+	indexKey := UserSessionsKey(data.UserID)
+	now := data.CreatedAt.Unix()
 
+	pipe := c.rdb.TxPipeline()
 	// Store session data with hard cap TTL (24 hours)
-	if err := c.rdb.Set(ctx, sessionKey, data.UserID, SessionTTL).Err(); err != nil {
-		return err
-	}
-
+	pipe.Set(ctx, sessionKey, data.UserID, SessionTTL)
 	// Store idle marker with sliding TTL (30 minutes)
-	if err := c.rdb.Set(ctx, idleKey, "active", SessionIdleTTL).Err(); err != nil {
-		return err
-	}
-
-	return nil
+	pipe.Set(ctx, idleKey, now, SessionIdleTTL)
+	pipe.HSet(ctx, infoKey, "user_agent", data.UserAgent, "ip", data.IP, "created_at", now)
+	pipe.Expire(ctx, infoKey, SessionTTL)
+	// Every indexed session ends within the hard cap, so the index can expire with the newest one
+	pipe.HSet(ctx, indexKey, SessionPublicID(sessionID), sessionID)
+	pipe.Expire(ctx, indexKey, SessionTTL)
+	_, err := pipe.Exec(ctx)
+	return err
 }
 
 // GetSession retrieves session data if both session key and idle key exist
@@ -165,22 +140,148 @@ func (c *Client) GetSession(ctx context.Context, sessionID string) (string, erro
 }
 
 // RefreshSession updates the idle TTL on activity (sliding window)
+// The marker is only rewritten while it exists, so an idle session stays expired.
 func (c *Client) RefreshSession(ctx context.Context, sessionID string) error {
 	idleKey := SessionIdleKey(sessionID)
 
-	// Update idle marker TTL (sliding window)
-	return c.rdb.Expire(ctx, idleKey, SessionIdleTTL).Err()
+	// Update idle marker and its TTL (sliding window)
+	return c.rdb.SetXX(ctx, idleKey, time.Now().Unix(), SessionIdleTTL).Err()
 }
 
-// DeleteSession removes both session keys (logout)
+// DeleteSession removes both session keys (logout) and drops the session from its user's index
 func (c *Client) DeleteSession(ctx context.Context, sessionID string) error {
 	sessionKey := SessionKey(sessionID)
 	idleKey := SessionIdleKey(sessionID)
 
-	_, err := c.rdb.Del(ctx, sessionKey, idleKey).Result()
+	userID, err := c.rdb.Get(ctx, sessionKey).Result()
+	if err == redis.Nil {
+		// Past the hard cap; the index entry is removed the next time the user's sessions are listed
+		_, err = c.rdb.Del(ctx, sessionKey, idleKey, SessionInfoKey(sessionID)).Result()
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	return c.deleteUserSession(ctx, userID, sessionID)
+}
+
+// deleteUserSession removes a session's keys and its entry in the user's index
+func (c *Client) deleteUserSession(ctx context.Context, userID, sessionID string) error {
+	pipe := c.rdb.TxPipeline()
+	pipe.Del(ctx, SessionKey(sessionID), SessionIdleKey(sessionID), SessionInfoKey(sessionID))
+	pipe.HDel(ctx, UserSessionsKey(userID), SessionPublicID(sessionID))
+	_, err := pipe.Exec(ctx)
 	return err
 }
 
+// ListUserSessions returns a user's active sessions
+// Sessions that have expired or gone idle are removed from the index as they are found.
+func (c *Client) ListUserSessions(ctx context.Context, userID string) ([]SessionInfo, error) {
+	indexKey := UserSessionsKey(userID)
+	index, err := c.rdb.HGetAll(ctx, indexKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	type pending struct {
+		publicID  string
+		sessionID string
+		exists    *redis.IntCmd
+		lastSeen  *redis.StringCmd
+		info      *redis.MapStringStringCmd
+	}
+	entries := make([]pending, 0, len(index))
+	pipe := c.rdb.Pipeline()
+	for publicID, sessionID := range index {
+		entries = append(entries, pending{
+			publicID:  publicID,
+			sessionID: sessionID,
+			exists:    pipe.Exists(ctx, SessionKey(sessionID)),
+			lastSeen:  pipe.Get(ctx, SessionIdleKey(sessionID)),
+			info:      pipe.HGetAll(ctx, SessionInfoKey(sessionID)),
+		})
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	sessions := []SessionInfo{}
+	var stale []string
+	for _, e := range entries {
+		lastSeen, err := e.lastSeen.Int64()
+		if e.exists.Val() == 0 || err == redis.Nil {
+			stale = append(stale, e.sessionID)
+			continue
+		}
+		info := e.info.Val()
+		createdAt, _ := strconv.ParseInt(info["created_at"], 10, 64)
+		if lastSeen == 0 {
+			lastSeen = createdAt
+		}
+		sessions = append(sessions, SessionInfo{
+			ID:         e.publicID,
+			UserAgent:  info["user_agent"],
+			IP:         info["ip"],
+			CreatedAt:  time.Unix(createdAt, 0).UTC(),
+			LastSeenAt: time.Unix(lastSeen, 0).UTC(),
+		})
+	}
+	for _, sessionID := range stale {
+		if err := c.deleteUserSession(ctx, userID, sessionID); err != nil {
+			return nil, err
+		}
+	}
+	return sessions, nil
+}
+
+// RevokeUserSession ends one of a user's sessions by its public ID
+// Returns false if the user has no such session.
+func (c *Client) RevokeUserSession(ctx context.Context, userID, publicID string) (bool, error) {
+	sessionID, err := c.rdb.HGet(ctx, UserSessionsKey(userID), publicID).Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := c.deleteUserSession(ctx, userID, sessionID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RevokeUserSessions ends all of a user's sessions except keepSessionID, which may be empty
+// Returns the public IDs of the revoked sessions.
+func (c *Client) RevokeUserSessions(ctx context.Context, userID, keepSessionID string) ([]string, error) {
+	index, err := c.rdb.HGetAll(ctx, UserSessionsKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	revoked := []string{}
+	for publicID, sessionID := range index {
+		if sessionID == keepSessionID {
+			continue
+		}
+		if err := c.deleteUserSession(ctx, userID, sessionID); err != nil {
+			return revoked, err
+		}
+		revoked = append(revoked, publicID)
+	}
+	return revoked, nil
+}
+
+// DeleteUserData removes everything kept in Redis for a deleted account: its sessions,
+// MUD session variables and any pending passkey registration
+// Returns the public IDs of the sessions that were ended.
+func (c *Client) DeleteUserData(ctx context.Context, userID string) ([]string, error) {
+	revoked, err := c.RevokeUserSessions(ctx, userID, "")
+	if err != nil {
+		return revoked, err
+	}
+	err = c.rdb.Del(ctx, UserSessionsKey(userID), SessionVarsKey(userID), WebAuthnChallengeKey("register", userID)).Err()
+	return revoked, err
+}
+
 // ============================================================================
 // Generic Key-Value Operations (SP02)
 // ============================================================================
@@ -195,6 +296,16 @@ func (c *Client) Get(ctx context.Context, key string) (string, error) {
 	return c.rdb.Get(ctx, key).Result()
 }
 
+// GetDel atomically retrieves and removes a value, for single-use keys
+// Returns ErrKeyNotFound if the key does not exist
+func (c *Client) GetDel(ctx context.Context, key string) (string, error) {
+	value, err := c.rdb.GetDel(ctx, key).Result()
+	if err == redis.Nil {
+		return "", ErrKeyNotFound
+	}
+	return value, err
+}
+
 // Delete removes a key
 func (c *Client) Delete(ctx context.Context, key string) error {
 	return c.rdb.Del(ctx, key).Err()
@@ -206,6 +317,40 @@ func (c *Client) Exists(ctx context.Context, key string) (bool, error) {
 	return n > 0, err
 }
 
+// ============================================================================
+// Session Variable Operations
+// ============================================================================
+
+// SaveSessionVars writes changed runtime variables and removes deleted ones in one round trip
+// Values are JSON strings; the hash expires with the session hard cap
+func (c *Client) SaveSessionVars(ctx context.Context, userID string, set map[string]string, deleted []string) error {
+	key := SessionVarsKey(userID)
+	pipe := c.rdb.TxPipeline()
+	if len(set) > 0 {
+		values := make([]interface{}, 0, len(set)*2)
+		for name, value := range set {
+			values = append(values, name, value)
+		}
+		pipe.HSet(ctx, key, values...)
+	}
+	if len(deleted) > 0 {
+		pipe.HDel(ctx, key, deleted...)
+	}
+	pipe.Expire(ctx, key, SessionTTL)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// GetSessionVars returns all runtime variables for a user's MUD session
+func (c *Client) GetSessionVars(ctx context.Context, userID string) (map[string]string, error) {
+	return c.rdb.HGetAll(ctx, SessionVarsKey(userID)).Result()
+}
+
+// DeleteSessionVars removes all runtime variables for a user's MUD session
+func (c *Client) DeleteSessionVars(ctx context.Context, userID string) error {
+	return c.rdb.Del(ctx, SessionVarsKey(userID)).Err()
+}
+
 // ============================================================================
 // Rate Limiting Operations (SP01PH05)
 // ============================================================================
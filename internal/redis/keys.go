@@ -3,24 +3,21 @@ package redis
 import (
 	"crypto/sha256"
 	"encoding/hex"
-	"strings"
 )
 
 // Key prefixes
 const (
-	OTPKeyPrefix      = "otp:email:"
-	SessionKeyPrefix  = "session:"
-	SessionIdlePrefix = "session_idle:"
-	RateLimitPrefix   = "ratelimit:"
+	SessionKeyPrefix   = "session:"
+	SessionIdlePrefix  = "session_idle:"
+	RateLimitPrefix    = "ratelimit:"
+	SessionVarsPrefix  = "session_vars:"
+	WebAuthnPrefix     = "webauthn:"
+	MFAPrefix          = "mfa:"
+	SessionInfoPrefix  = "session_info:"
+	UserSessionsPrefix = "user_sessions:"
+	OIDCPrefix         = "oidc:"
 )
 
-// OTPKey generates the Redis key for storing OTP
-// Format: otp:email:{sha256(lower(email))}
-func OTPKey(email string) string {
-	hash := sha256.Sum256([]byte(strings.ToLower(email)))
-	return OTPKeyPrefix + hex.EncodeToString(hash[:])
-}
-
 // SessionKey generates the Redis key for session data
 // Format: session:{sessionID}
 func SessionKey(sessionID string) string {
@@ -33,9 +30,52 @@ func SessionIdleKey(sessionID string) string {
 	return SessionIdlePrefix + sessionID
 }
 
+// SessionInfoKey generates the Redis key for a session's device details
+// Format: session_info:{sessionID}
+func SessionInfoKey(sessionID string) string {
+	return SessionInfoPrefix + sessionID
+}
+
+// UserSessionsKey generates the Redis key for the index of a user's sessions
+// Format: user_sessions:{userID}, a hash of public session ID to session ID
+func UserSessionsKey(userID string) string {
+	return UserSessionsPrefix + userID
+}
+
+// SessionPublicID returns the ID a session is shown and revoked by
+// The session ID itself is the login token, so it is never sent back to clients.
+func SessionPublicID(sessionID string) string {
+	hash := sha256.Sum256([]byte(sessionID))
+	return hex.EncodeToString(hash[:16])
+}
+
 // RateLimitKey generates the Redis key for rate limiting
 // Format: ratelimit:{type}:{identifier}
 // Examples: ratelimit:otp:user@example.com, ratelimit:login:192.168.1.1
 func RateLimitKey(rateLimitType string, identifier string) string {
 	return RateLimitPrefix + rateLimitType + ":" + identifier
 }
+
+// WebAuthnChallengeKey generates the Redis key for a pending passkey ceremony
+// Format: webauthn:{ceremony}:{id}, where id is the user ID for registrations and the challenge for logins
+func WebAuthnChallengeKey(ceremony, id string) string {
+	return WebAuthnPrefix + ceremony + ":" + id
+}
+
+// MFAKey generates the Redis key for a login waiting for its second factor
+// Format: mfa:{token}
+func MFAKey(token string) string {
+	return MFAPrefix + token
+}
+
+// OIDCStateKey generates the Redis key for a single sign-on login waiting for the provider's callback
+// Format: oidc:{state}
+func OIDCStateKey(state string) string {
+	return OIDCPrefix + state
+}
+
+// SessionVarsKey generates the Redis key for a MUD session's runtime variables
+// Format: session_vars:{userID}
+func SessionVarsKey(userID string) string {
+	return SessionVarsPrefix + userID
+}
@@ -4,10 +4,6 @@ import "time"
 
 // TTL constants for Redis keys
 const (
-	// OTP TTL: 15 minutes (900 seconds)
-	// Used for one-time password storage
-	OTPTTL = 900 * time.Second
-
 	// SessionTTL: 24 hours (86400 seconds)
 	// Hard cap - absolute maximum session duration
 	SessionTTL = 86400 * time.Second
@@ -23,13 +19,27 @@ const (
 	// Login rate limit: 10 minutes (600 seconds)
 	// Max 10 login attempts per IP per 10 minutes
 	LoginRateLimitTTL = 600 * time.Second
+
+	// WebAuthn challenge TTL: 5 minutes (300 seconds)
+	// A passkey registration or login must finish within this time
+	WebAuthnChallengeTTL = 300 * time.Second
+
+	// MFA ticket TTL: 5 minutes (300 seconds)
+	// Time allowed to enter a TOTP code after the first login factor
+	MFATicketTTL = 300 * time.Second
+
+	// OIDC state TTL: 10 minutes (600 seconds)
+	// Time allowed to log in at the identity provider during single sign-on
+	OIDCStateTTL = 600 * time.Second
 )
 
 // TTLSeconds returns the TTL in seconds for each key type
 var TTLSeconds = map[string]int64{
-	"otp":              int64(OTPTTL.Seconds()),
 	"session":          int64(SessionTTL.Seconds()),
 	"session_idle":     int64(SessionIdleTTL.Seconds()),
 	"otp_rate_limit":   int64(OTPRateLimitTTL.Seconds()),
 	"login_rate_limit": int64(LoginRateLimitTTL.Seconds()),
+	"webauthn":         int64(WebAuthnChallengeTTL.Seconds()),
+	"mfa":              int64(MFATicketTTL.Seconds()),
+	"oidc":             int64(OIDCStateTTL.Seconds()),
 }
@@ -0,0 +1,556 @@
+package script
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+type builtin func(in *interp, args []Value) (Value, error)
+
+var builtins map[string]builtin
+
+func init() {
+	builtins = map[string]builtin{
+		"send":       builtinSend,
+		"echo":       builtinEcho,
+		"len":        builtinLen,
+		"upper":      stringFunc(strings.ToUpper),
+		"lower":      stringFunc(strings.ToLower),
+		"trim":       stringFunc(strings.TrimSpace),
+		"contains":   builtinContains,
+		"startswith": stringPredicate(strings.HasPrefix),
+		"endswith":   stringPredicate(strings.HasSuffix),
+		"replace":    builtinReplace,
+		"substr":     builtinSubstr,
+		"find":       builtinFind,
+		"tonumber":   builtinToNumber,
+		"tostring":   builtinToString,
+		"floor":      mathFunc(math.Floor),
+		"ceil":       mathFunc(math.Ceil),
+		"round":      mathFunc(math.Round),
+		"abs":        builtinAbs,
+		"min":        builtinMinMax(-1),
+		"max":        builtinMinMax(1),
+		"random":     builtinRandom,
+		"type":       builtinType,
+		"push":       builtinPush,
+		"pop":        builtinPop,
+		"remove":     builtinRemove,
+		"keys":       builtinKeys,
+		"haskey":     builtinHasKey,
+		"join":       builtinJoin,
+		"split":      builtinSplit,
+	}
+}
+
+// str2 converts two arguments to strings
+func (in *interp) str2(a, b Value) (string, string, error) {
+	as, err := in.str(a)
+	if err != nil {
+		return "", "", err
+	}
+	bs, err := in.str(b)
+	return as, bs, err
+}
+
+func argCount(args []Value, min, max int) error {
+	if len(args) < min || len(args) > max {
+		if min == max {
+			return &RuntimeError{Msg: fmt.Sprintf("expected %d arguments, got %d", min, len(args))}
+		}
+		return &RuntimeError{Msg: fmt.Sprintf("expected %d to %d arguments, got %d", min, max, len(args))}
+	}
+	return nil
+}
+
+func numberArg(args []Value, i int) (Value, error) {
+	n, ok := args[i].Number()
+	if !ok {
+		return Nil, &RuntimeError{Msg: fmt.Sprintf("argument %d must be a number, got %s", i+1, args[i].Kind())}
+	}
+	return n, nil
+}
+
+func intArg(args []Value, i int) (int64, error) {
+	n, err := numberArg(args, i)
+	if err != nil {
+		return 0, err
+	}
+	if n.Kind() == KindInt {
+		return n.i, nil
+	}
+	return int64(n.f), nil
+}
+
+// send(text) queues a command for the MUD
+func builtinSend(in *interp, args []Value) (Value, error) {
+	if err := argCount(args, 1, 1); err != nil {
+		return Nil, err
+	}
+	if len(in.out.Commands) >= in.limits.MaxCommands {
+		return Nil, ErrCommandLimit
+	}
+	text, err := in.str(args[0])
+	if err != nil {
+		return Nil, err
+	}
+	in.out.Commands = append(in.out.Commands, text)
+	return Nil, nil
+}
+
+// echo(text) shows a line to the client without sending it to the MUD
+func builtinEcho(in *interp, args []Value) (Value, error) {
+	if err := argCount(args, 1, 1); err != nil {
+		return Nil, err
+	}
+	if len(in.out.Messages) >= in.limits.MaxCommands {
+		return Nil, ErrCommandLimit
+	}
+	text, err := in.str(args[0])
+	if err != nil {
+		return Nil, err
+	}
+	in.out.Messages = append(in.out.Messages, text)
+	return Nil, nil
+}
+
+// len(v) returns the number of characters in a string or entries in a list or table
+func builtinLen(in *interp, args []Value) (Value, error) {
+	if err := argCount(args, 1, 1); err != nil {
+		return Nil, err
+	}
+	switch args[0].Kind() {
+	case KindList:
+		return Int(int64(len(args[0].l.items))), nil
+	case KindTable:
+		return Int(int64(len(args[0].t.fields))), nil
+	default:
+		s, err := in.str(args[0])
+		if err != nil {
+			return Nil, err
+		}
+		return Int(int64(len([]rune(s)))), nil
+	}
+}
+
+// contains(s, sub) tests for a substring; contains(list, v) tests for membership
+func builtinContains(in *interp, args []Value) (Value, error) {
+	if err := argCount(args, 2, 2); err != nil {
+		return Nil, err
+	}
+	if args[0].Kind() == KindList {
+		for _, item := range args[0].l.items {
+			if equal(item, args[1]) {
+				return Bool(true), nil
+			}
+		}
+		return Bool(false), nil
+	}
+	s, sub, err := in.str2(args[0], args[1])
+	if err != nil {
+		return Nil, err
+	}
+	return Bool(strings.Contains(s, sub)), nil
+}
+
+func stringFunc(fn func(string) string) builtin {
+	return func(in *interp, args []Value) (Value, error) {
+		if err := argCount(args, 1, 1); err != nil {
+			return Nil, err
+		}
+		s, err := in.str(args[0])
+		if err != nil {
+			return Nil, err
+		}
+		return String(fn(s)), nil
+	}
+}
+
+func stringPredicate(fn func(string, string) bool) builtin {
+	return func(in *interp, args []Value) (Value, error) {
+		if err := argCount(args, 2, 2); err != nil {
+			return Nil, err
+		}
+		s, arg, err := in.str2(args[0], args[1])
+		if err != nil {
+			return Nil, err
+		}
+		return Bool(fn(s, arg)), nil
+	}
+}
+
+// replace(s, old, new) replaces every occurrence of old in s
+func builtinReplace(in *interp, args []Value) (Value, error) {
+	if err := argCount(args, 3, 3); err != nil {
+		return Nil, err
+	}
+	s, old, err := in.str2(args[0], args[1])
+	if err != nil {
+		return Nil, err
+	}
+	repl, err := in.str(args[2])
+	if err != nil {
+		return Nil, err
+	}
+	if old == "" {
+		return String(s), nil
+	}
+	// Check the result size before building it
+	n := strings.Count(s, old)
+	if len(s)+n*(len(repl)-len(old)) > in.limits.MaxMemory-in.memory {
+		return Nil, ErrMemoryLimit
+	}
+	return String(strings.ReplaceAll(s, old, repl)), nil
+}
+
+// substr(s, start[, length]) returns a substring using 1-based character positions
+func builtinSubstr(in *interp, args []Value) (Value, error) {
+	if err := argCount(args, 2, 3); err != nil {
+		return Nil, err
+	}
+	s, err := in.str(args[0])
+	if err != nil {
+		return Nil, err
+	}
+	runes := []rune(s)
+	start, err := intArg(args, 1)
+	if err != nil {
+		return Nil, err
+	}
+	if start < 1 {
+		start = 1
+	}
+	end := int64(len(runes))
+	if len(args) == 3 {
+		length, err := intArg(args, 2)
+		if err != nil {
+			return Nil, err
+		}
+		if length < 0 {
+			length = 0
+		}
+		if start-1+length < end {
+			end = start - 1 + length
+		}
+	}
+	if start-1 >= end {
+		return String(""), nil
+	}
+	return String(string(runes[start-1 : end])), nil
+}
+
+// find(s, sub) returns the 1-based character position of sub in s, or 0
+func builtinFind(in *interp, args []Value) (Value, error) {
+	if err := argCount(args, 2, 2); err != nil {
+		return Nil, err
+	}
+	s, sub, err := in.str2(args[0], args[1])
+	if err != nil {
+		return Nil, err
+	}
+	idx := strings.Index(s, sub)
+	if idx < 0 {
+		return Int(0), nil
+	}
+	return Int(int64(len([]rune(s[:idx]))) + 1), nil
+}
+
+// tonumber(v) converts v to a number, or nil if it is not numeric
+func builtinToNumber(in *interp, args []Value) (Value, error) {
+	if err := argCount(args, 1, 1); err != nil {
+		return Nil, err
+	}
+	n, ok := args[0].Number()
+	if !ok {
+		return Nil, nil
+	}
+	return n, nil
+}
+
+func builtinToString(in *interp, args []Value) (Value, error) {
+	if err := argCount(args, 1, 1); err != nil {
+		return Nil, err
+	}
+	s, err := in.str(args[0])
+	if err != nil {
+		return Nil, err
+	}
+	return String(s), nil
+}
+
+// mathFunc wraps a rounding function; integers pass through unchanged
+func mathFunc(fn func(float64) float64) builtin {
+	return func(in *interp, args []Value) (Value, error) {
+		if err := argCount(args, 1, 1); err != nil {
+			return Nil, err
+		}
+		n, err := numberArg(args, 0)
+		if err != nil {
+			return Nil, err
+		}
+		if n.Kind() == KindInt {
+			return n, nil
+		}
+		r := fn(n.f)
+		if math.Abs(r) < math.MaxInt64 {
+			return Int(int64(r)), nil
+		}
+		return Float(r), nil
+	}
+}
+
+func builtinAbs(in *interp, args []Value) (Value, error) {
+	if err := argCount(args, 1, 1); err != nil {
+		return Nil, err
+	}
+	n, err := numberArg(args, 0)
+	if err != nil {
+		return Nil, err
+	}
+	if n.Kind() == KindInt {
+		if n.i < 0 {
+			return Int(-n.i), nil
+		}
+		return n, nil
+	}
+	return Float(math.Abs(n.f)), nil
+}
+
+// builtinMinMax returns min (sign -1) or max (sign 1) of its numeric arguments
+func builtinMinMax(sign int) builtin {
+	return func(in *interp, args []Value) (Value, error) {
+		if len(args) == 0 {
+			return Nil, &RuntimeError{Msg: "expected at least 1 argument"}
+		}
+		best, err := numberArg(args, 0)
+		if err != nil {
+			return Nil, err
+		}
+		for i := 1; i < len(args); i++ {
+			n, err := numberArg(args, i)
+			if err != nil {
+				return Nil, err
+			}
+			if (sign < 0 && n.Float64() < best.Float64()) || (sign > 0 && n.Float64() > best.Float64()) {
+				best = n
+			}
+		}
+		return best, nil
+	}
+}
+
+// random() returns a float in [0,1); random(n) an int in [1,n]; random(a, b) an int in [a,b]
+func builtinRandom(in *interp, args []Value) (Value, error) {
+	if err := argCount(args, 0, 2); err != nil {
+		return Nil, err
+	}
+	if len(args) == 0 {
+		return Float(rand.Float64()), nil
+	}
+	lo, hi := int64(1), int64(0)
+	var err error
+	if len(args) == 1 {
+		if hi, err = intArg(args, 0); err != nil {
+			return Nil, err
+		}
+	} else {
+		if lo, err = intArg(args, 0); err != nil {
+			return Nil, err
+		}
+		if hi, err = intArg(args, 1); err != nil {
+			return Nil, err
+		}
+	}
+	if hi < lo {
+		return Nil, &RuntimeError{Msg: "empty range"}
+	}
+	if hi-lo+1 <= 0 {
+		return Nil, &RuntimeError{Msg: "range too large"}
+	}
+	return Int(lo + rand.Int63n(hi-lo+1)), nil
+}
+
+// type(v) returns the name of a value's type
+func builtinType(in *interp, args []Value) (Value, error) {
+	if err := argCount(args, 1, 1); err != nil {
+		return Nil, err
+	}
+	return String(args[0].Kind().String()), nil
+}
+
+func listArg(args []Value, i int) (Value, error) {
+	if args[i].Kind() != KindList {
+		return Nil, &RuntimeError{Msg: fmt.Sprintf("argument %d must be a list, got %s", i+1, args[i].Kind())}
+	}
+	return args[i], nil
+}
+
+func tableArg(args []Value, i int) (Value, error) {
+	if args[i].Kind() != KindTable {
+		return Nil, &RuntimeError{Msg: fmt.Sprintf("argument %d must be a table, got %s", i+1, args[i].Kind())}
+	}
+	return args[i], nil
+}
+
+// push(list, v) appends v to list
+func builtinPush(in *interp, args []Value) (Value, error) {
+	if err := argCount(args, 2, 2); err != nil {
+		return Nil, err
+	}
+	l, err := listArg(args, 0)
+	if err != nil {
+		return Nil, err
+	}
+	in.memory += elementSize
+	l.l.items = append(l.l.items, args[1])
+	in.out.Mutated = true
+	return Nil, nil
+}
+
+// pop(list) removes and returns the last element of list, or nil if it is empty
+func builtinPop(in *interp, args []Value) (Value, error) {
+	if err := argCount(args, 1, 1); err != nil {
+		return Nil, err
+	}
+	l, err := listArg(args, 0)
+	if err != nil {
+		return Nil, err
+	}
+	n := len(l.l.items)
+	if n == 0 {
+		return Nil, nil
+	}
+	last := l.l.items[n-1]
+	l.l.items = l.l.items[:n-1]
+	in.out.Mutated = true
+	return last, nil
+}
+
+// remove(list, index) or remove(table, key) deletes an entry and returns it
+func builtinRemove(in *interp, args []Value) (Value, error) {
+	if err := argCount(args, 2, 2); err != nil {
+		return Nil, err
+	}
+	switch args[0].Kind() {
+	case KindList:
+		idx, ok := listIndex(args[1])
+		items := args[0].l.items
+		if !ok || idx < 1 || idx > int64(len(items)) {
+			return Nil, nil
+		}
+		removed := items[idx-1]
+		args[0].l.items = append(items[:idx-1], items[idx:]...)
+		in.out.Mutated = true
+		return removed, nil
+	case KindTable:
+		key, err := in.str(args[1])
+		if err != nil {
+			return Nil, err
+		}
+		removed := args[0].t.fields[key]
+		delete(args[0].t.fields, key)
+		in.out.Mutated = true
+		return removed, nil
+	default:
+		return Nil, &RuntimeError{Msg: fmt.Sprintf("argument 1 must be a list or table, got %s", args[0].Kind())}
+	}
+}
+
+// keys(table) returns the table's keys as a sorted list
+func builtinKeys(in *interp, args []Value) (Value, error) {
+	if err := argCount(args, 1, 1); err != nil {
+		return Nil, err
+	}
+	t, err := tableArg(args, 0)
+	if err != nil {
+		return Nil, err
+	}
+	names := make([]string, 0, len(t.t.fields))
+	for k := range t.t.fields {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	items := make([]Value, len(names))
+	for i, k := range names {
+		items[i] = String(k)
+	}
+	return List(items), nil
+}
+
+// haskey(table, key) reports whether key is present
+func builtinHasKey(in *interp, args []Value) (Value, error) {
+	if err := argCount(args, 2, 2); err != nil {
+		return Nil, err
+	}
+	t, err := tableArg(args, 0)
+	if err != nil {
+		return Nil, err
+	}
+	key, err := in.str(args[1])
+	if err != nil {
+		return Nil, err
+	}
+	_, ok := t.t.fields[key]
+	return Bool(ok), nil
+}
+
+// join(list[, sep]) joins list elements into a string (default separator "|")
+func builtinJoin(in *interp, args []Value) (Value, error) {
+	if err := argCount(args, 1, 2); err != nil {
+		return Nil, err
+	}
+	l, err := listArg(args, 0)
+	if err != nil {
+		return Nil, err
+	}
+	sep := "|"
+	if len(args) == 2 {
+		if sep, err = in.str(args[1]); err != nil {
+			return Nil, err
+		}
+	}
+	parts := make([]string, len(l.l.items))
+	for i, item := range l.l.items {
+		if err := in.step(); err != nil {
+			return Nil, err
+		}
+		if parts[i], err = in.str(item); err != nil {
+			return Nil, err
+		}
+	}
+	return String(strings.Join(parts, sep)), nil
+}
+
+// split(s[, sep]) splits a string into a list (default separator "|")
+func builtinSplit(in *interp, args []Value) (Value, error) {
+	if err := argCount(args, 1, 2); err != nil {
+		return Nil, err
+	}
+	s, err := in.str(args[0])
+	if err != nil {
+		return Nil, err
+	}
+	sep := "|"
+	if len(args) == 2 {
+		if sep, err = in.str(args[1]); err != nil {
+			return Nil, err
+		}
+	}
+	if s == "" {
+		return List(nil), nil
+	}
+	var parts []string
+	if sep == "" {
+		parts = strings.Fields(s)
+	} else {
+		parts = strings.Split(s, sep)
+	}
+	items := make([]Value, len(parts))
+	for i, p := range parts {
+		items[i] = String(p)
+	}
+	return List(items), nil
+}
@@ -0,0 +1,173 @@
+package script
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Limits on formatting or encoding a value outside a script
+// Lists and tables are shared by reference, so a value can hold the same list many times over
+// and expand far beyond the memory charged when it was built. Every node visited and byte
+// written is counted so that expansion stays bounded.
+const (
+	maxEncodeNodes = 100000
+	MaxEncodedLen  = 1 << 20 // Longest text or JSON produced from one value
+)
+
+// ErrValueTooLarge is returned when a value expands beyond MaxEncodedLen bytes or too many nodes
+var ErrValueTooLarge = errors.New("value too large")
+
+// encoder writes values as text or JSON within a budget
+type encoder struct {
+	buf      bytes.Buffer
+	visit    func() error // Charged for every node visited
+	maxBytes int
+	overflow error // Returned once more than maxBytes have been written
+	stopped  error // Set when the budget ran out
+}
+
+// newEncoder returns an encoder with the limits for values used outside a script
+func newEncoder() *encoder {
+	nodes := 0
+	return &encoder{
+		visit: func() error {
+			nodes++
+			if nodes > maxEncodeNodes {
+				return ErrValueTooLarge
+			}
+			return nil
+		},
+		maxBytes: MaxEncodedLen,
+		overflow: ErrValueTooLarge,
+	}
+}
+
+// node charges one node and checks the output written so far
+func (e *encoder) node() error {
+	if err := e.visit(); err != nil {
+		e.stopped = err
+		return err
+	}
+	if e.buf.Len() > e.maxBytes {
+		e.stopped = e.overflow
+		return e.overflow
+	}
+	return nil
+}
+
+// finish checks the size of the complete output
+func (e *encoder) finish() error {
+	if e.buf.Len() > e.maxBytes {
+		return e.overflow
+	}
+	return nil
+}
+
+// text writes a value as String formats it
+func (e *encoder) text(v Value, depth int) error {
+	if v.kind == KindTable {
+		// Tables are formatted as JSON; ones that cannot be encoded are abbreviated
+		mark := e.buf.Len()
+		if err := e.json(v, 0); err != nil {
+			if e.stopped != nil {
+				return err
+			}
+			e.buf.Truncate(mark)
+			e.buf.WriteString("{...}")
+		}
+		return nil
+	}
+
+	if err := e.node(); err != nil {
+		return err
+	}
+	if v.kind != KindList {
+		e.buf.WriteString(v.scalarString())
+		return nil
+	}
+	if depth >= maxValueDepth {
+		e.buf.WriteString("...")
+		return nil
+	}
+	for i, item := range v.l.items {
+		if i > 0 {
+			e.buf.WriteByte('|')
+		}
+		if err := e.text(item, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// json writes a value as JSON
+// Whole floats keep a decimal point so they decode back as floats
+func (e *encoder) json(v Value, depth int) error {
+	if depth > maxValueDepth {
+		return ErrValueTooDeep
+	}
+	if err := e.node(); err != nil {
+		return err
+	}
+
+	buf := &e.buf
+	switch v.kind {
+	case KindNil:
+		buf.WriteString("null")
+	case KindBool:
+		buf.WriteString(strconv.FormatBool(v.b))
+	case KindInt:
+		buf.WriteString(strconv.FormatInt(v.i, 10))
+	case KindFloat:
+		if math.IsInf(v.f, 0) || math.IsNaN(v.f) {
+			return errors.New("cannot encode non-finite number")
+		}
+		s := strconv.FormatFloat(v.f, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".eE") {
+			s += ".0"
+		}
+		buf.WriteString(s)
+	case KindString:
+		data, err := json.Marshal(v.s)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+	case KindList:
+		buf.WriteByte('[')
+		for i, item := range v.l.items {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := e.json(item, depth+1); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case KindTable:
+		keys := make([]string, 0, len(v.t.fields))
+		for k := range v.t.fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buf.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			key, _ := json.Marshal(k)
+			buf.Write(key)
+			buf.WriteByte(':')
+			if err := e.json(v.t.fields[k], depth+1); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	}
+	return nil
+}
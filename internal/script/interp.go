@@ -0,0 +1,719 @@
+package script
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Env provides access to variables that outlive a single script invocation
+type Env interface {
+	Get(name string) (Value, bool)
+	Set(name string, value Value) error
+}
+
+// Limits bounds the resources a single script invocation may use
+type Limits struct {
+	MaxSourceLen int           // Maximum script length in bytes
+	MaxSteps     int           // Maximum statements and expressions evaluated
+	MaxDuration  time.Duration // Maximum wall-clock running time
+	MaxMemory    int           // Maximum bytes of string data produced
+	MaxCommands  int           // Maximum commands queued with send()
+	MaxDepth     int           // Maximum nesting depth of blocks and expressions
+}
+
+// DefaultLimits returns the limits applied to trigger, alias and timer scripts
+func DefaultLimits() Limits {
+	return Limits{
+		MaxSourceLen: 4096,
+		MaxSteps:     10000,
+		MaxDuration:  50 * time.Millisecond,
+		MaxMemory:    1 << 20,
+		MaxCommands:  20,
+		MaxDepth:     32,
+	}
+}
+
+// Limit errors
+var (
+	ErrSourceTooLong = errors.New("script too long")
+	ErrStepLimit     = errors.New("script exceeded step limit")
+	ErrTimeLimit     = errors.New("script exceeded time limit")
+	ErrMemoryLimit   = errors.New("script exceeded memory limit")
+	ErrCommandLimit  = errors.New("script exceeded command limit")
+)
+
+// RuntimeError describes a failure while executing a script
+type RuntimeError struct {
+	Pos int
+	Msg string
+}
+
+func (e *RuntimeError) Error() string {
+	return fmt.Sprintf("runtime error at %d: %s", e.Pos, e.Msg)
+}
+
+// Output holds the side effects of a script invocation
+type Output struct {
+	Commands []string // Lines to send to the MUD, in order
+	Messages []string // Lines to echo to the client only
+	Mutated  bool     // A list or table was changed in place, possibly through another variable
+}
+
+// How often (in steps) the wall clock is checked
+const clockInterval = 256
+
+// Control flow signals
+var (
+	errBreak  = errors.New("break")
+	errReturn = errors.New("return")
+)
+
+type interp struct {
+	env      Env
+	locals   map[string]Value
+	limits   Limits
+	steps    int
+	memory   int
+	deadline time.Time
+	out      *Output
+}
+
+// Run parses and executes a script
+// locals are pre-seeded script-scoped variables (e.g. trigger captures); assignments
+// without "local" are written to env
+func Run(src string, env Env, locals map[string]Value, limits Limits) (*Output, error) {
+	if len(src) > limits.MaxSourceLen {
+		return nil, ErrSourceTooLong
+	}
+	body, err := parse(src, limits.MaxDepth)
+	if err != nil {
+		return nil, err
+	}
+
+	in := &interp{
+		env:      env,
+		locals:   make(map[string]Value, len(locals)),
+		limits:   limits,
+		deadline: time.Now().Add(limits.MaxDuration),
+		out:      &Output{},
+	}
+	for name, v := range locals {
+		in.locals[name] = v
+	}
+
+	if err := in.execBlock(body); err != nil && err != errReturn {
+		if err == errBreak {
+			return in.out, &RuntimeError{Msg: "break outside loop"}
+		}
+		return in.out, err
+	}
+	return in.out, nil
+}
+
+// Check parses a script without running it
+func Check(src string, limits Limits) error {
+	if len(src) > limits.MaxSourceLen {
+		return ErrSourceTooLong
+	}
+	_, err := parse(src, limits.MaxDepth)
+	return err
+}
+
+// step charges one unit of work and enforces the step and time budgets
+func (in *interp) step() error {
+	in.steps++
+	if in.steps > in.limits.MaxSteps {
+		return ErrStepLimit
+	}
+	if in.steps%clockInterval == 0 && time.Now().After(in.deadline) {
+		return ErrTimeLimit
+	}
+	return nil
+}
+
+// str converts a value to a string
+// Shared references let a small list or table expand to a very large string, so converting one
+// charges a step for every node visited and memory for the text produced.
+func (in *interp) str(v Value) (string, error) {
+	if v.kind != KindList && v.kind != KindTable {
+		return v.scalarString(), nil
+	}
+	e := &encoder{visit: in.step, maxBytes: in.limits.MaxMemory - in.memory, overflow: ErrMemoryLimit}
+	if err := e.text(v, 0); err != nil {
+		return "", err
+	}
+	if err := e.finish(); err != nil {
+		return "", err
+	}
+	in.memory += e.buf.Len()
+	return e.buf.String(), nil
+}
+
+// alloc charges memory for a newly produced value
+func (in *interp) alloc(v Value) error {
+	in.memory += v.size()
+	if in.memory > in.limits.MaxMemory {
+		return ErrMemoryLimit
+	}
+	return nil
+}
+
+func (in *interp) execBlock(body []stmt) error {
+	for _, s := range body {
+		if err := in.exec(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (in *interp) exec(s stmt) error {
+	if err := in.step(); err != nil {
+		return err
+	}
+
+	switch s := s.(type) {
+	case *assignStmt:
+		if s.target != nil {
+			return in.assignIndex(s)
+		}
+		v, err := in.eval(s.value)
+		if err != nil {
+			return err
+		}
+		if s.local {
+			in.locals[s.name] = v
+			return nil
+		}
+		if _, ok := in.locals[s.name]; ok {
+			in.locals[s.name] = v
+			return nil
+		}
+		if in.env == nil {
+			in.locals[s.name] = v
+			return nil
+		}
+		return in.env.Set(s.name, v)
+
+	case *ifStmt:
+		for i, cond := range s.conds {
+			v, err := in.eval(cond)
+			if err != nil {
+				return err
+			}
+			if v.Truthy() {
+				return in.execBlock(s.blocks[i])
+			}
+		}
+		return in.execBlock(s.elseBody)
+
+	case *whileStmt:
+		for {
+			v, err := in.eval(s.cond)
+			if err != nil {
+				return err
+			}
+			if !v.Truthy() {
+				return nil
+			}
+			if err := in.execBlock(s.body); err != nil {
+				if err == errBreak {
+					return nil
+				}
+				return err
+			}
+		}
+
+	case *forStmt:
+		return in.execFor(s)
+
+	case *breakStmt:
+		return errBreak
+
+	case *returnStmt:
+		return errReturn
+
+	case *exprStmt:
+		_, err := in.eval(s.x)
+		return err
+	}
+	return &RuntimeError{Msg: "unknown statement"}
+}
+
+// assignIndex sets a list or table element, e.g. targets[2] = "orc" or stats.hp = 10
+func (in *interp) assignIndex(s *assignStmt) error {
+	container, err := in.eval(s.target.x)
+	if err != nil {
+		return err
+	}
+	key, err := in.eval(s.target.key)
+	if err != nil {
+		return err
+	}
+	v, err := in.eval(s.value)
+	if err != nil {
+		return err
+	}
+
+	switch container.Kind() {
+	case KindList:
+		idx, ok := listIndex(key)
+		if !ok {
+			return &RuntimeError{Pos: s.target.pos, Msg: fmt.Sprintf("list index must be an integer, got %s", key.Kind())}
+		}
+		items := container.l.items
+		switch {
+		case idx >= 1 && idx <= int64(len(items)):
+			items[idx-1] = v
+		case idx == int64(len(items))+1:
+			container.l.items = append(items, v)
+		default:
+			return &RuntimeError{Pos: s.target.pos, Msg: fmt.Sprintf("list index %d out of range", idx)}
+		}
+		in.memory += elementSize
+		in.out.Mutated = true
+	case KindTable:
+		name, err := in.str(key)
+		if err != nil {
+			return err
+		}
+		if v.Kind() == KindNil {
+			// Assigning nil removes the key, as in Lua
+			delete(container.t.fields, name)
+			in.out.Mutated = true
+			break
+		}
+		in.memory += elementSize + len(name)
+		container.t.fields[name] = v
+		in.out.Mutated = true
+	default:
+		return &RuntimeError{Pos: s.target.pos, Msg: fmt.Sprintf("cannot index %s", container.Kind())}
+	}
+	if in.memory > in.limits.MaxMemory {
+		return ErrMemoryLimit
+	}
+
+	// Tell the environment that a session variable changed in place
+	if root, ok := rootIdent(s.target); ok && in.env != nil {
+		if _, local := in.locals[root]; !local {
+			if rv, found := in.env.Get(root); found {
+				return in.env.Set(root, rv)
+			}
+		}
+	}
+	return nil
+}
+
+// rootIdent returns the variable at the base of an index chain such as a.b[1]
+func rootIdent(x *indexExpr) (string, bool) {
+	var e expr = x
+	for {
+		switch n := e.(type) {
+		case *indexExpr:
+			e = n.x
+		case *identExpr:
+			return n.name, true
+		default:
+			return "", false
+		}
+	}
+}
+
+// listIndex converts a key to a 1-based list index
+func listIndex(key Value) (int64, bool) {
+	n, ok := key.Number()
+	if !ok {
+		return 0, false
+	}
+	if n.Kind() == KindInt {
+		return n.i, true
+	}
+	if n.f != math.Trunc(n.f) {
+		return 0, false
+	}
+	return int64(n.f), true
+}
+
+func (in *interp) execFor(s *forStmt) error {
+	start, err := in.evalNumber(s.start, "for start")
+	if err != nil {
+		return err
+	}
+	end, err := in.evalNumber(s.end, "for end")
+	if err != nil {
+		return err
+	}
+	step := Int(1)
+	if s.step != nil {
+		if step, err = in.evalNumber(s.step, "for step"); err != nil {
+			return err
+		}
+		if step.Float64() == 0 {
+			return &RuntimeError{Msg: "for step must not be zero"}
+		}
+	}
+
+	// Integer loops stay integers; anything else iterates in floats
+	if start.Kind() == KindInt && end.Kind() == KindInt && step.Kind() == KindInt {
+		for i := start.i; (step.i > 0 && i <= end.i) || (step.i < 0 && i >= end.i); i += step.i {
+			in.locals[s.name] = Int(i)
+			if err := in.execBlock(s.body); err != nil {
+				if err == errBreak {
+					return nil
+				}
+				return err
+			}
+		}
+		return nil
+	}
+
+	f, e, st := start.Float64(), end.Float64(), step.Float64()
+	for x := f; (st > 0 && x <= e) || (st < 0 && x >= e); x += st {
+		in.locals[s.name] = Float(x)
+		if err := in.execBlock(s.body); err != nil {
+			if err == errBreak {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (in *interp) evalNumber(x expr, what string) (Value, error) {
+	v, err := in.eval(x)
+	if err != nil {
+		return Nil, err
+	}
+	n, ok := v.Number()
+	if !ok {
+		return Nil, &RuntimeError{Msg: fmt.Sprintf("%s must be a number, got %s", what, v.Kind())}
+	}
+	return n, nil
+}
+
+func (in *interp) lookup(name string) Value {
+	if v, ok := in.locals[name]; ok {
+		return v
+	}
+	if in.env != nil {
+		if v, ok := in.env.Get(name); ok {
+			return v
+		}
+	}
+	return Nil
+}
+
+func (in *interp) eval(x expr) (Value, error) {
+	if err := in.step(); err != nil {
+		return Nil, err
+	}
+
+	switch x := x.(type) {
+	case *literalExpr:
+		return x.value, nil
+
+	case *identExpr:
+		return in.lookup(x.name), nil
+
+	case *unaryExpr:
+		v, err := in.eval(x.x)
+		if err != nil {
+			return Nil, err
+		}
+		if x.op == "not" {
+			return Bool(!v.Truthy()), nil
+		}
+		n, ok := v.Number()
+		if !ok {
+			return Nil, &RuntimeError{Msg: fmt.Sprintf("cannot negate %s", v.Kind())}
+		}
+		if n.Kind() == KindInt {
+			return Int(-n.i), nil
+		}
+		return Float(-n.f), nil
+
+	case *binaryExpr:
+		return in.evalBinary(x)
+
+	case *callExpr:
+		return in.call(x)
+
+	case *indexExpr:
+		return in.evalIndex(x)
+
+	case *listExpr:
+		items := make([]Value, len(x.items))
+		for i, item := range x.items {
+			v, err := in.eval(item)
+			if err != nil {
+				return Nil, err
+			}
+			items[i] = v
+		}
+		v := List(items)
+		return v, in.alloc(v)
+
+	case *tableExpr:
+		fields := make(map[string]Value, len(x.keys))
+		for i, key := range x.keys {
+			v, err := in.eval(x.values[i])
+			if err != nil {
+				return Nil, err
+			}
+			if v.Kind() != KindNil {
+				fields[key] = v
+			}
+		}
+		v := Table(fields)
+		return v, in.alloc(v)
+	}
+	return Nil, &RuntimeError{Msg: "unknown expression"}
+}
+
+// evalIndex reads a list element or table field; missing entries are nil
+func (in *interp) evalIndex(x *indexExpr) (Value, error) {
+	container, err := in.eval(x.x)
+	if err != nil {
+		return Nil, err
+	}
+	key, err := in.eval(x.key)
+	if err != nil {
+		return Nil, err
+	}
+
+	switch container.Kind() {
+	case KindList:
+		idx, ok := listIndex(key)
+		if !ok {
+			return Nil, &RuntimeError{Pos: x.pos, Msg: fmt.Sprintf("list index must be an integer, got %s", key.Kind())}
+		}
+		if idx < 1 || idx > int64(len(container.l.items)) {
+			return Nil, nil
+		}
+		return container.l.items[idx-1], nil
+	case KindTable:
+		name, err := in.str(key)
+		if err != nil {
+			return Nil, err
+		}
+		return container.t.fields[name], nil
+	case KindNil:
+		return Nil, &RuntimeError{Pos: x.pos, Msg: "cannot index nil"}
+	default:
+		return Nil, &RuntimeError{Pos: x.pos, Msg: fmt.Sprintf("cannot index %s", container.Kind())}
+	}
+}
+
+func (in *interp) evalBinary(x *binaryExpr) (Value, error) {
+	left, err := in.eval(x.left)
+	if err != nil {
+		return Nil, err
+	}
+
+	// Short-circuit logic returns the deciding operand, as in Lua
+	switch x.op {
+	case "and":
+		if !left.Truthy() {
+			return left, nil
+		}
+		return in.eval(x.right)
+	case "or":
+		if left.Truthy() {
+			return left, nil
+		}
+		return in.eval(x.right)
+	}
+
+	right, err := in.eval(x.right)
+	if err != nil {
+		return Nil, err
+	}
+
+	switch x.op {
+	case "..":
+		return in.concat(left, right)
+	case "==":
+		return Bool(equal(left, right)), nil
+	case "!=":
+		return Bool(!equal(left, right)), nil
+	case "<", "<=", ">", ">=":
+		return in.compare(x.op, left, right)
+	}
+
+	ln, lok := left.Number()
+	rn, rok := right.Number()
+	if !lok || !rok {
+		// "+" falls back to concatenation for non-numeric operands
+		if x.op == "+" {
+			return in.concat(left, right)
+		}
+		bad := left
+		if lok {
+			bad = right
+		}
+		return Nil, &RuntimeError{Msg: fmt.Sprintf("cannot apply %s to %s", x.op, bad.Kind())}
+	}
+	return arith(x.op, ln, rn)
+}
+
+// concat joins two values as strings
+func (in *interp) concat(left, right Value) (Value, error) {
+	ls, err := in.str(left)
+	if err != nil {
+		return Nil, err
+	}
+	rs, err := in.str(right)
+	if err != nil {
+		return Nil, err
+	}
+	v := String(ls + rs)
+	return v, in.alloc(v)
+}
+
+// arith applies an arithmetic operator to two numbers
+// Integer operands stay integers except for division with a remainder
+func arith(op string, a, b Value) (Value, error) {
+	if a.Kind() == KindInt && b.Kind() == KindInt {
+		switch op {
+		case "+":
+			return Int(a.i + b.i), nil
+		case "-":
+			return Int(a.i - b.i), nil
+		case "*":
+			return Int(a.i * b.i), nil
+		case "/":
+			if b.i == 0 {
+				return Nil, &RuntimeError{Msg: "division by zero"}
+			}
+			if a.i%b.i == 0 {
+				return Int(a.i / b.i), nil
+			}
+			return Float(float64(a.i) / float64(b.i)), nil
+		case "%":
+			if b.i == 0 {
+				return Nil, &RuntimeError{Msg: "division by zero"}
+			}
+			return Int(a.i % b.i), nil
+		}
+	}
+
+	fa, fb := a.Float64(), b.Float64()
+	switch op {
+	case "+":
+		return Float(fa + fb), nil
+	case "-":
+		return Float(fa - fb), nil
+	case "*":
+		return Float(fa * fb), nil
+	case "/":
+		if fb == 0 {
+			return Nil, &RuntimeError{Msg: "division by zero"}
+		}
+		return Float(fa / fb), nil
+	case "%":
+		if fb == 0 {
+			return Nil, &RuntimeError{Msg: "division by zero"}
+		}
+		return Float(math.Mod(fa, fb)), nil
+	}
+	return Nil, &RuntimeError{Msg: "unknown operator " + op}
+}
+
+// equal compares numbers numerically and everything else by kind and value
+func equal(a, b Value) bool {
+	an, aok := numericOnly(a)
+	bn, bok := numericOnly(b)
+	if aok && bok {
+		return an.Float64() == bn.Float64()
+	}
+	if a.Kind() != b.Kind() {
+		return false
+	}
+	switch a.Kind() {
+	case KindNil:
+		return true
+	case KindBool:
+		return a.b == b.b
+	case KindList:
+		return a.l == b.l
+	case KindTable:
+		return a.t == b.t
+	default:
+		return a.s == b.s
+	}
+}
+
+// compare orders numbers (including numeric strings) numerically and other values as strings
+func (in *interp) compare(op string, a, b Value) (Value, error) {
+	an, aok := a.Number()
+	bn, bok := b.Number()
+	var c int
+	if aok && bok {
+		fa, fb := an.Float64(), bn.Float64()
+		switch {
+		case fa < fb:
+			c = -1
+		case fa > fb:
+			c = 1
+		}
+	} else {
+		as, err := in.str(a)
+		if err != nil {
+			return Nil, err
+		}
+		bs, err := in.str(b)
+		if err != nil {
+			return Nil, err
+		}
+		switch {
+		case as < bs:
+			c = -1
+		case as > bs:
+			c = 1
+		}
+	}
+	switch op {
+	case "<":
+		return Bool(c < 0), nil
+	case "<=":
+		return Bool(c <= 0), nil
+	case ">":
+		return Bool(c > 0), nil
+	default:
+		return Bool(c >= 0), nil
+	}
+}
+
+func numericOnly(v Value) (Value, bool) {
+	if v.Kind() == KindInt || v.Kind() == KindFloat {
+		return v, true
+	}
+	return Nil, false
+}
+
+func (in *interp) call(x *callExpr) (Value, error) {
+	fn, ok := builtins[x.name]
+	if !ok {
+		return Nil, &RuntimeError{Pos: x.pos, Msg: "unknown function " + x.name}
+	}
+	args := make([]Value, len(x.args))
+	for i, a := range x.args {
+		v, err := in.eval(a)
+		if err != nil {
+			return Nil, err
+		}
+		args[i] = v
+	}
+	v, err := fn(in, args)
+	if err != nil {
+		if rt, ok := err.(*RuntimeError); ok && rt.Pos == 0 {
+			rt.Pos = x.pos
+			rt.Msg = x.name + ": " + rt.Msg
+		}
+		return Nil, err
+	}
+	return v, in.alloc(v)
+}
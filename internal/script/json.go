@@ -0,0 +1,88 @@
+package script
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// maxValueDepth bounds nesting when converting lists and tables, which also stops
+// self-referencing tables from recursing forever
+const maxValueDepth = 16
+
+// ErrValueTooDeep is returned when a value is nested too deeply (or contains itself)
+var ErrValueTooDeep = errors.New("value nested too deeply")
+
+// FromJSON converts JSON into a script value
+// Whole numbers become ints, other numbers floats, arrays lists and objects tables
+func FromJSON(data []byte) (Value, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var decoded interface{}
+	if err := dec.Decode(&decoded); err != nil {
+		return Nil, err
+	}
+	return fromDecoded(decoded, 0)
+}
+
+func fromDecoded(value interface{}, depth int) (Value, error) {
+	if depth > maxValueDepth {
+		return Nil, ErrValueTooDeep
+	}
+
+	switch val := value.(type) {
+	case nil:
+		return Nil, nil
+	case bool:
+		return Bool(val), nil
+	case string:
+		return String(val), nil
+	case json.Number:
+		if !strings.ContainsAny(val.String(), ".eE") {
+			if i, err := val.Int64(); err == nil {
+				return Int(i), nil
+			}
+		}
+		f, err := val.Float64()
+		if err != nil {
+			return Nil, err
+		}
+		return Float(f), nil
+	case []interface{}:
+		items := make([]Value, len(val))
+		for i, item := range val {
+			v, err := fromDecoded(item, depth+1)
+			if err != nil {
+				return Nil, err
+			}
+			items[i] = v
+		}
+		return List(items), nil
+	case map[string]interface{}:
+		fields := make(map[string]Value, len(val))
+		for k, item := range val {
+			v, err := fromDecoded(item, depth+1)
+			if err != nil {
+				return Nil, err
+			}
+			fields[k] = v
+		}
+		return Table(fields), nil
+	default:
+		return Nil, errors.New("unsupported JSON value")
+	}
+}
+
+// MarshalJSON encodes the value as JSON
+// Whole floats keep a decimal point so they decode back as floats
+func (v Value) MarshalJSON() ([]byte, error) {
+	e := newEncoder()
+	if err := e.json(v, 0); err != nil {
+		return nil, err
+	}
+	if err := e.finish(); err != nil {
+		return nil, err
+	}
+	return e.buf.Bytes(), nil
+}
@@ -0,0 +1,156 @@
+package script
+
+import (
+	"fmt"
+	"strings"
+)
+
+type tokenType int
+
+const (
+	tokEOF tokenType = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokOp
+	tokKeyword
+)
+
+type token struct {
+	typ tokenType
+	val string
+	pos int
+}
+
+var keywords = map[string]bool{
+	"if": true, "elseif": true, "else": true, "while": true, "for": true,
+	"break": true, "return": true, "local": true,
+	"and": true, "or": true, "not": true,
+	"true": true, "false": true, "nil": true,
+}
+
+// Two-character operators must be listed before their one-character prefixes
+var operators = []string{
+	"==", "!=", "<=", ">=", "&&", "||", "..",
+	"+", "-", "*", "/", "%", "<", ">", "=", "!",
+	".", "(", ")", "{", "}", "[", "]", ",", ";",
+}
+
+// SyntaxError describes a problem found while parsing a script
+type SyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at %d: %s", e.Pos, e.Msg)
+}
+
+// lex splits source into tokens
+func lex(src string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(src) {
+		c := src[i]
+
+		// Whitespace and newlines separate tokens only
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+			i++
+			continue
+		}
+
+		// Line comments
+		if strings.HasPrefix(src[i:], "//") {
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+			continue
+		}
+
+		start := i
+		switch {
+		case isLetter(c):
+			for i < len(src) && (isLetter(src[i]) || isDigit(src[i])) {
+				i++
+			}
+			word := src[start:i]
+			if keywords[word] {
+				tokens = append(tokens, token{typ: tokKeyword, val: word, pos: start})
+			} else {
+				tokens = append(tokens, token{typ: tokIdent, val: word, pos: start})
+			}
+
+		case isDigit(c):
+			for i < len(src) && isDigit(src[i]) {
+				i++
+			}
+			if i+1 < len(src) && src[i] == '.' && isDigit(src[i+1]) {
+				i++
+				for i < len(src) && isDigit(src[i]) {
+					i++
+				}
+			}
+			tokens = append(tokens, token{typ: tokNumber, val: src[start:i], pos: start})
+
+		case c == '"' || c == '\'':
+			s, next, err := lexString(src, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{typ: tokString, val: s, pos: start})
+			i = next
+
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(src[i:], op) {
+					tokens = append(tokens, token{typ: tokOp, val: op, pos: start})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, &SyntaxError{Pos: start, Msg: fmt.Sprintf("unexpected character %q", c)}
+			}
+		}
+	}
+	tokens = append(tokens, token{typ: tokEOF, pos: len(src)})
+	return tokens, nil
+}
+
+// lexString reads a quoted string literal starting at src[start]
+func lexString(src string, start int) (string, int, error) {
+	quote := src[start]
+	var b strings.Builder
+	i := start + 1
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == quote:
+			return b.String(), i + 1, nil
+		case c == '\\' && i+1 < len(src):
+			i++
+			switch src[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				b.WriteByte(src[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+		i++
+	}
+	return "", 0, &SyntaxError{Pos: start, Msg: "unterminated string"}
+}
+
+func isLetter(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
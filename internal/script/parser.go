@@ -0,0 +1,561 @@
+package script
+
+import (
+	"fmt"
+)
+
+// Statement and expression nodes
+
+type stmt interface{}
+type expr interface{}
+
+type assignStmt struct {
+	name   string
+	local  bool
+	target *indexExpr // Set for list and table element assignment
+	value  expr
+}
+
+type ifStmt struct {
+	conds    []expr
+	blocks   [][]stmt
+	elseBody []stmt
+}
+
+type whileStmt struct {
+	cond expr
+	body []stmt
+}
+
+type forStmt struct {
+	name       string
+	start, end expr
+	step       expr // nil means 1
+	body       []stmt
+}
+
+type breakStmt struct{}
+
+type returnStmt struct{}
+
+type exprStmt struct {
+	x expr
+}
+
+type literalExpr struct {
+	value Value
+}
+
+type identExpr struct {
+	name string
+	pos  int
+}
+
+type unaryExpr struct {
+	op string
+	x  expr
+}
+
+type binaryExpr struct {
+	op          string
+	left, right expr
+}
+
+type indexExpr struct {
+	x   expr
+	key expr
+	pos int
+}
+
+type listExpr struct {
+	items []expr
+}
+
+type tableExpr struct {
+	keys   []string
+	values []expr
+}
+
+type callExpr struct {
+	name string
+	args []expr
+	pos  int
+}
+
+// Binary operator precedence, lowest first
+var precedence = map[string]int{
+	"or": 1, "||": 1,
+	"and": 2, "&&": 2,
+	"==": 3, "!=": 3, "<": 3, "<=": 3, ">": 3, ">=": 3,
+	"..": 4,
+	"+":  5, "-": 5,
+	"*": 6, "/": 6, "%": 6,
+}
+
+type parser struct {
+	tokens   []token
+	pos      int
+	depth    int
+	maxDepth int
+}
+
+// parse turns source into a list of statements
+func parse(src string, maxDepth int) ([]stmt, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, maxDepth: maxDepth}
+	body, err := p.block(false)
+	if err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.typ != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) is(typ tokenType, val string) bool {
+	t := p.peek()
+	return t.typ == typ && t.val == val
+}
+
+func (p *parser) expect(typ tokenType, val string) error {
+	t := p.next()
+	if t.typ != typ || t.val != val {
+		return p.errorf(t, "expected %q", val)
+	}
+	return nil
+}
+
+func (p *parser) errorf(t token, format string, args ...interface{}) error {
+	msg := fmt.Sprintf(format, args...)
+	if t.typ == tokEOF {
+		msg += " before end of script"
+	} else {
+		msg += fmt.Sprintf(", found %q", t.val)
+	}
+	return &SyntaxError{Pos: t.pos, Msg: msg}
+}
+
+// enter guards against deeply nested input exhausting the Go stack
+func (p *parser) enter() error {
+	p.depth++
+	if p.depth > p.maxDepth {
+		return &SyntaxError{Pos: p.peek().pos, Msg: "nesting too deep"}
+	}
+	return nil
+}
+
+func (p *parser) leave() {
+	p.depth--
+}
+
+// block parses statements until "}" (if braced) or end of input
+func (p *parser) block(braced bool) ([]stmt, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	var body []stmt
+	for {
+		// Semicolons are optional statement separators
+		for p.is(tokOp, ";") {
+			p.next()
+		}
+		t := p.peek()
+		if braced && t.typ == tokOp && t.val == "}" {
+			p.next()
+			return body, nil
+		}
+		if t.typ == tokEOF {
+			if braced {
+				return nil, p.errorf(t, "expected %q", "}")
+			}
+			return body, nil
+		}
+		s, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, s)
+	}
+}
+
+func (p *parser) braceBlock() ([]stmt, error) {
+	if err := p.expect(tokOp, "{"); err != nil {
+		return nil, err
+	}
+	return p.block(true)
+}
+
+func (p *parser) statement() (stmt, error) {
+	t := p.peek()
+
+	if t.typ == tokKeyword {
+		switch t.val {
+		case "if":
+			return p.ifStatement()
+		case "while":
+			p.next()
+			cond, err := p.expression(0)
+			if err != nil {
+				return nil, err
+			}
+			body, err := p.braceBlock()
+			if err != nil {
+				return nil, err
+			}
+			return &whileStmt{cond: cond, body: body}, nil
+		case "for":
+			return p.forStatement()
+		case "break":
+			p.next()
+			return &breakStmt{}, nil
+		case "return":
+			p.next()
+			return &returnStmt{}, nil
+		case "local":
+			p.next()
+			name := p.next()
+			if name.typ != tokIdent {
+				return nil, p.errorf(name, "expected variable name")
+			}
+			if err := p.expect(tokOp, "="); err != nil {
+				return nil, err
+			}
+			value, err := p.expression(0)
+			if err != nil {
+				return nil, err
+			}
+			return &assignStmt{name: name.val, local: true, value: value}, nil
+		}
+	}
+
+	// Assignment: name = expr
+	if t.typ == tokIdent && p.tokens[p.pos+1].typ == tokOp && p.tokens[p.pos+1].val == "=" {
+		p.next()
+		p.next()
+		value, err := p.expression(0)
+		if err != nil {
+			return nil, err
+		}
+		return &assignStmt{name: t.val, value: value}, nil
+	}
+
+	// Otherwise it must be an element assignment or a function call used as a statement
+	x, err := p.expression(0)
+	if err != nil {
+		return nil, err
+	}
+	if target, ok := x.(*indexExpr); ok && p.is(tokOp, "=") {
+		p.next()
+		value, err := p.expression(0)
+		if err != nil {
+			return nil, err
+		}
+		return &assignStmt{target: target, value: value}, nil
+	}
+	if _, ok := x.(*callExpr); !ok {
+		return nil, &SyntaxError{Pos: t.pos, Msg: "expected statement"}
+	}
+	return &exprStmt{x: x}, nil
+}
+
+func (p *parser) ifStatement() (stmt, error) {
+	s := &ifStmt{}
+	p.next() // if
+	for {
+		cond, err := p.expression(0)
+		if err != nil {
+			return nil, err
+		}
+		body, err := p.braceBlock()
+		if err != nil {
+			return nil, err
+		}
+		s.conds = append(s.conds, cond)
+		s.blocks = append(s.blocks, body)
+
+		if p.is(tokKeyword, "elseif") {
+			p.next()
+			continue
+		}
+		if p.is(tokKeyword, "else") {
+			p.next()
+			// Allow "else if" as a synonym for "elseif"
+			if p.is(tokKeyword, "if") {
+				p.next()
+				continue
+			}
+			elseBody, err := p.braceBlock()
+			if err != nil {
+				return nil, err
+			}
+			s.elseBody = elseBody
+		}
+		return s, nil
+	}
+}
+
+// forStatement parses "for i = start, end[, step] { ... }"
+func (p *parser) forStatement() (stmt, error) {
+	p.next() // for
+	name := p.next()
+	if name.typ != tokIdent {
+		return nil, p.errorf(name, "expected loop variable")
+	}
+	if err := p.expect(tokOp, "="); err != nil {
+		return nil, err
+	}
+	start, err := p.expression(0)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(tokOp, ","); err != nil {
+		return nil, err
+	}
+	end, err := p.expression(0)
+	if err != nil {
+		return nil, err
+	}
+	var step expr
+	if p.is(tokOp, ",") {
+		p.next()
+		if step, err = p.expression(0); err != nil {
+			return nil, err
+		}
+	}
+	body, err := p.braceBlock()
+	if err != nil {
+		return nil, err
+	}
+	return &forStmt{name: name.val, start: start, end: end, step: step, body: body}, nil
+}
+
+// expression parses a binary expression using precedence climbing
+func (p *parser) expression(minPrec int) (expr, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		t := p.peek()
+		if t.typ != tokOp && t.typ != tokKeyword {
+			return left, nil
+		}
+		prec, ok := precedence[t.val]
+		if !ok || prec <= minPrec {
+			return left, nil
+		}
+		p.next()
+		right, err := p.expression(prec)
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: normalizeOp(t.val), left: left, right: right}
+	}
+}
+
+func (p *parser) unary() (expr, error) {
+	t := p.peek()
+	if (t.typ == tokOp && (t.val == "-" || t.val == "!")) || (t.typ == tokKeyword && t.val == "not") {
+		p.next()
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{op: normalizeOp(t.val), x: x}, nil
+	}
+	return p.postfix()
+}
+
+// postfix parses indexing such as list[1], table["key"] and table.key
+func (p *parser) postfix() (expr, error) {
+	x, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		switch {
+		case t.typ == tokOp && t.val == "[":
+			p.next()
+			key, err := p.expression(0)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(tokOp, "]"); err != nil {
+				return nil, err
+			}
+			x = &indexExpr{x: x, key: key, pos: t.pos}
+		case t.typ == tokOp && t.val == ".":
+			p.next()
+			name := p.next()
+			if name.typ != tokIdent && name.typ != tokKeyword {
+				return nil, p.errorf(name, "expected field name")
+			}
+			x = &indexExpr{x: x, key: &literalExpr{value: String(name.val)}, pos: t.pos}
+		default:
+			return x, nil
+		}
+	}
+}
+
+func (p *parser) primary() (expr, error) {
+	t := p.next()
+	switch t.typ {
+	case tokNumber:
+		v, ok := ParseNumber(t.val)
+		if !ok {
+			return nil, p.errorf(t, "invalid number")
+		}
+		return &literalExpr{value: v}, nil
+	case tokString:
+		return &literalExpr{value: String(t.val)}, nil
+	case tokKeyword:
+		switch t.val {
+		case "true":
+			return &literalExpr{value: Bool(true)}, nil
+		case "false":
+			return &literalExpr{value: Bool(false)}, nil
+		case "nil":
+			return &literalExpr{value: Nil}, nil
+		}
+	case tokIdent:
+		if p.is(tokOp, "(") {
+			p.next()
+			call := &callExpr{name: t.val, pos: t.pos}
+			if p.is(tokOp, ")") {
+				p.next()
+				return call, nil
+			}
+			for {
+				arg, err := p.expression(0)
+				if err != nil {
+					return nil, err
+				}
+				call.args = append(call.args, arg)
+				if p.is(tokOp, ",") {
+					p.next()
+					continue
+				}
+				if err := p.expect(tokOp, ")"); err != nil {
+					return nil, err
+				}
+				return call, nil
+			}
+		}
+		return &identExpr{name: t.val, pos: t.pos}, nil
+	case tokOp:
+		if t.val == "(" {
+			x, err := p.expression(0)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(tokOp, ")"); err != nil {
+				return nil, err
+			}
+			return x, nil
+		}
+		if t.val == "[" {
+			return p.listLiteral()
+		}
+		if t.val == "{" {
+			return p.tableLiteral()
+		}
+	}
+	return nil, p.errorf(t, "expected expression")
+}
+
+// listLiteral parses "[a, b, c]" after the opening bracket
+func (p *parser) listLiteral() (expr, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	l := &listExpr{}
+	for !p.is(tokOp, "]") {
+		item, err := p.expression(0)
+		if err != nil {
+			return nil, err
+		}
+		l.items = append(l.items, item)
+		if !p.is(tokOp, ",") {
+			break
+		}
+		p.next()
+	}
+	if err := p.expect(tokOp, "]"); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// tableLiteral parses "{name = value, "key" = value}" after the opening brace
+func (p *parser) tableLiteral() (expr, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	t := &tableExpr{}
+	for !p.is(tokOp, "}") {
+		key := p.next()
+		if key.typ != tokIdent && key.typ != tokString {
+			return nil, p.errorf(key, "expected table key")
+		}
+		if err := p.expect(tokOp, "="); err != nil {
+			return nil, err
+		}
+		value, err := p.expression(0)
+		if err != nil {
+			return nil, err
+		}
+		t.keys = append(t.keys, key.val)
+		t.values = append(t.values, value)
+		if !p.is(tokOp, ",") {
+			break
+		}
+		p.next()
+	}
+	if err := p.expect(tokOp, "}"); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// normalizeOp maps symbolic and word operators onto one spelling
+func normalizeOp(op string) string {
+	switch op {
+	case "&&":
+		return "and"
+	case "||":
+		return "or"
+	case "!":
+		return "not"
+	}
+	return op
+}
@@ -0,0 +1,224 @@
+package script
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Kind identifies the type of a script value
+type Kind int
+
+const (
+	KindNil Kind = iota
+	KindBool
+	KindInt
+	KindFloat
+	KindString
+	KindList
+	KindTable
+)
+
+// String returns the kind name used in error messages
+func (k Kind) String() string {
+	switch k {
+	case KindNil:
+		return "nil"
+	case KindBool:
+		return "bool"
+	case KindInt:
+		return "int"
+	case KindFloat:
+		return "float"
+	case KindString:
+		return "string"
+	case KindList:
+		return "list"
+	case KindTable:
+		return "table"
+	default:
+		return "unknown"
+	}
+}
+
+// Value is a dynamically typed script value
+// Lists and tables are references: copies of a Value share the same items
+type Value struct {
+	kind Kind
+	b    bool
+	i    int64
+	f    float64
+	s    string
+	l    *list
+	t    *table
+}
+
+type list struct {
+	items []Value
+}
+
+type table struct {
+	fields map[string]Value
+}
+
+// Approximate per-element overhead of lists and tables for memory accounting
+const elementSize = 16
+
+// Nil is the nil value
+var Nil = Value{}
+
+// Bool creates a bool value
+func Bool(b bool) Value { return Value{kind: KindBool, b: b} }
+
+// Int creates an integer value
+func Int(i int64) Value { return Value{kind: KindInt, i: i} }
+
+// Float creates a float value
+func Float(f float64) Value { return Value{kind: KindFloat, f: f} }
+
+// String creates a string value
+func String(s string) Value { return Value{kind: KindString, s: s} }
+
+// List creates a list value holding items
+func List(items []Value) Value { return Value{kind: KindList, l: &list{items: items}} }
+
+// Table creates a table value holding fields
+func Table(fields map[string]Value) Value {
+	if fields == nil {
+		fields = make(map[string]Value)
+	}
+	return Value{kind: KindTable, t: &table{fields: fields}}
+}
+
+// Items returns the elements of a list (nil for other kinds)
+func (v Value) Items() []Value {
+	if v.kind != KindList {
+		return nil
+	}
+	return v.l.items
+}
+
+// Fields returns the entries of a table (nil for other kinds)
+func (v Value) Fields() map[string]Value {
+	if v.kind != KindTable {
+		return nil
+	}
+	return v.t.fields
+}
+
+// Kind returns the value's type
+func (v Value) Kind() Kind { return v.kind }
+
+// Truthy reports whether the value counts as true in conditions
+// nil, false, 0 and "" are false; everything else is true
+func (v Value) Truthy() bool {
+	switch v.kind {
+	case KindNil:
+		return false
+	case KindBool:
+		return v.b
+	case KindInt:
+		return v.i != 0
+	case KindFloat:
+		return v.f != 0
+	case KindString:
+		return v.s != ""
+	default:
+		// Lists and tables are true even when empty, as in Lua
+		return true
+	}
+}
+
+// Number returns the value as a number, coercing numeric strings
+// The second result is false if the value is not numeric
+func (v Value) Number() (Value, bool) {
+	switch v.kind {
+	case KindInt, KindFloat:
+		return v, true
+	case KindString:
+		return ParseNumber(v.s)
+	default:
+		return Nil, false
+	}
+}
+
+// Float64 returns the numeric value as a float64 (0 for non-numeric values)
+func (v Value) Float64() float64 {
+	n, ok := v.Number()
+	if !ok {
+		return 0
+	}
+	if n.kind == KindInt {
+		return float64(n.i)
+	}
+	return n.f
+}
+
+// String returns the value formatted for output and concatenation
+// Lists are joined with "|" as in CMUD string lists; tables are formatted as JSON.
+// Values that expand beyond MaxEncodedLen are cut short with "...".
+func (v Value) String() string {
+	if v.kind != KindList && v.kind != KindTable {
+		return v.scalarString()
+	}
+	e := newEncoder()
+	if err := e.text(v, 0); err != nil {
+		return e.buf.String() + "..."
+	}
+	return e.buf.String()
+}
+
+// scalarString formats a value that is not a list or table
+func (v Value) scalarString() string {
+	switch v.kind {
+	case KindBool:
+		return strconv.FormatBool(v.b)
+	case KindInt:
+		return strconv.FormatInt(v.i, 10)
+	case KindFloat:
+		if v.f == math.Trunc(v.f) && math.Abs(v.f) < 1e15 {
+			return strconv.FormatFloat(v.f, 'f', 1, 64)
+		}
+		return strconv.FormatFloat(v.f, 'g', -1, 64)
+	case KindString:
+		return v.s
+	default:
+		return ""
+	}
+}
+
+// ParseNumber parses an int or float literal such as "42" or "3.5"
+func ParseNumber(s string) (Value, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Nil, false
+	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return Int(i), true
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+		return Float(f), true
+	}
+	return Nil, false
+}
+
+// size returns the approximate memory footprint of a value for limit accounting
+// Only the top level of lists and tables is counted; nested values were counted when created.
+// A list or table can still hold another many times over, so converting one to a string is
+// charged separately (see interp.str).
+func (v Value) size() int {
+	switch v.kind {
+	case KindString:
+		return len(v.s)
+	case KindList:
+		return len(v.l.items) * elementSize
+	case KindTable:
+		n := 0
+		for k := range v.t.fields {
+			n += len(k) + elementSize
+		}
+		return n
+	default:
+		return 0
+	}
+}
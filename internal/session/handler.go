@@ -3,8 +3,11 @@ package session
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"sort"
+	"strconv"
 	"time"
 
 	"github.com/amaranth494/MudPuppy/internal/config"
@@ -23,6 +26,7 @@ type HandlerCallbacks struct {
 	OnConnected     func(connectionID, userID uuid.UUID) error
 	GetAutoLogin    func(connectionID uuid.UUID) (username, password string, err error)
 	SendCredentials func(userID, username, password string) error
+	LookupEmail     func(userID uuid.UUID) (string, error)
 }
 
 // NewHandler creates a new session handler
@@ -135,6 +139,16 @@ func (h *Handler) Connect(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+		// Bind the connection's automation profile to the live session
+		if err := h.manager.StartAutomation(userIDStr, req.ConnectionID); err != nil {
+			log.Printf("Failed to start automation engine: %v", err)
+		}
+
+		// Record the session if logging is turned on for the connection
+		if err := h.manager.StartLogging(userIDStr, req.ConnectionID); err != nil {
+			log.Printf("Failed to start session logging: %v", err)
+		}
+
 		// Handle auto-login
 		if h.callbacks.GetAutoLogin != nil {
 			username, password, err := h.callbacks.GetAutoLogin(req.ConnectionID)
@@ -245,6 +259,98 @@ func (h *Handler) Status(w http.ResponseWriter, r *http.Request) {
 	h.sendJSON(w, resp)
 }
 
+// RuntimeVariable is a session variable as returned by the variables endpoint
+type RuntimeVariable struct {
+	Name  string          `json:"name"`
+	Value json.RawMessage `json:"value"`
+}
+
+// VariablesResponse lists the runtime variables of the current MUD session
+type VariablesResponse struct {
+	Items []RuntimeVariable `json:"items"`
+}
+
+// Variables handles GET /api/v1/session/variables
+func (h *Handler) Variables(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("user_id")
+	if userID == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userIDStr := userID.(string)
+
+	vars, err := h.manager.RuntimeVariables(r.Context(), userIDStr)
+	if err != nil {
+		log.Printf("Failed to load runtime variables: %v", err)
+		h.sendError(w, "Failed to load variables")
+		return
+	}
+
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	resp := VariablesResponse{Items: make([]RuntimeVariable, 0, len(names))}
+	for _, name := range names {
+		if !json.Valid([]byte(vars[name])) {
+			continue
+		}
+		resp.Items = append(resp.Items, RuntimeVariable{Name: name, Value: json.RawMessage(vars[name])})
+	}
+	h.sendJSON(w, resp)
+}
+
+// Scrollback handles GET /api/v1/session/scrollback?before=&limit=
+// Returns up to limit lines of recent output ending at the before cursor, or at the latest output.
+// Data messages carry the cursor at their end, so a reconnecting client can skip output it already has.
+func (h *Handler) Scrollback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Context().Value("user_id")
+	if userID == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userIDStr := userID.(string)
+
+	query := r.URL.Query()
+	before := int64(-1)
+	if s := query.Get("before"); s != "" {
+		cursor, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || cursor < 0 {
+			h.sendError(w, "Invalid before cursor")
+			return
+		}
+		before = cursor
+	}
+	limit := defaultScrollbackPage
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > MaxScrollbackPage {
+			h.sendError(w, fmt.Sprintf("limit must be between 1 and %d", MaxScrollbackPage))
+			return
+		}
+		limit = n
+	}
+
+	scrollback := h.manager.Scrollback(userIDStr)
+	if scrollback == nil {
+		h.sendJSON(w, ScrollbackPage{})
+		return
+	}
+	h.sendJSON(w, scrollback.Page(before, limit))
+}
+
 // sendJSON sends a JSON response
 func (h *Handler) sendJSON(w http.ResponseWriter, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
@@ -10,7 +10,11 @@ import (
 	"sync"
 	"time"
 
+	"github.com/amaranth494/MudPuppy/internal/automation"
 	"github.com/amaranth494/MudPuppy/internal/metrics"
+	"github.com/amaranth494/MudPuppy/internal/sessionlog"
+	"github.com/amaranth494/MudPuppy/internal/store"
+	"github.com/google/uuid"
 )
 
 // Session state constants
@@ -56,6 +60,26 @@ type Manager struct {
 	sessions map[string]*Session // userID -> session
 	conns    map[string]net.Conn
 	cleanups map[string]context.CancelFunc
+
+	// Server-side automation
+	automationStore automation.ProfileStore
+	automationOpts  automation.Options
+	engines         map[string]*automation.Engine // userID -> engine
+
+	// Session transcripts
+	logStore  sessionlog.Store
+	recorders map[string]*sessionlog.Recorder // userID -> recorder
+
+	// Timed recordings
+	castStore     sessionlog.CastStore
+	castRecorders map[string]*sessionlog.CastRecorder // userID -> recorder
+
+	// Server-side scrollback, kept after a disconnect until the user connects again
+	scrollbackLines int
+	scrollbacks     map[string]*Scrollback // userID -> scrollback
+
+	// Read-only spectators of live sessions
+	spectate spectators
 }
 
 // NewManager creates a new session manager
@@ -119,7 +143,317 @@ func NewManager(portWhitelist string, portDenylist string, portAllowlistOverride
 		sessions:              make(map[string]*Session),
 		conns:                 make(map[string]net.Conn),
 		cleanups:              make(map[string]context.CancelFunc),
+		engines:               make(map[string]*automation.Engine),
+		recorders:             make(map[string]*sessionlog.Recorder),
+		castRecorders:         make(map[string]*sessionlog.CastRecorder),
+		scrollbackLines:       DefaultScrollbackLines,
+		scrollbacks:           make(map[string]*Scrollback),
+		spectate: spectators{
+			shares:   make(map[string]*Share),
+			watchers: make(map[string]map[uuid.UUID]*Spectator),
+		},
+	}
+}
+
+// SetAutomationStore sets the profile store used to load live automation engines
+func (m *Manager) SetAutomationStore(profiles automation.ProfileStore) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.automationStore = profiles
+}
+
+// SetAutomationOptions configures runtime variable storage for new automation engines
+func (m *Manager) SetAutomationOptions(opts automation.Options) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.automationOpts = opts
+}
+
+// StartAutomation loads the automation engine for a saved connection and binds it to the user's session
+func (m *Manager) StartAutomation(userID string, connectionID uuid.UUID) error {
+	m.mu.RLock()
+	profiles := m.automationStore
+	opts := m.automationOpts
+	m.mu.RUnlock()
+
+	if profiles == nil {
+		return fmt.Errorf("automation not configured")
+	}
+
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return err
+	}
+
+	// Speedwalk steps go straight to this session's MUD connection
+	opts.Send = func(command string) error {
+		return m.SendCommand(userID, command)
+	}
+
+	engine, err := automation.NewEngine(profiles, userUUID, connectionID, opts)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.conns[userID]; !ok {
+		engine.Close()
+		return fmt.Errorf("no active connection")
+	}
+	if previous, ok := m.engines[userID]; ok {
+		previous.Close()
+	}
+	m.engines[userID] = engine
+
+	log.Printf("Automation engine started: user=%s, connection=%s", userID, connectionID)
+	return nil
+}
+
+// SetSessionLogStore sets the store session transcripts are written to
+func (m *Manager) SetSessionLogStore(logs sessionlog.Store) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.logStore = logs
+}
+
+// StartLogging starts recording the user's session if logging is turned on for the saved connection
+func (m *Manager) StartLogging(userID string, connectionID uuid.UUID) error {
+	m.mu.RLock()
+	logs := m.logStore
+	session, ok := m.sessions[userID]
+	var host string
+	var port int
+	if ok {
+		host, port = session.Host, session.Port
+	}
+	m.mu.RUnlock()
+
+	if logs == nil {
+		return nil
+	}
+	if !ok || host == "" {
+		return fmt.Errorf("no active connection")
+	}
+
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return err
+	}
+
+	recorder, err := sessionlog.Start(logs, userUUID, connectionID, host, port)
+	if err != nil || recorder == nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.conns[userID]; !ok {
+		recorder.Close()
+		return fmt.Errorf("no active connection")
+	}
+	if previous, ok := m.recorders[userID]; ok {
+		previous.Close()
+	}
+	m.recorders[userID] = recorder
+
+	log.Printf("Session logging started: user=%s, connection=%s, log=%s", userID, connectionID, recorder.LogID())
+	return nil
+}
+
+// UpdateLogging applies a change to a connection's logging setting to the user's live session
+// Recording starts or stops immediately when the session is bound to that connection
+func (m *Manager) UpdateLogging(userID string, connectionID uuid.UUID, enabled bool) error {
+	m.mu.Lock()
+	recorder, recording := m.recorders[userID]
+	engine := m.engines[userID]
+	if !enabled {
+		if recording && recorder.ConnectionID() == connectionID {
+			recorder.Close()
+			delete(m.recorders, userID)
+			log.Printf("Session logging stopped: user=%s, log=%s", userID, recorder.LogID())
+		}
+		m.mu.Unlock()
+		return nil
+	}
+	m.mu.Unlock()
+
+	if recording || engine == nil || engine.ConnectionID() != connectionID {
+		return nil
+	}
+	return m.StartLogging(userID, connectionID)
+}
+
+// recorder returns the transcript recorder of a user's session, or nil if it is not being logged
+func (m *Manager) recorder(userID string) *sessionlog.Recorder {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.recorders[userID]
+}
+
+// LogReceived adds MUD output to the user's session transcript, if it is being logged
+func (m *Manager) LogReceived(userID string, data []byte) {
+	if recorder := m.recorder(userID); recorder != nil && len(data) > 0 {
+		recorder.Received(data)
+	}
+}
+
+// SetRemoteEcho records that the MUD turned echo off (on=true) or back on for the user's session
+// Commands typed while the MUD echoes for itself, such as passwords, are redacted from the transcript
+func (m *Manager) SetRemoteEcho(userID string, on bool) {
+	if recorder := m.recorder(userID); recorder != nil {
+		recorder.SetRemoteEcho(on)
+	}
+}
+
+// SetRecordingStore sets the store timed session recordings are written to
+func (m *Manager) SetRecordingStore(recordings sessionlog.CastStore) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.castStore = recordings
+}
+
+// StartRecording starts a timed recording of the output of the user's session
+// A recording already in progress is finished first
+func (m *Manager) StartRecording(userID, title string, width, height int) (*store.SessionRecording, error) {
+	m.mu.RLock()
+	recordings := m.castStore
+	session, ok := m.sessions[userID]
+	_, connected := m.conns[userID]
+	rec := &store.SessionRecording{Title: title, Width: width, Height: height}
+	if ok {
+		rec.Host, rec.Port = session.Host, session.Port
+	}
+	if engine := m.engines[userID]; engine != nil {
+		connectionID := engine.ConnectionID()
+		rec.ConnectionID = &connectionID
+	}
+	m.mu.RUnlock()
+
+	if recordings == nil {
+		return nil, fmt.Errorf("recording is not available")
+	}
+	if !ok || !connected {
+		return nil, fmt.Errorf("no active connection")
+	}
+
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, err
+	}
+	rec.UserID = userUUID
+
+	recorder, created, err := sessionlog.StartRecording(recordings, rec)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.conns[userID]; !ok {
+		recorder.Close()
+		return nil, fmt.Errorf("no active connection")
+	}
+	if previous, ok := m.castRecorders[userID]; ok {
+		previous.Close()
+	}
+	m.castRecorders[userID] = recorder
+
+	log.Printf("Session recording started: user=%s, recording=%s", userID, created.ID)
+	return created, nil
+}
+
+// StopRecording finishes the recording of the user's session, returning its ID
+// Returns false if the session is not being recorded
+func (m *Manager) StopRecording(userID string) (uuid.UUID, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	recorder, ok := m.castRecorders[userID]
+	if !ok {
+		return uuid.Nil, false
+	}
+	recorder.Close()
+	delete(m.castRecorders, userID)
+	log.Printf("Session recording stopped: user=%s, recording=%s", userID, recorder.ID())
+	return recorder.ID(), true
+}
+
+// Recording returns the ID of the recording in progress for the user's session
+func (m *Manager) Recording(userID string) (uuid.UUID, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if recorder, ok := m.castRecorders[userID]; ok {
+		return recorder.ID(), true
 	}
+	return uuid.Nil, false
+}
+
+// RecordOutput adds MUD output that arrived at the given time to the user's recording, if one is in progress
+func (m *Manager) RecordOutput(userID string, data []byte, at time.Time) {
+	m.mu.RLock()
+	recorder := m.castRecorders[userID]
+	m.mu.RUnlock()
+	if recorder != nil && len(data) > 0 {
+		recorder.Output(data, at)
+	}
+}
+
+// SetScrollbackLines sets how many lines of output are kept for each session
+func (m *Manager) SetScrollbackLines(lines int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.scrollbackLines = lines
+}
+
+// Scrollback returns the scrollback of the user's current or last session
+func (m *Manager) Scrollback(userID string) *Scrollback {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.scrollbacks[userID]
+}
+
+// AppendScrollback adds output sent to the client and returns the cursor at its end
+// The cursor is 0 when the user has no session.
+func (m *Manager) AppendScrollback(userID string, data []byte) int64 {
+	scrollback := m.Scrollback(userID)
+	if scrollback == nil {
+		return 0
+	}
+	return scrollback.Append(data)
+}
+
+// Automation returns the live automation engine for a user's session, or nil if none is loaded
+func (m *Manager) Automation(userID string) *automation.Engine {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.engines[userID]
+}
+
+// RuntimeVariables returns the automation variables of a user's MUD session
+// Sessions on this instance are read from the live engine; otherwise the shared runtime
+// store is consulted when one is configured. Values are JSON encoded.
+func (m *Manager) RuntimeVariables(ctx context.Context, userID string) (map[string]string, error) {
+	m.mu.RLock()
+	engine := m.engines[userID]
+	runtime := m.automationOpts.Runtime
+	m.mu.RUnlock()
+
+	if engine != nil {
+		return engine.EncodedVariables(), nil
+	}
+
+	if runtime == nil {
+		return map[string]string{}, nil
+	}
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, err
+	}
+	return runtime.LoadVariables(ctx, userUUID)
 }
 
 // ValidatePort checks if a port is allowed (SP02PH04T06 - Port Denylist)
@@ -214,6 +548,7 @@ func (m *Manager) Connect(ctx context.Context, userID, host string, port int) (*
 	// Store connection
 	m.sessions[userID] = session
 	m.conns[userID] = conn
+	m.scrollbacks[userID] = NewScrollback(m.scrollbackLines)
 
 	// Record metrics
 	metrics.Get().IncConnect()
@@ -300,6 +635,8 @@ func (m *Manager) ResetIdleTimerOnOutbound(userID string) {
 
 // Disconnect terminates a user's MUD connection
 func (m *Manager) Disconnect(userID, reason string) error {
+	// Spectators are detached once the manager's lock is released
+	defer m.endSharing(userID)
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -320,6 +657,22 @@ func (m *Manager) Disconnect(userID, reason string) error {
 		delete(m.conns, userID)
 	}
 
+	// Discard the automation engine
+	if engine, ok := m.engines[userID]; ok {
+		engine.Close()
+		delete(m.engines, userID)
+	}
+
+	// Finish the session transcript and recording
+	if recorder, ok := m.recorders[userID]; ok {
+		recorder.Close()
+		delete(m.recorders, userID)
+	}
+	if recorder, ok := m.castRecorders[userID]; ok {
+		recorder.Close()
+		delete(m.castRecorders, userID)
+	}
+
 	// Update session state
 	session.State = StateDisconnected
 	session.DisconnectErr = reason
@@ -372,6 +725,31 @@ func (m *Manager) SendCommand(userID, command string) error {
 		return fmt.Errorf("failed to send command: %v", err)
 	}
 
+	if recorder := m.recorder(userID); recorder != nil {
+		recorder.Sent(command)
+	}
+
+	// Let the automapper track movement
+	if engine := m.Automation(userID); engine != nil {
+		engine.HandleSent(command)
+	}
+
+	return nil
+}
+
+// SendRaw writes bytes to the MUD server unchanged, e.g. telnet negotiation
+func (m *Manager) SendRaw(userID string, data []byte) error {
+	m.mu.RLock()
+	conn, ok := m.conns[userID]
+	m.mu.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("no active connection")
+	}
+
+	if _, err := conn.Write(data); err != nil {
+		return fmt.Errorf("failed to send: %v", err)
+	}
 	return nil
 }
 
@@ -407,6 +785,16 @@ func (m *Manager) SendCredentials(userID, username, password string) error {
 		}
 	}
 
+	// The password never reaches the transcript
+	if recorder := m.recorder(userID); recorder != nil {
+		if username != "" {
+			recorder.Sent(username)
+		}
+		if password != "" {
+			recorder.SentSecret()
+		}
+	}
+
 	log.Printf("[SP03PH05T08] Credentials sent for user=%s", userID)
 	return nil
 }
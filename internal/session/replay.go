@@ -0,0 +1,155 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/amaranth494/MudPuppy/internal/sessionlog"
+	"github.com/amaranth494/MudPuppy/internal/store"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// Replay speed limits
+const (
+	minReplaySpeed = 0.1
+	maxReplaySpeed = 16
+)
+
+// ReplayStore is the subset of store.SessionRecordingStore replays need
+type ReplayStore interface {
+	GetRecording(userID, recordingID uuid.UUID) (*store.SessionRecording, error)
+	ListRecordingChunks(recordingID uuid.UUID, afterSeq, limit int) ([]store.SessionRecordingChunk, error)
+}
+
+// SetRecordingStore sets the store recordings are replayed from
+func (h *WebSocketHandler) SetRecordingStore(recordings ReplayStore) {
+	h.recordings = recordings
+}
+
+// handleReplay streams a recording over the WebSocket as if it were a live session
+// Query parameters: replay (recording ID), speed (default 1) and idle_limit (seconds; longer pauses are shortened)
+// The client receives a connected status, data messages at the recorded pace and a disconnect at the end.
+func (h *WebSocketHandler) handleReplay(w http.ResponseWriter, r *http.Request, userID string) {
+	if h.recordings == nil {
+		http.Error(w, "Replay is not available", http.StatusServiceUnavailable)
+		return
+	}
+	query := r.URL.Query()
+	recordingID, err := uuid.Parse(query.Get("replay"))
+	if err != nil {
+		http.Error(w, "Invalid recording ID", http.StatusBadRequest)
+		return
+	}
+	opts := sessionlog.ReplayOptions{Speed: 1}
+	if s := query.Get("speed"); s != "" {
+		speed, err := strconv.ParseFloat(s, 64)
+		if err != nil || speed < minReplaySpeed || speed > maxReplaySpeed {
+			http.Error(w, "speed must be between 0.1 and 16", http.StatusBadRequest)
+			return
+		}
+		opts.Speed = speed
+	}
+	if s := query.Get("idle_limit"); s != "" {
+		seconds, err := strconv.ParseFloat(s, 64)
+		if err != nil || seconds <= 0 {
+			http.Error(w, "idle_limit must be a number of seconds", http.StatusBadRequest)
+			return
+		}
+		opts.IdleLimit = time.Duration(seconds * float64(time.Second))
+	}
+
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	rec, err := h.recordings.GetRecording(userUUID, recordingID)
+	if err != nil {
+		log.Printf("Get recording for replay failed: %v", err)
+		http.Error(w, "Failed to load recording", http.StatusInternalServerError)
+		return
+	}
+	if rec == nil {
+		http.Error(w, "Recording not found", http.StatusNotFound)
+		return
+	}
+
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade for replay failed: %v", err)
+		return
+	}
+	defer conn.Close()
+	defer h.trackLogin(r, conn)()
+	conn.SetReadLimit(65536)
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	log.Printf("Replay started: user=%s, recording=%s, speed=%v", userID, rec.ID, opts.Speed)
+	if err := h.writeJSON(conn, WSMessage{Type: MsgTypeStatus, Status: StateConnected, Host: rec.Host, Port: rec.Port}); err != nil {
+		return
+	}
+
+	go func() {
+		defer cancel()
+		err := sessionlog.Replay(ctx, h.recordings, rec.ID, opts, func(data string) error {
+			return h.writeJSON(conn, WSMessage{Type: MsgTypeData, Data: data})
+		})
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Replay of recording %s failed: %v", rec.ID, err)
+			}
+			return
+		}
+		h.writeJSON(conn, WSMessage{Type: MsgTypeDisconnect, Status: StateDisconnected})
+		h.writeMessage(conn, websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "replay finished"))
+		// Give the client a moment to answer the close before the read loop gives up
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	}()
+	go h.pingUntilDone(ctx, conn)
+
+	// Replays are read-only; the client may only end them early
+	for {
+		msgType, msg, err := conn.ReadMessage()
+		if err != nil {
+			log.Printf("Replay ended: user=%s, recording=%s", userID, rec.ID)
+			return
+		}
+		if msgType != websocket.TextMessage {
+			continue
+		}
+		var wsMsg WSMessage
+		if err := json.Unmarshal(msg, &wsMsg); err != nil {
+			h.sendError(conn, "Invalid message format")
+			continue
+		}
+		switch wsMsg.Type {
+		case MsgTypeDisconnect:
+			return
+		case MsgTypeData, MsgTypeConnect:
+			h.sendError(conn, "Replays are read-only")
+		}
+	}
+}
+
+// pingUntilDone keeps a WebSocket alive through long pauses until ctx is cancelled
+func (h *WebSocketHandler) pingUntilDone(ctx context.Context, conn *websocket.Conn) {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := h.writeMessage(conn, websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package session
+
+import (
+	"bytes"
+	"sync"
+)
+
+// Scrollback limits
+const (
+	DefaultScrollbackLines = 5000
+	defaultScrollbackPage  = 200
+	MaxScrollbackPage      = 1000     // Lines returned by one scrollback request
+	maxScrollbackLine      = 8 * 1024 // Longer lines are split so a prompt without a newline stays bounded
+	maxScrollbackBytes     = 2 << 20  // Total bytes kept per session, whatever the line limit
+)
+
+// Scrollback keeps the most recent output of a session so clients that attach later can page back through it.
+// Positions in the output are cursors: the number of bytes sent to clients since the session started.
+type Scrollback struct {
+	mu       sync.Mutex
+	maxLines int
+	lines    []scrollbackLine // complete lines, oldest first
+	bytes    int              // bytes held in lines
+	partial  []byte           // output after the last newline
+	end      int64            // cursor at the end of the output
+}
+
+type scrollbackLine struct {
+	start int64
+	data  []byte // the line as sent, including its newline
+}
+
+// ScrollbackPage is a run of output between two cursors
+// Data is the output exactly as it was sent to clients, ANSI codes included.
+type ScrollbackPage struct {
+	Data    string `json:"data"`
+	Start   int64  `json:"start"`
+	End     int64  `json:"end"`
+	Lines   int    `json:"lines"`
+	HasMore bool   `json:"has_more"`
+}
+
+// NewScrollback creates a scrollback that keeps up to maxLines lines
+func NewScrollback(maxLines int) *Scrollback {
+	if maxLines <= 0 {
+		maxLines = DefaultScrollbackLines
+	}
+	return &Scrollback{maxLines: maxLines}
+}
+
+// Append adds output and returns the cursor at its end
+func (s *Scrollback) Append(data []byte) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(data) > 0 {
+		n := bytes.IndexByte(data, '\n') + 1
+		if n == 0 {
+			n = len(data)
+		}
+		if room := maxScrollbackLine - len(s.partial); n > room {
+			n = room
+		}
+		s.partial = append(s.partial, data[:n]...)
+		s.end += int64(n)
+		data = data[n:]
+		if s.partial[len(s.partial)-1] == '\n' || len(s.partial) >= maxScrollbackLine {
+			s.pushLine()
+		}
+	}
+	return s.end
+}
+
+// pushLine moves the partial line into the complete lines, trimming the oldest lines past the limits
+func (s *Scrollback) pushLine() {
+	line := scrollbackLine{start: s.end - int64(len(s.partial)), data: s.partial}
+	s.partial = nil
+	s.lines = append(s.lines, line)
+	s.bytes += len(line.data)
+
+	drop := 0
+	for drop < len(s.lines)-1 && (len(s.lines)-drop > s.maxLines || s.bytes > maxScrollbackBytes) {
+		s.bytes -= len(s.lines[drop].data)
+		drop++
+	}
+	// Appending past the slice's capacity copies only the kept lines, so reslicing does not leak
+	s.lines = s.lines[drop:]
+}
+
+// Cursor returns the cursor at the end of the output
+func (s *Scrollback) Cursor() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.end
+}
+
+// Page returns up to limit lines ending at before, oldest first
+// With before < 0 the page ends with the latest output, including a line still waiting for its newline.
+// Paging further back uses the returned Start as the next before.
+func (s *Scrollback) Page(before int64, limit int) ScrollbackPage {
+	if limit < 1 {
+		limit = 1
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	latest := before < 0 || before >= s.end
+	// Lines up to last end at or before the cursor
+	last := len(s.lines)
+	if !latest {
+		for last > 0 && s.lines[last-1].start+int64(len(s.lines[last-1].data)) > before {
+			last--
+		}
+	}
+	first := last - limit
+	if latest && len(s.partial) > 0 {
+		first++
+	}
+	if first < 0 {
+		first = 0
+	}
+
+	var buf bytes.Buffer
+	page := ScrollbackPage{End: s.end}
+	for _, line := range s.lines[first:last] {
+		buf.Write(line.data)
+	}
+	page.Lines = last - first
+	if latest && len(s.partial) > 0 {
+		buf.Write(s.partial)
+		page.Lines++
+	} else if !latest {
+		page.End = s.end - int64(len(s.partial))
+		if last < len(s.lines) {
+			page.End = s.lines[last].start
+		}
+	}
+	page.Start = page.End - int64(buf.Len())
+	page.Data = buf.String()
+	page.HasMore = first > 0
+	return page
+}
@@ -0,0 +1,315 @@
+package session
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Spectator sharing limits
+const (
+	DefaultShareMinutes     = 60
+	MaxShareMinutes         = 24 * 60
+	MaxSharesPerSession     = 10
+	MaxSpectatorsPerSession = 20
+	spectatorBuffer         = 256 // Output messages queued per spectator; a spectator that falls further behind is dropped
+	spectatorHistoryLines   = 200 // Scrollback lines sent to a spectator when it attaches
+)
+
+// Reasons a spectator is detached
+const (
+	SpectateRevoked = "revoked"
+	SpectateExpired = "expired"
+	SpectateEnded   = "session_ended"
+	SpectateSlow    = "too_slow"
+)
+
+// Errors returned when attaching a spectator
+var (
+	ErrShareNotFound   = errors.New("share link is invalid or has expired")
+	ErrOwnSession      = errors.New("you cannot spectate your own session")
+	ErrTooManyWatchers = fmt.Errorf("a session can have at most %d spectators", MaxSpectatorsPerSession)
+)
+
+// Share is a time-limited token that lets other users watch a live session read-only
+// Only a hash of the token is kept; the token itself is shown once, when the share is created.
+type Share struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+
+	ownerID   string
+	tokenHash string
+}
+
+// Spectator is a user watching another user's session
+type Spectator struct {
+	ID         uuid.UUID `json:"id"`
+	ShareID    uuid.UUID `json:"share_id"`
+	UserID     string    `json:"user_id"`
+	AttachedAt time.Time `json:"attached_at"`
+
+	ownerID   string
+	expiresAt time.Time
+	output    chan spectatorOutput
+	done      chan struct{}
+	reason    string
+}
+
+type spectatorOutput struct {
+	data   []byte
+	cursor int64
+}
+
+// Done is closed when the spectator is detached
+func (s *Spectator) Done() <-chan struct{} {
+	return s.done
+}
+
+// Reason returns why the spectator was detached, once Done is closed
+func (s *Spectator) Reason() string {
+	<-s.done
+	return s.reason
+}
+
+// spectators holds the shares and spectators of live sessions
+type spectators struct {
+	mu       sync.Mutex
+	shares   map[string]*Share                   // token hash -> share
+	watchers map[string]map[uuid.UUID]*Spectator // owner userID -> spectator ID -> spectator
+}
+
+func hashShareToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateShare creates a share token for the user's live session, valid for ttl
+func (m *Manager) CreateShare(ownerID string, ttl time.Duration) (*Share, string, error) {
+	session, err := m.GetSession(ownerID)
+	if err != nil || session.State != StateConnected {
+		return nil, "", fmt.Errorf("no active session to share")
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, "", err
+	}
+	token := hex.EncodeToString(buf)
+
+	m.spectate.mu.Lock()
+	defer m.spectate.mu.Unlock()
+	m.pruneShares()
+
+	count := 0
+	for _, share := range m.spectate.shares {
+		if share.ownerID == ownerID {
+			count++
+		}
+	}
+	if count >= MaxSharesPerSession {
+		return nil, "", fmt.Errorf("a session can have at most %d share links", MaxSharesPerSession)
+	}
+
+	now := time.Now()
+	share := &Share{
+		ID:        uuid.New(),
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+		ownerID:   ownerID,
+		tokenHash: hashShareToken(token),
+	}
+	m.spectate.shares[share.tokenHash] = share
+	log.Printf("Share created: user=%s, share=%s, expires=%s", ownerID, share.ID, share.ExpiresAt.Format(time.RFC3339))
+	return share, token, nil
+}
+
+// Shares returns the user's unexpired shares, oldest first
+func (m *Manager) Shares(ownerID string) []Share {
+	m.spectate.mu.Lock()
+	defer m.spectate.mu.Unlock()
+	m.pruneShares()
+
+	shares := []Share{}
+	for _, share := range m.spectate.shares {
+		if share.ownerID == ownerID {
+			shares = append(shares, *share)
+		}
+	}
+	sort.Slice(shares, func(i, j int) bool { return shares[i].CreatedAt.Before(shares[j].CreatedAt) })
+	return shares
+}
+
+// RevokeShare deletes a share and detaches the spectators who joined with it
+func (m *Manager) RevokeShare(ownerID string, shareID uuid.UUID) bool {
+	m.spectate.mu.Lock()
+	defer m.spectate.mu.Unlock()
+
+	found := false
+	for hash, share := range m.spectate.shares {
+		if share.ownerID == ownerID && share.ID == shareID {
+			delete(m.spectate.shares, hash)
+			found = true
+		}
+	}
+	for _, spectator := range m.spectate.watchers[ownerID] {
+		if spectator.ShareID == shareID {
+			m.detachLocked(spectator, SpectateRevoked)
+			found = true
+		}
+	}
+	return found
+}
+
+// AttachSpectator adds userID as a spectator of the session the token was created for
+func (m *Manager) AttachSpectator(token, userID string) (*Spectator, *Session, error) {
+	hash := hashShareToken(token)
+	m.spectate.mu.Lock()
+	m.pruneShares()
+	share, ok := m.spectate.shares[hash]
+	m.spectate.mu.Unlock()
+	if !ok {
+		return nil, nil, ErrShareNotFound
+	}
+	if share.ownerID == userID {
+		return nil, nil, ErrOwnSession
+	}
+
+	// The session is looked up without holding the spectator lock, which Disconnect takes after the manager's lock
+	session, err := m.GetSession(share.ownerID)
+	if err != nil || session.State != StateConnected {
+		return nil, nil, ErrShareNotFound
+	}
+
+	m.spectate.mu.Lock()
+	defer m.spectate.mu.Unlock()
+	// A share is deleted when its session ends, so it still existing means the session is live
+	if m.spectate.shares[hash] != share {
+		return nil, nil, ErrShareNotFound
+	}
+	if len(m.spectate.watchers[share.ownerID]) >= MaxSpectatorsPerSession {
+		return nil, nil, ErrTooManyWatchers
+	}
+
+	spectator := &Spectator{
+		ID:         uuid.New(),
+		ShareID:    share.ID,
+		UserID:     userID,
+		AttachedAt: time.Now(),
+		ownerID:    share.ownerID,
+		expiresAt:  share.ExpiresAt,
+		output:     make(chan spectatorOutput, spectatorBuffer),
+		done:       make(chan struct{}),
+	}
+	if m.spectate.watchers[share.ownerID] == nil {
+		m.spectate.watchers[share.ownerID] = make(map[uuid.UUID]*Spectator)
+	}
+	m.spectate.watchers[share.ownerID][spectator.ID] = spectator
+	log.Printf("Spectator attached: owner=%s, spectator=%s, user=%s", share.ownerID, spectator.ID, userID)
+	return spectator, session, nil
+}
+
+// Spectators returns the users watching the user's session, in the order they attached
+func (m *Manager) Spectators(ownerID string) []Spectator {
+	m.spectate.mu.Lock()
+	defer m.spectate.mu.Unlock()
+
+	list := make([]Spectator, 0, len(m.spectate.watchers[ownerID]))
+	for _, spectator := range m.spectate.watchers[ownerID] {
+		list = append(list, Spectator{
+			ID:         spectator.ID,
+			ShareID:    spectator.ShareID,
+			UserID:     spectator.UserID,
+			AttachedAt: spectator.AttachedAt,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].AttachedAt.Before(list[j].AttachedAt) })
+	return list
+}
+
+// RemoveSpectator detaches one spectator from the user's session
+func (m *Manager) RemoveSpectator(ownerID string, spectatorID uuid.UUID) bool {
+	m.spectate.mu.Lock()
+	defer m.spectate.mu.Unlock()
+
+	spectator, ok := m.spectate.watchers[ownerID][spectatorID]
+	if !ok {
+		return false
+	}
+	m.detachLocked(spectator, SpectateRevoked)
+	return true
+}
+
+// DetachSpectator removes a spectator whose connection has closed, or whose share has expired
+func (m *Manager) DetachSpectator(spectator *Spectator, reason string) {
+	m.spectate.mu.Lock()
+	defer m.spectate.mu.Unlock()
+	m.detachLocked(spectator, reason)
+}
+
+// BroadcastOutput queues output sent to the owner's client for each spectator
+// cursor is the scrollback position at the end of data.
+func (m *Manager) BroadcastOutput(ownerID string, data []byte, cursor int64) {
+	if len(data) == 0 {
+		return
+	}
+	m.spectate.mu.Lock()
+	defer m.spectate.mu.Unlock()
+
+	for _, spectator := range m.spectate.watchers[ownerID] {
+		select {
+		case spectator.output <- spectatorOutput{data: data, cursor: cursor}:
+		default:
+			log.Printf("Spectator %s of user %s fell behind", spectator.ID, ownerID)
+			m.detachLocked(spectator, SpectateSlow)
+		}
+	}
+}
+
+// endSharing detaches all spectators of the user's session and deletes its shares
+func (m *Manager) endSharing(ownerID string) {
+	m.spectate.mu.Lock()
+	defer m.spectate.mu.Unlock()
+
+	for hash, share := range m.spectate.shares {
+		if share.ownerID == ownerID {
+			delete(m.spectate.shares, hash)
+		}
+	}
+	for _, spectator := range m.spectate.watchers[ownerID] {
+		m.detachLocked(spectator, SpectateEnded)
+	}
+}
+
+// detachLocked removes a spectator; the caller holds m.spectate.mu
+func (m *Manager) detachLocked(spectator *Spectator, reason string) {
+	watchers := m.spectate.watchers[spectator.ownerID]
+	if watchers[spectator.ID] != spectator {
+		return
+	}
+	delete(watchers, spectator.ID)
+	if len(watchers) == 0 {
+		delete(m.spectate.watchers, spectator.ownerID)
+	}
+	spectator.reason = reason
+	close(spectator.done)
+	log.Printf("Spectator detached: owner=%s, spectator=%s, reason=%s", spectator.ownerID, spectator.ID, reason)
+}
+
+// pruneShares deletes expired shares; the caller holds m.spectate.mu
+func (m *Manager) pruneShares() {
+	now := time.Now()
+	for hash, share := range m.spectate.shares {
+		if now.After(share.ExpiresAt) {
+			delete(m.spectate.shares, hash)
+		}
+	}
+}
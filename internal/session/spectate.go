@@ -0,0 +1,257 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// handleSpectate attaches a read-only spectator to another user's live session
+// Query parameter: spectate (share token). The spectator receives a connected status, recent scrollback,
+// then the owner's output as it arrives, and a disconnect with the reason when it is detached.
+func (h *WebSocketHandler) handleSpectate(w http.ResponseWriter, r *http.Request, userID string) {
+	spectator, session, err := h.manager.AttachSpectator(r.URL.Query().Get("spectate"), userID)
+	if err != nil {
+		status := http.StatusNotFound
+		switch {
+		case errors.Is(err, ErrOwnSession):
+			status = http.StatusBadRequest
+		case errors.Is(err, ErrTooManyWatchers):
+			status = http.StatusConflict
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	defer h.manager.DetachSpectator(spectator, SpectateEnded)
+
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade for spectator failed: %v", err)
+		return
+	}
+	defer conn.Close()
+	defer h.trackLogin(r, conn)()
+	conn.SetReadLimit(65536)
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	if err := h.writeJSON(conn, WSMessage{Type: MsgTypeStatus, Status: StateConnected, Host: session.Host, Port: session.Port}); err != nil {
+		return
+	}
+
+	go func() {
+		defer cancel()
+		h.relayToSpectator(ctx, conn, spectator)
+	}()
+	go h.pingUntilDone(ctx, conn)
+
+	// Spectators are read-only; they may only leave
+	for {
+		msgType, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if msgType != websocket.TextMessage {
+			continue
+		}
+		var wsMsg WSMessage
+		if err := json.Unmarshal(msg, &wsMsg); err != nil {
+			h.sendError(conn, "Invalid message format")
+			continue
+		}
+		switch wsMsg.Type {
+		case MsgTypeDisconnect:
+			return
+		case MsgTypeData, MsgTypeConnect:
+			h.sendError(conn, "Spectators cannot send commands")
+		}
+	}
+}
+
+// relayToSpectator sends the owner's recent scrollback and then its live output until the spectator is detached
+// Cursors are used to skip output that was already sent as scrollback.
+func (h *WebSocketHandler) relayToSpectator(ctx context.Context, conn *websocket.Conn, spectator *Spectator) {
+	var sent int64
+	if scrollback := h.manager.Scrollback(spectator.ownerID); scrollback != nil {
+		page := scrollback.Page(-1, spectatorHistoryLines)
+		sent = page.End
+		if page.Data != "" {
+			if err := h.writeJSON(conn, WSMessage{Type: MsgTypeData, Data: page.Data, Cursor: page.End}); err != nil {
+				return
+			}
+		}
+	}
+
+	expiry := time.NewTimer(time.Until(spectator.expiresAt))
+	defer expiry.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-expiry.C:
+			h.manager.DetachSpectator(spectator, SpectateExpired)
+		case out := <-spectator.output:
+			data := out.data
+			if start := out.cursor - int64(len(data)); start < sent {
+				if out.cursor <= sent {
+					continue
+				}
+				data = data[sent-start:]
+			}
+			if err := h.writeJSON(conn, WSMessage{Type: MsgTypeData, Data: string(data), Cursor: out.cursor}); err != nil {
+				return
+			}
+			sent = out.cursor
+		case <-spectator.Done():
+			h.writeJSON(conn, WSMessage{Type: MsgTypeDisconnect, Status: StateDisconnected, Error: spectator.Reason()})
+			h.writeMessage(conn, websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, spectator.Reason()))
+			// Give the client a moment to answer the close before the read loop gives up
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			return
+		}
+	}
+}
+
+// Request/Response types for session sharing
+
+type CreateShareRequest struct {
+	ExpiresInMinutes int `json:"expires_in_minutes,omitempty"`
+}
+
+// CreateShareResponse includes the share token, which is only ever returned here
+type CreateShareResponse struct {
+	Share
+	Token string `json:"token"`
+}
+
+type SharesResponse struct {
+	Items []Share `json:"items"`
+}
+
+// SpectatorInfo is a spectator as listed to the session owner
+type SpectatorInfo struct {
+	Spectator
+	Email string `json:"email,omitempty"`
+}
+
+type SpectatorsResponse struct {
+	Items []SpectatorInfo `json:"items"`
+}
+
+// ListShares handles GET /api/v1/session/shares
+func (h *Handler) ListShares(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id")
+	if userID == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	h.sendJSON(w, SharesResponse{Items: h.manager.Shares(userID.(string))})
+}
+
+// CreateShare handles POST /api/v1/session/shares
+// Creates a time-limited token that lets another user watch the live session read-only
+func (h *Handler) CreateShare(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id")
+	if userID == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userIDStr := userID.(string)
+
+	var req CreateShareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.sendError(w, "Invalid request body")
+		return
+	}
+	if req.ExpiresInMinutes == 0 {
+		req.ExpiresInMinutes = DefaultShareMinutes
+	}
+	if req.ExpiresInMinutes < 1 || req.ExpiresInMinutes > MaxShareMinutes {
+		h.sendError(w, fmt.Sprintf("expires_in_minutes must be between 1 and %d", MaxShareMinutes))
+		return
+	}
+
+	share, token, err := h.manager.CreateShare(userIDStr, time.Duration(req.ExpiresInMinutes)*time.Minute)
+	if err != nil {
+		log.Printf("Create share failed: user=%s, error=%v", userIDStr, err)
+		h.sendError(w, err.Error())
+		return
+	}
+	h.sendJSON(w, CreateShareResponse{Share: *share, Token: token})
+}
+
+// RevokeShare handles DELETE /api/v1/session/shares/:id
+// Spectators who joined with the share are detached
+func (h *Handler) RevokeShare(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id")
+	if userID == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	shareID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		h.sendError(w, "Invalid share ID")
+		return
+	}
+
+	if !h.manager.RevokeShare(userID.(string), shareID) {
+		h.sendError(w, "Share not found")
+		return
+	}
+	h.sendJSON(w, map[string]string{"status": "revoked"})
+}
+
+// ListSpectators handles GET /api/v1/session/spectators
+func (h *Handler) ListSpectators(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id")
+	if userID == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	spectators := h.manager.Spectators(userID.(string))
+	resp := SpectatorsResponse{Items: make([]SpectatorInfo, 0, len(spectators))}
+	for _, spectator := range spectators {
+		info := SpectatorInfo{Spectator: spectator}
+		if h.callbacks != nil && h.callbacks.LookupEmail != nil {
+			if spectatorUUID, err := uuid.Parse(spectator.UserID); err == nil {
+				if email, err := h.callbacks.LookupEmail(spectatorUUID); err != nil {
+					log.Printf("Failed to look up spectator email: %v", err)
+				} else {
+					info.Email = email
+				}
+			}
+		}
+		resp.Items = append(resp.Items, info)
+	}
+	h.sendJSON(w, resp)
+}
+
+// RemoveSpectator handles DELETE /api/v1/session/spectators/:id
+func (h *Handler) RemoveSpectator(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("user_id")
+	if userID == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	spectatorID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		h.sendError(w, "Invalid spectator ID")
+		return
+	}
+
+	if !h.manager.RemoveSpectator(userID.(string), spectatorID) {
+		h.sendError(w, "Spectator not found")
+		return
+	}
+	h.sendJSON(w, map[string]string{"status": "removed"})
+}
@@ -0,0 +1,163 @@
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// Telnet command bytes
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetWONT = 252
+	telnetDO   = 253
+	telnetDONT = 254
+	telnetIAC  = 255
+
+	telnetOptEcho = 1
+	telnetOptGMCP = 201
+)
+
+// maxSubnegotiation bounds a single IAC SB ... IAC SE payload; larger ones are discarded
+const maxSubnegotiation = 64 * 1024
+
+// Telnet parser states
+const (
+	telnetStateData = iota
+	telnetStateIAC
+	telnetStateOption // After WILL/WONT/DO/DONT, waiting for the option byte
+	telnetStateSB     // Inside a subnegotiation
+	telnetStateSBIAC  // IAC inside a subnegotiation
+)
+
+// telnetEvent is a negotiation or completed subnegotiation seen in MUD output
+type telnetEvent struct {
+	Command byte   // WILL, WONT, DO, DONT or SB
+	Option  byte   // Telnet option, e.g. telnetOptGMCP
+	Data    []byte // Subnegotiation payload (SB only)
+}
+
+// telnetFilter removes telnet commands from a MUD stream
+// Unlike a per-chunk strip it keeps state between reads, so sequences split across
+// reads and subnegotiation payloads (GMCP) never leak into the text sent to the client
+type telnetFilter struct {
+	state   int
+	command byte
+	sb      []byte
+	sbLarge bool
+}
+
+// Filter returns the text in data with telnet commands removed, and the negotiations seen
+func (f *telnetFilter) Filter(data []byte) ([]byte, []telnetEvent) {
+	if f.state == telnetStateData && bytes.IndexByte(data, telnetIAC) < 0 {
+		return data, nil
+	}
+
+	var events []telnetEvent
+	text := make([]byte, 0, len(data))
+	for _, b := range data {
+		switch f.state {
+		case telnetStateData:
+			if b == telnetIAC {
+				f.state = telnetStateIAC
+			} else {
+				text = append(text, b)
+			}
+
+		case telnetStateIAC:
+			switch b {
+			case telnetIAC: // IAC IAC - escaped literal 255
+				text = append(text, telnetIAC)
+				f.state = telnetStateData
+			case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+				f.command = b
+				f.state = telnetStateOption
+			case telnetSB:
+				f.sb = f.sb[:0]
+				f.sbLarge = false
+				f.state = telnetStateSB
+			default:
+				// Two-byte commands (GA, NOP, ...) carry no payload
+				f.state = telnetStateData
+			}
+
+		case telnetStateOption:
+			events = append(events, telnetEvent{Command: f.command, Option: b})
+			f.state = telnetStateData
+
+		case telnetStateSB:
+			if b == telnetIAC {
+				f.state = telnetStateSBIAC
+			} else {
+				f.appendSB(b)
+			}
+
+		case telnetStateSBIAC:
+			switch b {
+			case telnetSE:
+				if len(f.sb) > 0 && !f.sbLarge {
+					events = append(events, telnetEvent{
+						Command: telnetSB,
+						Option:  f.sb[0],
+						Data:    append([]byte(nil), f.sb[1:]...),
+					})
+				}
+				f.state = telnetStateData
+			case telnetIAC:
+				f.appendSB(telnetIAC)
+				f.state = telnetStateSB
+			default:
+				// Malformed subnegotiation; treat the byte as a new command
+				f.state = telnetStateIAC
+				text, events = f.continueIAC(b, text, events)
+			}
+		}
+	}
+	return text, events
+}
+
+// continueIAC reprocesses a byte that followed an unexpected IAC inside a subnegotiation
+func (f *telnetFilter) continueIAC(b byte, text []byte, events []telnetEvent) ([]byte, []telnetEvent) {
+	t, ev := f.Filter([]byte{b})
+	return append(text, t...), append(events, ev...)
+}
+
+func (f *telnetFilter) appendSB(b byte) {
+	if len(f.sb) >= maxSubnegotiation {
+		f.sbLarge = true
+		return
+	}
+	f.sb = append(f.sb, b)
+}
+
+// parseGMCP splits a GMCP payload "Package.Message {json}" into its package name and data
+func parseGMCP(payload []byte) (string, []byte) {
+	payload = bytes.TrimSpace(payload)
+	if i := bytes.IndexAny(payload, " \t\r\n"); i >= 0 {
+		return string(payload[:i]), bytes.TrimSpace(payload[i+1:])
+	}
+	return string(payload), nil
+}
+
+// gmcpMessage frames a GMCP message for sending to the MUD
+func gmcpMessage(pkg string, data interface{}) []byte {
+	msg := []byte{telnetIAC, telnetSB, telnetOptGMCP}
+	msg = append(msg, pkg...)
+	if data != nil {
+		encoded, err := json.Marshal(data)
+		if err == nil {
+			msg = append(msg, ' ')
+			msg = append(msg, bytes.ReplaceAll(encoded, []byte{telnetIAC}, []byte{telnetIAC, telnetIAC})...)
+		}
+	}
+	return append(msg, telnetIAC, telnetSE)
+}
+
+// gmcpHandshake is sent after agreeing to GMCP: enable GMCP and subscribe to room updates
+func gmcpHandshake() []byte {
+	msg := []byte{telnetIAC, telnetDO, telnetOptGMCP}
+	msg = append(msg, gmcpMessage("Core.Hello", map[string]string{"client": "MudPuppy", "version": "1.0"})...)
+	msg = append(msg, gmcpMessage("Core.Supports.Set", []string{"Room 1"})...)
+	return msg
+}
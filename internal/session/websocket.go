@@ -8,6 +8,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/amaranth494/MudPuppy/internal/automation"
 	"github.com/amaranth494/MudPuppy/internal/config"
 	"github.com/amaranth494/MudPuppy/internal/metrics"
 	"github.com/gorilla/websocket"
@@ -20,6 +21,8 @@ const (
 	MsgTypeData       = "data"
 	MsgTypeError      = "error"
 	MsgTypeStatus     = "status"
+	MsgTypeAutomation = "automation" // Automation command replies and engine events
+	MsgTypeCapture    = "capture"    // Lines routed to a capture stream by triggers
 )
 
 // WebSocket message structure
@@ -30,6 +33,20 @@ type WSMessage struct {
 	Data   string `json:"data,omitempty"`
 	Error  string `json:"error,omitempty"`
 	Status string `json:"status,omitempty"`
+
+	// Cursor is the session's scrollback position at the end of a data message's output
+	Cursor int64 `json:"cursor,omitempty"`
+
+	// Event carries server-side automation events
+	Event *automation.Event `json:"event,omitempty"`
+
+	// Script carries the alias arguments or trigger line for a #script action sent by the client
+	Script *automation.ScriptContext `json:"script,omitempty"`
+
+	// Stream and Lines carry capture stream output; History marks a stream's scrollback
+	Stream  string                   `json:"stream,omitempty"`
+	Lines   []automation.CaptureLine `json:"lines,omitempty"`
+	History bool                     `json:"history,omitempty"`
 }
 
 // RateLimiter implements a simple token bucket rate limiter
@@ -78,7 +95,10 @@ type WebSocketHandler struct {
 	upgrader       websocket.Upgrader
 	rateLimiters   map[string]*RateLimiter
 	rateLimitersMu sync.RWMutex
-	wsWriteMu      sync.Mutex // Protects WebSocket writes from concurrent goroutines
+	wsWriteMu      sync.Mutex                              // Protects WebSocket writes from concurrent goroutines
+	recordings     ReplayStore                             // Recordings played back with ?replay=; nil disables replay
+	logins         map[string]map[*websocket.Conn]struct{} // Open WebSockets by the login (auth session) that opened them
+	loginsMu       sync.Mutex
 }
 
 // NewWebSocketHandler creates a new WebSocket handler
@@ -95,6 +115,54 @@ func NewWebSocketHandler(manager *Manager, cfg *config.Config) *WebSocketHandler
 			},
 		},
 		rateLimiters: make(map[string]*RateLimiter),
+		logins:       make(map[string]map[*websocket.Conn]struct{}),
+	}
+}
+
+// trackLogin records a WebSocket under the login it was opened with, so revoking the login can close it
+// The returned function stops tracking it.
+func (h *WebSocketHandler) trackLogin(r *http.Request, conn *websocket.Conn) func() {
+	loginID, _ := r.Context().Value("auth_session_id").(string)
+	if loginID == "" {
+		return func() {}
+	}
+
+	h.loginsMu.Lock()
+	if h.logins[loginID] == nil {
+		h.logins[loginID] = make(map[*websocket.Conn]struct{})
+	}
+	h.logins[loginID][conn] = struct{}{}
+	h.loginsMu.Unlock()
+
+	return func() {
+		h.loginsMu.Lock()
+		delete(h.logins[loginID], conn)
+		if len(h.logins[loginID]) == 0 {
+			delete(h.logins, loginID)
+		}
+		h.loginsMu.Unlock()
+	}
+}
+
+// CloseLogins closes every WebSocket opened by the given logins, after they were logged out or revoked
+// Login IDs are the public session IDs from the auth package.
+func (h *WebSocketHandler) CloseLogins(loginIDs []string) {
+	var conns []*websocket.Conn
+	h.loginsMu.Lock()
+	for _, loginID := range loginIDs {
+		for conn := range h.logins[loginID] {
+			conns = append(conns, conn)
+		}
+	}
+	h.loginsMu.Unlock()
+
+	for _, conn := range conns {
+		// Closing the connection ends its read loop, which cleans up as if the client had left
+		h.writeMessage(conn, websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "logged out"))
+		conn.Close()
+	}
+	if len(conns) > 0 {
+		log.Printf("[SP02PH02] Closed %d WebSocket(s) for revoked logins", len(conns))
 	}
 }
 
@@ -141,6 +209,7 @@ func (h *WebSocketHandler) writeMessage(conn *websocket.Conn, msgType int, data
 }
 
 // HandleWebSocket handles WebSocket connections at /api/v1/session/stream
+// With ?replay=<recording id> it replays a recording instead
 func (h *WebSocketHandler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	// Get user ID from context (set by session middleware)
 	userID := r.Context().Value("user_id")
@@ -151,6 +220,17 @@ func (h *WebSocketHandler) HandleWebSocket(w http.ResponseWriter, r *http.Reques
 	}
 	userIDStr := userID.(string)
 
+	// ?replay=<recording id> plays back a recording instead of a live session
+	if r.URL.Query().Has("replay") {
+		h.handleReplay(w, r, userIDStr)
+		return
+	}
+	// ?spectate=<share token> watches another user's session read-only
+	if r.URL.Query().Has("spectate") {
+		h.handleSpectate(w, r, userIDStr)
+		return
+	}
+
 	log.Printf("[SP02PH02] WebSocket connection from user: %s at %v", userIDStr, time.Now().UnixNano())
 
 	// Upgrade HTTP to WebSocket
@@ -160,6 +240,7 @@ func (h *WebSocketHandler) HandleWebSocket(w http.ResponseWriter, r *http.Reques
 		return
 	}
 	defer conn.Close()
+	defer h.trackLogin(r, conn)()
 
 	// Set read limit to prevent memory exhaustion (SP02 hardening)
 	conn.SetReadLimit(65536) // 64KB max message size
@@ -334,6 +415,9 @@ func (h *WebSocketHandler) HandleWebSocket(w http.ResponseWriter, r *http.Reques
 			go h.relayMUDToClient(ctx, userIDStr, conn, mudToClient)
 			log.Printf("[SP02PH02] Started relay at %v", time.Now().UnixNano())
 
+			// Forward automation events (class toggles etc.) for sessions bound to a profile
+			go h.relayAutomationEvents(ctx, userIDStr, conn)
+
 		case MsgTypeDisconnect:
 			if connected {
 				log.Printf("[SP02PH02] User requested disconnect")
@@ -362,6 +446,12 @@ func (h *WebSocketHandler) HandleWebSocket(w http.ResponseWriter, r *http.Reques
 				continue
 			}
 
+			// Server-side automation commands are handled here, not sent to the MUD
+			if automation.IsCommand(wsMsg.Data) {
+				h.handleAutomationCommand(conn, userIDStr, wsMsg.Data, wsMsg.Script, clientToMUD)
+				continue
+			}
+
 			// Send command to MUD via channel
 			log.Printf("[SP02PH02] TRACE: Received WebSocket message at %v: %q", time.Now().UnixNano(), wsMsg.Data)
 			select {
@@ -436,20 +526,30 @@ func (h *WebSocketHandler) relayMUDToClient(ctx context.Context, userID string,
 	log.Printf("[SP02PH02] relayMUDToClient started at %v", time.Now().UnixNano())
 
 	var coalesceBuffer []byte
+	var cursor int64
 	lastSendTime := time.Now()
 	dropCount := 0
 	sustainedDropCount := 0
+	telnet := &telnetFilter{}
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case data := <-mudToClient:
+			receivedAt := time.Now()
+
 			// Reset idle timer on inbound data
 			h.manager.ResetIdleTimerOnInbound(userID)
 
-			// Strip telnet IAC sequences before sending to client
-			cleanData := stripTelnetIAC(data)
+			// Strip telnet commands before sending to client; negotiations feed the automapper
+			// Logs and recordings keep lines that triggers moved to capture streams
+			text, events := telnet.Filter(data)
+			cleanData := h.handleTelnet(userID, text, events)
+			h.manager.RecordOutput(userID, text, receivedAt)
+			// The scrollback holds what clients are sent, so a client can join it with the live output
+			end := h.manager.AppendScrollback(userID, cleanData)
+			h.manager.BroadcastOutput(userID, cleanData, end)
 
 			log.Printf("[SP02PH02] TRACE: Forwarding %d bytes to WebSocket at %v", len(cleanData), time.Now().UnixNano())
 
@@ -459,11 +559,12 @@ func (h *WebSocketHandler) relayMUDToClient(ctx context.Context, userID string,
 			} else {
 				// Buffer full - send current and start new
 				if len(coalesceBuffer) > 0 {
-					h.sendCoalescedData(conn, userID, coalesceBuffer, &dropCount, &sustainedDropCount)
+					h.sendCoalescedData(conn, userID, coalesceBuffer, cursor, &dropCount, &sustainedDropCount)
 					coalesceBuffer = nil
 				}
 				coalesceBuffer = append(coalesceBuffer, cleanData...)
 			}
+			cursor = end
 
 			// Record metrics for outgoing bytes to client
 			metrics.Get().AddMudBytesOut(int64(len(cleanData)))
@@ -471,7 +572,7 @@ func (h *WebSocketHandler) relayMUDToClient(ctx context.Context, userID string,
 			// Check if we should send due to time
 			sinceLastSend := time.Since(lastSendTime)
 			if sinceLastSend >= coalesceTimeout && len(coalesceBuffer) > 0 {
-				h.sendCoalescedData(conn, userID, coalesceBuffer, &dropCount, &sustainedDropCount)
+				h.sendCoalescedData(conn, userID, coalesceBuffer, cursor, &dropCount, &sustainedDropCount)
 				coalesceBuffer = nil
 			}
 
@@ -479,7 +580,7 @@ func (h *WebSocketHandler) relayMUDToClient(ctx context.Context, userID string,
 			// No data available - check if we should send coalesced data
 			sinceLastSend := time.Since(lastSendTime)
 			if sinceLastSend >= coalesceTimeout && len(coalesceBuffer) > 0 {
-				h.sendCoalescedData(conn, userID, coalesceBuffer, &dropCount, &sustainedDropCount)
+				h.sendCoalescedData(conn, userID, coalesceBuffer, cursor, &dropCount, &sustainedDropCount)
 				coalesceBuffer = nil
 			}
 
@@ -490,10 +591,12 @@ func (h *WebSocketHandler) relayMUDToClient(ctx context.Context, userID string,
 }
 
 // sendCoalescedData sends coalesced data to WebSocket with drop handling
-func (h *WebSocketHandler) sendCoalescedData(conn *websocket.Conn, userID string, data []byte, dropCount *int, sustainedDropCount *int) {
+// cursor is the scrollback position at the end of data
+func (h *WebSocketHandler) sendCoalescedData(conn *websocket.Conn, userID string, data []byte, cursor int64, dropCount *int, sustainedDropCount *int) {
 	err := h.writeJSON(conn, WSMessage{
-		Type: MsgTypeData,
-		Data: string(data),
+		Type:   MsgTypeData,
+		Data:   string(data),
+		Cursor: cursor,
 	})
 
 	if err != nil {
@@ -522,61 +625,42 @@ func (h *WebSocketHandler) sendCoalescedData(conn *websocket.Conn, userID string
 	*sustainedDropCount = 0
 }
 
-// stripTelnetIAC removes telnet IAC (Interpret As Command) sequences from the data
-// IAC is byte 255 (0xFF). Telnet commands are: IAC + command + [option]
-func stripTelnetIAC(data []byte) []byte {
-	if len(data) == 0 {
-		return data
-	}
-
-	// First pass: check if there are any IAC bytes
-	hasIAC := false
-	for _, b := range data {
-		if b == 255 {
-			hasIAC = true
-			break
+// handleTelnet passes MUD output and telnet negotiations to the session's automation engine
+// and transcript. GMCP is only negotiated for sessions bound to a saved connection.
+// It returns the output to send to the client, without lines moved to capture streams.
+func (h *WebSocketHandler) handleTelnet(userID string, text []byte, events []telnetEvent) []byte {
+	// MUDs offer to echo (WILL ECHO) while asking for a password so typed input stays hidden
+	for _, ev := range events {
+		if ev.Option == telnetOptEcho && (ev.Command == telnetWILL || ev.Command == telnetWONT) {
+			h.manager.SetRemoteEcho(userID, ev.Command == telnetWILL)
 		}
 	}
-	if !hasIAC {
-		return data
+	h.manager.LogReceived(userID, text)
+
+	engine := h.manager.Automation(userID)
+	if engine == nil {
+		return text
 	}
 
-	// Second pass: strip IAC sequences
-	result := make([]byte, 0, len(data))
-	i := 0
-	for i < len(data) {
-		if data[i] == 255 { // IAC
-			if i+1 < len(data) {
-				cmd := data[i+1]
-				switch cmd {
-				case 251, 252, 253, 254: // WILL, WON'T, DO, DON'T - skip command + option
-					if i+2 < len(data) {
-						i += 3 // Skip IAC + command + option
-						continue
-					}
-					// Incomplete (IAC + cmd but no option) - skip both bytes
-					i += 2
-					continue
-				case 255: // IAC IAC - escaped literal 255
-					i += 2
-					result = append(result, 255)
-					continue
-				default:
-					// Unknown command, skip command byte only
-					i += 2
-					continue
-				}
-			}
-			// Incomplete IAC at end of data (just 255 with no command) - skip it
-			i++
+	for _, ev := range events {
+		if ev.Option != telnetOptGMCP {
 			continue
 		}
-		// Regular byte (not part of IAC sequence)
-		result = append(result, data[i])
-		i++
+		switch ev.Command {
+		case telnetWILL:
+			if err := h.manager.SendRaw(userID, gmcpHandshake()); err != nil {
+				log.Printf("Failed to negotiate GMCP for user %s: %v", userID, err)
+			}
+		case telnetSB:
+			pkg, data := parseGMCP(ev.Data)
+			engine.HandleGMCP(pkg, data)
+		}
 	}
 
-	return result
+	if len(text) == 0 {
+		return text
+	}
+	return engine.HandleOutput(text)
 }
 
 // handleClientCommands handles commands from client and forwards to MUD
@@ -601,6 +685,75 @@ func (h *WebSocketHandler) handleClientCommands(ctx context.Context, userID stri
 	}
 }
 
+// handleAutomationCommand runs a # command against the session's automation engine
+// Commands produced by scripts are queued to the MUD like typed input
+func (h *WebSocketHandler) handleAutomationCommand(conn *websocket.Conn, userID, command string, ctx *automation.ScriptContext, clientToMUD chan<- string) {
+	engine := h.manager.Automation(userID)
+	if engine == nil {
+		h.sendError(conn, "Automation is only available for saved connections")
+		return
+	}
+
+	result, err := engine.HandleCommand(command, ctx)
+	if err != nil {
+		log.Printf("Automation command failed for user %s: %v", userID, err)
+		h.sendError(conn, err.Error())
+		return
+	}
+
+	for _, line := range result.Commands {
+		select {
+		case clientToMUD <- line:
+			metrics.Get().IncWSMessagesOut()
+		default:
+			h.sendError(conn, "Command queue full")
+			return
+		}
+	}
+
+	for _, msg := range result.Messages {
+		err := h.writeJSON(conn, WSMessage{
+			Type:  MsgTypeAutomation,
+			Event: &automation.Event{Type: automation.EventMessage, Message: msg},
+		})
+		if err != nil {
+			log.Printf("Error sending automation reply: %v", err)
+			return
+		}
+	}
+}
+
+// relayAutomationEvents forwards automation engine events to the WebSocket client
+func (h *WebSocketHandler) relayAutomationEvents(ctx context.Context, userID string, conn *websocket.Conn) {
+	engine := h.manager.Automation(userID)
+	if engine == nil {
+		return
+	}
+
+	events, unsubscribe := engine.Subscribe()
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			msg := WSMessage{Type: MsgTypeAutomation, Event: &ev}
+			if ev.Type == automation.EventCapture && ev.Capture != nil {
+				// Capture streams get their own message type so clients can route them to side windows
+				msg = WSMessage{Type: MsgTypeCapture, Stream: ev.Capture.Stream, Lines: ev.Capture.Lines, History: ev.Capture.History}
+			}
+			if err := h.writeJSON(conn, msg); err != nil {
+				log.Printf("Error sending automation event: %v", err)
+				return
+			}
+		}
+	}
+}
+
 // sendError sends an error message to the WebSocket client
 func (h *WebSocketHandler) sendError(conn *websocket.Conn, errorMsg string) {
 	err := h.writeJSON(conn, WSMessage{
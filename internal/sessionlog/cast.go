@@ -0,0 +1,333 @@
+package sessionlog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amaranth494/MudPuppy/internal/store"
+	"github.com/google/uuid"
+)
+
+// Recording defaults and limits
+const (
+	DefaultCastWidth  = 80
+	DefaultCastHeight = 24
+	MaxCastSize       = 1000 // Largest terminal width or height accepted
+	MaxCastTitle      = 200
+)
+
+// CastStore is the subset of store.SessionRecordingStore the cast recorder needs
+type CastStore interface {
+	CreateRecording(rec *store.SessionRecording) (*store.SessionRecording, error)
+	AppendRecordingChunk(chunk *store.SessionRecordingChunk) error
+	FinishRecording(recordingID uuid.UUID, durationMs int64) error
+}
+
+// CastChunkStore is the subset of store.SessionRecordingStore exporting and replaying need
+type CastChunkStore interface {
+	ListRecordingChunks(recordingID uuid.UUID, afterSeq, limit int) ([]store.SessionRecordingChunk, error)
+}
+
+// CastRecorder writes a timed recording of a live session's output as asciicast v2 events
+// Like Recorder, events are collected in memory and written in chunks on a worker goroutine.
+type CastRecorder struct {
+	store CastStore
+	id    uuid.UUID
+	start time.Time
+
+	queue     chan *store.SessionRecordingChunk
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu       sync.Mutex
+	events   strings.Builder
+	seq      int
+	partial  []byte // Incomplete UTF-8 sequence at the end of the last read
+	closed   bool
+	duration time.Duration
+}
+
+// StartRecording creates a recording and returns its recorder
+func StartRecording(s CastStore, rec *store.SessionRecording) (*CastRecorder, *store.SessionRecording, error) {
+	created, err := s.CreateRecording(rec)
+	if err != nil {
+		return nil, nil, err
+	}
+	if created == nil {
+		return nil, nil, errors.New("recording was not created")
+	}
+	r := &CastRecorder{
+		store: s,
+		id:    created.ID,
+		start: time.Now(),
+		queue: make(chan *store.SessionRecordingChunk, queueSize),
+		done:  make(chan struct{}),
+	}
+	go r.run()
+	return r, created, nil
+}
+
+// ID returns the recording being written
+func (r *CastRecorder) ID() uuid.UUID {
+	return r.id
+}
+
+// Output records MUD output (telnet commands already removed) that arrived at the given time
+func (r *CastRecorder) Output(data []byte, at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+
+	// Reads can end in the middle of a multi-byte character; keep it for the next read
+	if len(r.partial) > 0 {
+		data = append(r.partial, data...)
+	}
+	complete := len(data) - incompleteSuffix(data)
+	r.partial = append([]byte(nil), data[complete:]...)
+	if complete == 0 {
+		return
+	}
+
+	offset := max(at.Sub(r.start), 0)
+	r.events.WriteString(castEvent(offset, string(data[:complete])))
+	if r.events.Len() >= ChunkBytes {
+		r.flush()
+	}
+}
+
+// Close writes the remaining events and marks the recording as ended
+// It returns immediately; the database writes finish in the background
+func (r *CastRecorder) Close() {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		r.duration = time.Since(r.start)
+	}
+	r.mu.Unlock()
+	r.closeOnce.Do(func() { close(r.done) })
+}
+
+// castEvent formats an output event line: [seconds, "o", data]
+func castEvent(offset time.Duration, data string) string {
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	buf.WriteString(strconv.FormatFloat(offset.Seconds(), 'f', 6, 64))
+	buf.WriteString(`, "o", `)
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(data) // Strings always encode; invalid UTF-8 becomes U+FFFD
+	buf.Truncate(buf.Len() - 1)
+	buf.WriteString("]\n")
+	return buf.String()
+}
+
+// flush queues the pending events as a chunk; r.mu must be held
+func (r *CastRecorder) flush() {
+	chunk := r.take()
+	if chunk == nil {
+		return
+	}
+	select {
+	case r.queue <- chunk:
+	default:
+		log.Printf("Session recording queue full for recording=%s, dropping %d bytes", r.id, len(chunk.Events))
+	}
+}
+
+// take removes the pending events as the next chunk, or returns nil if there are none; r.mu must be held
+func (r *CastRecorder) take() *store.SessionRecordingChunk {
+	if r.events.Len() == 0 {
+		return nil
+	}
+	chunk := &store.SessionRecordingChunk{RecordingID: r.id, Seq: r.seq, Events: r.events.String()}
+	r.seq++
+	r.events.Reset()
+	return chunk
+}
+
+func (r *CastRecorder) run() {
+	ticker := time.NewTicker(ChunkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case chunk := <-r.queue:
+			r.write(chunk)
+		case <-ticker.C:
+			r.mu.Lock()
+			r.flush()
+			r.mu.Unlock()
+		case <-r.done:
+			r.finish()
+			return
+		}
+	}
+}
+
+// finish writes queued and pending chunks and ends the recording
+func (r *CastRecorder) finish() {
+	for drained := false; !drained; {
+		select {
+		case chunk := <-r.queue:
+			r.write(chunk)
+		default:
+			drained = true
+		}
+	}
+
+	r.mu.Lock()
+	chunk := r.take()
+	duration := r.duration
+	r.mu.Unlock()
+	if chunk != nil {
+		r.write(chunk)
+	}
+
+	if err := r.store.FinishRecording(r.id, duration.Milliseconds()); err != nil {
+		log.Printf("Failed to finish session recording=%s: %v", r.id, err)
+	}
+}
+
+func (r *CastRecorder) write(chunk *store.SessionRecordingChunk) {
+	if err := r.store.AppendRecordingChunk(chunk); err != nil {
+		log.Printf("Failed to write session recording=%s chunk=%d: %v", r.id, chunk.Seq, err)
+	}
+}
+
+// castHeader is the first line of an asciicast v2 file
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Duration  float64           `json:"duration,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env"`
+}
+
+// ExportCast writes a recording as an asciicast v2 file, loading its chunks a batch at a time
+func ExportCast(w io.Writer, s CastChunkStore, rec *store.SessionRecording) error {
+	bw := bufio.NewWriterSize(w, 32*1024)
+	header := castHeader{
+		Version:  2,
+		Width:    rec.Width,
+		Height:   rec.Height,
+		Duration: float64(rec.DurationMs) / 1000,
+		Title:    rec.Title,
+		Env:      map[string]string{"TERM": "xterm-256color"},
+	}
+	if t, err := time.Parse(time.RFC3339Nano, rec.StartedAt); err == nil {
+		header.Timestamp = t.Unix()
+	}
+	if err := json.NewEncoder(bw).Encode(header); err != nil {
+		return err
+	}
+
+	return eachCastChunk(s, rec.ID, func(events string) error {
+		if _, err := bw.WriteString(events); err != nil {
+			return err
+		}
+		// Send each chunk on before loading more
+		return bw.Flush()
+	})
+}
+
+// ReplayOptions control the pace of a replay
+// Speed multiplies the original pace; pauses longer than IdleLimit (if set) are shortened to it
+type ReplayOptions struct {
+	Speed     float64
+	IdleLimit time.Duration
+}
+
+// Replay sends a recording's output to send at the pace it was recorded, until it ends or ctx is cancelled
+func Replay(ctx context.Context, s CastChunkStore, recordingID uuid.UUID, opts ReplayOptions, send func(data string) error) error {
+	if opts.Speed <= 0 {
+		opts.Speed = 1
+	}
+	begin := time.Now()
+	var elapsed, last time.Duration // Recorded time replayed so far, and offset of the last event
+
+	return eachCastChunk(s, recordingID, func(events string) error {
+		for _, line := range strings.Split(events, "\n") {
+			offset, data, ok := parseCastEvent(line)
+			if !ok {
+				continue
+			}
+			gap := max(offset-last, 0)
+			if opts.IdleLimit > 0 && gap > opts.IdleLimit {
+				gap = opts.IdleLimit
+			}
+			last = offset
+			elapsed += gap
+
+			// Wait against the start of the replay rather than the last event so delays do not add up
+			wait := time.Until(begin.Add(time.Duration(float64(elapsed) / opts.Speed)))
+			if wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return ctx.Err()
+				case <-timer.C:
+				}
+			} else if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := send(data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// parseCastEvent reads an output event line, skipping other event types
+func parseCastEvent(line string) (time.Duration, string, bool) {
+	if line == "" {
+		return 0, "", false
+	}
+	var fields []json.RawMessage
+	if err := json.Unmarshal([]byte(line), &fields); err != nil || len(fields) < 3 {
+		return 0, "", false
+	}
+	var seconds float64
+	var kind, data string
+	if json.Unmarshal(fields[0], &seconds) != nil || json.Unmarshal(fields[1], &kind) != nil || kind != "o" {
+		return 0, "", false
+	}
+	if json.Unmarshal(fields[2], &data) != nil {
+		return 0, "", false
+	}
+	return time.Duration(seconds * float64(time.Second)), data, true
+}
+
+// eachCastChunk calls fn with the events of each chunk of a recording in order
+func eachCastChunk(s CastChunkStore, recordingID uuid.UUID, fn func(events string) error) error {
+	afterSeq := -1
+	for {
+		chunks, err := s.ListRecordingChunks(recordingID, afterSeq, exportBatch)
+		if err != nil {
+			return err
+		}
+		for _, chunk := range chunks {
+			if err := fn(chunk.Events); err != nil {
+				return err
+			}
+			afterSeq = chunk.Seq
+		}
+		if len(chunks) < exportBatch {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package sessionlog
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/amaranth494/MudPuppy/internal/store"
+	"github.com/google/uuid"
+)
+
+// Export formats
+const (
+	FormatHTML = "html" // Standalone page with colours preserved
+	FormatText = "text" // Plain text with ANSI codes removed
+	FormatANSI = "ans"  // Raw output with ANSI codes kept
+)
+
+// exportBatch is the number of chunks loaded at a time while exporting
+const exportBatch = 16
+
+// ErrUnknownFormat indicates an unsupported export format
+var ErrUnknownFormat = errors.New("unknown export format: use html, text or ans")
+
+// ChunkStore is the subset of store.SessionLogStore exporting needs
+type ChunkStore interface {
+	ListChunks(logID uuid.UUID, afterSeq int, from, to *time.Time, limit int) ([]store.SessionLogChunk, error)
+}
+
+// ValidFormat reports whether format is a supported export format
+func ValidFormat(format string) bool {
+	switch format {
+	case FormatHTML, FormatText, FormatANSI:
+		return true
+	}
+	return false
+}
+
+// ContentType returns the MIME type of an export format
+func ContentType(format string) string {
+	switch format {
+	case FormatHTML:
+		return "text/html; charset=utf-8"
+	case FormatText:
+		return "text/plain; charset=utf-8"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// FileExtension returns the file extension of an export format
+func FileExtension(format string) string {
+	switch format {
+	case FormatHTML:
+		return ".html"
+	case FormatText:
+		return ".txt"
+	default:
+		return ".ans"
+	}
+}
+
+// exportWriter renders log entries in one export format
+type exportWriter interface {
+	begin(l *store.SessionLog)
+	received(data string)
+	sent(command string)
+	end()
+}
+
+// Export writes a log, or the part of it between from and to, in the given format
+// Chunks are loaded a batch at a time and written as they are read, so large logs are never held in memory.
+// Commands sent appear after the output they followed, as they did on screen.
+func Export(w io.Writer, s ChunkStore, l *store.SessionLog, format string, from, to *time.Time) error {
+	bw := bufio.NewWriterSize(w, 32*1024)
+	var ew exportWriter
+	switch format {
+	case FormatHTML:
+		ew = &htmlWriter{w: bw}
+	case FormatText:
+		ew = &textWriter{w: bw}
+	case FormatANSI:
+		ew = &ansiWriter{w: bw}
+	default:
+		return ErrUnknownFormat
+	}
+
+	ew.begin(l)
+	afterSeq := -1
+	for {
+		chunks, err := s.ListChunks(l.ID, afterSeq, from, to, exportBatch)
+		if err != nil {
+			return err
+		}
+		for _, chunk := range chunks {
+			for _, e := range chunk.Entries {
+				if from != nil && e.Time < from.UnixMilli() {
+					continue
+				}
+				if to != nil && e.Time > to.UnixMilli() {
+					continue
+				}
+				if e.Direction == store.LogDirectionSent {
+					ew.sent(e.Data)
+				} else {
+					ew.received(e.Data)
+				}
+			}
+			afterSeq = chunk.Seq
+		}
+		// Send each batch on before loading the next
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+		if len(chunks) < exportBatch {
+			break
+		}
+	}
+	ew.end()
+	return bw.Flush()
+}
+
+// ansiWriter writes output exactly as received
+type ansiWriter struct {
+	w *bufio.Writer
+}
+
+func (a *ansiWriter) begin(l *store.SessionLog) {}
+
+func (a *ansiWriter) received(data string) {
+	a.w.WriteString(data)
+}
+
+func (a *ansiWriter) sent(command string) {
+	a.w.WriteString(command)
+	a.w.WriteString("\r\n")
+}
+
+func (a *ansiWriter) end() {}
+
+// textWriter writes output with escape sequences and control characters removed
+type textWriter struct {
+	w      *bufio.Writer
+	parser ansiParser
+}
+
+func (t *textWriter) begin(l *store.SessionLog) {}
+
+func (t *textWriter) received(data string) {
+	t.parser.feed(data, func(text string) { t.w.WriteString(text) }, nil)
+}
+
+func (t *textWriter) sent(command string) {
+	t.w.WriteString(StripANSI(command))
+	t.w.WriteByte('\n')
+}
+
+func (t *textWriter) end() {}
+
+// htmlWriter writes a standalone page, turning SGR colour codes into styled spans
+type htmlWriter struct {
+	w      *bufio.Writer
+	parser ansiParser
+	style  sgrStyle
+	open   bool // A span for the current style has been written
+	dirty  bool // The style changed since the span was opened
+}
+
+const htmlHead = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>%s</title>
+<style>
+body { margin: 0; background: #000000; color: #c0c0c0; }
+header { padding: 8px 12px; border-bottom: 1px solid #333333; font: 14px sans-serif; color: #888888; }
+pre { margin: 0; padding: 12px; font: 14px/1.3 Menlo, Consolas, "DejaVu Sans Mono", monospace; white-space: pre-wrap; word-wrap: break-word; }
+.sent { color: #ffff80; }
+</style>
+</head>
+<body>
+<header>%s</header>
+<pre>`
+
+func (h *htmlWriter) begin(l *store.SessionLog) {
+	title := html.EscapeString(fmt.Sprintf("%s:%d", l.Host, l.Port))
+	started := l.StartedAt
+	if t, err := time.Parse(time.RFC3339Nano, l.StartedAt); err == nil {
+		started = t.UTC().Format("2006-01-02 15:04 MST")
+	}
+	fmt.Fprintf(h.w, htmlHead, title, title+" &middot; "+html.EscapeString(started))
+}
+
+func (h *htmlWriter) received(data string) {
+	h.parser.feed(data, h.text, func(params string) {
+		h.style.apply(params)
+		h.dirty = true
+	})
+}
+
+func (h *htmlWriter) text(text string) {
+	if !h.open || h.dirty {
+		h.closeSpan()
+		if css := h.style.css(); css != "" {
+			h.w.WriteString(`<span style="` + css + `">`)
+			h.open = true
+		}
+		h.dirty = false
+	}
+	h.w.WriteString(html.EscapeString(text))
+}
+
+func (h *htmlWriter) sent(command string) {
+	h.closeSpan()
+	h.dirty = true
+	h.w.WriteString(`<span class="sent">`)
+	h.w.WriteString(html.EscapeString(StripANSI(command)))
+	h.w.WriteString("</span>\n")
+}
+
+func (h *htmlWriter) closeSpan() {
+	if h.open {
+		h.w.WriteString("</span>")
+		h.open = false
+	}
+}
+
+func (h *htmlWriter) end() {
+	h.closeSpan()
+	h.w.WriteString("</pre>\n</body>\n</html>\n")
+}
+
+// Escape sequence parser states
+const (
+	parseText = iota
+	parseEscape
+	parseCSI
+)
+
+// maxCSILength bounds the parameters kept for one control sequence
+const maxCSILength = 64
+
+// ansiParser splits MUD output into text and SGR (colour) sequences
+// It keeps its state between calls, so sequences split across reads are handled.
+// Other escape sequences and control characters apart from newline and tab are dropped.
+type ansiParser struct {
+	state  int
+	params strings.Builder
+	skip   bool // The current sequence is too long or not SGR
+}
+
+// feed parses data, calling text for runs of printable text and sgr (if not nil) with the parameters of each SGR sequence
+func (p *ansiParser) feed(data string, text func(string), sgr func(string)) {
+	start := 0
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch p.state {
+		case parseText:
+			if (c >= 0x20 && c != 0x7f) || c == '\n' || c == '\t' {
+				continue
+			}
+			if start < i {
+				text(data[start:i])
+			}
+			start = i + 1
+			if c == 0x1b {
+				p.state = parseEscape
+			}
+		case parseEscape:
+			if c == '[' {
+				p.state = parseCSI
+				p.params.Reset()
+				p.skip = false
+			} else {
+				// Two-character sequences (and anything unexpected) are dropped
+				p.state = parseText
+			}
+			start = i + 1
+		case parseCSI:
+			start = i + 1
+			switch {
+			case c >= 0x40 && c <= 0x7e:
+				if c == 'm' && !p.skip && sgr != nil {
+					sgr(p.params.String())
+				}
+				p.state = parseText
+			case c >= 0x30 && c <= 0x3f:
+				if c >= 0x3c || p.params.Len() >= maxCSILength {
+					// Private sequences (ESC [ ? ...) are never colours
+					p.skip = true
+				} else {
+					p.params.WriteByte(c)
+				}
+			case c >= 0x20 && c <= 0x2f:
+				p.skip = true
+			default:
+				// A control character aborts the sequence
+				p.state = parseText
+			}
+		}
+	}
+	if p.state == parseText && start < len(data) {
+		text(data[start:])
+	}
+}
@@ -0,0 +1,67 @@
+package sessionlog
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Limits are the retention limits of users who have not chosen their own
+type Limits struct {
+	RetentionDays int
+	MaxBytes      int64
+}
+
+// PruneStore is the subset of store.SessionLogStore the pruning job needs
+type PruneStore interface {
+	PruneExpired(defaultDays int) (int64, error)
+	PruneOverQuota(defaultMaxBytes int64) (int64, error)
+}
+
+// RecordingPruneStore is the subset of store.SessionRecordingStore the pruning job needs
+type RecordingPruneStore interface {
+	PruneExpiredRecordings(defaultDays int) (int64, error)
+}
+
+// RunPruner deletes logs past their owner's retention period or storage limit, and recordings
+// past the retention period, once at startup and then every interval until ctx is cancelled
+// recordings may be nil
+func RunPruner(ctx context.Context, s PruneStore, recordings RecordingPruneStore, defaults Limits, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		Prune(s, recordings, defaults)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Prune runs one pass of the pruning job
+func Prune(s PruneStore, recordings RecordingPruneStore, defaults Limits) {
+	expired, err := s.PruneExpired(defaults.RetentionDays)
+	if err != nil {
+		log.Printf("Pruning expired session logs failed: %v", err)
+	}
+	overQuota, err := s.PruneOverQuota(defaults.MaxBytes)
+	if err != nil {
+		log.Printf("Pruning session logs over quota failed: %v", err)
+	}
+	if expired > 0 || overQuota > 0 {
+		log.Printf("Pruned session logs: expired=%d, over_quota=%d", expired, overQuota)
+	}
+
+	if recordings == nil {
+		return
+	}
+	expired, err = recordings.PruneExpiredRecordings(defaults.RetentionDays)
+	if err != nil {
+		log.Printf("Pruning expired session recordings failed: %v", err)
+	}
+	if expired > 0 {
+		log.Printf("Pruned session recordings: expired=%d", expired)
+	}
+}
@@ -0,0 +1,246 @@
+// Package sessionlog records transcripts of MUD sessions and prunes them past each user's limits
+// Entries are collected in memory and written in chunks on a worker goroutine, so the relay
+// never waits on the database.
+package sessionlog
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/amaranth494/MudPuppy/internal/store"
+	"github.com/google/uuid"
+)
+
+// Chunking limits
+const (
+	ChunkBytes    = 64 * 1024        // Entries are written once they reach this size
+	ChunkInterval = 30 * time.Second // Pending entries are written at least this often
+	queueSize     = 16               // Chunks awaiting the database before new ones are dropped
+)
+
+// Redacted replaces commands sent while the MUD has turned echo off, such as passwords
+const Redacted = "[redacted]"
+
+// Store is the subset of store.SessionLogStore the recorder needs
+type Store interface {
+	CreateLog(userID, connectionID uuid.UUID, host string, port int) (*store.SessionLog, error)
+	AppendChunk(chunk *store.SessionLogChunk) error
+	FinishLog(logID uuid.UUID) error
+}
+
+// Recorder writes the transcript of one live session
+type Recorder struct {
+	store        Store
+	logID        uuid.UUID
+	connectionID uuid.UUID
+
+	queue     chan *store.SessionLogChunk
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu      sync.Mutex
+	entries []store.SessionLogEntry
+	size    int
+	seq     int
+	echoOff bool   // The MUD echoes input itself (telnet WILL ECHO), e.g. at a password prompt
+	partial []byte // Incomplete UTF-8 sequence at the end of the last read
+	closed  bool
+}
+
+// Start creates a log for a session and returns its recorder
+// Returns nil, nil if logging is turned off for the connection
+func Start(s Store, userID, connectionID uuid.UUID, host string, port int) (*Recorder, error) {
+	l, err := s.CreateLog(userID, connectionID, host, port)
+	if err != nil || l == nil {
+		return nil, err
+	}
+	r := &Recorder{
+		store:        s,
+		logID:        l.ID,
+		connectionID: connectionID,
+		queue:        make(chan *store.SessionLogChunk, queueSize),
+		done:         make(chan struct{}),
+	}
+	go r.run()
+	return r, nil
+}
+
+// LogID returns the log being written
+func (r *Recorder) LogID() uuid.UUID {
+	return r.logID
+}
+
+// ConnectionID returns the saved connection being recorded
+func (r *Recorder) ConnectionID() uuid.UUID {
+	return r.connectionID
+}
+
+// Received records output from the MUD, with telnet commands already removed
+func (r *Recorder) Received(data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Reads can end in the middle of a multi-byte character; keep it for the next read
+	if len(r.partial) > 0 {
+		data = append(r.partial, data...)
+	}
+	complete := len(data) - incompleteSuffix(data)
+	r.partial = append([]byte(nil), data[complete:]...)
+	if complete > 0 {
+		r.add(store.LogDirectionReceived, string(data[:complete]))
+	}
+}
+
+// Sent records a command sent to the MUD; commands sent while echo is off are redacted
+func (r *Recorder) Sent(command string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.echoOff {
+		command = Redacted
+	}
+	r.add(store.LogDirectionSent, command)
+}
+
+// SentSecret records a command whose text must never be stored, such as an auto-login password
+func (r *Recorder) SentSecret() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.add(store.LogDirectionSent, Redacted)
+}
+
+// SetRemoteEcho records whether the MUD has taken over echoing input
+// MUDs do this to hide what is typed at password prompts
+func (r *Recorder) SetRemoteEcho(on bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.echoOff = on
+}
+
+// Close writes the remaining entries and marks the log as ended
+// It returns immediately; the database writes finish in the background
+func (r *Recorder) Close() {
+	r.mu.Lock()
+	r.closed = true
+	r.mu.Unlock()
+	r.closeOnce.Do(func() { close(r.done) })
+}
+
+// add appends an entry, queueing a chunk once enough has accumulated; r.mu must be held
+func (r *Recorder) add(direction, text string) {
+	if r.closed {
+		return
+	}
+	// JSONB cannot hold NUL characters or invalid UTF-8
+	text = strings.ToValidUTF8(strings.ReplaceAll(text, "\x00", ""), "\ufffd")
+	if text == "" {
+		return
+	}
+	r.entries = append(r.entries, store.SessionLogEntry{
+		Time:      time.Now().UnixMilli(),
+		Direction: direction,
+		Data:      text,
+	})
+	r.size += len(text)
+	if r.size >= ChunkBytes {
+		r.flush()
+	}
+}
+
+// flush queues the pending entries as a chunk; r.mu must be held
+func (r *Recorder) flush() {
+	chunk := r.take()
+	if chunk == nil {
+		return
+	}
+	select {
+	case r.queue <- chunk:
+	default:
+		log.Printf("Session log queue full for log=%s, dropping %d bytes", r.logID, chunk.Bytes)
+	}
+}
+
+// take removes the pending entries as the next chunk, or returns nil if there are none; r.mu must be held
+func (r *Recorder) take() *store.SessionLogChunk {
+	if len(r.entries) == 0 {
+		return nil
+	}
+	chunk := &store.SessionLogChunk{
+		LogID:   r.logID,
+		Seq:     r.seq,
+		Entries: r.entries,
+		Bytes:   r.size,
+	}
+	r.seq++
+	r.entries = nil
+	r.size = 0
+	return chunk
+}
+
+func (r *Recorder) run() {
+	ticker := time.NewTicker(ChunkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case chunk := <-r.queue:
+			r.write(chunk)
+		case <-ticker.C:
+			r.mu.Lock()
+			r.flush()
+			r.mu.Unlock()
+		case <-r.done:
+			r.finish()
+			return
+		}
+	}
+}
+
+// finish writes queued and pending chunks and ends the log
+func (r *Recorder) finish() {
+	for drained := false; !drained; {
+		select {
+		case chunk := <-r.queue:
+			r.write(chunk)
+		default:
+			drained = true
+		}
+	}
+
+	r.mu.Lock()
+	chunk := r.take()
+	r.mu.Unlock()
+	if chunk != nil {
+		r.write(chunk)
+	}
+
+	if err := r.store.FinishLog(r.logID); err != nil {
+		log.Printf("Failed to finish session log=%s: %v", r.logID, err)
+	}
+}
+
+func (r *Recorder) write(chunk *store.SessionLogChunk) {
+	chunk.SearchText = searchText(chunk.Entries)
+	if err := r.store.AppendChunk(chunk); err != nil {
+		log.Printf("Failed to write session log=%s chunk=%d: %v", r.logID, chunk.Seq, err)
+	}
+}
+
+// incompleteSuffix returns the length of a truncated UTF-8 sequence at the end of data
+func incompleteSuffix(data []byte) int {
+	for n := 1; n < utf8.UTFMax && n <= len(data); n++ {
+		b := data[len(data)-n]
+		if b < utf8.RuneSelf {
+			return 0
+		}
+		if utf8.RuneStart(b) {
+			if utf8.FullRune(data[len(data)-n:]) {
+				return 0
+			}
+			return n
+		}
+	}
+	return 0
+}
@@ -0,0 +1,372 @@
+package sessionlog
+
+import (
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/amaranth494/MudPuppy/internal/store"
+	"github.com/google/uuid"
+)
+
+// Search limits
+const (
+	MaxQueryLength   = 200
+	MaxSearchResults = 100
+	MaxContextLines  = 10
+	searchBatch      = 20  // Chunks fetched per query
+	maxSearchChunks  = 500 // Chunks examined per request before returning a cursor
+)
+
+// Search errors
+var (
+	ErrEmptyQuery    = errors.New("query must contain at least one word")
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// SearchStore is the subset of store.SessionLogStore searching needs
+type SearchStore interface {
+	SearchChunks(userID uuid.UUID, query string, filter store.LogSearchFilter, before *uuid.UUID, limit int) ([]store.LogSearchChunk, error)
+}
+
+// SearchOptions describes a search of a user's logs
+// Direction is store.LogDirectionSent, store.LogDirectionReceived or empty for both
+type SearchOptions struct {
+	Query     string
+	Filter    store.LogSearchFilter
+	Direction string
+	Context   int
+	Limit     int
+	Cursor    string
+}
+
+// Span is a highlighted match within a line, in characters from the start of the line
+type Span struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// ContextLine is a line shown around a match
+type ContextLine struct {
+	Time      string `json:"time"`
+	Direction string `json:"direction"`
+	Text      string `json:"text"`
+}
+
+// Hit is a line of a log matching a search; the text has ANSI codes removed
+type Hit struct {
+	LogID        uuid.UUID     `json:"log_id"`
+	ConnectionID uuid.UUID     `json:"connection_id"`
+	Time         string        `json:"time"`
+	Direction    string        `json:"direction"`
+	Text         string        `json:"text"`
+	Matches      []Span        `json:"matches"`
+	Before       []ContextLine `json:"before"`
+	After        []ContextLine `json:"after"`
+}
+
+// SearchResult is a page of search hits, newest first
+// NextCursor is empty when there are no more hits
+type SearchResult struct {
+	Items      []Hit  `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// Search finds the lines of a user's logs matching a query
+// Postgres full-text search picks the chunks to look at; the matching lines are then found here
+func Search(s SearchStore, userID uuid.UUID, opts SearchOptions) (*SearchResult, error) {
+	query := ParseQuery(opts.Query)
+	if query.Empty() {
+		return nil, ErrEmptyQuery
+	}
+	before, beforeLine, err := decodeCursor(opts.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SearchResult{Items: []Hit{}}
+	examined := 0
+	for examined < maxSearchChunks {
+		chunks, err := s.SearchChunks(userID, opts.Query, opts.Filter, before, searchBatch)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range chunks {
+			chunk := &chunks[i]
+			if before != nil && chunk.ID == *before {
+				// The cursor's chunk is returned again; resume below the last line already reported
+				if beforeLine == 0 {
+					continue
+				}
+			} else {
+				beforeLine = -1
+			}
+			examined++
+
+			lines := SplitLines(chunk.Entries)
+			start := len(lines) - 1
+			if beforeLine >= 0 && beforeLine <= len(lines) {
+				start = beforeLine - 1
+			}
+			for n := start; n >= 0; n-- {
+				line := lines[n]
+				if opts.Direction != "" && line.Direction != opts.Direction {
+					continue
+				}
+				if !inRange(line.Time, opts.Filter) {
+					continue
+				}
+				text := StripANSI(line.Text)
+				spans := query.Match(text)
+				if spans == nil {
+					continue
+				}
+				result.Items = append(result.Items, Hit{
+					LogID:        chunk.LogID,
+					ConnectionID: chunk.ConnectionID,
+					Time:         formatTime(line.Time),
+					Direction:    line.Direction,
+					Text:         text,
+					Matches:      spans,
+					Before:       contextLines(lines, n-opts.Context, n),
+					After:        contextLines(lines, n+1, n+1+opts.Context),
+				})
+				if len(result.Items) == opts.Limit {
+					result.NextCursor = encodeCursor(chunk.ID, n)
+					return result, nil
+				}
+			}
+			beforeLine = 0
+		}
+
+		if len(chunks) < searchBatch {
+			return result, nil
+		}
+		last := chunks[len(chunks)-1].ID
+		before, beforeLine = &last, 0
+	}
+
+	// Stop here to bound the work of one request; the client can continue from the cursor
+	result.NextCursor = encodeCursor(*before, 0)
+	return result, nil
+}
+
+func inRange(t int64, filter store.LogSearchFilter) bool {
+	if filter.From != nil && t < filter.From.UnixMilli() {
+		return false
+	}
+	if filter.To != nil && t > filter.To.UnixMilli() {
+		return false
+	}
+	return true
+}
+
+func contextLines(lines []Line, from, to int) []ContextLine {
+	if from < 0 {
+		from = 0
+	}
+	if to > len(lines) {
+		to = len(lines)
+	}
+	context := []ContextLine{}
+	for _, line := range lines[from:to] {
+		context = append(context, ContextLine{
+			Time:      formatTime(line.Time),
+			Direction: line.Direction,
+			Text:      StripANSI(line.Text),
+		})
+	}
+	return context
+}
+
+func formatTime(ms int64) string {
+	return time.UnixMilli(ms).UTC().Format(time.RFC3339Nano)
+}
+
+// Cursors name a chunk and the number of its lines (newest last) still to be searched;
+// 0 means the chunk is done and the search continues with older chunks
+func encodeCursor(chunkID uuid.UUID, line int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(chunkID.String() + ":" + strconv.Itoa(line)))
+}
+
+func decodeCursor(cursor string) (*uuid.UUID, int, error) {
+	if cursor == "" {
+		return nil, -1, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, 0, ErrInvalidCursor
+	}
+	idStr, lineStr, ok := strings.Cut(string(data), ":")
+	if !ok {
+		return nil, 0, ErrInvalidCursor
+	}
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		return nil, 0, ErrInvalidCursor
+	}
+	line, err := strconv.Atoi(lineStr)
+	if err != nil || line < 0 {
+		return nil, 0, ErrInvalidCursor
+	}
+	return &id, line, nil
+}
+
+// Query is a parsed web-search style query: words, "quoted phrases", -excluded words and OR
+// As in Postgres, OR binds tighter than the implicit AND between words: "a b or c" is a and (b or c)
+type Query struct {
+	groups   [][][]string // Every group must match; a group matches if any of its terms does
+	excluded [][]string   // Each term is a sequence of lower-cased words
+}
+
+// ParseQuery parses a query the way Postgres websearch_to_tsquery reads it
+func ParseQuery(q string) Query {
+	var query Query
+	or := false
+	for q = strings.TrimSpace(q); q != ""; q = strings.TrimSpace(q) {
+		negate := false
+		if q[0] == '-' {
+			negate = true
+			q = q[1:]
+		}
+
+		var raw string
+		if strings.HasPrefix(q, `"`) {
+			end := strings.IndexByte(q[1:], '"')
+			if end < 0 {
+				raw, q = q[1:], ""
+			} else {
+				raw, q = q[1:end+1], q[end+2:]
+			}
+		} else {
+			end := strings.IndexFunc(q, unicode.IsSpace)
+			if end < 0 {
+				raw, q = q, ""
+			} else {
+				raw, q = q[:end], q[end:]
+			}
+		}
+
+		if !negate && strings.EqualFold(raw, "or") {
+			or = len(query.groups) > 0
+			continue
+		}
+		words := words(raw)
+		if len(words) == 0 {
+			continue
+		}
+		term := make([]string, len(words))
+		for i, w := range words {
+			term[i] = w.text
+		}
+		switch {
+		case negate:
+			query.excluded = append(query.excluded, term)
+		case or:
+			last := len(query.groups) - 1
+			query.groups[last] = append(query.groups[last], term)
+		default:
+			query.groups = append(query.groups, [][]string{term})
+		}
+		or = false
+	}
+	return query
+}
+
+// Empty reports whether the query has no words to look for
+func (q Query) Empty() bool {
+	return len(q.groups) == 0
+}
+
+// Match returns where the query's terms occur in a line, or nil if the line does not match
+func (q Query) Match(line string) []Span {
+	lineWords := words(line)
+	for _, term := range q.excluded {
+		if len(findTerm(lineWords, term)) > 0 {
+			return nil
+		}
+	}
+
+	var spans []Span
+	for _, group := range q.groups {
+		matched := false
+		for _, term := range group {
+			found := findTerm(lineWords, term)
+			if len(found) > 0 {
+				matched = true
+				spans = append(spans, found...)
+			}
+		}
+		if !matched {
+			return nil
+		}
+	}
+	return mergeSpans(spans)
+}
+
+// word is a word of a line, lower-cased, with its position in characters
+type word struct {
+	text       string
+	start, end int
+}
+
+// words splits text into runs of letters and digits, as the Postgres "simple" configuration does
+func words(text string) []word {
+	var result []word
+	var b strings.Builder
+	start, pos := -1, 0
+	for _, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start < 0 {
+				start = pos
+			}
+			b.WriteRune(unicode.ToLower(r))
+		} else if start >= 0 {
+			result = append(result, word{text: b.String(), start: start, end: pos})
+			b.Reset()
+			start = -1
+		}
+		pos++
+	}
+	if start >= 0 {
+		result = append(result, word{text: b.String(), start: start, end: pos})
+	}
+	return result
+}
+
+// findTerm returns the spans where a sequence of words occurs consecutively
+func findTerm(lineWords []word, term []string) []Span {
+	var spans []Span
+	for i := 0; i+len(term) <= len(lineWords); i++ {
+		matched := true
+		for j, w := range term {
+			if lineWords[i+j].text != w {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			spans = append(spans, Span{Start: lineWords[i].start, End: lineWords[i+len(term)-1].end})
+		}
+	}
+	return spans
+}
+
+// mergeSpans orders spans by position and joins overlapping ones
+func mergeSpans(spans []Span) []Span {
+	sort.Slice(spans, func(i, j int) bool { return spans[i].Start < spans[j].Start })
+	merged := spans[:0]
+	for _, span := range spans {
+		if n := len(merged); n > 0 && span.Start <= merged[n-1].End {
+			merged[n-1].End = max(merged[n-1].End, span.End)
+			continue
+		}
+		merged = append(merged, span)
+	}
+	return merged
+}
@@ -0,0 +1,199 @@
+package sessionlog
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// colorDefault means the terminal's default colour; other colours are
+// 0-255 from the xterm palette or colorRGB|0xRRGGBB
+const (
+	colorDefault = -1
+	colorRGB     = 1 << 24
+)
+
+// Colours used for the default foreground and background, matching the exported page
+const (
+	defaultForeground = "#c0c0c0"
+	defaultBackground = "#000000"
+)
+
+// basePalette is the xterm palette for the 16 standard colours
+var basePalette = [16]string{
+	"#000000", "#cd0000", "#00cd00", "#cdcd00", "#0000ee", "#cd00cd", "#00cdcd", "#e5e5e5",
+	"#7f7f7f", "#ff0000", "#00ff00", "#ffff00", "#5c5cff", "#ff00ff", "#00ffff", "#ffffff",
+}
+
+// sgrStyle is the text style set by SGR (Select Graphic Rendition) sequences
+type sgrStyle struct {
+	fg, bg    int
+	bold      bool
+	dim       bool
+	italic    bool
+	underline bool
+	reverse   bool
+	strike    bool
+	set       bool // fg and bg have been initialised
+}
+
+// apply updates the style from the parameters of an SGR sequence, e.g. "1;31"
+func (s *sgrStyle) apply(params string) {
+	if !s.set {
+		s.reset()
+	}
+	// Colon-separated sub-parameters (38:5:n) are read like semicolons
+	fields := strings.FieldsFunc(params, func(r rune) bool { return r == ';' || r == ':' })
+	if len(fields) == 0 {
+		s.reset()
+		return
+	}
+	codes := make([]int, len(fields))
+	for i, f := range fields {
+		codes[i], _ = strconv.Atoi(f)
+	}
+
+	for i := 0; i < len(codes); i++ {
+		switch c := codes[i]; {
+		case c == 0:
+			s.reset()
+		case c == 1:
+			s.bold = true
+		case c == 2:
+			s.dim = true
+		case c == 3:
+			s.italic = true
+		case c == 4:
+			s.underline = true
+		case c == 7:
+			s.reverse = true
+		case c == 9:
+			s.strike = true
+		case c == 22:
+			s.bold, s.dim = false, false
+		case c == 23:
+			s.italic = false
+		case c == 24:
+			s.underline = false
+		case c == 27:
+			s.reverse = false
+		case c == 29:
+			s.strike = false
+		case c >= 30 && c <= 37:
+			s.fg = c - 30
+		case c == 38, c == 48:
+			color, used := extendedColor(codes[i+1:])
+			i += used
+			if color == colorDefault {
+				continue
+			}
+			if c == 38 {
+				s.fg = color
+			} else {
+				s.bg = color
+			}
+		case c == 39:
+			s.fg = colorDefault
+		case c >= 40 && c <= 47:
+			s.bg = c - 40
+		case c == 49:
+			s.bg = colorDefault
+		case c >= 90 && c <= 97:
+			s.fg = c - 90 + 8
+		case c >= 100 && c <= 107:
+			s.bg = c - 100 + 8
+		}
+	}
+}
+
+// extendedColor reads the arguments of a 38 or 48 code: 5;n or 2;r;g;b
+// It returns the colour and how many arguments it used.
+func extendedColor(args []int) (int, int) {
+	if len(args) >= 2 && args[0] == 5 {
+		if args[1] < 0 || args[1] > 255 {
+			return colorDefault, 2
+		}
+		return args[1], 2
+	}
+	if len(args) >= 4 && args[0] == 2 {
+		r, g, b := clampByte(args[1]), clampByte(args[2]), clampByte(args[3])
+		return colorRGB | r<<16 | g<<8 | b, 4
+	}
+	return colorDefault, len(args)
+}
+
+func clampByte(n int) int {
+	return min(max(n, 0), 255)
+}
+
+func (s *sgrStyle) reset() {
+	*s = sgrStyle{fg: colorDefault, bg: colorDefault, set: true}
+}
+
+// css returns the inline style for the current text style, or "" for the default style
+func (s *sgrStyle) css() string {
+	if !s.set {
+		return ""
+	}
+	fg := s.fg
+	// Bold text in one of the 8 standard colours is shown bright, as in most MUD clients
+	if s.bold && fg >= 0 && fg < 8 {
+		fg += 8
+	}
+	fgCSS, bgCSS := colorCSS(fg), colorCSS(s.bg)
+	if s.reverse {
+		if fgCSS == "" {
+			fgCSS = defaultForeground
+		}
+		if bgCSS == "" {
+			bgCSS = defaultBackground
+		}
+		fgCSS, bgCSS = bgCSS, fgCSS
+	}
+
+	var b strings.Builder
+	if fgCSS != "" {
+		b.WriteString("color:" + fgCSS + ";")
+	}
+	if bgCSS != "" {
+		b.WriteString("background-color:" + bgCSS + ";")
+	}
+	if s.bold {
+		b.WriteString("font-weight:bold;")
+	}
+	if s.dim {
+		b.WriteString("opacity:0.7;")
+	}
+	if s.italic {
+		b.WriteString("font-style:italic;")
+	}
+	switch {
+	case s.underline && s.strike:
+		b.WriteString("text-decoration:underline line-through;")
+	case s.underline:
+		b.WriteString("text-decoration:underline;")
+	case s.strike:
+		b.WriteString("text-decoration:line-through;")
+	}
+	return b.String()
+}
+
+// colorCSS returns a colour as a CSS hex colour, or "" for the default colour
+func colorCSS(color int) string {
+	switch {
+	case color == colorDefault:
+		return ""
+	case color&colorRGB != 0:
+		return fmt.Sprintf("#%06x", color&0xffffff)
+	case color < 16:
+		return basePalette[color]
+	case color < 232:
+		// 6x6x6 colour cube
+		levels := [6]int{0, 95, 135, 175, 215, 255}
+		n := color - 16
+		return fmt.Sprintf("#%02x%02x%02x", levels[n/36], levels[n/6%6], levels[n%6])
+	default:
+		gray := 8 + (color-232)*10
+		return fmt.Sprintf("#%02x%02x%02x", gray, gray, gray)
+	}
+}
@@ -0,0 +1,77 @@
+package sessionlog
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/amaranth494/MudPuppy/internal/store"
+)
+
+// ansiRegex matches ANSI escape sequences (colours, cursor movement)
+var ansiRegex = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]`)
+
+// StripANSI removes ANSI escape sequences from text
+func StripANSI(text string) string {
+	if !strings.Contains(text, "\x1b") {
+		return text
+	}
+	return ansiRegex.ReplaceAllString(text, "")
+}
+
+// Line is one line of a transcript
+// Received output is split at newlines; each command sent is a line of its own.
+// Time is when the line started, in unix milliseconds. Text may contain ANSI codes.
+type Line struct {
+	Time      int64
+	Direction string
+	Text      string
+}
+
+// SplitLines turns log entries into lines
+// A line of output cut short by a command (usually a prompt) ends where the command was sent
+func SplitLines(entries []store.SessionLogEntry) []Line {
+	var lines []Line
+	var partial strings.Builder
+	var partialTime int64
+	flush := func() {
+		if partial.Len() > 0 {
+			lines = append(lines, Line{Time: partialTime, Direction: store.LogDirectionReceived, Text: partial.String()})
+			partial.Reset()
+		}
+	}
+
+	for _, e := range entries {
+		if e.Direction == store.LogDirectionSent {
+			flush()
+			lines = append(lines, Line{Time: e.Time, Direction: e.Direction, Text: e.Data})
+			continue
+		}
+		data := e.Data
+		for data != "" {
+			if partial.Len() == 0 {
+				partialTime = e.Time
+			}
+			i := strings.IndexByte(data, '\n')
+			if i < 0 {
+				partial.WriteString(data)
+				break
+			}
+			partial.WriteString(strings.TrimSuffix(data[:i], "\r"))
+			lines = append(lines, Line{Time: partialTime, Direction: store.LogDirectionReceived, Text: partial.String()})
+			partial.Reset()
+			data = data[i+1:]
+		}
+	}
+	flush()
+	return lines
+}
+
+// searchText returns the text of entries to index for full-text search
+func searchText(entries []store.SessionLogEntry) string {
+	var b strings.Builder
+	for _, e := range entries {
+		b.WriteString(StripANSI(e.Data))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
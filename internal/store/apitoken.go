@@ -0,0 +1,147 @@
+package store
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// APIToken is a personal access token a user created for scripts and bots
+// Only a hash of the token is stored; the prefix lets users tell their tokens apart.
+type APIToken struct {
+	ID         uuid.UUID `json:"id"`
+	UserID     uuid.UUID `json:"-"`
+	Name       string    `json:"name"`
+	TokenHash  string    `json:"-"`
+	Prefix     string    `json:"prefix"`
+	Scopes     []string  `json:"scopes"`
+	ExpiresAt  string    `json:"expires_at"`
+	CreatedAt  string    `json:"created_at"`
+	LastUsedAt *string   `json:"last_used_at,omitempty"`
+}
+
+// APITokenStore handles API token database operations
+type APITokenStore struct {
+	db *sql.DB
+}
+
+// NewAPITokenStore creates a new API token store
+func NewAPITokenStore(db *sql.DB) *APITokenStore {
+	return &APITokenStore{db: db}
+}
+
+const apiTokenColumns = `id, user_id, name, token_hash, token_prefix, scopes, expires_at, created_at, last_used_at`
+
+func scanAPIToken(row rowScanner) (*APIToken, error) {
+	var t APIToken
+	var scopes string
+	var lastUsed sql.NullString
+	err := row.Scan(&t.ID, &t.UserID, &t.Name, &t.TokenHash, &t.Prefix, &scopes, &t.ExpiresAt, &t.CreatedAt, &lastUsed)
+	if err != nil {
+		return nil, err
+	}
+	t.Scopes = []string{}
+	if scopes != "" {
+		t.Scopes = strings.Split(scopes, ",")
+	}
+	if lastUsed.Valid {
+		t.LastUsedAt = &lastUsed.String
+	}
+	return &t, nil
+}
+
+// Create stores a new API token
+func (s *APITokenStore) Create(t *APIToken, expiresAt time.Time) error {
+	return s.db.QueryRow(`
+		INSERT INTO api_tokens (user_id, name, token_hash, token_prefix, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, expires_at, created_at
+	`, t.UserID, t.Name, t.TokenHash, t.Prefix, strings.Join(t.Scopes, ","), expiresAt).Scan(&t.ID, &t.ExpiresAt, &t.CreatedAt)
+}
+
+// ListByUser returns a user's API tokens, newest first, including expired ones
+func (s *APITokenStore) ListByUser(userID uuid.UUID) ([]APIToken, error) {
+	rows, err := s.db.Query(`
+		SELECT `+apiTokenColumns+`
+		FROM api_tokens
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []APIToken{}
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *t)
+	}
+	return tokens, rows.Err()
+}
+
+// CountByUser returns how many API tokens a user has
+func (s *APITokenStore) CountByUser(userID uuid.UUID) (int, error) {
+	var count int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM api_tokens WHERE user_id = $1`, userID).Scan(&count)
+	return count, err
+}
+
+// GetActiveByHash returns the unexpired token with a hash, or nil if there is none
+func (s *APITokenStore) GetActiveByHash(tokenHash string) (*APIToken, error) {
+	t, err := scanAPIToken(s.db.QueryRow(`
+		SELECT `+apiTokenColumns+`
+		FROM api_tokens
+		WHERE token_hash = $1 AND expires_at > NOW()
+	`, tokenHash))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return t, err
+}
+
+// RecordUse updates a token's last-used time
+// Writes are limited to one a minute per token, since scripts may call the API constantly.
+func (s *APITokenStore) RecordUse(id uuid.UUID) error {
+	_, err := s.db.Exec(`
+		UPDATE api_tokens SET last_used_at = NOW()
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')
+	`, id)
+	return err
+}
+
+// Delete revokes an API token
+func (s *APITokenStore) Delete(userID, id uuid.UUID) (bool, error) {
+	result, err := s.db.Exec(`
+		DELETE FROM api_tokens WHERE id = $1 AND user_id = $2
+	`, id, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// DeleteByUser removes all of a user's API tokens and returns their IDs
+func (s *APITokenStore) DeleteByUser(userID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := s.db.Query(`DELETE FROM api_tokens WHERE user_id = $1 RETURNING id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/google/uuid"
+)
+
+// AuditEvent is a security-relevant event on a user's account, such as a login or a credential change
+// Details holds event-specific context, such as the login method or the connection affected.
+type AuditEvent struct {
+	ID        int64             `json:"id"`
+	UserID    uuid.UUID         `json:"-"`
+	Event     string            `json:"event"`
+	IP        string            `json:"ip,omitempty"`
+	UserAgent string            `json:"user_agent,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+	CreatedAt string            `json:"created_at"`
+}
+
+// AuditStore handles audit log database operations
+// The log is append-only: events are never updated, and are removed only with the account.
+type AuditStore struct {
+	db *sql.DB
+}
+
+// NewAuditStore creates a new audit store
+func NewAuditStore(db *sql.DB) *AuditStore {
+	return &AuditStore{db: db}
+}
+
+// Record appends an event to the log, filling in e.ID and e.CreatedAt
+func (s *AuditStore) Record(e *AuditEvent) error {
+	details := []byte("{}")
+	if len(e.Details) > 0 {
+		var err error
+		if details, err = json.Marshal(e.Details); err != nil {
+			return err
+		}
+	}
+	return s.db.QueryRow(`
+		INSERT INTO audit_events (user_id, event, ip, user_agent, details)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`, e.UserID, e.Event, e.IP, e.UserAgent, details).Scan(&e.ID, &e.CreatedAt)
+}
+
+// ListByUser returns up to limit of a user's events, newest first
+// before is the ID of the last event of the previous page, or 0 for the first page.
+func (s *AuditStore) ListByUser(userID uuid.UUID, before int64, limit int) ([]AuditEvent, error) {
+	query := `
+		SELECT id, user_id, event, ip, user_agent, details, created_at
+		FROM audit_events
+		WHERE user_id = $1 AND ($2::bigint = 0 OR id < $2)
+		ORDER BY id DESC
+		LIMIT $3
+	`
+	rows, err := s.db.Query(query, userID, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []AuditEvent{}
+	for rows.Next() {
+		var e AuditEvent
+		var details []byte
+		if err := rows.Scan(&e.ID, &e.UserID, &e.Event, &e.IP, &e.UserAgent, &details, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(details, &e.Details); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
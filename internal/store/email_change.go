@@ -0,0 +1,202 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EmailChange is a request to move an account to a new email address
+// It is pending until the code sent to the new address is entered. Once confirmed, the old address
+// can undo the change with a revert link until RevertExpiresAt.
+type EmailChange struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	OldEmail  string
+	NewEmail  string
+	OTPHash   string
+	Attempts  int
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// EmailChangeStore handles email change database operations
+type EmailChangeStore struct {
+	db *sql.DB
+}
+
+// NewEmailChangeStore creates a new email change store
+func NewEmailChangeStore(db *sql.DB) *EmailChangeStore {
+	return &EmailChangeStore{db: db}
+}
+
+// Create stores a pending change, replacing any earlier pending change for the user
+func (s *EmailChangeStore) Create(userID uuid.UUID, oldEmail, newEmail, otpHash string, expiresAt time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM email_changes WHERE user_id = $1 AND confirmed_at IS NULL`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO email_changes (user_id, old_email, new_email, otp_hash, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, userID, oldEmail, newEmail, otpHash, expiresAt); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// StartAttempt counts an attempt against the user's unconfirmed, unexpired change with fewer than
+// maxAttempts attempts and returns it with the new total, or nil if there is none
+// The attempt is counted before the code is compared, so concurrent requests cannot try more codes
+// than the limit allows.
+func (s *EmailChangeStore) StartAttempt(userID uuid.UUID, maxAttempts int) (*EmailChange, error) {
+	var c EmailChange
+	err := s.db.QueryRow(`
+		UPDATE email_changes SET attempts = attempts + 1
+		WHERE id = (
+			SELECT id FROM email_changes
+			WHERE user_id = $1 AND confirmed_at IS NULL AND expires_at > NOW() AND attempts < $2
+			ORDER BY created_at DESC
+			LIMIT 1
+		) AND confirmed_at IS NULL AND expires_at > NOW() AND attempts < $2
+		RETURNING id, user_id, old_email, new_email, otp_hash, attempts, expires_at
+	`, userID, maxAttempts).Scan(&c.ID, &c.UserID, &c.OldEmail, &c.NewEmail, &c.OTPHash, &c.Attempts, &c.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Confirm applies a pending change: the user's email becomes the new address, marked verified,
+// and the old address can revert it with the given token until revertExpiresAt.
+// It reports false if the change was already used, has expired or run out of attempts, or the new
+// address has since been taken.
+func (s *EmailChangeStore) Confirm(c *EmailChange, revertTokenHash string, revertExpiresAt time.Time, maxAttempts int) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE email_changes
+		SET confirmed_at = NOW(), revert_token_hash = $2, revert_expires_at = $3
+		WHERE id = $1 AND confirmed_at IS NULL AND expires_at > NOW() AND attempts <= $4
+	`, c.ID, revertTokenHash, revertExpiresAt, maxAttempts)
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+
+	// Only if the account still has the address the change was requested from
+	result, err = tx.Exec(`
+		UPDATE users
+		SET email = $2, email_verified_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND email = $3 AND NOT EXISTS (SELECT 1 FROM users WHERE email = $2)
+	`, c.UserID, c.NewEmail, c.OldEmail)
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// Revert undoes a confirmed change with the token sent to the old address, restoring that address
+// Returns nil if the token is unknown, expired or already used, or the account has since moved to an
+// address that did not come from this change or a later one, and false if the old address now belongs
+// to another account. Every other revert link for the account stops working, so a later change back
+// to an address someone else controls cannot be replayed once the owner has reverted.
+func (s *EmailChangeStore) Revert(revertTokenHash string) (*EmailChange, bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	var c EmailChange
+	var confirmedAt time.Time
+	err = tx.QueryRow(`
+		SELECT id, user_id, old_email, new_email, confirmed_at, created_at
+		FROM email_changes
+		WHERE revert_token_hash = $1 AND reverted_at IS NULL AND revert_expires_at > NOW()
+		FOR UPDATE
+	`, revertTokenHash).Scan(&c.ID, &c.UserID, &c.OldEmail, &c.NewEmail, &confirmedAt, &c.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	// The account must still have the address this change moved it to, or one a later change moved it
+	// on to, so the owner can undo a chain of changes from the first one
+	var current string
+	err = tx.QueryRow(`
+		SELECT email FROM users
+		WHERE id = $1 AND (email = $2 OR email IN (
+			SELECT new_email FROM email_changes
+			WHERE user_id = $1 AND confirmed_at > $3 AND reverted_at IS NULL
+		))
+		FOR UPDATE
+	`, c.UserID, c.NewEmail, confirmedAt).Scan(&current)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	result, err := tx.Exec(`
+		UPDATE users
+		SET email = $2, email_verified_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND email = $3 AND NOT EXISTS (SELECT 1 FROM users WHERE email = $2 AND id <> $1)
+	`, c.UserID, c.OldEmail, current)
+	if err != nil {
+		return nil, false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return &c, false, err
+	}
+
+	if _, err := tx.Exec(`UPDATE email_changes SET reverted_at = NOW() WHERE id = $1`, c.ID); err != nil {
+		return nil, false, err
+	}
+	// The account's other revert links are used up with this one
+	if _, err := tx.Exec(`
+		UPDATE email_changes SET reverted_at = NOW(), revert_token_hash = NULL
+		WHERE user_id = $1 AND id <> $2 AND confirmed_at IS NOT NULL AND reverted_at IS NULL
+	`, c.UserID, c.ID); err != nil {
+		return nil, false, err
+	}
+	// A change started from the account since is dropped too
+	if _, err := tx.Exec(`DELETE FROM email_changes WHERE user_id = $1 AND confirmed_at IS NULL`, c.UserID); err != nil {
+		return nil, false, err
+	}
+	return &c, true, tx.Commit()
+}
+
+// Reserved reports whether an address was recently changed away from and can still be reverted to
+// Such addresses cannot be registered or changed to, so the revert link keeps working.
+func (s *EmailChangeStore) Reserved(email string) (bool, error) {
+	var exists bool
+	err := s.db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM email_changes
+			WHERE old_email = $1 AND confirmed_at IS NOT NULL AND reverted_at IS NULL AND revert_expires_at > NOW()
+		)
+	`, email).Scan(&exists)
+	return exists, err
+}
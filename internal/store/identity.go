@@ -0,0 +1,139 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Identity is an account at an OpenID Connect provider linked to a user
+type Identity struct {
+	ID          uuid.UUID `json:"id"`
+	UserID      uuid.UUID `json:"-"`
+	Issuer      string    `json:"issuer"`
+	Subject     string    `json:"-"`
+	Email       string    `json:"email"`
+	CreatedAt   string    `json:"created_at"`
+	LastLoginAt *string   `json:"last_login_at,omitempty"`
+}
+
+// IdentityStore handles linked identity database operations
+type IdentityStore struct {
+	db *sql.DB
+}
+
+// NewIdentityStore creates a new identity store
+func NewIdentityStore(db *sql.DB) *IdentityStore {
+	return &IdentityStore{db: db}
+}
+
+const identityColumns = `id, user_id, issuer, subject, email, created_at, last_login_at`
+
+func scanIdentity(row rowScanner) (*Identity, error) {
+	var i Identity
+	var lastLogin sql.NullString
+	if err := row.Scan(&i.ID, &i.UserID, &i.Issuer, &i.Subject, &i.Email, &i.CreatedAt, &lastLogin); err != nil {
+		return nil, err
+	}
+	if lastLogin.Valid {
+		i.LastLoginAt = &lastLogin.String
+	}
+	return &i, nil
+}
+
+// Get returns the identity with an issuer and subject, or nil if it is not linked to anyone
+func (s *IdentityStore) Get(issuer, subject string) (*Identity, error) {
+	i, err := scanIdentity(s.db.QueryRow(`
+		SELECT `+identityColumns+`
+		FROM user_identities
+		WHERE issuer = $1 AND subject = $2
+	`, issuer, subject))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return i, err
+}
+
+// Create links an identity to a user
+// It reports false if the identity is already linked, to this user or another.
+func (s *IdentityStore) Create(i *Identity) (bool, error) {
+	err := s.db.QueryRow(`
+		INSERT INTO user_identities (user_id, issuer, subject, email)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (issuer, subject) DO NOTHING
+		RETURNING id, created_at
+	`, i.UserID, i.Issuer, i.Subject, i.Email).Scan(&i.ID, &i.CreatedAt)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ListByUser returns the identities linked to a user, oldest first
+func (s *IdentityStore) ListByUser(userID uuid.UUID) ([]Identity, error) {
+	rows, err := s.db.Query(`
+		SELECT `+identityColumns+`
+		FROM user_identities
+		WHERE user_id = $1
+		ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []Identity{}
+	for rows.Next() {
+		i, err := scanIdentity(rows)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, *i)
+	}
+	return identities, rows.Err()
+}
+
+// ListCreatedSince returns the identities linked to a user at or after a time, oldest first
+func (s *IdentityStore) ListCreatedSince(userID uuid.UUID, since time.Time) ([]Identity, error) {
+	rows, err := s.db.Query(`
+		SELECT `+identityColumns+`
+		FROM user_identities
+		WHERE user_id = $1 AND created_at >= $2
+		ORDER BY created_at
+	`, userID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []Identity{}
+	for rows.Next() {
+		i, err := scanIdentity(rows)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, *i)
+	}
+	return identities, rows.Err()
+}
+
+// RecordLogin marks an identity used and stores the email the provider reported
+func (s *IdentityStore) RecordLogin(id uuid.UUID, email string) error {
+	_, err := s.db.Exec(`
+		UPDATE user_identities SET last_login_at = NOW(), email = $2 WHERE id = $1
+	`, id, email)
+	return err
+}
+
+// Delete unlinks an identity
+func (s *IdentityStore) Delete(userID, id uuid.UUID) (bool, error) {
+	result, err := s.db.Exec(`
+		DELETE FROM user_identities WHERE id = $1 AND user_id = $2
+	`, id, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
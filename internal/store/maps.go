@@ -0,0 +1,384 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Map holds the automapper settings for one saved connection
+// Rooms are stored separately in map_rooms
+type Map struct {
+	ID              uuid.UUID `json:"id"`
+	UserID          uuid.UUID `json:"user_id"`
+	ConnectionID    uuid.UUID `json:"connection_id"`
+	RoomNamePattern string    `json:"room_name_pattern"` // Regex matching room title lines (MUDs without GMCP)
+	ExitsPattern    string    `json:"exits_pattern"`     // Regex matching exit lines; group 1 holds the exit list
+	CreatedAt       string    `json:"created_at"`
+	UpdatedAt       string    `json:"updated_at"`
+}
+
+// MapExit is a single exit from a room
+// To holds the room key of the destination, or is empty when it is not yet known
+// Weight is the pathfinding cost of taking the exit; 0 means the default of 1
+// Extra keeps fields from imported maps, keyed by format (see MapRoom.Extra)
+type MapExit struct {
+	Direction string                     `json:"direction"`
+	To        string                     `json:"to,omitempty"`
+	Weight    int                        `json:"weight,omitempty"`
+	Extra     map[string]json.RawMessage `json:"extra,omitempty"`
+}
+
+// Cost returns the pathfinding cost of the exit
+func (e MapExit) Cost() int {
+	if e.Weight <= 0 {
+		return 1
+	}
+	return e.Weight
+}
+
+// MapRoom is a room recorded by the automapper
+// Extra holds fields from imported maps that the room model has no place for, keyed by
+// format ("mudlet-json", "zmud-tables", ...), so they can be written back out on export
+type MapRoom struct {
+	ID          uuid.UUID                  `json:"id"`
+	MapID       uuid.UUID                  `json:"map_id"`
+	Key         string                     `json:"key"`
+	Name        string                     `json:"name"`
+	Area        string                     `json:"area"`
+	Environment string                     `json:"environment,omitempty"`
+	X           int                        `json:"x"`
+	Y           int                        `json:"y"`
+	Z           int                        `json:"z"`
+	Exits       []MapExit                  `json:"exits"`
+	Avoid       bool                       `json:"avoid"` // Never route through this room
+	Extra       map[string]json.RawMessage `json:"extra,omitempty"`
+	CreatedAt   string                     `json:"created_at"`
+	UpdatedAt   string                     `json:"updated_at"`
+}
+
+// Exit returns the exit in the given direction
+func (r *MapRoom) Exit(direction string) (MapExit, bool) {
+	for _, exit := range r.Exits {
+		if strings.EqualFold(exit.Direction, direction) {
+			return exit, true
+		}
+	}
+	return MapExit{}, false
+}
+
+// SetExit adds or replaces the exit in the given direction
+func (r *MapRoom) SetExit(exit MapExit) {
+	for i := range r.Exits {
+		if strings.EqualFold(r.Exits[i].Direction, exit.Direction) {
+			r.Exits[i] = exit
+			return
+		}
+	}
+	r.Exits = append(r.Exits, exit)
+}
+
+// MapArea summarizes an area of a map
+type MapArea struct {
+	Name      string `json:"name"`
+	RoomCount int    `json:"room_count"`
+}
+
+// MapStore handles automapper database operations
+type MapStore struct {
+	db *sql.DB
+}
+
+// NewMapStore creates a new map store
+func NewMapStore(db *sql.DB) *MapStore {
+	return &MapStore{db: db}
+}
+
+const mapColumns = `id, user_id, connection_id, room_name_pattern, exits_pattern, created_at, updated_at`
+
+const mapRoomColumns = `id, map_id, room_key, name, area, environment, x, y, z, exits, avoid, extra, created_at, updated_at`
+
+// GetMap retrieves the map of a connection (for a specific user)
+func (s *MapStore) GetMap(userID, connectionID uuid.UUID) (*Map, error) {
+	query := `SELECT ` + mapColumns + ` FROM maps WHERE connection_id = $1 AND user_id = $2`
+	return scanMap(s.db.QueryRow(query, connectionID, userID))
+}
+
+// EnsureMap returns the map of a connection, creating an empty one if needed
+// Returns nil, nil if the connection does not belong to the user
+func (s *MapStore) EnsureMap(userID, connectionID uuid.UUID) (*Map, error) {
+	query := `
+		INSERT INTO maps (user_id, connection_id)
+		SELECT user_id, id FROM saved_connections WHERE id = $1 AND user_id = $2
+		ON CONFLICT (connection_id) DO UPDATE SET updated_at = maps.updated_at
+		RETURNING ` + mapColumns
+	return scanMap(s.db.QueryRow(query, connectionID, userID))
+}
+
+// UpdateMapSettings sets the room-name and exit-line patterns of a map
+func (s *MapStore) UpdateMapSettings(userID, mapID uuid.UUID, roomNamePattern, exitsPattern string) (*Map, error) {
+	query := `
+		UPDATE maps
+		SET room_name_pattern = $1, exits_pattern = $2, updated_at = NOW()
+		WHERE id = $3 AND user_id = $4
+		RETURNING ` + mapColumns
+	return scanMap(s.db.QueryRow(query, roomNamePattern, exitsPattern, mapID, userID))
+}
+
+func scanMap(row *sql.Row) (*Map, error) {
+	m := &Map{}
+	err := row.Scan(&m.ID, &m.UserID, &m.ConnectionID, &m.RoomNamePattern, &m.ExitsPattern, &m.CreatedAt, &m.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// UpsertRoom inserts a room or updates the room with the same key, filling in room.ID
+// The avoid flag and extra fields are only set on insert; the mapper never changes them
+func (s *MapStore) UpsertRoom(room *MapRoom) error {
+	if room.Exits == nil {
+		room.Exits = []MapExit{}
+	}
+	exitsJSON, err := json.Marshal(room.Exits)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO map_rooms (map_id, room_key, name, area, environment, x, y, z, exits, avoid)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (map_id, room_key) DO UPDATE SET
+			name = EXCLUDED.name, area = EXCLUDED.area, environment = EXCLUDED.environment,
+			x = EXCLUDED.x, y = EXCLUDED.y, z = EXCLUDED.z, exits = EXCLUDED.exits, updated_at = NOW()
+		RETURNING id, avoid, extra, created_at, updated_at
+	`
+	var extraJSON []byte
+	err = s.db.QueryRow(query, room.MapID, room.Key, room.Name, room.Area, room.Environment,
+		room.X, room.Y, room.Z, exitsJSON, room.Avoid).Scan(&room.ID, &room.Avoid, &extraJSON, &room.CreatedAt, &room.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	return decodeRoomExtra(extraJSON, room)
+}
+
+// ImportRooms writes imported rooms in one transaction, replacing rooms with the same key completely
+func (s *MapStore) ImportRooms(mapID uuid.UUID, rooms []MapRoom) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO map_rooms (map_id, room_key, name, area, environment, x, y, z, exits, avoid, extra)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (map_id, room_key) DO UPDATE SET
+			name = EXCLUDED.name, area = EXCLUDED.area, environment = EXCLUDED.environment,
+			x = EXCLUDED.x, y = EXCLUDED.y, z = EXCLUDED.z, exits = EXCLUDED.exits,
+			avoid = EXCLUDED.avoid, extra = EXCLUDED.extra, updated_at = NOW()
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for i := range rooms {
+		room := &rooms[i]
+		if room.Exits == nil {
+			room.Exits = []MapExit{}
+		}
+		exitsJSON, err := json.Marshal(room.Exits)
+		if err != nil {
+			return err
+		}
+		extra := room.Extra
+		if extra == nil {
+			extra = map[string]json.RawMessage{}
+		}
+		extraJSON, err := json.Marshal(extra)
+		if err != nil {
+			return err
+		}
+		if _, err := stmt.Exec(mapID, room.Key, room.Name, room.Area, room.Environment,
+			room.X, room.Y, room.Z, exitsJSON, room.Avoid, extraJSON); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetRoom retrieves a room by ID
+func (s *MapStore) GetRoom(mapID, roomID uuid.UUID) (*MapRoom, error) {
+	query := `SELECT ` + mapRoomColumns + ` FROM map_rooms WHERE id = $1 AND map_id = $2`
+	return scanMapRoom(s.db.QueryRow(query, roomID, mapID))
+}
+
+// GetRoomByKey retrieves a room by its room key
+func (s *MapStore) GetRoomByKey(mapID uuid.UUID, key string) (*MapRoom, error) {
+	query := `SELECT ` + mapRoomColumns + ` FROM map_rooms WHERE map_id = $1 AND room_key = $2`
+	return scanMapRoom(s.db.QueryRow(query, mapID, key))
+}
+
+// FindRoomAt retrieves a room with the given name at a position, used to close loops when
+// mapping without room numbers
+func (s *MapStore) FindRoomAt(mapID uuid.UUID, area, name string, x, y, z int) (*MapRoom, error) {
+	query := `
+		SELECT ` + mapRoomColumns + ` FROM map_rooms
+		WHERE map_id = $1 AND area = $2 AND name = $3 AND x = $4 AND y = $5 AND z = $6
+		ORDER BY created_at
+		LIMIT 1
+	`
+	return scanMapRoom(s.db.QueryRow(query, mapID, area, name, x, y, z))
+}
+
+// ListRooms retrieves the rooms of a map, optionally limited to one area
+func (s *MapStore) ListRooms(mapID uuid.UUID, area string) ([]MapRoom, error) {
+	query := `SELECT ` + mapRoomColumns + ` FROM map_rooms WHERE map_id = $1`
+	args := []interface{}{mapID}
+	if area != "" {
+		query += ` AND area = $2`
+		args = append(args, area)
+	}
+	query += ` ORDER BY area, z, y, x, room_key`
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rooms := []MapRoom{}
+	for rows.Next() {
+		room, err := scanMapRoom(rows)
+		if err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, *room)
+	}
+	return rooms, rows.Err()
+}
+
+// ListAreas retrieves the areas of a map with their room counts
+func (s *MapStore) ListAreas(mapID uuid.UUID) ([]MapArea, error) {
+	query := `
+		SELECT area, COUNT(*) FROM map_rooms
+		WHERE map_id = $1
+		GROUP BY area
+		ORDER BY area
+	`
+	rows, err := s.db.Query(query, mapID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	areas := []MapArea{}
+	for rows.Next() {
+		var area MapArea
+		if err := rows.Scan(&area.Name, &area.RoomCount); err != nil {
+			return nil, err
+		}
+		areas = append(areas, area)
+	}
+	return areas, rows.Err()
+}
+
+// UpdateRoom saves edits to an existing room; the room key cannot be changed
+// Returns nil, nil if the room does not exist
+func (s *MapStore) UpdateRoom(room *MapRoom) (*MapRoom, error) {
+	if room.Exits == nil {
+		room.Exits = []MapExit{}
+	}
+	exitsJSON, err := json.Marshal(room.Exits)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		UPDATE map_rooms
+		SET name = $1, area = $2, environment = $3, x = $4, y = $5, z = $6, exits = $7, avoid = $8, updated_at = NOW()
+		WHERE id = $9 AND map_id = $10
+		RETURNING ` + mapRoomColumns
+	return scanMapRoom(s.db.QueryRow(query, room.Name, room.Area, room.Environment,
+		room.X, room.Y, room.Z, exitsJSON, room.Avoid, room.ID, room.MapID))
+}
+
+// DeleteRoom deletes a room, reporting whether it existed
+func (s *MapStore) DeleteRoom(mapID, roomID uuid.UUID) (bool, error) {
+	result, err := s.db.Exec(`DELETE FROM map_rooms WHERE id = $1 AND map_id = $2`, roomID, mapID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// RenameArea moves every room of an area to a new area name, returning the number of rooms moved
+func (s *MapStore) RenameArea(mapID uuid.UUID, from, to string) (int64, error) {
+	result, err := s.db.Exec(`
+		UPDATE map_rooms SET area = $1, updated_at = NOW()
+		WHERE map_id = $2 AND area = $3
+	`, to, mapID, from)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DeleteArea deletes every room of an area, returning the number of rooms deleted
+func (s *MapStore) DeleteArea(mapID uuid.UUID, area string) (int64, error) {
+	result, err := s.db.Exec(`DELETE FROM map_rooms WHERE map_id = $1 AND area = $2`, mapID, area)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// rowScanner is satisfied by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanMapRoom(row rowScanner) (*MapRoom, error) {
+	room := &MapRoom{}
+	var exitsJSON, extraJSON []byte
+	err := row.Scan(&room.ID, &room.MapID, &room.Key, &room.Name, &room.Area, &room.Environment,
+		&room.X, &room.Y, &room.Z, &exitsJSON, &room.Avoid, &extraJSON, &room.CreatedAt, &room.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(exitsJSON, &room.Exits); err != nil {
+		return nil, err
+	}
+	if room.Exits == nil {
+		room.Exits = []MapExit{}
+	}
+	if err := decodeRoomExtra(extraJSON, room); err != nil {
+		return nil, err
+	}
+	return room, nil
+}
+
+// decodeRoomExtra fills room.Extra from the extra column, leaving it nil when empty
+func decodeRoomExtra(data []byte, room *MapRoom) error {
+	room.Extra = nil
+	if len(data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, &room.Extra); err != nil {
+		return err
+	}
+	if len(room.Extra) == 0 {
+		room.Extra = nil
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OTPChallenge is an emailed login code waiting to be entered
+// Only a keyed hash of the code is stored.
+type OTPChallenge struct {
+	ID        uuid.UUID
+	Email     string
+	OTPHash   string
+	ExpiresAt time.Time
+	Attempts  int
+	CreatedAt time.Time
+}
+
+// OTPChallengeStore handles OTP challenge database operations
+type OTPChallengeStore struct {
+	db *sql.DB
+}
+
+// NewOTPChallengeStore creates a new OTP challenge store
+func NewOTPChallengeStore(db *sql.DB) *OTPChallengeStore {
+	return &OTPChallengeStore{db: db}
+}
+
+// Create stores a new challenge for an email, replacing any earlier one so only the latest code works
+// Expired challenges for every email are cleaned up at the same time.
+func (s *OTPChallengeStore) Create(email, otpHash string, expiresAt time.Time) (uuid.UUID, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM otp_challenges WHERE email = $1 OR expires_at < NOW()`, email); err != nil {
+		return uuid.Nil, err
+	}
+	var id uuid.UUID
+	if err := tx.QueryRow(`
+		INSERT INTO otp_challenges (email, otp_hash, expires_at)
+		VALUES ($1, $2, $3)
+		RETURNING id
+	`, email, otpHash, expiresAt).Scan(&id); err != nil {
+		return uuid.Nil, err
+	}
+	return id, tx.Commit()
+}
+
+// StartAttempt counts an attempt against the unexpired challenge for an email that has fewer than
+// maxAttempts attempts and returns it with the new total, or nil if there is none
+// The attempt is counted before the code is compared, so concurrent requests cannot try more codes
+// than the limit allows.
+func (s *OTPChallengeStore) StartAttempt(email string, maxAttempts int) (*OTPChallenge, error) {
+	var c OTPChallenge
+	err := s.db.QueryRow(`
+		UPDATE otp_challenges SET attempts = attempts + 1
+		WHERE id = (
+			SELECT id FROM otp_challenges
+			WHERE email = $1 AND expires_at > NOW() AND attempts < $2
+			ORDER BY created_at DESC
+			LIMIT 1
+		) AND expires_at > NOW() AND attempts < $2
+		RETURNING id, email, otp_hash, expires_at, attempts, created_at
+	`, email, maxAttempts).Scan(&c.ID, &c.Email, &c.OTPHash, &c.ExpiresAt, &c.Attempts, &c.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Consume deletes an unexpired challenge after its code was entered correctly
+// It reports false if another request already used it or it has expired, so each code logs in at most once.
+func (s *OTPChallengeStore) Consume(id uuid.UUID) (bool, error) {
+	result, err := s.db.Exec(`DELETE FROM otp_challenges WHERE id = $1 AND expires_at > NOW()`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// ConsumeActive deletes an unexpired challenge that has fewer than maxAttempts wrong attempts,
+// returning its email, or "" if there was no such challenge
+// Login links use this, so a link stops working once its code is used or replaced.
+func (s *OTPChallengeStore) ConsumeActive(id uuid.UUID, maxAttempts int) (string, error) {
+	var email string
+	err := s.db.QueryRow(`
+		DELETE FROM otp_challenges
+		WHERE id = $1 AND expires_at > NOW() AND attempts < $2
+		RETURNING email
+	`, id, maxAttempts).Scan(&email)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return email, err
+}
+
+// DeleteByEmail removes all challenges for an email
+func (s *OTPChallengeStore) DeleteByEmail(email string) error {
+	_, err := s.db.Exec(`DELETE FROM otp_challenges WHERE email = $1`, email)
+	return err
+}
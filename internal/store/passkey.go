@@ -0,0 +1,179 @@
+package store
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Passkey is a WebAuthn credential a user can log in with
+// The credential ID and COSE public key are never sent to clients in full.
+type Passkey struct {
+	ID             uuid.UUID `json:"id"`
+	UserID         uuid.UUID `json:"-"`
+	CredentialID   []byte    `json:"-"`
+	PublicKey      []byte    `json:"-"`
+	SignCount      uint32    `json:"-"`
+	AAGUID         []byte    `json:"-"`
+	Transports     []string  `json:"transports"`
+	Name           string    `json:"name"`
+	BackupEligible bool      `json:"backup_eligible"`
+	BackupState    bool      `json:"backup_state"`
+	CreatedAt      string    `json:"created_at"`
+	LastUsedAt     *string   `json:"last_used_at,omitempty"`
+}
+
+// PasskeyStore handles passkey database operations
+type PasskeyStore struct {
+	db *sql.DB
+}
+
+// NewPasskeyStore creates a new passkey store
+func NewPasskeyStore(db *sql.DB) *PasskeyStore {
+	return &PasskeyStore{db: db}
+}
+
+const passkeyColumns = `id, user_id, credential_id, public_key, sign_count, aaguid, transports, name,
+	backup_eligible, backup_state, created_at, last_used_at`
+
+func scanPasskey(row rowScanner) (*Passkey, error) {
+	var p Passkey
+	var signCount int64
+	var transports string
+	var lastUsed sql.NullString
+	err := row.Scan(&p.ID, &p.UserID, &p.CredentialID, &p.PublicKey, &signCount, &p.AAGUID, &transports, &p.Name,
+		&p.BackupEligible, &p.BackupState, &p.CreatedAt, &lastUsed)
+	if err != nil {
+		return nil, err
+	}
+	p.SignCount = uint32(signCount)
+	p.Transports = []string{}
+	if transports != "" {
+		p.Transports = strings.Split(transports, ",")
+	}
+	if lastUsed.Valid {
+		p.LastUsedAt = &lastUsed.String
+	}
+	return &p, nil
+}
+
+// Create stores a new passkey
+func (s *PasskeyStore) Create(p *Passkey) error {
+	return s.db.QueryRow(`
+		INSERT INTO webauthn_credentials (user_id, credential_id, public_key, sign_count, aaguid, transports, name, backup_eligible, backup_state)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, created_at
+	`, p.UserID, p.CredentialID, p.PublicKey, int64(p.SignCount), p.AAGUID, strings.Join(p.Transports, ","), p.Name,
+		p.BackupEligible, p.BackupState).Scan(&p.ID, &p.CreatedAt)
+}
+
+// ListByUser returns a user's passkeys, oldest first
+func (s *PasskeyStore) ListByUser(userID uuid.UUID) ([]Passkey, error) {
+	rows, err := s.db.Query(`
+		SELECT `+passkeyColumns+`
+		FROM webauthn_credentials
+		WHERE user_id = $1
+		ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	passkeys := []Passkey{}
+	for rows.Next() {
+		p, err := scanPasskey(rows)
+		if err != nil {
+			return nil, err
+		}
+		passkeys = append(passkeys, *p)
+	}
+	return passkeys, rows.Err()
+}
+
+// ListCreatedSince returns the passkeys a user added at or after a time, oldest first
+func (s *PasskeyStore) ListCreatedSince(userID uuid.UUID, since time.Time) ([]Passkey, error) {
+	rows, err := s.db.Query(`
+		SELECT `+passkeyColumns+`
+		FROM webauthn_credentials
+		WHERE user_id = $1 AND created_at >= $2
+		ORDER BY created_at
+	`, userID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	passkeys := []Passkey{}
+	for rows.Next() {
+		p, err := scanPasskey(rows)
+		if err != nil {
+			return nil, err
+		}
+		passkeys = append(passkeys, *p)
+	}
+	return passkeys, rows.Err()
+}
+
+// GetByCredentialID returns the passkey with a WebAuthn credential ID
+func (s *PasskeyStore) GetByCredentialID(credentialID []byte) (*Passkey, error) {
+	p, err := scanPasskey(s.db.QueryRow(`
+		SELECT `+passkeyColumns+`
+		FROM webauthn_credentials
+		WHERE credential_id = $1
+	`, credentialID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return p, err
+}
+
+// CredentialExists checks if a WebAuthn credential ID is already registered
+func (s *PasskeyStore) CredentialExists(credentialID []byte) (bool, error) {
+	var exists bool
+	err := s.db.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM webauthn_credentials WHERE credential_id = $1)
+	`, credentialID).Scan(&exists)
+	return exists, err
+}
+
+// RecordUse stores the signature counter and backup state of a login and marks the passkey used
+// The counter only moves forward, so of two concurrent logins with a cloned key at most one succeeds.
+func (s *PasskeyStore) RecordUse(id uuid.UUID, previousCount, signCount uint32, backupState bool) (bool, error) {
+	result, err := s.db.Exec(`
+		UPDATE webauthn_credentials
+		SET sign_count = $3, backup_state = $4, last_used_at = NOW()
+		WHERE id = $1 AND sign_count = $2
+	`, id, int64(previousCount), int64(signCount), backupState)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// Rename changes a passkey's name
+func (s *PasskeyStore) Rename(userID, id uuid.UUID, name string) (bool, error) {
+	result, err := s.db.Exec(`
+		UPDATE webauthn_credentials SET name = $3 WHERE id = $1 AND user_id = $2
+	`, id, userID, name)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// Delete removes a passkey
+func (s *PasskeyStore) Delete(userID, id uuid.UUID) (bool, error) {
+	result, err := s.db.Exec(`
+		DELETE FROM webauthn_credentials WHERE id = $1 AND user_id = $2
+	`, id, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
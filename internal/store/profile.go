@@ -3,6 +3,8 @@ package store
 import (
 	"database/sql"
 	"encoding/json"
+	"sort"
+	"strings"
 
 	"github.com/google/uuid"
 )
@@ -17,6 +19,8 @@ type Profile struct {
 	Aliases      Aliases           `json:"aliases"`
 	Triggers     Triggers          `json:"triggers"`
 	Variables    Variables         `json:"variables"`
+	Classes      Classes           `json:"classes"`
+	Paths        Paths             `json:"paths"`
 	CreatedAt    string            `json:"created_at"`
 	UpdatedAt    string            `json:"updated_at"`
 }
@@ -27,6 +31,7 @@ type Alias struct {
 	Pattern     string `json:"pattern"`
 	Replacement string `json:"replacement"`
 	Enabled     bool   `json:"enabled"`
+	Class       string `json:"class,omitempty"`
 }
 
 // Aliases wraps a list of aliases
@@ -35,13 +40,23 @@ type Aliases struct {
 }
 
 // Trigger represents an output-driven automation trigger
+// Capture, if set, sends matching lines to a capture stream; the action may then be empty
 type Trigger struct {
-	ID       string `json:"id"`
-	Match    string `json:"match"`
-	Type     string `json:"type"`
-	Action   string `json:"action"`
-	Cooldown int    `json:"cooldown_ms"`
-	Enabled  bool   `json:"enabled"`
+	ID       string          `json:"id"`
+	Match    string          `json:"match"`
+	Type     string          `json:"type"`
+	Action   string          `json:"action"`
+	Cooldown int             `json:"cooldown_ms"`
+	Enabled  bool            `json:"enabled"`
+	Class    string          `json:"class,omitempty"`
+	Capture  *TriggerCapture `json:"capture,omitempty"`
+}
+
+// TriggerCapture copies the lines a trigger matches into a named stream such as "chat"
+// With Move set the lines are also removed from the main output
+type TriggerCapture struct {
+	Stream string `json:"stream"`
+	Move   bool   `json:"move,omitempty"`
 }
 
 // Triggers wraps a list of triggers
@@ -50,10 +65,14 @@ type Triggers struct {
 }
 
 // Variable represents an environment variable for automation
+// Value holds JSON matching Type; see variable.go for the supported types
+// Changes made by scripts during a session are only written back when Persistent is set
 type Variable struct {
-	ID    string `json:"id"`
-	Name  string `json:"name"`
-	Value string `json:"value"`
+	ID         string          `json:"id"`
+	Name       string          `json:"name"`
+	Type       string          `json:"type,omitempty"`
+	Value      json.RawMessage `json:"value"`
+	Persistent bool            `json:"persistent,omitempty"`
 }
 
 // Variables wraps a list of environment variables
@@ -61,6 +80,115 @@ type Variables struct {
 	Items []Variable `json:"items"`
 }
 
+// Path is a named speedwalk route such as "bank-guild" -> "3n2e4s"
+type Path struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Route string `json:"route"`
+}
+
+// Paths wraps a list of named paths
+type Paths struct {
+	Items []Path `json:"items"`
+}
+
+// Find returns the path with the given name (case-insensitive)
+func (p Paths) Find(name string) (Path, bool) {
+	for _, path := range p.Items {
+		if strings.EqualFold(path.Name, name) {
+			return path, true
+		}
+	}
+	return Path{}, false
+}
+
+// Class represents the on/off state of a named group of aliases and triggers
+type Class struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+}
+
+// Classes wraps a list of class states
+type Classes struct {
+	Items []Class `json:"items"`
+}
+
+// IsEnabled reports whether a class is enabled
+// Items without a class and classes with no stored state are enabled
+func (c Classes) IsEnabled(name string) bool {
+	if name == "" {
+		return true
+	}
+	for _, class := range c.Items {
+		if class.Name == name {
+			return class.Enabled
+		}
+	}
+	return true
+}
+
+// WithState returns a copy of the class list with the given class set to enabled/disabled
+func (c Classes) WithState(name string, enabled bool) Classes {
+	items := make([]Class, 0, len(c.Items)+1)
+	found := false
+	for _, class := range c.Items {
+		if class.Name == name {
+			class.Enabled = enabled
+			found = true
+		}
+		items = append(items, class)
+	}
+	if !found {
+		items = append(items, Class{Name: name, Enabled: enabled})
+	}
+	return Classes{Items: items}
+}
+
+// ClassSummary describes a class and how many aliases and triggers belong to it
+type ClassSummary struct {
+	Name         string `json:"name"`
+	Enabled      bool   `json:"enabled"`
+	AliasCount   int    `json:"alias_count"`
+	TriggerCount int    `json:"trigger_count"`
+}
+
+// SummarizeClasses builds the class list for a profile from its aliases, triggers and stored class states
+// Classes are returned sorted by name; stored states for classes with no members are included
+func SummarizeClasses(aliases Aliases, triggers Triggers, classes Classes) []ClassSummary {
+	byName := make(map[string]*ClassSummary)
+	get := func(name string) *ClassSummary {
+		summary, ok := byName[name]
+		if !ok {
+			summary = &ClassSummary{Name: name, Enabled: classes.IsEnabled(name)}
+			byName[name] = summary
+		}
+		return summary
+	}
+
+	for _, alias := range aliases.Items {
+		if alias.Class != "" {
+			get(alias.Class).AliasCount++
+		}
+	}
+	for _, trigger := range triggers.Items {
+		if trigger.Class != "" {
+			get(trigger.Class).TriggerCount++
+		}
+	}
+	for _, class := range classes.Items {
+		get(class.Name)
+	}
+
+	summaries := make([]ClassSummary, 0, len(byName))
+	for _, summary := range byName {
+		summaries = append(summaries, *summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Name < summaries[j].Name
+	})
+	return summaries
+}
+
 // ProfileSettings contains UI and behavior settings for a profile
 type ProfileSettings struct {
 	ScrollbackLimit   int  `json:"scrollback_limit"`
@@ -68,6 +196,7 @@ type ProfileSettings struct {
 	TimestampOutput   bool `json:"timestamp_output"`
 	WordWrap          bool `json:"word_wrap"`
 	AutomationEnabled bool `json:"automation_enabled"`
+	WalkDelayMs       int  `json:"walk_delay_ms"`
 }
 
 // DefaultProfileSettings returns the default profile settings
@@ -78,6 +207,7 @@ func DefaultProfileSettings() ProfileSettings {
 		TimestampOutput:   false,
 		WordWrap:          true,
 		AutomationEnabled: true,
+		WalkDelayMs:       250,
 	}
 }
 
@@ -86,6 +216,9 @@ func normalizeSettings(s ProfileSettings) ProfileSettings {
 	if s.ScrollbackLimit == 0 {
 		s.ScrollbackLimit = DefaultProfileSettings().ScrollbackLimit
 	}
+	if s.WalkDelayMs == 0 {
+		s.WalkDelayMs = DefaultProfileSettings().WalkDelayMs
+	}
 	// Note: bool fields default to false which matches DefaultProfileSettings for EchoInput, TimestampOutput
 	// but WordWrap should be true - handle explicitly
 	if !s.WordWrap && s.ScrollbackLimit == 0 {
@@ -102,6 +235,8 @@ type ProfileUpdate struct {
 	Aliases     *Aliases           `json:"aliases,omitempty"`
 	Triggers    *Triggers          `json:"triggers,omitempty"`
 	Variables   *Variables         `json:"variables,omitempty"`
+	Classes     *Classes           `json:"classes,omitempty"`
+	Paths       *Paths             `json:"paths,omitempty"`
 }
 
 // DefaultAliases returns the default aliases structure
@@ -119,6 +254,16 @@ func DefaultVariables() Variables {
 	return Variables{Items: []Variable{}}
 }
 
+// DefaultPaths returns the default named paths structure
+func DefaultPaths() Paths {
+	return Paths{Items: []Path{}}
+}
+
+// DefaultClasses returns the default classes structure
+func DefaultClasses() Classes {
+	return Classes{Items: []Class{}}
+}
+
 // ProfileStore handles profiles database operations
 type ProfileStore struct {
 	db *sql.DB
@@ -132,8 +277,8 @@ func NewProfileStore(db *sql.DB) *ProfileStore {
 // CreateProfile creates a new profile for a connection
 func (s *ProfileStore) CreateProfile(userID, connectionID uuid.UUID) (*Profile, error) {
 	query := `
-		INSERT INTO profiles (user_id, connection_id, keybindings, settings, aliases, triggers, variables)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO profiles (user_id, connection_id, keybindings, settings, aliases, triggers, variables, classes, paths)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 		RETURNING id, created_at, updated_at
 	`
 
@@ -146,9 +291,13 @@ func (s *ProfileStore) CreateProfile(userID, connectionID uuid.UUID) (*Profile,
 	triggersJSON, _ := json.Marshal(defaultTriggers)
 	defaultVariables := DefaultVariables()
 	variablesJSON, _ := json.Marshal(defaultVariables)
+	defaultClasses := DefaultClasses()
+	classesJSON, _ := json.Marshal(defaultClasses)
+	defaultPaths := DefaultPaths()
+	pathsJSON, _ := json.Marshal(defaultPaths)
 
 	var profile Profile
-	err := s.db.QueryRow(query, userID, connectionID, defaultKeybindings, settingsJSON, aliasesJSON, triggersJSON, variablesJSON).
+	err := s.db.QueryRow(query, userID, connectionID, defaultKeybindings, settingsJSON, aliasesJSON, triggersJSON, variablesJSON, classesJSON, pathsJSON).
 		Scan(&profile.ID, &profile.CreatedAt, &profile.UpdatedAt)
 	if err != nil {
 		return nil, err
@@ -161,6 +310,8 @@ func (s *ProfileStore) CreateProfile(userID, connectionID uuid.UUID) (*Profile,
 	profile.Aliases = defaultAliases
 	profile.Triggers = defaultTriggers
 	profile.Variables = defaultVariables
+	profile.Classes = defaultClasses
+	profile.Paths = defaultPaths
 
 	return &profile, nil
 }
@@ -168,13 +319,13 @@ func (s *ProfileStore) CreateProfile(userID, connectionID uuid.UUID) (*Profile,
 // GetProfile retrieves a profile by ID for a specific user
 func (s *ProfileStore) GetProfile(userID, profileID uuid.UUID) (*Profile, error) {
 	query := `
-		SELECT id, user_id, connection_id, keybindings, settings, aliases, triggers, variables, created_at, updated_at
+		SELECT id, user_id, connection_id, keybindings, settings, aliases, triggers, variables, classes, paths, created_at, updated_at
 		FROM profiles
 		WHERE id = $1 AND user_id = $2
 	`
 
 	var profile Profile
-	var keybindingsJSON, settingsJSON, aliasesJSON, triggersJSON, variablesJSON []byte
+	var keybindingsJSON, settingsJSON, aliasesJSON, triggersJSON, variablesJSON, classesJSON, pathsJSON []byte
 
 	err := s.db.QueryRow(query, profileID, userID).Scan(
 		&profile.ID,
@@ -185,6 +336,8 @@ func (s *ProfileStore) GetProfile(userID, profileID uuid.UUID) (*Profile, error)
 		&aliasesJSON,
 		&triggersJSON,
 		&variablesJSON,
+		&classesJSON,
+		&pathsJSON,
 		&profile.CreatedAt,
 		&profile.UpdatedAt,
 	)
@@ -211,6 +364,12 @@ func (s *ProfileStore) GetProfile(userID, profileID uuid.UUID) (*Profile, error)
 	if err := json.Unmarshal(variablesJSON, &profile.Variables); err != nil {
 		return nil, err
 	}
+	if err := json.Unmarshal(classesJSON, &profile.Classes); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(pathsJSON, &profile.Paths); err != nil {
+		return nil, err
+	}
 
 	// Normalize settings to defaults if empty/partial
 	profile.Settings = normalizeSettings(profile.Settings)
@@ -221,13 +380,13 @@ func (s *ProfileStore) GetProfile(userID, profileID uuid.UUID) (*Profile, error)
 // GetProfileByConnection retrieves a profile by connection ID for a specific user
 func (s *ProfileStore) GetProfileByConnection(userID, connectionID uuid.UUID) (*Profile, error) {
 	query := `
-		SELECT id, user_id, connection_id, keybindings, settings, aliases, triggers, variables, created_at, updated_at
+		SELECT id, user_id, connection_id, keybindings, settings, aliases, triggers, variables, classes, paths, created_at, updated_at
 		FROM profiles
 		WHERE connection_id = $1 AND user_id = $2
 	`
 
 	var profile Profile
-	var keybindingsJSON, settingsJSON, aliasesJSON, triggersJSON, variablesJSON []byte
+	var keybindingsJSON, settingsJSON, aliasesJSON, triggersJSON, variablesJSON, classesJSON, pathsJSON []byte
 
 	err := s.db.QueryRow(query, connectionID, userID).Scan(
 		&profile.ID,
@@ -238,6 +397,8 @@ func (s *ProfileStore) GetProfileByConnection(userID, connectionID uuid.UUID) (*
 		&aliasesJSON,
 		&triggersJSON,
 		&variablesJSON,
+		&classesJSON,
+		&pathsJSON,
 		&profile.CreatedAt,
 		&profile.UpdatedAt,
 	)
@@ -264,6 +425,12 @@ func (s *ProfileStore) GetProfileByConnection(userID, connectionID uuid.UUID) (*
 	if err := json.Unmarshal(variablesJSON, &profile.Variables); err != nil {
 		return nil, err
 	}
+	if err := json.Unmarshal(classesJSON, &profile.Classes); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(pathsJSON, &profile.Paths); err != nil {
+		return nil, err
+	}
 
 	// Normalize settings to defaults if empty/partial
 	profile.Settings = normalizeSettings(profile.Settings)
@@ -288,6 +455,8 @@ func (s *ProfileStore) UpdateProfile(userID, profileID uuid.UUID, updates *Profi
 	var aliasesJSON []byte
 	var triggersJSON []byte
 	var variablesJSON []byte
+	var classesJSON []byte
+	var pathsJSON []byte
 
 	if updates.Keybindings != nil {
 		keybindingsJSON, _ = json.Marshal(*updates.Keybindings)
@@ -319,15 +488,27 @@ func (s *ProfileStore) UpdateProfile(userID, profileID uuid.UUID, updates *Profi
 		variablesJSON, _ = json.Marshal(existing.Variables)
 	}
 
+	if updates.Classes != nil {
+		classesJSON, _ = json.Marshal(*updates.Classes)
+	} else {
+		classesJSON, _ = json.Marshal(existing.Classes)
+	}
+
+	if updates.Paths != nil {
+		pathsJSON, _ = json.Marshal(*updates.Paths)
+	} else {
+		pathsJSON, _ = json.Marshal(existing.Paths)
+	}
+
 	query := `
 		UPDATE profiles
-		SET keybindings = $1, settings = $2, aliases = $3, triggers = $4, variables = $5, updated_at = NOW()
-		WHERE id = $6 AND user_id = $7
+		SET keybindings = $1, settings = $2, aliases = $3, triggers = $4, variables = $5, classes = $6, paths = $7, updated_at = NOW()
+		WHERE id = $8 AND user_id = $9
 		RETURNING updated_at
 	`
 
 	var updatedAt string
-	err = s.db.QueryRow(query, keybindingsJSON, settingsJSON, aliasesJSON, triggersJSON, variablesJSON, profileID, userID).Scan(&updatedAt)
+	err = s.db.QueryRow(query, keybindingsJSON, settingsJSON, aliasesJSON, triggersJSON, variablesJSON, classesJSON, pathsJSON, profileID, userID).Scan(&updatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -349,10 +530,54 @@ func (s *ProfileStore) UpdateProfile(userID, profileID uuid.UUID, updates *Profi
 	if updates.Variables != nil {
 		existing.Variables = *updates.Variables
 	}
+	if updates.Classes != nil {
+		existing.Classes = *updates.Classes
+	}
+	if updates.Paths != nil {
+		existing.Paths = *updates.Paths
+	}
 
 	return existing, nil
 }
 
+// SetClassState switches one class of a profile on or off and returns the updated profile
+// The profile row is locked while its class list changes, so concurrent toggles of different
+// classes are not lost. Returns nil if the profile does not exist.
+func (s *ProfileStore) SetClassState(userID, profileID uuid.UUID, name string, enabled bool) (*Profile, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var classesJSON []byte
+	err = tx.QueryRow(`
+		SELECT classes FROM profiles WHERE id = $1 AND user_id = $2 FOR UPDATE
+	`, profileID, userID).Scan(&classesJSON)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var classes Classes
+	if err := json.Unmarshal(classesJSON, &classes); err != nil {
+		return nil, err
+	}
+	classesJSON, _ = json.Marshal(classes.WithState(name, enabled))
+	if _, err := tx.Exec(`
+		UPDATE profiles SET classes = $1, updated_at = NOW() WHERE id = $2 AND user_id = $3
+	`, classesJSON, profileID, userID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.GetProfile(userID, profileID)
+}
+
 // DeleteProfile deletes a profile by ID
 func (s *ProfileStore) DeleteProfile(userID, profileID uuid.UUID) error {
 	query := `DELETE FROM profiles WHERE id = $1 AND user_id = $2`
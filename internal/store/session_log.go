@@ -0,0 +1,347 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Session log entry directions
+const (
+	LogDirectionSent     = "sent"
+	LogDirectionReceived = "received"
+)
+
+// SessionLog is the transcript of one recorded MUD session
+// EndedAt is nil while the session is still being recorded
+type SessionLog struct {
+	ID           uuid.UUID `json:"id"`
+	UserID       uuid.UUID `json:"user_id"`
+	ConnectionID uuid.UUID `json:"connection_id"`
+	Host         string    `json:"host"`
+	Port         int       `json:"port"`
+	StartedAt    string    `json:"started_at"`
+	EndedAt      *string   `json:"ended_at,omitempty"`
+	Bytes        int64     `json:"bytes"`
+	ChunkCount   int       `json:"chunk_count"`
+}
+
+// SessionLogEntry is one read from or command sent to the MUD
+// Time is in unix milliseconds; Data is the text with telnet commands removed
+type SessionLogEntry struct {
+	Time      int64  `json:"t"`
+	Direction string `json:"dir"`
+	Data      string `json:"data"`
+}
+
+// SessionLogChunk is a consecutive run of entries of a log, numbered from 0
+// SearchText is the chunk's text with ANSI codes removed, indexed for full-text search
+type SessionLogChunk struct {
+	ID        uuid.UUID         `json:"id"`
+	LogID     uuid.UUID         `json:"log_id"`
+	Seq       int               `json:"seq"`
+	StartedAt string            `json:"started_at"`
+	EndedAt   string            `json:"ended_at"`
+	Entries   []SessionLogEntry `json:"entries"`
+	Bytes     int               `json:"bytes"`
+
+	SearchText string `json:"-"`
+}
+
+// LogSearchFilter narrows a full-text search of a user's logs
+type LogSearchFilter struct {
+	ConnectionID *uuid.UUID
+	From         *time.Time
+	To           *time.Time
+}
+
+// LogSearchChunk is a chunk matching a search, with the connection its log belongs to
+type LogSearchChunk struct {
+	SessionLogChunk
+	ConnectionID uuid.UUID
+}
+
+// SessionLogSettings holds a user's retention limits
+type SessionLogSettings struct {
+	RetentionDays int   `json:"retention_days"`
+	MaxBytes      int64 `json:"max_bytes"`
+}
+
+// SessionLogStore handles session transcript database operations
+type SessionLogStore struct {
+	db *sql.DB
+}
+
+// NewSessionLogStore creates a new session log store
+func NewSessionLogStore(db *sql.DB) *SessionLogStore {
+	return &SessionLogStore{db: db}
+}
+
+const sessionLogColumns = `id, user_id, connection_id, host, port, started_at, ended_at, bytes, chunk_count`
+
+// LoggingEnabled reports whether logging is turned on for a connection
+// Returns false if the connection does not belong to the user
+func (s *SessionLogStore) LoggingEnabled(userID, connectionID uuid.UUID) (bool, error) {
+	var enabled bool
+	err := s.db.QueryRow(`SELECT logging_enabled FROM saved_connections WHERE id = $1 AND user_id = $2`,
+		connectionID, userID).Scan(&enabled)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return enabled, err
+}
+
+// SetLoggingEnabled turns logging on or off for a connection, reporting whether the connection exists
+func (s *SessionLogStore) SetLoggingEnabled(userID, connectionID uuid.UUID, enabled bool) (bool, error) {
+	result, err := s.db.Exec(`
+		UPDATE saved_connections SET logging_enabled = $1, updated_at = NOW()
+		WHERE id = $2 AND user_id = $3
+	`, enabled, connectionID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// CreateLog starts a log for a session on a connection
+// Returns nil, nil if the connection does not belong to the user or has logging turned off
+func (s *SessionLogStore) CreateLog(userID, connectionID uuid.UUID, host string, port int) (*SessionLog, error) {
+	query := `
+		INSERT INTO session_logs (user_id, connection_id, host, port)
+		SELECT user_id, id, $3::varchar, $4::integer FROM saved_connections
+		WHERE id = $1 AND user_id = $2 AND logging_enabled
+		RETURNING ` + sessionLogColumns
+	return scanSessionLog(s.db.QueryRow(query, connectionID, userID, host, port))
+}
+
+// AppendChunk stores the next chunk of a log and adds its size to the log
+func (s *SessionLogStore) AppendChunk(chunk *SessionLogChunk) error {
+	if len(chunk.Entries) == 0 {
+		return errors.New("empty chunk")
+	}
+	entriesJSON, err := json.Marshal(chunk.Entries)
+	if err != nil {
+		return err
+	}
+	startedAt := time.UnixMilli(chunk.Entries[0].Time)
+	endedAt := time.UnixMilli(chunk.Entries[len(chunk.Entries)-1].Time)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`
+		INSERT INTO session_log_chunks (log_id, seq, started_at, ended_at, entries, bytes, search)
+		VALUES ($1, $2, $3, $4, $5, $6, to_tsvector('simple', $7))
+		RETURNING id
+	`, chunk.LogID, chunk.Seq, startedAt, endedAt, entriesJSON, chunk.Bytes, chunk.SearchText).Scan(&chunk.ID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		UPDATE session_logs SET bytes = bytes + $1, chunk_count = chunk_count + 1 WHERE id = $2
+	`, chunk.Bytes, chunk.LogID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// FinishLog marks a log as ended
+func (s *SessionLogStore) FinishLog(logID uuid.UUID) error {
+	_, err := s.db.Exec(`UPDATE session_logs SET ended_at = NOW() WHERE id = $1`, logID)
+	return err
+}
+
+// GetLog retrieves a log (for a specific user)
+func (s *SessionLogStore) GetLog(userID, logID uuid.UUID) (*SessionLog, error) {
+	query := `SELECT ` + sessionLogColumns + ` FROM session_logs WHERE id = $1 AND user_id = $2`
+	return scanSessionLog(s.db.QueryRow(query, logID, userID))
+}
+
+// ListLogs returns a user's logs, newest first, optionally only those of one connection
+func (s *SessionLogStore) ListLogs(userID uuid.UUID, connectionID *uuid.UUID, limit, offset int) ([]SessionLog, error) {
+	query := `
+		SELECT ` + sessionLogColumns + ` FROM session_logs
+		WHERE user_id = $1 AND ($2::uuid IS NULL OR connection_id = $2)
+		ORDER BY started_at DESC, id
+		LIMIT $3 OFFSET $4
+	`
+	rows, err := s.db.Query(query, userID, connectionID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	logs := []SessionLog{}
+	for rows.Next() {
+		l, err := scanSessionLog(rows)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, *l)
+	}
+	return logs, rows.Err()
+}
+
+// ListChunks returns up to limit chunks of a log after seq afterSeq, in order
+// When from or to is set, only chunks overlapping that time range are returned
+func (s *SessionLogStore) ListChunks(logID uuid.UUID, afterSeq int, from, to *time.Time, limit int) ([]SessionLogChunk, error) {
+	rows, err := s.db.Query(`
+		SELECT id, log_id, seq, started_at, ended_at, entries, bytes
+		FROM session_log_chunks
+		WHERE log_id = $1 AND seq > $2
+			AND ($3::timestamptz IS NULL OR ended_at >= $3)
+			AND ($4::timestamptz IS NULL OR started_at <= $4)
+		ORDER BY seq
+		LIMIT $5
+	`, logID, afterSeq, from, to, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chunks []SessionLogChunk
+	for rows.Next() {
+		var c SessionLogChunk
+		var entriesJSON []byte
+		if err := rows.Scan(&c.ID, &c.LogID, &c.Seq, &c.StartedAt, &c.EndedAt, &entriesJSON, &c.Bytes); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(entriesJSON, &c.Entries); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, c)
+	}
+	return chunks, rows.Err()
+}
+
+// SearchChunks returns the chunks of a user's logs matching a web-search style query, newest first
+// When before is set, only that chunk and older ones are returned, so a search can be resumed
+func (s *SessionLogStore) SearchChunks(userID uuid.UUID, query string, filter LogSearchFilter, before *uuid.UUID, limit int) ([]LogSearchChunk, error) {
+	rows, err := s.db.Query(`
+		SELECT c.id, c.log_id, c.seq, c.started_at, c.ended_at, c.entries, c.bytes, l.connection_id
+		FROM session_log_chunks c
+		JOIN session_logs l ON l.id = c.log_id
+		WHERE l.user_id = $1
+			AND c.search @@ websearch_to_tsquery('simple', $2)
+			AND ($3::uuid IS NULL OR l.connection_id = $3)
+			AND ($4::timestamptz IS NULL OR c.ended_at >= $4)
+			AND ($5::timestamptz IS NULL OR c.started_at <= $5)
+			AND ($6::uuid IS NULL OR (c.started_at, c.id) <= (
+				SELECT started_at, id FROM session_log_chunks WHERE id = $6))
+		ORDER BY c.started_at DESC, c.id DESC
+		LIMIT $7
+	`, userID, query, filter.ConnectionID, filter.From, filter.To, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chunks []LogSearchChunk
+	for rows.Next() {
+		var c LogSearchChunk
+		var entriesJSON []byte
+		err := rows.Scan(&c.ID, &c.LogID, &c.Seq, &c.StartedAt, &c.EndedAt, &entriesJSON, &c.Bytes, &c.ConnectionID)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(entriesJSON, &c.Entries); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, c)
+	}
+	return chunks, rows.Err()
+}
+
+// DeleteLog deletes a log and its chunks, reporting whether it existed
+func (s *SessionLogStore) DeleteLog(userID, logID uuid.UUID) (bool, error) {
+	result, err := s.db.Exec(`DELETE FROM session_logs WHERE id = $1 AND user_id = $2`, logID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// GetSettings returns a user's retention limits, or nil if the user has not set any
+func (s *SessionLogStore) GetSettings(userID uuid.UUID) (*SessionLogSettings, error) {
+	settings := &SessionLogSettings{}
+	err := s.db.QueryRow(`SELECT retention_days, max_bytes FROM session_log_settings WHERE user_id = $1`, userID).
+		Scan(&settings.RetentionDays, &settings.MaxBytes)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// SaveSettings sets a user's retention limits
+func (s *SessionLogStore) SaveSettings(userID uuid.UUID, settings *SessionLogSettings) error {
+	_, err := s.db.Exec(`
+		INSERT INTO session_log_settings (user_id, retention_days, max_bytes)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET
+			retention_days = EXCLUDED.retention_days, max_bytes = EXCLUDED.max_bytes, updated_at = NOW()
+	`, userID, settings.RetentionDays, settings.MaxBytes)
+	return err
+}
+
+// PruneExpired deletes logs started longer ago than their owner's retention period
+// Users without settings keep logs for defaultDays. Returns the number of logs deleted.
+func (s *SessionLogStore) PruneExpired(defaultDays int) (int64, error) {
+	result, err := s.db.Exec(`
+		DELETE FROM session_logs l
+		WHERE l.started_at < NOW() - make_interval(days => COALESCE(
+			(SELECT retention_days FROM session_log_settings s WHERE s.user_id = l.user_id), $1))
+	`, defaultDays)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// PruneOverQuota deletes each user's oldest finished logs until their logs fit the user's storage limit
+// Users without settings may store defaultMaxBytes. Returns the number of logs deleted.
+func (s *SessionLogStore) PruneOverQuota(defaultMaxBytes int64) (int64, error) {
+	result, err := s.db.Exec(`
+		DELETE FROM session_logs WHERE id IN (
+			SELECT id FROM (
+				SELECT l.id, l.ended_at,
+					SUM(l.bytes) OVER (PARTITION BY l.user_id ORDER BY l.started_at DESC, l.id) AS total,
+					COALESCE(s.max_bytes, $1) AS max_bytes
+				FROM session_logs l
+				LEFT JOIN session_log_settings s ON s.user_id = l.user_id
+			) sized
+			WHERE sized.total > sized.max_bytes AND sized.ended_at IS NOT NULL
+		)
+	`, defaultMaxBytes)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func scanSessionLog(row rowScanner) (*SessionLog, error) {
+	l := &SessionLog{}
+	var endedAt sql.NullString
+	err := row.Scan(&l.ID, &l.UserID, &l.ConnectionID, &l.Host, &l.Port, &l.StartedAt, &endedAt, &l.Bytes, &l.ChunkCount)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if endedAt.Valid {
+		l.EndedAt = &endedAt.String
+	}
+	return l, nil
+}
@@ -0,0 +1,190 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+// SessionRecording is a timed recording of the output of a MUD session
+// ConnectionID is nil for sessions not opened from a saved connection; EndedAt is nil while recording
+type SessionRecording struct {
+	ID           uuid.UUID  `json:"id"`
+	UserID       uuid.UUID  `json:"user_id"`
+	ConnectionID *uuid.UUID `json:"connection_id,omitempty"`
+	Host         string     `json:"host"`
+	Port         int        `json:"port"`
+	Title        string     `json:"title"`
+	Width        int        `json:"width"`
+	Height       int        `json:"height"`
+	StartedAt    string     `json:"started_at"`
+	EndedAt      *string    `json:"ended_at,omitempty"`
+	DurationMs   int64      `json:"duration_ms"`
+	Bytes        int64      `json:"bytes"`
+	ChunkCount   int        `json:"chunk_count"`
+}
+
+// SessionRecordingChunk is a consecutive run of asciicast v2 event lines of a recording, numbered from 0
+type SessionRecordingChunk struct {
+	RecordingID uuid.UUID `json:"recording_id"`
+	Seq         int       `json:"seq"`
+	Events      string    `json:"events"`
+}
+
+// SessionRecordingStore handles session recording database operations
+type SessionRecordingStore struct {
+	db *sql.DB
+}
+
+// NewSessionRecordingStore creates a new session recording store
+func NewSessionRecordingStore(db *sql.DB) *SessionRecordingStore {
+	return &SessionRecordingStore{db: db}
+}
+
+const sessionRecordingColumns = `id, user_id, connection_id, host, port, title, width, height, started_at, ended_at, duration_ms, bytes, chunk_count`
+
+// CreateRecording starts a recording
+func (s *SessionRecordingStore) CreateRecording(rec *SessionRecording) (*SessionRecording, error) {
+	query := `
+		INSERT INTO session_recordings (user_id, connection_id, host, port, title, width, height)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING ` + sessionRecordingColumns
+	return scanSessionRecording(s.db.QueryRow(query,
+		rec.UserID, rec.ConnectionID, rec.Host, rec.Port, rec.Title, rec.Width, rec.Height))
+}
+
+// AppendRecordingChunk stores the next chunk of a recording and adds its size to the recording
+func (s *SessionRecordingStore) AppendRecordingChunk(chunk *SessionRecordingChunk) error {
+	if chunk.Events == "" {
+		return errors.New("empty chunk")
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO session_recording_chunks (recording_id, seq, events, bytes)
+		VALUES ($1, $2, $3, $4)
+	`, chunk.RecordingID, chunk.Seq, chunk.Events, len(chunk.Events))
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		UPDATE session_recordings SET bytes = bytes + $1, chunk_count = chunk_count + 1 WHERE id = $2
+	`, len(chunk.Events), chunk.RecordingID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// FinishRecording marks a recording as ended, durationMs after it started
+func (s *SessionRecordingStore) FinishRecording(recordingID uuid.UUID, durationMs int64) error {
+	_, err := s.db.Exec(`UPDATE session_recordings SET ended_at = NOW(), duration_ms = $1 WHERE id = $2`,
+		durationMs, recordingID)
+	return err
+}
+
+// GetRecording retrieves a recording (for a specific user)
+func (s *SessionRecordingStore) GetRecording(userID, recordingID uuid.UUID) (*SessionRecording, error) {
+	query := `SELECT ` + sessionRecordingColumns + ` FROM session_recordings WHERE id = $1 AND user_id = $2`
+	return scanSessionRecording(s.db.QueryRow(query, recordingID, userID))
+}
+
+// ListRecordings returns a user's recordings, newest first
+func (s *SessionRecordingStore) ListRecordings(userID uuid.UUID, limit, offset int) ([]SessionRecording, error) {
+	query := `
+		SELECT ` + sessionRecordingColumns + ` FROM session_recordings
+		WHERE user_id = $1
+		ORDER BY started_at DESC, id
+		LIMIT $2 OFFSET $3
+	`
+	rows, err := s.db.Query(query, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	recordings := []SessionRecording{}
+	for rows.Next() {
+		rec, err := scanSessionRecording(rows)
+		if err != nil {
+			return nil, err
+		}
+		recordings = append(recordings, *rec)
+	}
+	return recordings, rows.Err()
+}
+
+// ListRecordingChunks returns up to limit chunks of a recording after seq afterSeq, in order
+func (s *SessionRecordingStore) ListRecordingChunks(recordingID uuid.UUID, afterSeq, limit int) ([]SessionRecordingChunk, error) {
+	rows, err := s.db.Query(`
+		SELECT recording_id, seq, events FROM session_recording_chunks
+		WHERE recording_id = $1 AND seq > $2
+		ORDER BY seq
+		LIMIT $3
+	`, recordingID, afterSeq, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chunks []SessionRecordingChunk
+	for rows.Next() {
+		var c SessionRecordingChunk
+		if err := rows.Scan(&c.RecordingID, &c.Seq, &c.Events); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, c)
+	}
+	return chunks, rows.Err()
+}
+
+// DeleteRecording deletes a recording and its chunks, reporting whether it existed
+func (s *SessionRecordingStore) DeleteRecording(userID, recordingID uuid.UUID) (bool, error) {
+	result, err := s.db.Exec(`DELETE FROM session_recordings WHERE id = $1 AND user_id = $2`, recordingID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// PruneExpiredRecordings deletes finished recordings started longer ago than their owner's log retention period
+// Users without settings keep recordings for defaultDays. Returns the number of recordings deleted.
+func (s *SessionRecordingStore) PruneExpiredRecordings(defaultDays int) (int64, error) {
+	result, err := s.db.Exec(`
+		DELETE FROM session_recordings r
+		WHERE r.ended_at IS NOT NULL AND r.started_at < NOW() - make_interval(days => COALESCE(
+			(SELECT retention_days FROM session_log_settings s WHERE s.user_id = r.user_id), $1))
+	`, defaultDays)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func scanSessionRecording(row rowScanner) (*SessionRecording, error) {
+	rec := &SessionRecording{}
+	var connectionID uuid.NullUUID
+	var endedAt sql.NullString
+	err := row.Scan(&rec.ID, &rec.UserID, &connectionID, &rec.Host, &rec.Port, &rec.Title, &rec.Width, &rec.Height,
+		&rec.StartedAt, &endedAt, &rec.DurationMs, &rec.Bytes, &rec.ChunkCount)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if connectionID.Valid {
+		rec.ConnectionID = &connectionID.UUID
+	}
+	if endedAt.Valid {
+		rec.EndedAt = &endedAt.String
+	}
+	return rec, nil
+}
@@ -0,0 +1,186 @@
+package store
+
+import (
+	"database/sql"
+
+	"github.com/google/uuid"
+)
+
+// UserTOTP is a user's TOTP second factor
+// EnabledAt is nil while enrollment waits for the first code to be confirmed.
+type UserTOTP struct {
+	UserID          uuid.UUID
+	EncryptedSecret []byte
+	KeyVersion      int
+	LastUsedStep    int64
+	EnabledAt       *string
+	CreatedAt       string
+}
+
+// TOTPStore handles TOTP and recovery code database operations
+type TOTPStore struct {
+	db *sql.DB
+}
+
+// NewTOTPStore creates a new TOTP store
+func NewTOTPStore(db *sql.DB) *TOTPStore {
+	return &TOTPStore{db: db}
+}
+
+// Get returns a user's TOTP settings, or nil if they have never started enrollment
+func (s *TOTPStore) Get(userID uuid.UUID) (*UserTOTP, error) {
+	var t UserTOTP
+	var enabledAt sql.NullString
+	err := s.db.QueryRow(`
+		SELECT user_id, encrypted_secret, key_version, last_used_step, enabled_at, created_at
+		FROM user_totp
+		WHERE user_id = $1
+	`, userID).Scan(&t.UserID, &t.EncryptedSecret, &t.KeyVersion, &t.LastUsedStep, &enabledAt, &t.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if enabledAt.Valid {
+		t.EnabledAt = &enabledAt.String
+	}
+	return &t, nil
+}
+
+// IsEnabled checks if a user has a confirmed TOTP second factor
+func (s *TOTPStore) IsEnabled(userID uuid.UUID) (bool, error) {
+	var enabled bool
+	err := s.db.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM user_totp WHERE user_id = $1 AND enabled_at IS NOT NULL)
+	`, userID).Scan(&enabled)
+	return enabled, err
+}
+
+// SavePending stores a new secret awaiting confirmation, replacing any earlier unconfirmed one
+// It does nothing if TOTP is already enabled, and reports whether the secret was saved.
+func (s *TOTPStore) SavePending(userID uuid.UUID, encryptedSecret []byte, keyVersion int) (bool, error) {
+	result, err := s.db.Exec(`
+		INSERT INTO user_totp (user_id, encrypted_secret, key_version)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE
+		SET encrypted_secret = EXCLUDED.encrypted_secret, key_version = EXCLUDED.key_version,
+			last_used_step = 0, created_at = NOW()
+		WHERE user_totp.enabled_at IS NULL
+	`, userID, encryptedSecret, keyVersion)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// Enable confirms a pending secret and replaces the user's recovery codes
+func (s *TOTPStore) Enable(userID uuid.UUID, step int64, codeHashes []string) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE user_totp
+		SET enabled_at = NOW(), last_used_step = $2
+		WHERE user_id = $1 AND enabled_at IS NULL
+	`, userID, step)
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	if err := replaceRecoveryCodes(tx, userID, codeHashes); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// UseStep records a code's time step so the code cannot be used again
+// It fails if another request used a code for the same or a later step first.
+func (s *TOTPStore) UseStep(userID uuid.UUID, step int64) (bool, error) {
+	result, err := s.db.Exec(`
+		UPDATE user_totp SET last_used_step = $2
+		WHERE user_id = $1 AND last_used_step < $2
+	`, userID, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// Disable removes a user's TOTP secret and recovery codes
+func (s *TOTPStore) Disable(userID uuid.UUID) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM totp_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM user_totp WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReplaceRecoveryCodes invalidates a user's recovery codes and stores new ones
+func (s *TOTPStore) ReplaceRecoveryCodes(userID uuid.UUID, codeHashes []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(tx, userID, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userID uuid.UUID, codeHashes []string) error {
+	if _, err := tx.Exec(`DELETE FROM totp_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	for _, hash := range codeHashes {
+		if _, err := tx.Exec(`
+			INSERT INTO totp_recovery_codes (user_id, code_hash) VALUES ($1, $2)
+		`, userID, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode marks an unused recovery code as used, reporting whether it was valid
+func (s *TOTPStore) UseRecoveryCode(userID uuid.UUID, codeHash string) (bool, error) {
+	result, err := s.db.Exec(`
+		UPDATE totp_recovery_codes SET used_at = NOW()
+		WHERE id = (
+			SELECT id FROM totp_recovery_codes
+			WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+			LIMIT 1
+		) AND used_at IS NULL
+	`, userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// CountRecoveryCodes returns how many unused recovery codes a user has left
+func (s *TOTPStore) CountRecoveryCodes(userID uuid.UUID) (int, error) {
+	var count int
+	err := s.db.QueryRow(`
+		SELECT COUNT(*) FROM totp_recovery_codes WHERE user_id = $1 AND used_at IS NULL
+	`, userID).Scan(&count)
+	return count, err
+}
@@ -109,6 +109,16 @@ func (s *UserStore) MarkEmailVerified(id uuid.UUID) error {
 	return err
 }
 
+// Delete removes a user; everything they own is removed with them (ON DELETE CASCADE)
+func (s *UserStore) Delete(id uuid.UUID) (bool, error) {
+	result, err := s.db.Exec(`DELETE FROM users WHERE id = $1`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
 // EmailExists checks if an email already exists
 func (s *UserStore) EmailExists(email string) (bool, error) {
 	var exists bool
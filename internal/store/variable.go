@@ -0,0 +1,143 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Variable types
+const (
+	VariableTypeString = "string"
+	VariableTypeInt    = "int"
+	VariableTypeFloat  = "float"
+	VariableTypeBool   = "bool"
+	VariableTypeList   = "list"
+	VariableTypeTable  = "table"
+)
+
+// Variable value limits
+const (
+	MaxVariableDepth = 8         // Maximum nesting of lists and tables
+	MaxVariableSize  = 64 * 1024 // Maximum encoded size of one value in bytes
+)
+
+// Normalize validates the value against the declared type and fills in a missing type
+// Variables saved before typed values existed have no type and a string value;
+// an untyped value is given the type inferred from its JSON
+func (v *Variable) Normalize() error {
+	value := bytes.TrimSpace(v.Value)
+	if len(value) == 0 {
+		return errors.New("value is required")
+	}
+	if len(value) > MaxVariableSize {
+		return fmt.Errorf("value exceeds %d bytes", MaxVariableSize)
+	}
+
+	decoded, err := decodeVariableJSON(value)
+	if err != nil {
+		return errors.New("value is not valid JSON")
+	}
+	if decoded == nil {
+		return errors.New("value cannot be null")
+	}
+
+	inferred, err := variableTypeOf(decoded, 0)
+	if err != nil {
+		return err
+	}
+
+	switch v.Type {
+	case "":
+		v.Type = inferred
+	case inferred:
+	case VariableTypeFloat:
+		// Whole numbers are valid floats
+		if inferred != VariableTypeInt {
+			return fmt.Errorf("value does not match type %s", v.Type)
+		}
+	case VariableTypeString, VariableTypeInt, VariableTypeBool, VariableTypeList, VariableTypeTable:
+		return fmt.Errorf("value does not match type %s", v.Type)
+	default:
+		return fmt.Errorf("unknown type %q", v.Type)
+	}
+
+	v.Value = json.RawMessage(value)
+	return nil
+}
+
+// StringValue returns the value as text, as used for plain-text substitution
+// Strings are returned unquoted; other types are returned as JSON
+func (v Variable) StringValue() string {
+	var s string
+	if err := json.Unmarshal(v.Value, &s); err == nil {
+		return s
+	}
+	return string(v.Value)
+}
+
+// decodeVariableJSON decodes a single JSON value keeping numbers as json.Number
+func decodeVariableJSON(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var decoded interface{}
+	if err := dec.Decode(&decoded); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("trailing data")
+	}
+	return decoded, nil
+}
+
+// variableTypeOf returns the variable type of a decoded JSON value, checking nested values
+func variableTypeOf(value interface{}, depth int) (string, error) {
+	if depth > MaxVariableDepth {
+		return "", fmt.Errorf("value nested deeper than %d levels", MaxVariableDepth)
+	}
+
+	switch val := value.(type) {
+	case string:
+		return VariableTypeString, nil
+	case bool:
+		return VariableTypeBool, nil
+	case json.Number:
+		if strings.ContainsAny(val.String(), ".eE") {
+			if _, err := val.Float64(); err != nil {
+				return "", fmt.Errorf("invalid number %s", val)
+			}
+			return VariableTypeFloat, nil
+		}
+		if _, err := val.Int64(); err != nil {
+			return "", fmt.Errorf("integer %s out of range", val)
+		}
+		return VariableTypeInt, nil
+	case []interface{}:
+		for _, item := range val {
+			if item == nil {
+				return "", errors.New("list items cannot be null")
+			}
+			if _, err := variableTypeOf(item, depth+1); err != nil {
+				return "", err
+			}
+		}
+		return VariableTypeList, nil
+	case map[string]interface{}:
+		for key, item := range val {
+			if key == "" {
+				return "", errors.New("table keys cannot be empty")
+			}
+			if item == nil {
+				return "", errors.New("table values cannot be null")
+			}
+			if _, err := variableTypeOf(item, depth+1); err != nil {
+				return "", err
+			}
+		}
+		return VariableTypeTable, nil
+	default:
+		return "", errors.New("unsupported value")
+	}
+}
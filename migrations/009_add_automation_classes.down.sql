@@ -0,0 +1,4 @@
+-- +migrate Down
+-- Remove class states from profiles table
+ALTER TABLE profiles
+DROP COLUMN IF EXISTS classes;
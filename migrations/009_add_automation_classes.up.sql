@@ -0,0 +1,4 @@
+-- +migrate Up
+-- Add class (group) states to profiles so aliases and triggers can be toggled in bulk
+ALTER TABLE profiles
+ADD COLUMN IF NOT EXISTS classes JSONB NOT NULL DEFAULT '{"items": []}'::jsonb;
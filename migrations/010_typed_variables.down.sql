@@ -0,0 +1,14 @@
+-- +migrate Down
+-- Convert typed environment variables back to plain strings
+-- Non-string values are kept as their JSON text
+UPDATE profiles
+SET variables = jsonb_build_object('items', (
+    SELECT COALESCE(jsonb_agg(
+        (item - 'type') || jsonb_build_object('value',
+            CASE WHEN jsonb_typeof(item->'value') = 'string' THEN item->'value'
+                 ELSE to_jsonb((item->'value')::text) END)
+        ORDER BY ord
+    ), '[]'::jsonb)
+    FROM jsonb_array_elements(variables->'items') WITH ORDINALITY AS t(item, ord)
+))
+WHERE jsonb_typeof(variables->'items') = 'array';
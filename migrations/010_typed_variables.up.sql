@@ -0,0 +1,12 @@
+-- +migrate Up
+-- Give every existing environment variable an explicit type
+-- Variables saved before typed values existed are plain strings
+UPDATE profiles
+SET variables = jsonb_build_object('items', (
+    SELECT COALESCE(jsonb_agg(
+        CASE WHEN item ? 'type' THEN item ELSE item || '{"type": "string"}'::jsonb END
+        ORDER BY ord
+    ), '[]'::jsonb)
+    FROM jsonb_array_elements(variables->'items') WITH ORDINALITY AS t(item, ord)
+))
+WHERE jsonb_typeof(variables->'items') = 'array';
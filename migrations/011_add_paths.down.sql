@@ -0,0 +1,4 @@
+-- +migrate Down
+-- Remove named speedwalk paths from profiles table
+ALTER TABLE profiles
+DROP COLUMN IF EXISTS paths;
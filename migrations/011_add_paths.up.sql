@@ -0,0 +1,4 @@
+-- +migrate Up
+-- Add named speedwalk paths to profiles table
+ALTER TABLE profiles
+ADD COLUMN IF NOT EXISTS paths JSONB NOT NULL DEFAULT '{"items": []}'::jsonb;
@@ -0,0 +1,4 @@
+-- +migrate Down
+-- Drop automapper tables
+DROP TABLE IF EXISTS map_rooms;
+DROP TABLE IF EXISTS maps;
@@ -0,0 +1,35 @@
+-- +migrate Up
+-- Create automapper tables: one map per saved connection, holding its rooms
+
+CREATE TABLE IF NOT EXISTS maps (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    connection_id UUID NOT NULL REFERENCES saved_connections(id) ON DELETE CASCADE,
+    room_name_pattern TEXT NOT NULL DEFAULT '',
+    exits_pattern TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- One map per connection
+CREATE UNIQUE INDEX IF NOT EXISTS idx_maps_connection_id ON maps(connection_id);
+CREATE INDEX IF NOT EXISTS idx_maps_user_id ON maps(user_id);
+
+-- Rooms are keyed by the MUD's room number (GMCP) or a generated key (regex mapping)
+CREATE TABLE IF NOT EXISTS map_rooms (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    map_id UUID NOT NULL REFERENCES maps(id) ON DELETE CASCADE,
+    room_key TEXT NOT NULL,
+    name TEXT NOT NULL DEFAULT '',
+    area TEXT NOT NULL DEFAULT '',
+    environment TEXT NOT NULL DEFAULT '',
+    x INTEGER NOT NULL DEFAULT 0,
+    y INTEGER NOT NULL DEFAULT 0,
+    z INTEGER NOT NULL DEFAULT 0,
+    exits JSONB NOT NULL DEFAULT '[]'::jsonb,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_map_rooms_key ON map_rooms(map_id, room_key);
+CREATE INDEX IF NOT EXISTS idx_map_rooms_area ON map_rooms(map_id, area);
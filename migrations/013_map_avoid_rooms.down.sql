@@ -0,0 +1,4 @@
+-- +migrate Down
+-- Remove avoid flag from map rooms
+ALTER TABLE map_rooms
+DROP COLUMN IF EXISTS avoid;
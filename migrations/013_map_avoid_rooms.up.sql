@@ -0,0 +1,4 @@
+-- +migrate Up
+-- Add avoid flag to map rooms; pathfinding never routes through avoided rooms
+ALTER TABLE map_rooms
+ADD COLUMN IF NOT EXISTS avoid BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,4 @@
+-- +migrate Down
+-- Remove preserved import fields from map rooms
+ALTER TABLE map_rooms
+DROP COLUMN IF EXISTS extra;
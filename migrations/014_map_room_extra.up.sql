@@ -0,0 +1,4 @@
+-- +migrate Up
+-- Keep fields from imported maps that have no equivalent in our room model, keyed by format
+ALTER TABLE map_rooms
+ADD COLUMN IF NOT EXISTS extra JSONB NOT NULL DEFAULT '{}'::jsonb;
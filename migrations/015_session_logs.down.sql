@@ -0,0 +1,6 @@
+-- +migrate Down
+-- Drop session transcript tables
+DROP TABLE IF EXISTS session_log_settings;
+DROP TABLE IF EXISTS session_log_chunks;
+DROP TABLE IF EXISTS session_logs;
+ALTER TABLE saved_connections DROP COLUMN IF EXISTS logging_enabled;
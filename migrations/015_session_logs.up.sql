@@ -0,0 +1,41 @@
+-- +migrate Up
+-- Persistent session transcripts: one log per recorded session, stored in chunks
+
+-- Logging is opt-in per saved connection
+ALTER TABLE saved_connections ADD COLUMN IF NOT EXISTS logging_enabled BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS session_logs (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    connection_id UUID NOT NULL REFERENCES saved_connections(id) ON DELETE CASCADE,
+    host VARCHAR(255) NOT NULL,
+    port INTEGER NOT NULL,
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    ended_at TIMESTAMP WITH TIME ZONE,
+    bytes BIGINT NOT NULL DEFAULT 0,
+    chunk_count INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_session_logs_user_started ON session_logs(user_id, started_at DESC);
+CREATE INDEX IF NOT EXISTS idx_session_logs_connection_id ON session_logs(connection_id);
+
+-- Entries are a JSON array of {t, dir, data}: unix milliseconds, sent/received and the text
+CREATE TABLE IF NOT EXISTS session_log_chunks (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    log_id UUID NOT NULL REFERENCES session_logs(id) ON DELETE CASCADE,
+    seq INTEGER NOT NULL,
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    ended_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    entries JSONB NOT NULL DEFAULT '[]'::jsonb,
+    bytes INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_session_log_chunks_seq ON session_log_chunks(log_id, seq);
+
+-- Per-user retention limits; users without a row get the server defaults
+CREATE TABLE IF NOT EXISTS session_log_settings (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    retention_days INTEGER NOT NULL,
+    max_bytes BIGINT NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
@@ -0,0 +1,5 @@
+-- +migrate Down
+-- Drop full-text search over session transcripts
+DROP INDEX IF EXISTS idx_session_log_chunks_started;
+DROP INDEX IF EXISTS idx_session_log_chunks_search;
+ALTER TABLE session_log_chunks DROP COLUMN IF EXISTS search;
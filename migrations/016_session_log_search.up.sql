@@ -0,0 +1,14 @@
+-- +migrate Up
+-- Full-text search over session transcripts
+
+ALTER TABLE session_log_chunks ADD COLUMN IF NOT EXISTS search TSVECTOR;
+
+-- Index chunks written before search existed, with ANSI escape sequences removed
+UPDATE session_log_chunks c
+SET search = to_tsvector('simple', regexp_replace(
+    (SELECT COALESCE(string_agg(e->>'data', E'\n'), '') FROM jsonb_array_elements(c.entries) e),
+    E'\\x1b\\[[0-9;?]*[ -/]*[@-~]', '', 'g'))
+WHERE search IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_session_log_chunks_search ON session_log_chunks USING GIN (search);
+CREATE INDEX IF NOT EXISTS idx_session_log_chunks_started ON session_log_chunks(started_at DESC, id DESC);
@@ -0,0 +1,4 @@
+-- +migrate Down
+-- Drop session recording tables
+DROP TABLE IF EXISTS session_recording_chunks;
+DROP TABLE IF EXISTS session_recordings;
@@ -0,0 +1,31 @@
+-- +migrate Up
+-- Timed recordings of MUD output, replayable and exportable as asciicast v2
+
+CREATE TABLE IF NOT EXISTS session_recordings (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    connection_id UUID REFERENCES saved_connections(id) ON DELETE SET NULL,
+    host VARCHAR(255) NOT NULL,
+    port INTEGER NOT NULL,
+    title VARCHAR(200) NOT NULL DEFAULT '',
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    ended_at TIMESTAMP WITH TIME ZONE,
+    duration_ms BIGINT NOT NULL DEFAULT 0,
+    bytes BIGINT NOT NULL DEFAULT 0,
+    chunk_count INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_session_recordings_user_started ON session_recordings(user_id, started_at DESC);
+
+-- Events are asciicast v2 event lines, e.g. [1.25, "o", "text"], one per line
+CREATE TABLE IF NOT EXISTS session_recording_chunks (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    recording_id UUID NOT NULL REFERENCES session_recordings(id) ON DELETE CASCADE,
+    seq INTEGER NOT NULL,
+    events TEXT NOT NULL,
+    bytes INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_session_recording_chunks_seq ON session_recording_chunks(recording_id, seq);
@@ -0,0 +1,3 @@
+-- +migrate Down
+-- Drop passkey credentials
+DROP TABLE IF EXISTS webauthn_credentials;
@@ -0,0 +1,4 @@
+-- +migrate Down
+-- Drop TOTP tables
+DROP TABLE IF EXISTS totp_recovery_codes;
+DROP TABLE IF EXISTS user_totp;
@@ -0,0 +1,3 @@
+-- +migrate Down
+-- Drop personal API tokens
+DROP TABLE IF EXISTS api_tokens;
@@ -0,0 +1,3 @@
+-- +migrate Down
+-- Drop linked identities
+DROP TABLE IF EXISTS user_identities;
@@ -0,0 +1,3 @@
+-- +migrate Down
+-- Drop email address changes
+DROP TABLE IF EXISTS email_changes;
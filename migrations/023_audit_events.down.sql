@@ -0,0 +1,4 @@
+-- +migrate Down
+-- Drop the security audit log
+DROP TABLE IF EXISTS audit_events;
+DROP FUNCTION IF EXISTS reject_audit_event_update();